@@ -0,0 +1,228 @@
+package keyflare
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Environment variables read by WithEnv
+const (
+	envErrorRate      = "KEYFLARE_ERROR_RATE"
+	envTopK           = "KEYFLARE_TOPK"
+	envDecayFactor    = "KEYFLARE_DECAY_FACTOR"
+	envDecayInterval  = "KEYFLARE_DECAY_INTERVAL" // in seconds
+	envHotThreshold   = "KEYFLARE_HOT_THRESHOLD"
+	envHotThresholdPc = "KEYFLARE_HOT_THRESHOLD_PERCENT"
+	envMinHotCount    = "KEYFLARE_MIN_HOT_COUNT"
+	envPolicyType     = "KEYFLARE_POLICY_TYPE"
+	envMetricsEnabled = "KEYFLARE_METRICS_ENABLED"
+	envMetricsAddr    = "KEYFLARE_METRICS_ADDR"
+	envMetricsNS      = "KEYFLARE_METRICS_NAMESPACE"
+	envMetricsToken   = "KEYFLARE_METRICS_API_TOKEN"
+	envExportFile     = "KEYFLARE_METRICS_EXPORT_FILE"
+)
+
+// NewFromJSON creates the global KeyFlare instance from a JSON configuration document
+func NewFromJSON(r io.Reader) error {
+	options, err := OptionsFromJSON(r)
+	if err != nil {
+		return err
+	}
+
+	return New(func(o *Options) {
+		*o = options
+	})
+}
+
+// NewFromFile creates the global KeyFlare instance from a JSON configuration file
+func NewFromFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	return NewFromJSON(f)
+}
+
+// OptionsFromJSON reads Options from a JSON document.
+// Fields missing from the document keep their default values.
+func OptionsFromJSON(r io.Reader) (Options, error) {
+	options := DefaultOptions()
+	if err := json.NewDecoder(r).Decode(&options); err != nil {
+		return Options{}, fmt.Errorf("failed to decode config: %w", err)
+	}
+	return options, nil
+}
+
+// UnmarshalJSON decodes PolicyOptions, using Type to determine the type of Parameters
+func (o *PolicyOptions) UnmarshalJSON(data []byte) error {
+	type policyOptions PolicyOptions
+	aux := struct {
+		*policyOptions
+		Parameters json.RawMessage `json:"parameters"`
+	}{
+		policyOptions: (*policyOptions)(o),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	// Without parameters, the defaults for the policy type are applied by New
+	if len(aux.Parameters) == 0 || bytes.Equal(aux.Parameters, []byte("null")) {
+		o.Parameters = nil
+		return nil
+	}
+
+	switch o.Type {
+	case LocalCache, "":
+		params := DefaultLocalCacheParams()
+		if err := json.Unmarshal(aux.Parameters, &params); err != nil {
+			return fmt.Errorf("invalid local cache parameters: %w", err)
+		}
+		o.Parameters = params
+	case KeySplitting:
+		params := DefaultKeySplittingParams()
+		if err := json.Unmarshal(aux.Parameters, &params); err != nil {
+			return fmt.Errorf("invalid key splitting parameters: %w", err)
+		}
+		o.Parameters = params
+	default:
+		return fmt.Errorf("unsupported policy type: %s", o.Type)
+	}
+
+	return nil
+}
+
+// WithEnv overlays options with the KEYFLARE_* environment variables.
+// It should be passed after the other options so the environment takes precedence.
+// Unset and empty variables leave the options unchanged, and values that fail to parse
+// make New and Reconfigure return ErrInvalidEnv.
+func WithEnv() Option {
+	return func(o *Options) {
+		var errs []error
+		if v, ok, err := envFloat(envErrorRate); ok {
+			o.DetectorOptions.ErrorRate = v
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+		if v, ok, err := envInt(envTopK); ok {
+			o.DetectorOptions.TopK = int(v)
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+		if v, ok, err := envFloat(envDecayFactor); ok {
+			o.DetectorOptions.DecayFactor = v
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+		if v, ok, err := envInt(envDecayInterval); ok {
+			o.DetectorOptions.DecayInterval = time.Duration(v)
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+		if v, ok, err := envUint(envHotThreshold); ok {
+			o.DetectorOptions.HotThreshold = v
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+		if v, ok, err := envFloat(envHotThresholdPc); ok {
+			o.DetectorOptions.HotThresholdPercent = v
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+		if v, ok, err := envUint(envMinHotCount); ok {
+			o.DetectorOptions.MinHotCount = v
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+		if v, ok := envValue(envPolicyType); ok && PolicyType(v) != o.PolicyOptions.Type {
+			// Parameters of another policy type don't apply, so fall back to the defaults
+			o.PolicyOptions.Type = PolicyType(v)
+			o.PolicyOptions.Parameters = nil
+		}
+		if v, ok, err := envBool(envMetricsEnabled); ok {
+			o.EnableMetrics = v
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+		if v, ok := envValue(envMetricsAddr); ok {
+			o.MetricsOptions.MetricServerAddress = v
+		}
+		if v, ok := envValue(envMetricsNS); ok {
+			o.MetricsOptions.Namespace = v
+		}
+		if v, ok := envValue(envMetricsToken); ok {
+			o.MetricsOptions.APIToken = v
+		}
+		if v, ok := envValue(envExportFile); ok {
+			o.MetricsOptions.ExportFilePath = v
+		}
+		o.err = errors.Join(o.err, errors.Join(errs...))
+	}
+}
+
+// envValue returns the value of the environment variable, and false if it's unset or empty
+func envValue(name string) (string, bool) {
+	v := os.Getenv(name)
+	return v, v != ""
+}
+
+// envError reports a malformed value of the environment variable
+func envError(name, value string, err error) error {
+	return fmt.Errorf("%w %s=%q: %w", ErrInvalidEnv, name, value, err)
+}
+
+func envInt(name string) (int64, bool, error) {
+	s, ok := envValue(name)
+	if !ok {
+		return 0, false, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false, envError(name, s, err)
+	}
+	return v, true, nil
+}
+
+func envUint(name string) (uint64, bool, error) {
+	s, ok := envValue(name)
+	if !ok {
+		return 0, false, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false, envError(name, s, err)
+	}
+	return v, true, nil
+}
+
+func envFloat(name string) (float64, bool, error) {
+	s, ok := envValue(name)
+	if !ok {
+		return 0, false, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, envError(name, s, err)
+	}
+	return v, true, nil
+}
+
+func envBool(name string) (bool, bool, error) {
+	s, ok := envValue(name)
+	if !ok {
+		return false, false, nil
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, false, envError(name, s, err)
+	}
+	return v, true, nil
+}
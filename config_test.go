@@ -0,0 +1,202 @@
+package keyflare_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mingrammer/keyflare"
+)
+
+func TestOptionsFromJSON_LocalCache(t *testing.T) {
+	doc := `{
+		"detector": {"top_k": 50, "hot_threshold": 10},
+		"policy": {
+			"type": "local-cache",
+			"parameters": {"ttl": 120, "capacity": 500},
+			"whitelist_keys": ["config:global"]
+		},
+		"metrics": {"namespace": "myapp", "aggregation_patterns": ["user:*"]},
+		"enable_metrics": false
+	}`
+
+	opts, err := keyflare.OptionsFromJSON(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("Failed to parse options: %v", err)
+	}
+
+	if opts.DetectorOptions.TopK != 50 || opts.DetectorOptions.HotThreshold != 10 {
+		t.Errorf("Unexpected detector options: %+v", opts.DetectorOptions)
+	}
+	if opts.DetectorOptions.DecayFactor != keyflare.DefaultDetectorDecayFactor {
+		t.Errorf("Expected default decay factor to be kept, got %f", opts.DetectorOptions.DecayFactor)
+	}
+
+	params, ok := opts.PolicyOptions.Parameters.(keyflare.LocalCacheParams)
+	if !ok {
+		t.Fatalf("Expected LocalCacheParams, got %T", opts.PolicyOptions.Parameters)
+	}
+	if params.TTL != 120 || params.Capacity != 500 {
+		t.Errorf("Unexpected local cache params: %+v", params)
+	}
+	if params.RefreshAhead != keyflare.DefaultLocalCacheRefreshAhead {
+		t.Errorf("Expected default refresh ahead to be kept, got %f", params.RefreshAhead)
+	}
+	if len(opts.PolicyOptions.WhitelistKeys) != 1 || opts.PolicyOptions.WhitelistKeys[0] != "config:global" {
+		t.Errorf("Unexpected whitelist keys: %v", opts.PolicyOptions.WhitelistKeys)
+	}
+
+	if opts.MetricsOptions.Namespace != "myapp" || len(opts.MetricsOptions.AggregationPatterns) != 1 {
+		t.Errorf("Unexpected metrics options: %+v", opts.MetricsOptions)
+	}
+	if opts.EnableMetrics {
+		t.Error("Expected metrics to be disabled")
+	}
+}
+
+func TestOptionsFromJSON_KeySplitting(t *testing.T) {
+	doc := `{"policy": {"type": "key-splitting", "parameters": {"shards": 5}}}`
+
+	opts, err := keyflare.OptionsFromJSON(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("Failed to parse options: %v", err)
+	}
+
+	params, ok := opts.PolicyOptions.Parameters.(keyflare.KeySplittingParams)
+	if !ok {
+		t.Fatalf("Expected KeySplittingParams, got %T", opts.PolicyOptions.Parameters)
+	}
+	if params.Shards != 5 {
+		t.Errorf("Expected 5 shards, got %d", params.Shards)
+	}
+}
+
+func TestOptionsFromJSON_InvalidPolicyType(t *testing.T) {
+	doc := `{"policy": {"type": "unknown", "parameters": {}}}`
+
+	if _, err := keyflare.OptionsFromJSON(strings.NewReader(doc)); err == nil {
+		t.Error("Expected error for unsupported policy type")
+	}
+}
+
+func TestNewFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyflare.json")
+	doc := `{"policy": {"type": "key-splitting", "parameters": {"shards": 3}}, "enable_metrics": false}`
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	if err := keyflare.NewFromFile(path); err != nil {
+		t.Fatalf("Failed to create KeyFlare from file: %v", err)
+	}
+
+	if err := keyflare.Start(); err != nil {
+		t.Fatalf("Failed to start KeyFlare: %v", err)
+	}
+	defer keyflare.Stop()
+}
+
+func TestNewFromFile_NotFound(t *testing.T) {
+	if err := keyflare.NewFromFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected error for missing config file")
+	}
+}
+
+func TestWithEnv(t *testing.T) {
+	t.Setenv("KEYFLARE_TOPK", "250")
+	t.Setenv("KEYFLARE_HOT_THRESHOLD", "75")
+	t.Setenv("KEYFLARE_DECAY_FACTOR", "0.9")
+	t.Setenv("KEYFLARE_METRICS_ADDR", ":9999")
+	t.Setenv("KEYFLARE_METRICS_ENABLED", "false")
+	t.Setenv("KEYFLARE_POLICY_TYPE", "key-splitting")
+	t.Setenv("KEYFLARE_METRICS_EXPORT_FILE", "/tmp/hot-keys.json")
+
+	opts := keyflare.DefaultOptions()
+	keyflare.WithDetectorOptions(keyflare.DetectorOptions{TopK: 10, HotThreshold: 5})(&opts)
+	keyflare.WithEnv()(&opts)
+
+	if opts.DetectorOptions.TopK != 250 {
+		t.Errorf("Expected TopK 250, got %d", opts.DetectorOptions.TopK)
+	}
+	if opts.DetectorOptions.HotThreshold != 75 {
+		t.Errorf("Expected HotThreshold 75, got %d", opts.DetectorOptions.HotThreshold)
+	}
+	if opts.DetectorOptions.DecayFactor != 0.9 {
+		t.Errorf("Expected DecayFactor 0.9, got %f", opts.DetectorOptions.DecayFactor)
+	}
+	if opts.MetricsOptions.MetricServerAddress != ":9999" {
+		t.Errorf("Expected metrics address :9999, got %s", opts.MetricsOptions.MetricServerAddress)
+	}
+	if opts.MetricsOptions.ExportFilePath != "/tmp/hot-keys.json" {
+		t.Errorf("Expected export file /tmp/hot-keys.json, got %s", opts.MetricsOptions.ExportFilePath)
+	}
+	if opts.EnableMetrics {
+		t.Error("Expected metrics to be disabled")
+	}
+	if opts.PolicyOptions.Type != keyflare.KeySplitting || opts.PolicyOptions.Parameters != nil {
+		t.Errorf("Expected key-splitting policy with default parameters, got %s with %v",
+			opts.PolicyOptions.Type, opts.PolicyOptions.Parameters)
+	}
+}
+
+func TestWithEnv_InvalidValues(t *testing.T) {
+	t.Setenv("KEYFLARE_TOPK", "not-a-number")
+	t.Setenv("KEYFLARE_HOT_THRESHOLD", "-1")
+
+	opts := keyflare.DefaultOptions()
+	keyflare.WithEnv()(&opts)
+
+	if opts.DetectorOptions.TopK != keyflare.DefaultDetectorTopK {
+		t.Errorf("Expected TopK to be unchanged, got %d", opts.DetectorOptions.TopK)
+	}
+	if opts.DetectorOptions.HotThreshold != keyflare.DefaultDetectorHotThreshold {
+		t.Errorf("Expected HotThreshold to be unchanged, got %d", opts.DetectorOptions.HotThreshold)
+	}
+
+	// The malformed values are reported rather than silently ignored
+	if err := keyflare.Reconfigure(opts); !errors.Is(err, keyflare.ErrInvalidEnv) {
+		t.Errorf("Expected ErrInvalidEnv from Reconfigure, got: %v", err)
+	}
+	err := keyflare.New(keyflare.WithMetricsEnabled(false), keyflare.WithEnv())
+	if !errors.Is(err, keyflare.ErrInvalidEnv) {
+		t.Fatalf("Expected ErrInvalidEnv from New, got: %v", err)
+	}
+	for _, name := range []string{"KEYFLARE_TOPK", "KEYFLARE_HOT_THRESHOLD"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected the error to name %s, got: %v", name, err)
+		}
+	}
+	if _, err := keyflare.Stats(); !errors.Is(err, keyflare.ErrNotInitialized) {
+		t.Errorf("Expected KeyFlare not to be created, got: %v", err)
+	}
+}
+
+func TestWithEnv_EmptyValues(t *testing.T) {
+	for _, name := range []string{"KEYFLARE_TOPK", "KEYFLARE_POLICY_TYPE", "KEYFLARE_METRICS_ADDR", "KEYFLARE_METRICS_NAMESPACE"} {
+		t.Setenv(name, "")
+	}
+
+	opts := keyflare.DefaultOptions()
+	keyflare.WithPolicyOptions(keyflare.PolicyOptions{
+		Type:       keyflare.KeySplitting,
+		Parameters: keyflare.KeySplittingParams{Shards: 5},
+	})(&opts)
+	keyflare.WithMetricsOptions(keyflare.MetricsOptions{MetricServerAddress: ":9999", Namespace: "app"})(&opts)
+	keyflare.WithEnv()(&opts)
+
+	if opts.PolicyOptions.Type != keyflare.KeySplitting || opts.PolicyOptions.Parameters == nil {
+		t.Errorf("Expected the policy to be unchanged, got %s with %v", opts.PolicyOptions.Type, opts.PolicyOptions.Parameters)
+	}
+	if opts.MetricsOptions.MetricServerAddress != ":9999" || opts.MetricsOptions.Namespace != "app" {
+		t.Errorf("Expected the metrics options to be unchanged, got address %q and namespace %q",
+			opts.MetricsOptions.MetricServerAddress, opts.MetricsOptions.Namespace)
+	}
+
+	err := keyflare.New(keyflare.WithMetricsEnabled(false), keyflare.WithEnv())
+	if err != nil {
+		t.Fatalf("Expected empty variables to be ignored, got: %v", err)
+	}
+	keyflare.Stop()
+}
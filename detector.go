@@ -0,0 +1,165 @@
+package keyflare
+
+import (
+	"context"
+
+	"github.com/mingrammer/keyflare/internal/detector"
+)
+
+// Detector is a hot key detector that can replace the built-in one (see WithDetector).
+// Implementations must be safe for concurrent use.
+type Detector interface {
+	// Increment records count accesses to key.
+	// op is "read" or "write", or empty for accesses that are neither.
+	Increment(ctx context.Context, key string, count uint64, op string)
+
+	// GetCount returns the estimated count of key.
+	GetCount(key string) uint64
+
+	// TopK returns the hottest keys, sorted by descending count.
+	TopK() []KeyCount
+
+	// IsHot returns whether key is hot.
+	IsHot(key string) bool
+
+	// TotalCount returns the total count of all accesses.
+	TotalCount() uint64
+
+	// Reset clears all counts.
+	Reset()
+}
+
+// externalDetector adapts a user-supplied Detector to the internal detector interface
+// Features the Detector doesn't provide, such as shard and hourly counts, report nothing
+type externalDetector struct {
+	detector Detector
+	config   detector.Config
+}
+
+// Increment increments the count for a key
+func (d *externalDetector) Increment(key string, count uint64) {
+	d.IncrementOp(context.Background(), key, count, detector.OpUnknown)
+}
+
+// IncrementCtx increments the count for a key, skipping it if ctx is already done
+func (d *externalDetector) IncrementCtx(ctx context.Context, key string, count uint64) {
+	d.IncrementOp(ctx, key, count, detector.OpUnknown)
+}
+
+// IncrementOp passes the increment to the detector, skipping it if ctx is already done
+// or was created by WithoutTracking
+func (d *externalDetector) IncrementOp(ctx context.Context, key string, count uint64, op detector.Operation) {
+	if ctx.Err() != nil || !detector.Tracked(ctx) {
+		return
+	}
+
+	var name string
+	switch op {
+	case detector.OpRead:
+		name = "read"
+	case detector.OpWrite:
+		name = "write"
+	}
+	d.detector.Increment(ctx, key, count, name)
+}
+
+// GetCount returns the estimated count for a key
+func (d *externalDetector) GetCount(key string) uint64 {
+	return d.detector.GetCount(key)
+}
+
+// GetCountWithBounds returns the estimated count for a key, with no known error bound
+func (d *externalDetector) GetCountWithBounds(key string) (estimate, errorBound uint64) {
+	return d.detector.GetCount(key), 0
+}
+
+// TopK returns the top K hot keys
+func (d *externalDetector) TopK() []detector.KeyCount {
+	topK := d.detector.TopK()
+	keys := make([]detector.KeyCount, len(topK))
+	for i, kc := range topK {
+		keys[i] = detector.KeyCount{Key: kc.Key, Count: kc.Count, Reads: kc.Reads, Writes: kc.Writes}
+	}
+	return keys
+}
+
+// Keys returns the top K hot keys, since the detector doesn't expose the other tracked keys
+func (d *externalDetector) Keys() []detector.KeyCount {
+	return d.TopK()
+}
+
+// IsHot returns true if the key is considered hot
+func (d *externalDetector) IsHot(key string) bool {
+	return d.detector.IsHot(key)
+}
+
+// Rank returns the 1-based rank of the key in TopK, or 0 if it isn't one of the top K keys
+func (d *externalDetector) Rank(key string) int {
+	for i, kc := range d.detector.TopK() {
+		if kc.Key == key {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// KeyStatus returns the rank and the count of the key from a single TopK call, so they
+// agree, and whether the detector considers it hot
+func (d *externalDetector) KeyStatus(key string) (rank int, count uint64, hot bool) {
+	for i, kc := range d.detector.TopK() {
+		if kc.Key == key {
+			return i + 1, kc.Count, d.detector.IsHot(key)
+		}
+	}
+	return 0, d.detector.GetCount(key), d.detector.IsHot(key)
+}
+
+// TotalCount returns the total count of all increments
+func (d *externalDetector) TotalCount() uint64 {
+	return d.detector.TotalCount()
+}
+
+// Config returns the detector options KeyFlare was created with
+func (d *externalDetector) Config() detector.Config {
+	return d.config
+}
+
+// IncrementShard does nothing, shard accesses aren't tracked
+func (d *externalDetector) IncrementShard(key string, shard int) {}
+
+// ShardCounts returns nil, shard accesses aren't tracked
+func (d *externalDetector) ShardCounts(key string) []uint64 {
+	return nil
+}
+
+// HourlyTopK returns nil, hourly tracking isn't supported
+func (d *externalDetector) HourlyTopK(hour int) []detector.KeyCount {
+	return nil
+}
+
+// Prune does nothing, the detector manages its own capacity
+func (d *externalDetector) Prune(minCount uint64) int {
+	return 0
+}
+
+// Remove returns false, single keys can't be reset
+func (d *externalDetector) Remove(key string) bool {
+	return false
+}
+
+// Dropped returns 0, increments are passed to the detector synchronously
+func (d *externalDetector) Dropped() uint64 {
+	return 0
+}
+
+// Close closes the detector if it has a Close method
+func (d *externalDetector) Close() {
+	if closer, ok := d.detector.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// Reset resets the detector
+func (d *externalDetector) Reset() {
+	d.detector.Reset()
+}
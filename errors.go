@@ -0,0 +1,34 @@
+package keyflare
+
+import (
+	"errors"
+
+	"github.com/mingrammer/keyflare/internal"
+	"github.com/mingrammer/keyflare/internal/policy"
+)
+
+// Errors returned by KeyFlare, wrapped with context
+// Use errors.Is to check for them
+var (
+	// ErrNotInitialized is returned when KeyFlare is used before New
+	ErrNotInitialized = internal.ErrNotInitialized
+
+	// ErrAlreadyInitialized is returned when New is called twice without Stop
+	ErrAlreadyInitialized = internal.ErrAlreadyInitialized
+
+	// ErrNotRunning is returned when KeyFlare is used after New but before Start
+	ErrNotRunning = internal.ErrNotRunning
+
+	// ErrAlreadyRunning is returned when Start is called twice
+	ErrAlreadyRunning = internal.ErrAlreadyRunning
+
+	// ErrDetectorChanged is returned by Reconfigure when the detector's ErrorRate, TopK or Capacity change
+	ErrDetectorChanged = internal.ErrDetectorChanged
+
+	// ErrInvalidPolicyParams is returned when the policy parameters don't match the policy type
+	ErrInvalidPolicyParams = policy.ErrInvalidParams
+
+	// ErrInvalidEnv is returned by New and Reconfigure when a KEYFLARE_* environment variable
+	// read by WithEnv has a malformed value
+	ErrInvalidEnv = errors.New("invalid environment variable")
+)
@@ -0,0 +1,55 @@
+package keyflare_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mingrammer/keyflare"
+)
+
+func TestErrors_Lifecycle(t *testing.T) {
+	if err := keyflare.Start(); !errors.Is(err, keyflare.ErrNotInitialized) {
+		t.Errorf("Expected ErrNotInitialized from Start, got: %v", err)
+	}
+	if err := keyflare.Stop(); !errors.Is(err, keyflare.ErrNotInitialized) {
+		t.Errorf("Expected ErrNotInitialized from Stop, got: %v", err)
+	}
+	if _, err := keyflare.Stats(); !errors.Is(err, keyflare.ErrNotInitialized) {
+		t.Errorf("Expected ErrNotInitialized from Stats, got: %v", err)
+	}
+
+	if err := keyflare.New(); err != nil {
+		t.Fatalf("Failed to create KeyFlare: %v", err)
+	}
+	defer keyflare.Stop()
+
+	if err := keyflare.New(); !errors.Is(err, keyflare.ErrAlreadyInitialized) {
+		t.Errorf("Expected ErrAlreadyInitialized from New, got: %v", err)
+	}
+	if _, err := keyflare.Stats(); !errors.Is(err, keyflare.ErrNotRunning) {
+		t.Errorf("Expected ErrNotRunning from Stats, got: %v", err)
+	}
+
+	if err := keyflare.Start(); err != nil {
+		t.Fatalf("Failed to start KeyFlare: %v", err)
+	}
+	if err := keyflare.Start(); !errors.Is(err, keyflare.ErrAlreadyRunning) {
+		t.Errorf("Expected ErrAlreadyRunning from Start, got: %v", err)
+	}
+}
+
+func TestErrors_InvalidPolicyParams(t *testing.T) {
+	err := keyflare.New(
+		keyflare.WithPolicyOptions(keyflare.PolicyOptions{
+			Type:       keyflare.KeySplitting,
+			Parameters: keyflare.LocalCacheParams{TTL: 60},
+		}),
+	)
+	if err == nil {
+		keyflare.Stop()
+		t.Fatal("Expected error for mismatched policy parameters")
+	}
+	if !errors.Is(err, keyflare.ErrInvalidPolicyParams) {
+		t.Errorf("Expected ErrInvalidPolicyParams, got: %v", err)
+	}
+}
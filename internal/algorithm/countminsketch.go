@@ -68,6 +68,22 @@ func (cms *CountMinSketch) Estimate(key []byte) uint64 {
 	return min
 }
 
+// ErrorBound returns the maximum overestimate of Estimate for a sketch holding
+// the given total count. It holds with the confidence the sketch was created with.
+func (cms *CountMinSketch) ErrorBound(total uint64) uint64 {
+	return uint64(math.Ceil(math.E * float64(total) / float64(cms.width)))
+}
+
+// Remove subtracts count from the counters of a value, stopping at zero.
+// Keys colliding with the value in a counter may undercount afterwards, if count
+// exceeds the value's true count.
+func (cms *CountMinSketch) Remove(key []byte, count uint64) {
+	for i := 0; i < cms.depth; i++ {
+		j := cms.hashFuncs[i](key, uint32(i)) % uint32(cms.width)
+		cms.matrix[i][j] -= min(cms.matrix[i][j], count)
+	}
+}
+
 // Reset resets the sketch.
 func (cms *CountMinSketch) Reset() {
 	for i := range cms.matrix {
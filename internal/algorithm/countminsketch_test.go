@@ -133,6 +133,19 @@ func TestCountMinSketch_Reset(t *testing.T) {
 	}
 }
 
+func TestCountMinSketch_ErrorBound(t *testing.T) {
+	cms := NewCountMinSketch(0.01, 0.01)
+
+	if bound := cms.ErrorBound(0); bound != 0 {
+		t.Errorf("Expected zero bound for an empty sketch, got %d", bound)
+	}
+
+	// The bound is about epsilon * total
+	if bound := cms.ErrorBound(10000); bound < 99 || bound > 101 {
+		t.Errorf("Expected a bound of about 100, got %d", bound)
+	}
+}
+
 func TestCountMinSketch_Decay(t *testing.T) {
 	cms := NewCountMinSketch(0.01, 0.01)
 
@@ -163,3 +176,19 @@ func TestCountMinSketch_Decay(t *testing.T) {
 		t.Errorf("Decay result unexpected for key1: %d (from %d)", decayedCount1, initialCount1)
 	}
 }
+
+func TestCountMinSketch_Remove(t *testing.T) {
+	cms := NewCountMinSketch(0.01, 0.01)
+	cms.Add([]byte("key"), 10)
+
+	cms.Remove([]byte("key"), 4)
+	if count := cms.Estimate([]byte("key")); count != 6 {
+		t.Errorf("Expected count 6, got %d", count)
+	}
+
+	// Counters don't go below zero
+	cms.Remove([]byte("key"), 100)
+	if count := cms.Estimate([]byte("key")); count != 0 {
+		t.Errorf("Expected count 0, got %d", count)
+	}
+}
@@ -1,7 +1,10 @@
 package algorithm
 
 import (
+	"cmp"
 	"container/heap"
+	"slices"
+	"strings"
 )
 
 // Item represents an item in the Space-Saving algorithm.
@@ -70,30 +73,20 @@ func (ss *SpaceSaving) Add(key string, count uint64) {
 	heap.Fix(&ss.heap, 0)
 }
 
-// TopK returns the top k items.
+// TopK returns the top k items, sorted by descending count and items with the same count by key.
 func (ss *SpaceSaving) TopK(k int) []Item {
-	// Create a copy of the heap
-	copyHeap := make(SpaceSavingHeap, len(ss.heap))
-	copy(copyHeap, ss.heap)
-
-	// Sort the copy
-	result := make([]Item, 0, len(copyHeap))
-	for len(copyHeap) > 0 {
-		item := heap.Pop(&copyHeap).(*Item)
-		result = append(result, *item)
-	}
+	// Sort a copy of the items, since popping the heap would change the indexes of the
+	// tracked items and break the heap
+	result := ss.Snapshot()
+	slices.SortFunc(result, func(a, b Item) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
 
 	// Return the top k items (or all if k > len(result))
-	if k > len(result) {
-		k = len(result)
-	}
-
-	// Reverse the order (we want highest count first)
-	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
-		result[i], result[j] = result[j], result[i]
-	}
-
-	return result[:k]
+	return result[:min(k, len(result))]
 }
 
 // Len returns the length of the heap.
@@ -136,6 +129,33 @@ func (ss *SpaceSaving) Count(key string) uint64 {
 	return 0
 }
 
+// Contains returns true if the key is currently tracked
+func (ss *SpaceSaving) Contains(key string) bool {
+	_, ok := ss.items[key]
+	return ok
+}
+
+// Snapshot returns a copy of the tracked items in no particular order
+// Unlike TopK it doesn't sort, and the copy can be iterated after the caller releases its lock
+func (ss *SpaceSaving) Snapshot() []Item {
+	items := make([]Item, len(ss.heap))
+	for i, item := range ss.heap {
+		items[i] = *item
+	}
+	return items
+}
+
+// Range calls fn with the key and count of each tracked item in no particular order,
+// until fn returns false
+// Unlike Snapshot it doesn't copy the items, so the caller must hold its lock throughout
+func (ss *SpaceSaving) Range(fn func(key string, count uint64) bool) {
+	for _, item := range ss.heap {
+		if !fn(item.Key, item.Count) {
+			return
+		}
+	}
+}
+
 // Decay applies exponential decay to all counts
 func (ss *SpaceSaving) Decay(factor float64) {
 	for _, item := range ss.items {
@@ -146,8 +166,33 @@ func (ss *SpaceSaving) Decay(factor float64) {
 	heap.Init(&ss.heap)
 }
 
+// Prune removes the items whose count is below minCount and returns how many were removed
+// The freed capacity is taken by new keys without evicting tracked ones
+func (ss *SpaceSaving) Prune(minCount uint64) int {
+	removed := 0
+	for len(ss.heap) > 0 && ss.heap[0].Count < minCount {
+		item := heap.Pop(&ss.heap).(*Item)
+		delete(ss.items, item.Key)
+		removed++
+	}
+	return removed
+}
+
+// Remove stops tracking a key and returns false if it wasn't tracked
+func (ss *SpaceSaving) Remove(key string) bool {
+	item, ok := ss.items[key]
+	if !ok {
+		return false
+	}
+	heap.Remove(&ss.heap, item.Index)
+	delete(ss.items, key)
+	return true
+}
+
 // Clear removes all items from the Space-Saving structure
+// The underlying map and heap allocations are reused
 func (ss *SpaceSaving) Clear() {
-	ss.items = make(map[string]*Item)
-	ss.heap = make(SpaceSavingHeap, 0, ss.capacity)
+	clear(ss.items)
+	clear(ss.heap) // avoid holding references to removed items
+	ss.heap = ss.heap[:0]
 }
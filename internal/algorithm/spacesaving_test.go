@@ -132,3 +132,210 @@ func TestSpaceSaving_ErrorTracking(t *testing.T) {
 		}
 	}
 }
+
+func TestSpaceSaving_Contains(t *testing.T) {
+	ss := NewSpaceSaving(2)
+
+	ss.Add("first", 10)
+	ss.Add("second", 5)
+
+	if !ss.Contains("first") || !ss.Contains("second") {
+		t.Error("Expected tracked keys to be contained")
+	}
+
+	// Replaces the least frequent item
+	ss.Add("third", 1)
+
+	if ss.Contains("second") {
+		t.Error("Expected evicted key to not be contained")
+	}
+	if !ss.Contains("third") {
+		t.Error("Expected newly added key to be contained")
+	}
+}
+
+func TestSpaceSaving_Range(t *testing.T) {
+	ss := NewSpaceSaving(5)
+
+	for i := 1; i <= 5; i++ {
+		ss.Add(fmt.Sprintf("key%d", i), uint64(i*10))
+	}
+
+	counts := make(map[string]uint64)
+	ss.Range(func(key string, count uint64) bool {
+		counts[key] = count
+		return true
+	})
+	if len(counts) != 5 || counts["key3"] != 30 {
+		t.Errorf("Expected every item with its count, got %v", counts)
+	}
+
+	// Returning false stops the iteration
+	calls := 0
+	ss.Range(func(key string, count uint64) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Errorf("Expected Range to stop after 1 call, got %d", calls)
+	}
+}
+
+func TestSpaceSaving_Snapshot(t *testing.T) {
+	ss := NewSpaceSaving(3)
+	ss.Add("a", 1)
+	ss.Add("b", 2)
+	ss.Add("c", 3)
+
+	snapshot := ss.Snapshot()
+	if len(snapshot) != 3 {
+		t.Fatalf("Expected 3 items, got %d", len(snapshot))
+	}
+
+	counts := make(map[string]uint64)
+	for _, item := range snapshot {
+		counts[item.Key] = item.Count
+	}
+	if counts["a"] != 1 || counts["b"] != 2 || counts["c"] != 3 {
+		t.Errorf("Unexpected snapshot counts: %v", counts)
+	}
+
+	// The snapshot is a copy, unaffected by later updates
+	ss.Add("a", 10)
+	for _, item := range snapshot {
+		if item.Key == "a" && item.Count != 1 {
+			t.Errorf("Expected snapshot count of a to stay 1, got %d", item.Count)
+		}
+	}
+}
+
+func TestSpaceSaving_Clear(t *testing.T) {
+	fill := func(ss *SpaceSaving) {
+		for i := 0; i < 10; i++ {
+			ss.Add(fmt.Sprintf("key%d", i%5), uint64(i+1))
+		}
+	}
+
+	cleared := NewSpaceSaving(3)
+	fill(cleared)
+	cleared.Clear()
+
+	if len(cleared.TopK(3)) != 0 {
+		t.Fatal("Expected no items after Clear")
+	}
+	if cleared.Contains("key0") || cleared.Count("key0") != 0 {
+		t.Error("Expected cleared keys to be removed")
+	}
+
+	// A cleared instance should behave exactly like a new one
+	fresh := NewSpaceSaving(3)
+	fill(cleared)
+	fill(fresh)
+
+	got := cleared.TopK(3)
+	want := fresh.TopK(3)
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d items, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].Key != want[i].Key || got[i].Count != want[i].Count || got[i].Error != want[i].Error {
+			t.Errorf("Item %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+// newBenchmarkSpaceSaving returns a full Space-Saving structure with the given capacity
+func newBenchmarkSpaceSaving(capacity int) *SpaceSaving {
+	ss := NewSpaceSaving(capacity)
+	for i := 0; i < capacity; i++ {
+		ss.Add(fmt.Sprintf("key%d", i), uint64(i+1))
+	}
+	return ss
+}
+
+// BenchmarkSpaceSaving_TopKMembership measures membership checks through a full TopK sort
+func BenchmarkSpaceSaving_TopKMembership(b *testing.B) {
+	ss := newBenchmarkSpaceSaving(100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, item := range ss.TopK(100) {
+			if item.Key == "key50" {
+				break
+			}
+		}
+	}
+}
+
+// BenchmarkSpaceSaving_Contains measures membership checks through the item map
+func BenchmarkSpaceSaving_Contains(b *testing.B) {
+	ss := newBenchmarkSpaceSaving(100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ss.Contains("key50")
+	}
+}
+
+// BenchmarkSpaceSaving_Snapshot measures copying the tracked items without sorting
+func BenchmarkSpaceSaving_Snapshot(b *testing.B) {
+	ss := newBenchmarkSpaceSaving(100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ss.Snapshot()
+	}
+}
+
+func TestSpaceSaving_Prune(t *testing.T) {
+	ss := NewSpaceSaving(5)
+	ss.Add("cold1", 1)
+	ss.Add("cold2", 2)
+	ss.Add("hot1", 10)
+	ss.Add("hot2", 20)
+
+	if removed := ss.Prune(5); removed != 2 {
+		t.Errorf("Expected 2 removed items, got %d", removed)
+	}
+	if ss.Contains("cold1") || ss.Contains("cold2") {
+		t.Error("Expected keys below the floor to be removed")
+	}
+	if ss.Count("hot1") != 10 || ss.Count("hot2") != 20 {
+		t.Error("Expected keys above the floor to keep their counts")
+	}
+
+	// The freed capacity is taken without evicting tracked keys
+	for i := 0; i < 3; i++ {
+		ss.Add(fmt.Sprintf("new%d", i), 1)
+	}
+	if !ss.Contains("hot1") || !ss.Contains("hot2") || !ss.Contains("new2") {
+		t.Error("Expected new keys to fill the freed capacity")
+	}
+}
+
+func TestSpaceSaving_Remove(t *testing.T) {
+	ss := NewSpaceSaving(3)
+	ss.Add("a", 10)
+	ss.Add("b", 5)
+	ss.Add("c", 1)
+
+	// TopK must leave the heap intact for later removals and evictions
+	ss.TopK(3)
+
+	if !ss.Remove("b") {
+		t.Fatal("Expected 'b' to be removed")
+	}
+	if ss.Remove("b") {
+		t.Error("Expected removing 'b' twice to return false")
+	}
+	if ss.Contains("b") {
+		t.Error("Expected 'b' not to be tracked")
+	}
+
+	// The freed slot is taken without evicting, then the smallest item is evicted
+	ss.Add("d", 2)
+	ss.Add("e", 1)
+	if ss.Contains("c") || !ss.Contains("a") || !ss.Contains("d") || !ss.Contains("e") {
+		t.Errorf("Expected 'c' to be evicted, got %v", ss.TopK(3))
+	}
+}
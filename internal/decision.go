@@ -0,0 +1,71 @@
+package internal
+
+import "github.com/mingrammer/keyflare/internal/policy"
+
+// Results of a policy decision
+const (
+	DecisionHit   = "hit"
+	DecisionMiss  = "miss"
+	DecisionSet   = "set"
+	DecisionShard = "shard"
+)
+
+// Decision describes how a wrapped command on a key was handled, for debugging
+type Decision struct {
+	// Key is the normalized key
+	Key string
+
+	// Operation is the policy operation of the command ("get" or "set")
+	Operation string
+
+	// Hot is whether the detector considered the key hot, whether or not a policy applies to it
+	Hot bool
+
+	// PolicyApplied is whether a policy was applied to the command
+	PolicyApplied bool
+
+	// Result is the type of the policy result (DecisionHit, DecisionMiss, DecisionSet,
+	// DecisionShard), or empty if no policy result was used
+	Result string
+
+	// BackendHit is whether the command reached the backend
+	BackendHit bool
+}
+
+// NewDecision creates a decision from the policy result of a command
+func NewDecision(key, operation string, hot, applied bool, result any) Decision {
+	d := Decision{
+		Key:           key,
+		Operation:     operation,
+		Hot:           hot,
+		PolicyApplied: applied,
+	}
+
+	switch result.(type) {
+	case policy.CacheHit:
+		d.Result = DecisionHit
+	case policy.CacheMiss:
+		d.Result = DecisionMiss
+	case policy.CacheSet:
+		d.Result = DecisionSet
+	case policy.KeySplittingGetAction, policy.KeySplittingSetAction:
+		d.Result = DecisionShard
+	}
+
+	// Only local cache hits are served without the backend
+	d.BackendHit = d.Result != DecisionHit
+	return d
+}
+
+// Debug returns whether policy decisions are traced
+// Wrappers check it before building decisions, so tracing costs nothing when disabled
+func (kf *KeyFlare) Debug() bool {
+	return kf.config.OnDecision != nil
+}
+
+// RecordDecision passes the decision to the OnDecision callback, if any
+func (kf *KeyFlare) RecordDecision(d Decision) {
+	if kf.config.OnDecision != nil {
+		kf.config.OnDecision(d)
+	}
+}
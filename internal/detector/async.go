@@ -0,0 +1,126 @@
+package detector
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// asyncBatchSize is the maximum number of queued increments applied under a single lock
+const asyncBatchSize = 256
+
+// increment is an increment queued by the async detector
+type increment struct {
+	key   string
+	count uint64
+	op    Operation
+}
+
+// asyncDetector queues increments on a buffered channel drained by a background goroutine,
+// so the callers of Increment never wait on the detector lock
+// The other methods read the wrapped detector directly, so they don't see queued increments yet
+type asyncDetector struct {
+	*hotKeyDetector
+
+	queue   chan increment
+	dropped atomic.Uint64
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newAsyncDetector wraps d to apply increments asynchronously, queuing up to size of them
+func newAsyncDetector(d *hotKeyDetector, size int) *asyncDetector {
+	a := &asyncDetector{
+		hotKeyDetector: d,
+		queue:          make(chan increment, size),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Increment queues an increment of the count for a key
+func (a *asyncDetector) Increment(key string, count uint64) {
+	a.IncrementOp(context.Background(), key, count, OpUnknown)
+}
+
+// IncrementCtx queues an increment of the count for a key, skipping it if ctx is already done
+func (a *asyncDetector) IncrementCtx(ctx context.Context, key string, count uint64) {
+	a.IncrementOp(ctx, key, count, OpUnknown)
+}
+
+// IncrementOp queues an increment of the count for a key with the type of access,
+// skipping it if ctx is already done or was created by WithoutTracking
+// The increment is dropped if the queue is full
+func (a *asyncDetector) IncrementOp(ctx context.Context, key string, count uint64, op Operation) {
+	if ctx.Err() != nil || !Tracked(ctx) {
+		return
+	}
+
+	select {
+	case a.queue <- increment{key: key, count: count, op: op}:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of increments dropped because the queue was full
+func (a *asyncDetector) Dropped() uint64 {
+	return a.dropped.Load()
+}
+
+// Close stops the background goroutine after applying the queued increments, then
+// stops the periodic reset of the wrapped detector
+// Increments queued after Close are never applied
+func (a *asyncDetector) Close() {
+	a.closeOnce.Do(func() {
+		close(a.stop)
+		<-a.done
+	})
+	a.hotKeyDetector.Close()
+}
+
+// run applies the queued increments in batches until Close is called
+func (a *asyncDetector) run() {
+	defer close(a.done)
+
+	batch := make([]increment, 0, asyncBatchSize)
+	for {
+		select {
+		case inc := <-a.queue:
+			batch = a.drain(append(batch[:0], inc))
+			a.apply(batch)
+		case <-a.stop:
+			for len(a.queue) > 0 {
+				a.apply(a.drain(batch[:0]))
+			}
+			return
+		}
+	}
+}
+
+// drain appends the queued increments to batch without waiting, up to asyncBatchSize
+func (a *asyncDetector) drain(batch []increment) []increment {
+	for len(batch) < asyncBatchSize {
+		select {
+		case inc := <-a.queue:
+			batch = append(batch, inc)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// apply applies a batch of increments under a single lock of the detector
+func (a *asyncDetector) apply(batch []increment) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, inc := range batch {
+		a.increment(inc.key, inc.count, inc.op)
+	}
+}
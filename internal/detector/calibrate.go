@@ -0,0 +1,112 @@
+package detector
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+const (
+	// calibrationRounds is the number of rounds the counts of a calibration distribution are
+	// spread over, so keys are interleaved like in real traffic instead of added all at once
+	calibrationRounds = 100
+
+	// calibrationTargetError is the relative error of the top-K counts SuggestedErrorRate aims for
+	calibrationTargetError = 0.1
+)
+
+// CalibrationReport describes how accurately a detector configuration counts a key distribution
+type CalibrationReport struct {
+	// Keys is the number of distinct keys of the distribution
+	Keys int
+
+	// TotalCount is the sum of the counts of the distribution
+	TotalCount uint64
+
+	// MeanRelativeError and MaxRelativeError are the mean and maximum of |estimate-count|/count
+	// over the true top-K keys, whose counts decide which keys are hot
+	MeanRelativeError float64
+	MaxRelativeError  float64
+
+	// MaxRelativeErrorKey is the key with the maximum relative error
+	MaxRelativeErrorKey string
+
+	// TopKRecall is the fraction of the true top-K keys reported by TopK
+	TopKRecall float64
+
+	// SuggestedErrorRate is the ErrorRate whose error bound is calibrationTargetError (10%)
+	// of the smallest true top-K count for this distribution
+	SuggestedErrorRate float64
+}
+
+// Calibrate loads a known key distribution into a new detector with the configuration
+// and compares the estimated counts to the true ones, to tell whether ErrorRate and
+// Capacity are sized right for the distribution
+// Decay, periodic reset and async increments are disabled, so counts aren't altered while loading
+func Calibrate(config Config, keys []KeyCount) CalibrationReport {
+	config.DecayFactor = 1
+	config.ResetInterval = 0
+	config.AsyncBufferSize = 0
+	d := New(config)
+	defer d.Close()
+
+	report := CalibrationReport{Keys: len(keys)}
+	remaining := make([]uint64, len(keys))
+	for i, kc := range keys {
+		remaining[i] = kc.Count
+		report.TotalCount += kc.Count
+	}
+	for round := calibrationRounds; round > 0; round-- {
+		for i, kc := range keys {
+			// Spread the rest of the count evenly over the remaining rounds
+			count := (remaining[i] + uint64(round) - 1) / uint64(round)
+			if count > 0 {
+				d.Increment(kc.Key, count)
+				remaining[i] -= count
+			}
+		}
+	}
+
+	// The true top-K keys, by descending count
+	top := slices.Clone(keys)
+	slices.SortStableFunc(top, func(a, b KeyCount) int {
+		return cmp.Compare(b.Count, a.Count)
+	})
+	top = top[:min(d.Config().TopK, len(top))]
+	for len(top) > 0 && top[len(top)-1].Count == 0 {
+		top = top[:len(top)-1]
+	}
+	if len(top) == 0 {
+		return report
+	}
+
+	var sum float64
+	for _, kc := range top {
+		estimate := d.GetCount(kc.Key)
+		relErr := math.Abs(float64(estimate)-float64(kc.Count)) / float64(kc.Count)
+		sum += relErr
+		if relErr > report.MaxRelativeError || report.MaxRelativeErrorKey == "" {
+			report.MaxRelativeError = relErr
+			report.MaxRelativeErrorKey = kc.Key
+		}
+	}
+	report.MeanRelativeError = sum / float64(len(top))
+
+	reported := make(map[string]bool)
+	for _, kc := range d.TopK() {
+		reported[kc.Key] = true
+	}
+	var recalled int
+	for _, kc := range top {
+		if reported[kc.Key] {
+			recalled++
+		}
+	}
+	report.TopKRecall = float64(recalled) / float64(len(top))
+
+	// The sketch overcounts by at most ErrorRate*TotalCount with high probability
+	smallest := top[len(top)-1].Count
+	report.SuggestedErrorRate = calibrationTargetError * float64(smallest) / float64(report.TotalCount)
+
+	return report
+}
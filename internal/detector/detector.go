@@ -2,6 +2,13 @@
 package detector
 
 import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"hash/maphash"
+	"math/rand/v2"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,6 +20,26 @@ const (
 	DefaultTopK          = 100
 	DefaultDecayFactor   = 0.98
 	DefaultDecayInterval = 60 * time.Second
+
+	// hoursPerDay is the number of hour-of-day buckets tracked with HourlyTracking
+	hoursPerDay = 24
+
+	// hourlyDecayFactor is applied to an hour-of-day bucket when it's entered again on a
+	// later day, so recent days weigh more in what's usually hot at that hour
+	hourlyDecayFactor = 0.5
+)
+
+// CountSource selects which structure the detector reads key counts from
+type CountSource string
+
+const (
+	// CountSourceCMS reads counts from the Count-Min Sketch, which never undercounts
+	// but may overcount keys that collide with heavier ones
+	CountSourceCMS CountSource = "cms"
+	// CountSourceSpaceSaving reads counts from the Space-Saving structure, which is
+	// tighter for tracked keys but includes the count inherited from evicted keys
+	// Keys it doesn't track fall back to the sketch
+	CountSourceSpaceSaving CountSource = "spacesaving"
 )
 
 // Config contains configuration options for the detector
@@ -20,24 +47,133 @@ type Config struct {
 	// ErrorRate is the acceptable error rate for probabilistic algorithms
 	ErrorRate float64
 
-	// TopK is the number of top hot keys to track
+	// TopK is the number of top hot keys to report (and consider hot in dynamic mode)
 	TopK int
 
+	// Capacity is the number of keys tracked by the Space-Saving structure (default: TopK)
+	// Tracking more keys than reported makes the reported top-K more accurate
+	// It's raised to TopK if smaller
+	Capacity int
+
 	// DecayFactor is used to decay old counts over time
+	// A factor of 1 (or more) disables decay, so counts are cumulative totals
 	DecayFactor float64
 
 	// DecayInterval is the interval at which decay is applied
 	DecayInterval time.Duration
 
+	// ResetInterval resets the detector at this interval if it's greater than 0, so counts
+	// only reflect the traffic of the current window. It disables decay
+	ResetInterval time.Duration
+
+	// DecayJitter is the randomness factor for DecayInterval (0.0-1.0)
+	// Each decay is scheduled within DecayInterval ± DecayInterval*DecayJitter
+	DecayJitter float64
+
 	// HotThreshold is the threshold for determining if a key is hot
 	// If it's 0, then the threshold is dynamically determined based on the Top-K keys
 	HotThreshold uint64
+
+	// HotThresholdPercent makes a key hot if its count is at least this percentage of
+	// TotalCount, so the threshold scales with traffic. It takes precedence over HotThreshold
+	HotThresholdPercent float64
+
+	// MinHotCount is the absolute minimum count for a key to be considered hot
+	// Keys below it are never hot, even if they are in the Top-K
+	MinHotCount uint64
+
+	// MinHotAge is how long a key must have met the other hot criteria before it's considered
+	// hot, so keys spiking briefly don't trigger policies. It counts from the first hotness
+	// check the key passes otherwise, and starts over once a check fails
+	MinHotAge time.Duration
+
+	// WarmupCount is the number of increments to record before any key is considered hot
+	WarmupCount uint64
+
+	// WarmupDuration is the time to wait after creation before any key is considered hot
+	// Both warmup conditions must be met, and they start over on Reset
+	WarmupDuration time.Duration
+
+	// CountOnlyEligible makes the client wrappers only count keys a policy applies to,
+	// so keys that can't be mitigated don't take up sketch and Space-Saving capacity
+	// It's applied by the client wrappers, not by the detector itself
+	CountOnlyEligible bool
+
+	// MemberGranularity makes the client wrappers also count accesses to members of
+	// sorted sets as "key:member", to show which members drive the hotness of a key
+	MemberGranularity bool
+
+	// HourlyTracking maintains a separate top-K per hour of day (in local time), to tell
+	// what's hot right now from what's usually hot at this hour
+	HourlyTracking bool
+
+	// CountSource selects where TopK, GetCount and IsHot read counts from (default: CountSourceCMS)
+	// The tracked keys are ranked into the top-K by these counts
+	CountSource CountSource
+
+	// HashKeys tracks keys by a fixed-size hash instead of their full name, so memory doesn't
+	// grow with key length. Names are only kept for keys tracked by the Space-Saving structure,
+	// so TopK still reports them (hourly buckets keep storing full names)
+	HashKeys bool
+
+	// AsyncBufferSize makes increments asynchronous if it's greater than 0: they're queued on a
+	// buffer of this size and applied in batches by a background goroutine, so callers never
+	// wait on the detector lock. Increments are dropped when the buffer is full
+	AsyncBufferSize int
 }
 
 // KeyCount represents a key and its estimated count
 type KeyCount struct {
 	Key   string
 	Count uint64
+
+	// Reads and Writes are the counts recorded with OpRead and OpWrite
+	Reads  uint64
+	Writes uint64
+}
+
+// Operation is the type of access recorded for a key
+type Operation int
+
+const (
+	// OpUnknown is an access that is neither tracked as a read nor as a write
+	OpUnknown Operation = iota
+	// OpRead is a read access (e.g. GET)
+	OpRead
+	// OpWrite is a write access (e.g. SET, DEL)
+	OpWrite
+)
+
+// ParseOperation returns the Operation named by op ("read" or "write", case-insensitive)
+// Any other value is OpUnknown
+func ParseOperation(op string) Operation {
+	switch strings.ToLower(op) {
+	case "read":
+		return OpRead
+	case "write":
+		return OpWrite
+	}
+	return OpUnknown
+}
+
+// withoutTrackingContextKey is the context key marking accesses that aren't counted
+type withoutTrackingContextKey struct{}
+
+// WithoutTracking returns a copy of ctx whose increments are skipped by the detector
+func WithoutTracking(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutTrackingContextKey{}, true)
+}
+
+// Tracked returns false if ctx was created by WithoutTracking
+func Tracked(ctx context.Context) bool {
+	skip, _ := ctx.Value(withoutTrackingContextKey{}).(bool)
+	return !skip
+}
+
+// opCounts holds the read and write counts of a key
+type opCounts struct {
+	reads  uint64
+	writes uint64
 }
 
 // Detector defines the interface for hot key detection
@@ -45,15 +181,68 @@ type Detector interface {
 	// Increment increments the count for a key
 	Increment(key string, count uint64)
 
+	// IncrementCtx increments the count for a key, skipping it if ctx is already done
+	// or was created by WithoutTracking
+	IncrementCtx(ctx context.Context, key string, count uint64)
+
+	// IncrementOp is like IncrementCtx but also records the type of access
+	IncrementOp(ctx context.Context, key string, count uint64, op Operation)
+
 	// GetCount returns the estimated count for a key
 	GetCount(key string) uint64
 
+	// GetCountWithBounds returns the estimated count for a key and its error bound
+	// The estimate never undercounts, and overcounts by at most errorBound with high probability
+	GetCountWithBounds(key string) (estimate, errorBound uint64)
+
 	// TopK returns the top K hot keys
 	TopK() []KeyCount
 
+	// Keys returns every key tracked by the Space-Saving structure (up to Capacity),
+	// sorted by descending count like TopK
+	Keys() []KeyCount
+
 	// IsHot returns true if the key is considered hot
 	IsHot(key string) bool
 
+	// Rank returns the 1-based rank of the key among the top K keys, or 0 if it isn't one of them
+	Rank(key string) int
+
+	// KeyStatus returns the rank, the estimated count and the hotness of the key, read at once
+	// so they agree with each other
+	KeyStatus(key string) (rank int, count uint64, hot bool)
+
+	// TotalCount returns the total (decayed) count of all increments
+	TotalCount() uint64
+
+	// Config returns the effective configuration, with defaults applied
+	Config() Config
+
+	// IncrementShard records an access to a shard of a split key
+	IncrementShard(key string, shard int)
+
+	// ShardCounts returns the access counts of each shard of a split key
+	ShardCounts(key string) []uint64
+
+	// HourlyTopK returns the top K keys of an hour of day (0-23) with their Space-Saving counts
+	// It returns nil if HourlyTracking is disabled or the hour is out of range
+	HourlyTopK(hour int) []KeyCount
+
+	// Prune stops tracking the keys whose Space-Saving count is below minCount, freeing
+	// capacity for hotter keys, and returns how many keys were removed
+	Prune(minCount uint64) int
+
+	// Remove resets the count of a key: it stops tracking the key and subtracts its estimated
+	// count from the sketch and the total. It returns false if the key wasn't counted
+	Remove(key string) bool
+
+	// Dropped returns the number of increments dropped because the async buffer was full
+	Dropped() uint64
+
+	// Close stops the background goroutines of the detector: the periodic reset, and the
+	// application of async increments after applying the queued ones
+	Close()
+
 	// Reset resets the detector
 	Reset()
 }
@@ -65,8 +254,43 @@ type hotKeyDetector struct {
 	topK          *algorithm.SpaceSaving
 	mu            sync.RWMutex
 	config        Config
-	lastDecay     time.Time
+	nextDecay     time.Time
 	decayInterval time.Duration
+
+	// ops holds the read/write counts of keys tracked by topK
+	ops map[string]*opCounts
+
+	// total is the decayed sum of all increments
+	total uint64
+
+	// shards holds the per-shard access counts of split keys tracked by topK
+	shards map[string][]uint64
+
+	// increments is the undecayed number of increments since warmupStart
+	increments  uint64
+	warmupStart time.Time
+
+	// hourly holds the top-K of each hour of day, if HourlyTracking is enabled
+	hourly [hoursPerDay]*algorithm.SpaceSaving
+	// currentHour is the start of the hour the latest increment was recorded in
+	currentHour time.Time
+
+	// since holds when keys started meeting the hot criteria other than MinHotAge, if it's set
+	// It's updated by hotness checks under the read lock, so it has its own lock
+	since   map[string]time.Time
+	sinceMu sync.Mutex
+
+	// seed and names are used with HashKeys: keys are tracked by their hash under seed,
+	// and names maps the hashes to the names of keys tracked by topK
+	seed  maphash.Seed
+	names map[string]string
+
+	// now returns the current time, replaced by tests to control the clock
+	now func() time.Time
+
+	// stop stops the periodic reset, if ResetInterval is set
+	stop      chan struct{}
+	closeOnce sync.Once
 }
 
 // New creates a new detector with the provided configuration
@@ -77,41 +301,280 @@ func New(config Config) Detector {
 	if config.TopK <= 0 {
 		config.TopK = DefaultTopK
 	}
+	if config.Capacity < config.TopK {
+		config.Capacity = config.TopK
+	}
 	if config.DecayFactor <= 0 {
 		config.DecayFactor = DefaultDecayFactor
 	}
+	if config.DecayFactor > 1 || config.ResetInterval > 0 {
+		config.DecayFactor = 1
+	}
 	if config.DecayInterval <= 0 {
 		config.DecayInterval = DefaultDecayInterval
 	}
+	if config.DecayJitter < 0 {
+		config.DecayJitter = 0
+	}
+	if config.DecayJitter > 1 {
+		config.DecayJitter = 1
+	}
+	if config.CountSource != CountSourceSpaceSaving {
+		config.CountSource = CountSourceCMS
+	}
 
 	sketch := algorithm.NewCountMinSketch(config.ErrorRate, 0.01) // 99% confidence
-	topK := algorithm.NewSpaceSaving(config.TopK)
+	topK := algorithm.NewSpaceSaving(config.Capacity)
 
-	return &hotKeyDetector{
+	d := &hotKeyDetector{
 		sketch:        sketch,
 		topK:          topK,
 		mu:            sync.RWMutex{},
 		config:        config,
-		lastDecay:     time.Now(),
 		decayInterval: config.DecayInterval,
+		ops:           make(map[string]*opCounts),
+		shards:        make(map[string][]uint64),
+		now:           time.Now,
+	}
+	if config.MinHotAge > 0 {
+		d.since = make(map[string]time.Time)
+	}
+	if config.HashKeys {
+		d.seed = maphash.MakeSeed()
+		d.names = make(map[string]string)
+	}
+	d.warmupStart = d.now()
+	d.nextDecay = d.now().Add(d.jitteredDecayInterval())
+
+	if config.HourlyTracking {
+		for hour := range d.hourly {
+			d.hourly[hour] = algorithm.NewSpaceSaving(config.Capacity)
+		}
 	}
+
+	if config.ResetInterval > 0 {
+		d.stop = make(chan struct{})
+		go d.resetPeriodically()
+	}
+
+	if config.AsyncBufferSize > 0 {
+		return newAsyncDetector(d, config.AsyncBufferSize)
+	}
+	return d
 }
 
 // Increment increments the count for a key
 func (d *hotKeyDetector) Increment(key string, count uint64) {
+	d.IncrementCtx(context.Background(), key, count)
+}
+
+// IncrementCtx increments the count for a key, skipping it if ctx is already done
+func (d *hotKeyDetector) IncrementCtx(ctx context.Context, key string, count uint64) {
+	d.IncrementOp(ctx, key, count, OpUnknown)
+}
+
+// IncrementOp increments the count for a key and records the type of access,
+// skipping it if ctx is already done or was created by WithoutTracking
+func (d *hotKeyDetector) IncrementOp(ctx context.Context, key string, count uint64, op Operation) {
+	if ctx.Err() != nil || !Tracked(ctx) {
+		return
+	}
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	d.increment(key, count, op)
+}
+
+// increment increments the count for a key, the caller must hold the lock
+func (d *hotKeyDetector) increment(key string, count uint64, op Operation) {
 	// Check if we need to apply decay
-	now := time.Now()
-	if now.Sub(d.lastDecay) >= d.decayInterval {
+	now := d.now()
+	if d.config.DecayFactor < 1 && !now.Before(d.nextDecay) {
 		d.sketch.Decay(d.config.DecayFactor)
-		d.lastDecay = now
+		d.topK.Decay(d.config.DecayFactor)
+		d.decayOps()
+		d.total = uint64(float64(d.total) * d.config.DecayFactor)
+		d.nextDecay = now.Add(d.jitteredDecayInterval())
 	}
 
 	// Update the sketch and topK
+	id := d.trackingKey(key)
 	d.sketch.Add([]byte(key), count)
-	d.topK.Add(key, count)
+	d.topK.Add(id, count)
+	d.total += count
+	d.increments++
+
+	if d.names != nil {
+		d.recordName(id, key)
+	}
+	if op != OpUnknown {
+		d.recordOp(id, count, op)
+	}
+
+	if d.config.HourlyTracking {
+		d.recordHourly(key, count, now)
+	}
+}
+
+// trackingKey returns the key topK and the per-key maps track a key by:
+// its hash with HashKeys, or the key itself
+func (d *hotKeyDetector) trackingKey(key string) string {
+	if d.names == nil {
+		return key
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], maphash.String(d.seed, key))
+	return string(b[:])
+}
+
+// recordName remembers the name of a hashed key, the caller must hold the lock
+func (d *hotKeyDetector) recordName(id, key string) {
+	if _, ok := d.names[id]; ok {
+		return
+	}
+	// Drop names of keys evicted from topK once the map grows past twice its capacity
+	if len(d.names) >= 2*d.config.Capacity {
+		d.pruneOps()
+	}
+	d.names[id] = key
+}
+
+// qualifiedFor returns how long a key has met the hot criteria other than MinHotAge,
+// given whether it meets them now, the caller must hold the lock
+// A key that stops meeting them starts over the next time it does
+func (d *hotKeyDetector) qualifiedFor(id string, qualifies bool) time.Duration {
+	d.sinceMu.Lock()
+	defer d.sinceMu.Unlock()
+
+	if !qualifies {
+		delete(d.since, id)
+		return 0
+	}
+
+	now := d.now()
+	since, ok := d.since[id]
+	if !ok {
+		// Drop keys evicted from topK once the map grows past twice its capacity
+		if len(d.since) >= 2*d.config.Capacity {
+			for other := range d.since {
+				if !d.topK.Contains(other) {
+					delete(d.since, other)
+				}
+			}
+		}
+		d.since[id] = now
+		return 0
+	}
+	return now.Sub(since)
+}
+
+// keyName returns the name of a key tracked by topK
+func (d *hotKeyDetector) keyName(id string) string {
+	if d.names == nil {
+		return id
+	}
+	return d.names[id]
+}
+
+// recordHourly records an increment in the bucket of the hour of day of now
+// A bucket is decayed when it's entered again, which happens once a day
+func (d *hotKeyDetector) recordHourly(key string, count uint64, now time.Time) {
+	hourStart := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
+	bucket := d.hourly[now.Hour()]
+	if !hourStart.Equal(d.currentHour) {
+		bucket.Decay(hourlyDecayFactor)
+		d.currentHour = hourStart
+	}
+	bucket.Add(key, count)
+}
+
+// recordOp records the read/write count of a key
+func (d *hotKeyDetector) recordOp(key string, count uint64, op Operation) {
+	c, ok := d.ops[key]
+	if !ok {
+		// Drop keys evicted from topK once the map grows past twice its capacity
+		if len(d.ops) >= 2*d.config.Capacity {
+			d.pruneOps()
+		}
+		c = &opCounts{}
+		d.ops[key] = c
+	}
+
+	switch op {
+	case OpRead:
+		c.reads += count
+	case OpWrite:
+		c.writes += count
+	}
+}
+
+// pruneOps removes the read/write and shard counts and the names of keys no longer tracked by topK
+func (d *hotKeyDetector) pruneOps() {
+	for id := range d.names {
+		if !d.topK.Contains(id) {
+			delete(d.names, id)
+		}
+	}
+	for key := range d.ops {
+		if !d.topK.Contains(key) {
+			delete(d.ops, key)
+		}
+	}
+	for key := range d.shards {
+		if !d.topK.Contains(key) {
+			delete(d.shards, key)
+		}
+	}
+}
+
+// decayOps applies the decay factor to the read/write and shard counts
+func (d *hotKeyDetector) decayOps() {
+	d.pruneOps()
+	for _, c := range d.ops {
+		c.reads = uint64(float64(c.reads) * d.config.DecayFactor)
+		c.writes = uint64(float64(c.writes) * d.config.DecayFactor)
+	}
+	for _, counts := range d.shards {
+		for i := range counts {
+			counts[i] = uint64(float64(counts[i]) * d.config.DecayFactor)
+		}
+	}
+}
+
+// IncrementShard records an access to a shard of a split key
+// Only keys tracked by topK are recorded, since only hot keys are split
+func (d *hotKeyDetector) IncrementShard(key string, shard int) {
+	if shard < 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := d.trackingKey(key)
+	if !d.topK.Contains(id) {
+		return
+	}
+
+	counts := d.shards[id]
+	if shard >= len(counts) {
+		counts = append(counts, make([]uint64, shard+1-len(counts))...)
+	}
+	counts[shard]++
+	d.shards[id] = counts
+}
+
+// ShardCounts returns the access counts of each shard of a split key
+func (d *hotKeyDetector) ShardCounts(key string) []uint64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	counts := d.shards[d.trackingKey(key)]
+	if len(counts) == 0 {
+		return nil
+	}
+	return append([]uint64(nil), counts...)
 }
 
 // GetCount returns the estimated count for a key
@@ -119,55 +582,281 @@ func (d *hotKeyDetector) GetCount(key string) uint64 {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
 
+	return d.count(key)
+}
+
+// count returns the count of a key from the configured source, the caller must hold the lock
+func (d *hotKeyDetector) count(key string) uint64 {
+	if d.config.CountSource == CountSourceSpaceSaving {
+		if id := d.trackingKey(key); d.topK.Contains(id) {
+			return d.topK.Count(id)
+		}
+	}
 	return d.sketch.Estimate([]byte(key))
 }
 
+// GetCountWithBounds returns the estimated count for a key and its error bound
+// The bound is derived from the sketch width and the total count, so the estimate always
+// comes from the sketch regardless of CountSource
+func (d *hotKeyDetector) GetCountWithBounds(key string) (estimate, errorBound uint64) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	estimate = d.sketch.Estimate([]byte(key))
+	errorBound = min(d.sketch.ErrorBound(d.total), estimate)
+	return estimate, errorBound
+}
+
 // TopK returns the top K hot keys
 func (d *hotKeyDetector) TopK() []KeyCount {
+	return d.topKeys(d.config.TopK)
+}
+
+// Keys returns every key tracked by the Space-Saving structure
+func (d *hotKeyDetector) Keys() []KeyCount {
+	return d.topKeys(d.config.Capacity)
+}
+
+// topKeys returns the top k keys tracked by the Space-Saving structure in rank order
+// Keys are ranked by their count from the configured source, so with CountSourceCMS the
+// reported keys may differ from the top k of the Space-Saving counts
+func (d *hotKeyDetector) topKeys(k int) []KeyCount {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
 
-	items := d.topK.TopK(d.config.TopK)
-	result := make([]KeyCount, 0, len(items))
+	// The Space-Saving structure already ranks by its counts, the sketch counts need a sort
+	var items []algorithm.Item
+	if d.config.CountSource == CountSourceCMS {
+		items = d.topK.Snapshot()
+		for i := range items {
+			items[i].Count = d.trackedCount(items[i].Key, items[i].Count)
+		}
+		slices.SortFunc(items, func(a, b algorithm.Item) int {
+			return compareRank(a.Key, a.Count, b.Key, b.Count)
+		})
+		items = items[:min(k, len(items))]
+	} else {
+		items = d.topK.TopK(k)
+	}
 
+	result := make([]KeyCount, 0, len(items))
 	for _, item := range items {
-		accurateCount := d.sketch.Estimate([]byte(item.Key))
-		result = append(result, KeyCount{
-			Key:   item.Key,
-			Count: accurateCount, // CMS count instead of Space-Saving count
-		})
+		kc := KeyCount{
+			Key:   d.keyName(item.Key),
+			Count: item.Count,
+		}
+		if c, ok := d.ops[item.Key]; ok {
+			kc.Reads = c.reads
+			kc.Writes = c.writes
+		}
+		result = append(result, kc)
 	}
+	return result
+}
 
-	// Sort by accurate count (descending)
-	for i := 0; i < len(result)-1; i++ {
-		for j := i + 1; j < len(result); j++ {
-			if result[i].Count < result[j].Count {
-				result[i], result[j] = result[j], result[i]
-			}
+// compareRank orders keys by descending count, and keys with the same count by their
+// tracking key so every ranking of the tracked keys agrees, including SpaceSaving.TopK
+func compareRank(idA string, countA uint64, idB string, countB uint64) int {
+	if c := cmp.Compare(countB, countA); c != 0 {
+		return c
+	}
+	return strings.Compare(idA, idB)
+}
+
+// trackedCount returns the count of a key tracked by topK from the configured source,
+// given its Space-Saving count, the caller must hold the lock
+func (d *hotKeyDetector) trackedCount(id string, count uint64) uint64 {
+	if d.config.CountSource == CountSourceCMS {
+		return d.sketch.Estimate([]byte(d.keyName(id)))
+	}
+	return count
+}
+
+// Rank returns the 1-based rank of the key in TopK, or 0 if it isn't one of the top K keys
+func (d *hotKeyDetector) Rank(key string) int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.rank(d.trackingKey(key))
+}
+
+// rank returns the 1-based rank of a tracking key in TopK, or 0 if it isn't one of the
+// top K keys, the caller must hold the lock
+// It counts the tracked keys ranking before it rather than sorting them
+func (d *hotKeyDetector) rank(id string) int {
+	if !d.topK.Contains(id) {
+		return 0
+	}
+
+	count := d.trackedCount(id, d.topK.Count(id))
+	rank := 1
+	d.topK.Range(func(other string, otherCount uint64) bool {
+		if other != id && compareRank(other, d.trackedCount(other, otherCount), id, count) < 0 {
+			rank++
 		}
+		return rank <= d.config.TopK
+	})
+	if rank > d.config.TopK {
+		return 0
 	}
+	return rank
+}
+
+// HourlyTopK returns the top K keys of an hour of day with their Space-Saving counts
+func (d *hotKeyDetector) HourlyTopK(hour int) []KeyCount {
+	if !d.config.HourlyTracking || hour < 0 || hour >= hoursPerDay {
+		return nil
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 
+	items := d.hourly[hour].TopK(d.config.TopK)
+	result := make([]KeyCount, 0, len(items))
+	for _, item := range items {
+		result = append(result, KeyCount{Key: item.Key, Count: item.Count})
+	}
 	return result
 }
 
 // IsHot returns true if the key is considered hot
+// The count and the top-K membership are checked within a single read lock
 func (d *hotKeyDetector) IsHot(key string) bool {
-	count := d.GetCount(key)
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	id := d.trackingKey(key)
+	return d.hot(id, d.count(key), func() int { return d.rank(id) })
+}
+
+// KeyStatus returns the rank, the estimated count and the hotness of the key
+// They're read within a single read lock, and hotness uses the same rank and count
+func (d *hotKeyDetector) KeyStatus(key string) (rank int, count uint64, hot bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	id := d.trackingKey(key)
+	rank, count = d.rank(id), d.count(key)
+	return rank, count, d.hot(id, count, func() int { return rank })
+}
+
+// hot returns true if a key with the given tracking key and count is considered hot,
+// the caller must hold the lock
+// rank is only called if the key's hotness depends on its rank among the top K keys
+func (d *hotKeyDetector) hot(id string, count uint64, rank func() int) bool {
+	qualifies := d.qualifies(id, count, rank)
+	if d.since == nil {
+		return qualifies
+	}
+
+	// Keys that met the other criteria for less than MinHotAge aren't hot yet
+	return d.qualifiedFor(id, qualifies) >= d.config.MinHotAge
+}
+
+// qualifies returns true if a key meets the hot criteria other than MinHotAge,
+// the caller must hold the lock
+func (d *hotKeyDetector) qualifies(id string, count uint64, rank func() int) bool {
+	// No key is hot until there's enough data
+	if !d.warmedUp() {
+		return false
+	}
+
+	// Keys below the floor are never hot
+	if count < d.config.MinHotCount {
+		return false
+	}
 
 	// If a threshold is specified, use it
+	if d.config.HotThresholdPercent > 0 {
+		return float64(count) >= float64(d.total)*d.config.HotThresholdPercent/100
+	}
 	if d.config.HotThreshold > 0 {
 		return count >= d.config.HotThreshold
 	}
 
-	// Otherwise, check if the key is in the top-K
-	topK := d.TopK()
-	for _, kc := range topK {
-		if kc.Key == key {
-			return true
-		}
+	// Otherwise, check if the key is in the top-K, ranked like TopK
+	// The Space-Saving structure holds exactly the top-K candidates unless it tracks more keys
+	if d.config.Capacity == d.config.TopK {
+		return d.topK.Contains(id)
 	}
+	return rank() > 0
+}
+
+// warmedUp returns true once both warmup conditions are met
+func (d *hotKeyDetector) warmedUp() bool {
+	return d.increments >= d.config.WarmupCount && d.now().Sub(d.warmupStart) >= d.config.WarmupDuration
+}
 
-	return false
+// Config returns the effective configuration, with defaults applied
+func (d *hotKeyDetector) Config() Config {
+	return d.config
+}
+
+// TotalCount returns the total (decayed) count of all increments
+func (d *hotKeyDetector) TotalCount() uint64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.total
+}
+
+// Prune stops tracking the keys whose Space-Saving count is below minCount
+func (d *hotKeyDetector) Prune(minCount uint64) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	removed := d.topK.Prune(minCount)
+	if removed > 0 {
+		d.pruneOps()
+	}
+	return removed
+}
+
+// Remove resets the count of a key
+// Keys colliding with it in the sketch may undercount afterwards, since its estimate
+// can include their counts
+func (d *hotKeyDetector) Remove(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	estimate := d.sketch.Estimate([]byte(key))
+	d.sketch.Remove([]byte(key), estimate)
+	d.total -= min(d.total, estimate)
+
+	tracked := d.topK.Remove(d.trackingKey(key))
+	if tracked {
+		d.pruneOps()
+	}
+	return tracked || estimate > 0
+}
+
+// Dropped returns 0, since synchronous increments are never dropped
+func (d *hotKeyDetector) Dropped() uint64 {
+	return 0
+}
+
+// Close stops the periodic reset, if any
+func (d *hotKeyDetector) Close() {
+	d.closeOnce.Do(func() {
+		if d.stop != nil {
+			close(d.stop)
+		}
+	})
+}
+
+// resetPeriodically resets the detector every ResetInterval until Close is called
+func (d *hotKeyDetector) resetPeriodically() {
+	ticker := time.NewTicker(d.config.ResetInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.Reset()
+		case <-d.stop:
+			return
+		}
+	}
 }
 
 // Reset resets the detector
@@ -176,6 +865,35 @@ func (d *hotKeyDetector) Reset() {
 	defer d.mu.Unlock()
 
 	d.sketch.Reset()
-	d.topK = algorithm.NewSpaceSaving(d.config.TopK)
-	d.lastDecay = time.Now()
+	d.topK.Clear()
+	clear(d.ops)
+	clear(d.shards)
+	clear(d.names)
+	clear(d.since)
+	d.total = 0
+	d.increments = 0
+	d.warmupStart = d.now()
+	d.nextDecay = d.now().Add(d.jitteredDecayInterval())
+	for _, bucket := range d.hourly {
+		if bucket != nil {
+			bucket.Clear()
+		}
+	}
+	d.currentHour = time.Time{}
+}
+
+// jitteredDecayInterval returns the decay interval with random jitter applied
+// so that detectors across a fleet don't decay at the same time
+func (d *hotKeyDetector) jitteredDecayInterval() time.Duration {
+	if d.config.DecayJitter <= 0 {
+		return d.decayInterval
+	}
+
+	// Random factor between -jitter and +jitter
+	factor := (rand.Float64()*2 - 1) * d.config.DecayJitter
+	interval := time.Duration(float64(d.decayInterval) * (1 + factor))
+	if interval <= 0 {
+		return d.decayInterval
+	}
+	return interval
 }
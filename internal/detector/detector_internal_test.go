@@ -0,0 +1,354 @@
+package detector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetector_DecayJitter(t *testing.T) {
+	interval := 60 * time.Second
+	d := New(Config{
+		TopK:          10,
+		DecayInterval: interval,
+		DecayJitter:   0.2,
+	}).(*hotKeyDetector)
+
+	minInterval := time.Duration(float64(interval) * 0.8)
+	maxInterval := time.Duration(float64(interval) * 1.2)
+
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		next := d.jitteredDecayInterval()
+		if next < minInterval || next > maxInterval {
+			t.Fatalf("Expected next decay within [%v, %v], got %v", minInterval, maxInterval, next)
+		}
+		seen[next] = true
+	}
+
+	if len(seen) < 2 {
+		t.Error("Expected next decay interval to vary with jitter")
+	}
+}
+
+func TestDetector_DecayWithoutJitter(t *testing.T) {
+	interval := 30 * time.Second
+	d := New(Config{
+		TopK:          10,
+		DecayInterval: interval,
+	}).(*hotKeyDetector)
+
+	for i := 0; i < 10; i++ {
+		if next := d.jitteredDecayInterval(); next != interval {
+			t.Fatalf("Expected decay interval %v without jitter, got %v", interval, next)
+		}
+	}
+}
+
+func TestDetector_DecayDisabled(t *testing.T) {
+	d := New(Config{TopK: 10, DecayFactor: 1, DecayInterval: time.Second}).(*hotKeyDetector)
+
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	d.Increment("key", 100)
+	for i := 0; i < 5; i++ {
+		now = now.Add(2 * time.Second)
+		d.Increment("other", 1)
+	}
+
+	if count := d.GetCount("key"); count != 100 {
+		t.Errorf("Expected count 100 without decay, got %d", count)
+	}
+	if total := d.TotalCount(); total != 105 {
+		t.Errorf("Expected total 105 without decay, got %d", total)
+	}
+	if topK := d.TopK(); len(topK) != 2 || topK[0].Count != 100 || topK[1].Count != 5 {
+		t.Errorf("Expected cumulative counts in the top-K, got %v", topK)
+	}
+
+	// Factors above 1 would grow counts, so they disable decay too
+	if factor := New(Config{DecayFactor: 1.5}).Config().DecayFactor; factor != 1 {
+		t.Errorf("Expected a decay factor above 1 to be clamped to 1, got %v", factor)
+	}
+}
+
+func TestDetector_DecaySpaceSaving(t *testing.T) {
+	d := New(Config{
+		TopK:          10,
+		HotThreshold:  50,
+		CountSource:   CountSourceSpaceSaving,
+		DecayFactor:   0.5,
+		DecayInterval: time.Second,
+	}).(*hotKeyDetector)
+
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	d.Increment("key", 100)
+	if !d.IsHot("key") {
+		t.Fatal("Expected key to be hot before decay")
+	}
+
+	// Increments of another key trigger the decay of every count
+	for i := 0; i < 3; i++ {
+		now = now.Add(2 * time.Second)
+		d.Increment("other", 1)
+	}
+
+	if d.IsHot("key") {
+		t.Errorf("Expected key to stop being hot after decay, got count %d", d.GetCount("key"))
+	}
+	if topK := d.TopK(); len(topK) == 0 || topK[0].Key != "key" || topK[0].Count != 12 {
+		t.Errorf("Expected the Space-Saving count of key to decay to 12, got %v", topK)
+	}
+}
+
+func TestDetector_IncrementCtxCanceled(t *testing.T) {
+	d := New(Config{TopK: 10}).(*hotKeyDetector)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Hold the lock so a non-skipped increment would block
+	d.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		d.IncrementCtx(ctx, "key", 10)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		d.mu.Unlock()
+		t.Fatal("Expected IncrementCtx with a canceled context to return without blocking")
+	}
+	d.mu.Unlock()
+
+	if count := d.GetCount("key"); count != 0 {
+		t.Errorf("Expected increment to be skipped, got count %d", count)
+	}
+
+	d.IncrementCtx(context.Background(), "key", 10)
+	if count := d.GetCount("key"); count != 10 {
+		t.Errorf("Expected count 10 with a live context, got %d", count)
+	}
+}
+
+func TestDetector_HourlyTracking(t *testing.T) {
+	d := New(Config{TopK: 10, HourlyTracking: true}).(*hotKeyDetector)
+
+	now := time.Date(2024, 1, 1, 8, 30, 0, 0, time.Local)
+	d.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	d.IncrementCtx(ctx, "morning", 10)
+
+	// Crossing the hour boundary moves increments to the next bucket
+	now = now.Add(45 * time.Minute) // 09:15
+	d.IncrementCtx(ctx, "rush", 20)
+	d.IncrementCtx(ctx, "morning", 1)
+
+	expectHourly := func(hour int, expected map[string]uint64) {
+		t.Helper()
+		keys := d.HourlyTopK(hour)
+		if len(keys) != len(expected) {
+			t.Fatalf("Expected %d keys at hour %d, got %v", len(expected), hour, keys)
+		}
+		for _, kc := range keys {
+			if want, ok := expected[kc.Key]; !ok || kc.Count != want {
+				t.Errorf("Expected count %d for key %s at hour %d, got %d", want, kc.Key, hour, kc.Count)
+			}
+		}
+	}
+
+	expectHourly(8, map[string]uint64{"morning": 10})
+	expectHourly(9, map[string]uint64{"rush": 20, "morning": 1})
+	expectHourly(10, map[string]uint64{})
+
+	// Entering the same hour on the next day decays what was recorded the previous day
+	now = now.Add(24 * time.Hour) // 09:15 the next day
+	d.IncrementCtx(ctx, "rush", 2)
+	expectHourly(9, map[string]uint64{"rush": 12, "morning": 0})
+
+	// Increments within the current hour don't decay the bucket again
+	now = now.Add(30 * time.Minute) // 09:45
+	d.IncrementCtx(ctx, "rush", 2)
+	expectHourly(9, map[string]uint64{"rush": 14, "morning": 0})
+
+	if keys := d.HourlyTopK(24); keys != nil {
+		t.Errorf("Expected no keys for an out of range hour, got %v", keys)
+	}
+}
+
+func TestDetector_MinHotAge(t *testing.T) {
+	d := New(Config{TopK: 10, HotThreshold: 10, MinHotAge: time.Minute}).(*hotKeyDetector)
+
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.Local)
+	d.now = func() time.Time { return now }
+	d.warmupStart = now
+	d.nextDecay = now.Add(time.Hour)
+
+	d.Increment("spike", 100)
+	if d.IsHot("spike") {
+		t.Error("Expected a freshly hot key to not be hot before MinHotAge")
+	}
+
+	now = now.Add(30 * time.Second)
+	d.Increment("spike", 100)
+	if d.IsHot("spike") {
+		t.Error("Expected the key to not be hot before MinHotAge")
+	}
+
+	// The age counts from when the key first met the threshold, not from its latest access
+	now = now.Add(30 * time.Second)
+	if !d.IsHot("spike") {
+		t.Error("Expected the key to be hot once it's met the threshold for MinHotAge")
+	}
+
+	// Keys that stop meeting the threshold start over
+	d.Remove("spike")
+	if d.IsHot("spike") {
+		t.Error("Expected a reset key to not be hot")
+	}
+	d.Increment("spike", 100)
+	if d.IsHot("spike") {
+		t.Error("Expected a key meeting the threshold again to not be hot before MinHotAge")
+	}
+
+	// Time spent tracked below the threshold doesn't count
+	d.Increment("slow", 5)
+	if d.IsHot("slow") {
+		t.Error("Expected a key below the threshold to not be hot")
+	}
+	now = now.Add(2 * time.Minute)
+	d.Increment("slow", 5)
+	if d.IsHot("slow") {
+		t.Error("Expected a key that just met the threshold to not be hot before MinHotAge")
+	}
+	now = now.Add(time.Minute)
+	if !d.IsHot("slow") {
+		t.Error("Expected the key to be hot once it's met the threshold for MinHotAge")
+	}
+}
+
+func TestDetector_HourlyTrackingDisabled(t *testing.T) {
+	d := New(Config{TopK: 10})
+	d.Increment("key", 1)
+
+	if keys := d.HourlyTopK(time.Now().Hour()); keys != nil {
+		t.Errorf("Expected no hourly keys when hourly tracking is disabled, got %v", keys)
+	}
+}
+
+func TestDetector_HashKeys(t *testing.T) {
+	capacity := 10
+	d := New(Config{TopK: 5, Capacity: capacity, HashKeys: true}).(*hotKeyDetector)
+
+	prefix := strings.Repeat("x", 1024)
+	hotKey := prefix + ":hot"
+	for i := 0; i < 1000; i++ {
+		d.IncrementOp(context.Background(), hotKey, 5, OpRead)
+		d.Increment(fmt.Sprintf("%s:%d", prefix, i), 1)
+	}
+
+	// Only fixed-size hashes are tracked, and names are kept for tracked keys only
+	for _, item := range d.topK.Snapshot() {
+		if len(item.Key) != 8 {
+			t.Fatalf("Expected keys to be tracked by an 8-byte hash, got %d bytes", len(item.Key))
+		}
+	}
+	if len(d.names) > 2*capacity {
+		t.Errorf("Expected at most %d key names to be kept, got %d", 2*capacity, len(d.names))
+	}
+
+	topK := d.TopK()
+	if len(topK) == 0 || topK[0].Key != hotKey {
+		t.Fatalf("Expected the hot key's name first in the top-K, got %v", topK)
+	}
+	if topK[0].Reads != 5000 {
+		t.Errorf("Expected 5000 reads for the hot key, got %d", topK[0].Reads)
+	}
+	if !d.IsHot(hotKey) {
+		t.Error("Expected the hot key to be hot")
+	}
+}
+
+func TestDetector_Async(t *testing.T) {
+	d := New(Config{TopK: 10, HotThreshold: 10, AsyncBufferSize: 100})
+	defer d.Close()
+
+	for i := 0; i < 50; i++ {
+		d.Increment("hot", 1)
+	}
+	d.IncrementOp(context.Background(), "written", 1, OpWrite)
+
+	deadline := time.Now().Add(time.Second)
+	for !d.IsHot("hot") {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected queued increments to be applied")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Close applies the increments still queued
+	d.Close()
+	found := false
+	for _, kc := range d.TopK() {
+		if kc.Key == "written" {
+			found = kc.Writes == 1
+		}
+	}
+	if !found {
+		t.Errorf("Expected 'written' in TopK with 1 write, got %v", d.TopK())
+	}
+	if dropped := d.Dropped(); dropped != 0 {
+		t.Errorf("Expected no dropped increments, got %d", dropped)
+	}
+}
+
+func TestDetector_AsyncDropsWhenFull(t *testing.T) {
+	// Without the draining goroutine, the queue fills up
+	a := &asyncDetector{
+		hotKeyDetector: New(Config{TopK: 10}).(*hotKeyDetector),
+		queue:          make(chan increment, 2),
+	}
+
+	for i := 0; i < 5; i++ {
+		a.Increment("key", 1)
+	}
+
+	if dropped := a.Dropped(); dropped != 3 {
+		t.Errorf("Expected 3 dropped increments, got %d", dropped)
+	}
+	if count := a.GetCount("key"); count != 0 {
+		t.Errorf("Expected queued increments not to be applied yet, got count %d", count)
+	}
+}
+
+func TestDetector_ResetInterval(t *testing.T) {
+	d := New(Config{TopK: 10, DecayFactor: 0.5, ResetInterval: 100 * time.Millisecond})
+	defer d.Close()
+
+	if factor := d.Config().DecayFactor; factor != 1 {
+		t.Errorf("Expected decay to be disabled with ResetInterval, got factor %v", factor)
+	}
+
+	for window := 0; window < 2; window++ {
+		d.Increment("key", 10)
+		if count := d.GetCount("key"); count == 0 {
+			t.Fatalf("Expected a count in window %d", window)
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for d.GetCount("key") != 0 || d.TotalCount() != 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected counts to be reset in window %d", window)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
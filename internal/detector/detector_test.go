@@ -1,6 +1,10 @@
 package detector_test
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
 	"testing"
 	"time"
 
@@ -92,6 +96,67 @@ func TestDetector_TopKResults(t *testing.T) {
 	}
 }
 
+func TestDetector_Rank(t *testing.T) {
+	d := detector.New(detector.Config{TopK: 2, Capacity: 10})
+
+	d.Increment("popular", 100)
+	d.Increment("medium", 50)
+	d.Increment("rare", 10)
+
+	tests := map[string]int{
+		"popular": 1,
+		"medium":  2,
+		"rare":    0, // tracked but not in the top 2
+		"missing": 0,
+	}
+	for key, expected := range tests {
+		if rank := d.Rank(key); rank != expected {
+			t.Errorf("Expected rank %d for %s, got %d", expected, key, rank)
+		}
+	}
+}
+
+func TestDetector_KeyStatus(t *testing.T) {
+	type status struct {
+		rank  int
+		count uint64
+		hot   bool
+	}
+	tests := []struct {
+		name     string
+		source   detector.CountSource
+		expected map[string]status
+	}{
+		// The sketch counts only the accesses to key:c
+		{name: "cms", source: detector.CountSourceCMS, expected: map[string]status{
+			"key:a": {rank: 1, count: 10, hot: true},
+			"key:c": {rank: 0, count: 8, hot: false},
+		}},
+		// key:c inherits the count of the evicted key:b
+		{name: "spacesaving", source: detector.CountSourceSpaceSaving, expected: map[string]status{
+			"key:a": {rank: 0, count: 10, hot: false},
+			"key:c": {rank: 1, count: 13, hot: true},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := detector.New(detector.Config{TopK: 1, Capacity: 2, CountSource: tt.source})
+
+			d.Increment("key:a", 10)
+			d.Increment("key:b", 5)
+			d.Increment("key:c", 8) // Evicts key:b
+
+			for key, expected := range tt.expected {
+				rank, count, hot := d.KeyStatus(key)
+				if got := (status{rank, count, hot}); got != expected {
+					t.Errorf("Expected %s to have status %+v, got %+v", key, expected, got)
+				}
+			}
+		})
+	}
+}
+
 func TestDetector_IsHotWithThreshold(t *testing.T) {
 	config := detector.Config{
 		TopK:          10,
@@ -119,6 +184,161 @@ func TestDetector_IsHotWithThreshold(t *testing.T) {
 	}
 }
 
+func TestDetector_IsHotWithThresholdPercent(t *testing.T) {
+	config := detector.Config{
+		TopK:                10,
+		HotThresholdPercent: 10,
+		DecayInterval:       60 * time.Second,
+	}
+	d := detector.New(config)
+
+	// The key is all of the traffic so far
+	d.Increment("hot_key", 10)
+	if !d.IsHot("hot_key") {
+		t.Error("Expected hot_key to be hot at 100% of the traffic")
+	}
+
+	// Traffic to other keys dilutes it below 10%
+	for i := 0; i < 200; i++ {
+		d.Increment(fmt.Sprintf("key_%d", i), 1)
+	}
+	if d.IsHot("hot_key") {
+		t.Errorf("Expected hot_key to not be hot at %d of %d accesses", d.GetCount("hot_key"), d.TotalCount())
+	}
+
+	// Enough accesses bring it back over the threshold
+	d.Increment("hot_key", 20)
+	if !d.IsHot("hot_key") {
+		t.Errorf("Expected hot_key to be hot at %d of %d accesses", d.GetCount("hot_key"), d.TotalCount())
+	}
+}
+
+func TestDetector_IsHotWithMinHotCount(t *testing.T) {
+	config := detector.Config{
+		TopK:          10,
+		MinHotCount:   20,
+		DecayInterval: 60 * time.Second,
+	}
+	d := detector.New(config)
+
+	// Both keys are in the top-K since only two keys are tracked
+	d.Increment("warm_key", 50)
+	d.Increment("trivial_key", 2)
+
+	if !d.IsHot("warm_key") {
+		t.Error("Expected warm_key to be hot")
+	}
+
+	if d.IsHot("trivial_key") {
+		t.Error("Expected trivial_key below MinHotCount to not be hot")
+	}
+}
+
+func TestDetector_IncrementOp(t *testing.T) {
+	d := detector.New(detector.Config{TopK: 10})
+	ctx := context.Background()
+
+	for i := 0; i < 8; i++ {
+		d.IncrementOp(ctx, "read-heavy", 1, detector.OpRead)
+	}
+	d.IncrementOp(ctx, "read-heavy", 2, detector.OpWrite)
+	d.IncrementOp(ctx, "write-heavy", 1, detector.OpRead)
+	d.IncrementOp(ctx, "write-heavy", 9, detector.OpWrite)
+	d.Increment("untagged", 5)
+
+	counts := make(map[string]detector.KeyCount)
+	for _, kc := range d.TopK() {
+		counts[kc.Key] = kc
+	}
+
+	if kc := counts["read-heavy"]; kc.Count != 10 || kc.Reads != 8 || kc.Writes != 2 {
+		t.Errorf("Expected read-heavy count 10 with 8 reads and 2 writes, got %+v", kc)
+	}
+	if kc := counts["write-heavy"]; kc.Count != 10 || kc.Reads != 1 || kc.Writes != 9 {
+		t.Errorf("Expected write-heavy count 10 with 1 read and 9 writes, got %+v", kc)
+	}
+	if kc := counts["untagged"]; kc.Count != 5 || kc.Reads != 0 || kc.Writes != 0 {
+		t.Errorf("Expected untagged count 5 without reads or writes, got %+v", kc)
+	}
+
+	d.Reset()
+	d.IncrementOp(ctx, "read-heavy", 1, detector.OpRead)
+	if kc := d.TopK()[0]; kc.Reads != 1 || kc.Writes != 0 {
+		t.Errorf("Expected read/write counts to be reset, got %+v", kc)
+	}
+}
+
+func TestDetector_GetCountWithBounds(t *testing.T) {
+	config := detector.Config{
+		ErrorRate:     0.1, // A narrow sketch so that keys collide
+		TopK:          10,
+		DecayInterval: 60 * time.Second,
+	}
+	d := detector.New(config)
+
+	counts := make(map[string]uint64)
+	for i := range 500 {
+		key := fmt.Sprintf("key:%d", i)
+		counts[key] = uint64(i%50 + 1)
+		d.Increment(key, counts[key])
+	}
+
+	overestimated := false
+	for key, count := range counts {
+		estimate, errorBound := d.GetCountWithBounds(key)
+		if estimate != d.GetCount(key) {
+			t.Fatalf("Expected estimate %d to match GetCount for %s", estimate, key)
+		}
+		if count > estimate || count < estimate-errorBound {
+			t.Errorf("Expected true count %d of %s within [%d, %d]", count, key, estimate-errorBound, estimate)
+		}
+		if estimate > count {
+			overestimated = true
+		}
+	}
+
+	if !overestimated {
+		t.Error("Expected the loaded sketch to overestimate some keys")
+	}
+}
+
+func TestDetector_TotalCount(t *testing.T) {
+	d := detector.New(detector.Config{TopK: 2})
+
+	d.Increment("a", 5)
+	d.Increment("b", 3)
+	d.Increment("c", 2) // evicts from topK but still counts toward the total
+
+	if total := d.TotalCount(); total != 10 {
+		t.Errorf("Expected total count 10, got %d", total)
+	}
+
+	d.Reset()
+	if total := d.TotalCount(); total != 0 {
+		t.Errorf("Expected total count 0 after reset, got %d", total)
+	}
+}
+
+func TestDetector_ShardCounts(t *testing.T) {
+	d := detector.New(detector.Config{TopK: 10})
+	d.Increment("hot", 10)
+
+	d.IncrementShard("hot", 0)
+	d.IncrementShard("hot", 2)
+	d.IncrementShard("hot", 2)
+
+	counts := d.ShardCounts("hot")
+	if len(counts) != 3 || counts[0] != 1 || counts[1] != 0 || counts[2] != 2 {
+		t.Errorf("Expected shard counts [1 0 2], got %v", counts)
+	}
+
+	// Keys not tracked by the top-K are ignored
+	d.IncrementShard("unknown", 0)
+	if counts := d.ShardCounts("unknown"); counts != nil {
+		t.Errorf("Expected no shard counts for an untracked key, got %v", counts)
+	}
+}
+
 func TestDetector_Reset(t *testing.T) {
 	config := detector.Config{
 		TopK:          10,
@@ -152,3 +372,410 @@ func TestDetector_Reset(t *testing.T) {
 		t.Errorf("Expected empty top K after reset, got %d keys", len(topK))
 	}
 }
+
+func TestDetector_IsHotDynamic(t *testing.T) {
+	config := detector.Config{
+		TopK:          2,
+		DecayInterval: 60 * time.Second,
+	}
+	d := detector.New(config)
+
+	d.Increment("first", 100)
+	d.Increment("second", 50)
+	d.Increment("third", 1) // Replaces the least frequent key
+
+	if !d.IsHot("first") {
+		t.Error("Expected first to be hot")
+	}
+	if d.IsHot("second") {
+		t.Error("Expected evicted key second to not be hot")
+	}
+	if d.IsHot("unknown") {
+		t.Error("Expected untracked key to not be hot")
+	}
+}
+
+func TestDetector_WarmupCount(t *testing.T) {
+	config := detector.Config{
+		TopK:          10,
+		WarmupCount:   5,
+		DecayInterval: 60 * time.Second,
+	}
+	d := detector.New(config)
+
+	for i := range 4 {
+		d.Increment("first", 1)
+		if d.IsHot("first") {
+			t.Fatalf("Expected no hot key during warmup, got hot after %d increments", i+1)
+		}
+	}
+
+	d.Increment("first", 1)
+	if !d.IsHot("first") {
+		t.Error("Expected first to be hot after warmup")
+	}
+
+	// Reset starts the warmup over
+	d.Reset()
+	d.Increment("first", 1)
+	if d.IsHot("first") {
+		t.Error("Expected no hot key during warmup after reset")
+	}
+}
+
+func TestDetector_WarmupDuration(t *testing.T) {
+	config := detector.Config{
+		TopK:           10,
+		WarmupDuration: 50 * time.Millisecond,
+		DecayInterval:  60 * time.Second,
+	}
+	d := detector.New(config)
+
+	d.Increment("first", 100)
+	if d.IsHot("first") {
+		t.Error("Expected no hot key during warmup")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !d.IsHot("first") {
+		t.Error("Expected first to be hot after warmup")
+	}
+}
+
+func TestDetector_CapacityLargerThanTopK(t *testing.T) {
+	d := detector.New(detector.Config{
+		TopK:          10,
+		Capacity:      100,
+		ErrorRate:     0.0001, // Keys are ranked by their sketch counts, which must not collide
+		DecayInterval: 60 * time.Second,
+	})
+
+	// 100 keys with distinct counts are all tracked, but only the top 10 are reported and hot
+	for i := 1; i <= 100; i++ {
+		d.Increment(fmt.Sprintf("key:%d", i), uint64(i))
+	}
+
+	topK := d.TopK()
+	if len(topK) != 10 {
+		t.Fatalf("Expected 10 reported keys, got %d", len(topK))
+	}
+	for _, kc := range topK {
+		var i int
+		if _, err := fmt.Sscanf(kc.Key, "key:%d", &i); err != nil || i <= 90 {
+			t.Errorf("Expected only key:91 to key:100 to be reported, got %s", kc.Key)
+		}
+	}
+
+	if !d.IsHot("key:91") {
+		t.Error("Expected the 10th key to be hot")
+	}
+	if d.IsHot("key:90") {
+		t.Error("Expected a tracked key outside the top 10 not to be hot")
+	}
+}
+
+func TestDetector_CapacitySmallerThanTopK(t *testing.T) {
+	d := detector.New(detector.Config{
+		TopK:          10,
+		Capacity:      1, // Raised to TopK
+		DecayInterval: 60 * time.Second,
+	})
+
+	for i := 1; i <= 10; i++ {
+		d.Increment(fmt.Sprintf("key:%d", i), uint64(i))
+	}
+
+	if topK := d.TopK(); len(topK) != 10 {
+		t.Errorf("Expected 10 reported keys, got %d", len(topK))
+	}
+	if !d.IsHot("key:1") {
+		t.Error("Expected every top 10 key to be hot")
+	}
+}
+
+// benchmarkKeys returns n keys, built once so that benchmarks don't measure formatting
+func benchmarkKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+	}
+	return keys
+}
+
+func newBenchmarkDetector() detector.Detector {
+	d := detector.New(detector.Config{
+		TopK:          100,
+		DecayInterval: 60 * time.Second,
+	})
+	for i := 0; i < 1000; i++ {
+		d.Increment(fmt.Sprintf("key:%d", i), uint64(i%100+1))
+	}
+	return d
+}
+
+// BenchmarkDetector_IsHot measures the single lock acquisition hot path
+func BenchmarkDetector_IsHot(b *testing.B) {
+	d := newBenchmarkDetector()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.IsHot("key:999")
+	}
+}
+
+// BenchmarkDetector_IsHotThreshold measures IsHot with a fixed threshold
+func BenchmarkDetector_IsHotThreshold(b *testing.B) {
+	d := detector.New(detector.Config{
+		TopK:          100,
+		DecayInterval: 60 * time.Second,
+		HotThreshold:  50,
+	})
+	for i := 0; i < 1000; i++ {
+		d.Increment(fmt.Sprintf("key:%d", i), uint64(i%100+1))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.IsHot("key:999")
+	}
+}
+
+// BenchmarkDetector_Increment measures recording accesses to a skewed key set
+func BenchmarkDetector_Increment(b *testing.B) {
+	d := newBenchmarkDetector()
+	keys := benchmarkKeys(1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Increment(keys[i%len(keys)], 1)
+	}
+}
+
+// BenchmarkDetector_IncrementParallel measures Increment under lock contention
+func BenchmarkDetector_IncrementParallel(b *testing.B) {
+	d := newBenchmarkDetector()
+	keys := benchmarkKeys(1000)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			d.Increment(keys[i%len(keys)], 1)
+			i++
+		}
+	})
+}
+
+// BenchmarkDetector_Reset measures resetting a populated detector, which reuses its allocations
+func BenchmarkDetector_Reset(b *testing.B) {
+	d := newBenchmarkDetector()
+	keys := benchmarkKeys(100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, key := range keys {
+			d.Increment(key, 1)
+		}
+		d.Reset()
+	}
+}
+
+// BenchmarkDetector_GetCountAndTopK measures the previous IsHot implementation,
+// which acquired the lock twice and computed the full top-K on every call
+func BenchmarkDetector_GetCountAndTopK(b *testing.B) {
+	d := newBenchmarkDetector()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = d.GetCount("key:999")
+		for _, kc := range d.TopK() {
+			if kc.Key == "key:999" {
+				break
+			}
+		}
+	}
+}
+
+func TestDetector_CountSource(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      detector.CountSource
+		expectedTop uint64
+		expectedHot bool
+	}{
+		// The sketch counts only the accesses to key:b
+		{name: "cms", source: detector.CountSourceCMS, expectedTop: 1, expectedHot: false},
+		// key:b inherits the count of the evicted key:a
+		{name: "spacesaving", source: detector.CountSourceSpaceSaving, expectedTop: 4, expectedHot: true},
+		// An unknown source falls back to the sketch
+		{name: "unknown", source: "unknown", expectedTop: 1, expectedHot: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := detector.New(detector.Config{
+				TopK:          1,
+				HotThreshold:  4,
+				DecayInterval: 60 * time.Second,
+				CountSource:   tt.source,
+			})
+
+			d.Increment("key:a", 3)
+			d.Increment("key:b", 1)
+
+			topK := d.TopK()
+			if len(topK) != 1 || topK[0].Key != "key:b" {
+				t.Fatalf("Expected key:b to be the only reported key, got %v", topK)
+			}
+			if topK[0].Count != tt.expectedTop {
+				t.Errorf("Expected TopK count %d, got %d", tt.expectedTop, topK[0].Count)
+			}
+			if count := d.GetCount("key:b"); count != tt.expectedTop {
+				t.Errorf("Expected GetCount %d, got %d", tt.expectedTop, count)
+			}
+			if hot := d.IsHot("key:b"); hot != tt.expectedHot {
+				t.Errorf("Expected IsHot %v, got %v", tt.expectedHot, hot)
+			}
+
+			// The evicted key is no longer tracked, so every source reads it from the sketch
+			if count := d.GetCount("key:a"); count != 3 {
+				t.Errorf("Expected GetCount 3 for the evicted key, got %d", count)
+			}
+		})
+	}
+}
+
+func TestDetector_TopKRankedByCountSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   detector.CountSource
+		expected string
+	}{
+		// The sketch counts only the accesses to key:c, so key:a ranks first
+		{name: "cms", source: detector.CountSourceCMS, expected: "key:a"},
+		// key:c inherits the count of the evicted key:b, so it ranks first
+		{name: "spacesaving", source: detector.CountSourceSpaceSaving, expected: "key:c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := detector.New(detector.Config{
+				TopK:          1,
+				Capacity:      2,
+				DecayInterval: 60 * time.Second,
+				CountSource:   tt.source,
+			})
+
+			d.Increment("key:a", 10)
+			d.Increment("key:b", 5)
+			d.Increment("key:c", 8) // Evicts key:b
+
+			topK := d.TopK()
+			if len(topK) != 1 || topK[0].Key != tt.expected {
+				t.Fatalf("Expected %s to be the only reported key, got %v", tt.expected, topK)
+			}
+
+			// IsHot and Rank agree with TopK
+			for _, key := range []string{"key:a", "key:c"} {
+				top := key == tt.expected
+				if hot := d.IsHot(key); hot != top {
+					t.Errorf("Expected IsHot(%s) %v, got %v", key, top, hot)
+				}
+				if rank := d.Rank(key); (rank == 1) != top {
+					t.Errorf("Expected Rank(%s) to be 1 only for the reported key, got %d", key, rank)
+				}
+			}
+		})
+	}
+}
+
+func TestDetector_Prune(t *testing.T) {
+	d := detector.New(detector.Config{
+		TopK:          10,
+		Capacity:      100,
+		DecayInterval: 60 * time.Second,
+	})
+
+	for i := 0; i < 95; i++ {
+		d.Increment(fmt.Sprintf("cold:%d", i), 1)
+	}
+	for i := 0; i < 5; i++ {
+		d.Increment(fmt.Sprintf("hot:%d", i), 100)
+	}
+
+	if removed := d.Prune(10); removed != 95 {
+		t.Errorf("Expected 95 pruned keys, got %d", removed)
+	}
+
+	topK := d.TopK()
+	if len(topK) != 5 {
+		t.Fatalf("Expected the 5 hot keys to remain, got %d keys", len(topK))
+	}
+	for _, kc := range topK {
+		if !strings.HasPrefix(kc.Key, "hot:") {
+			t.Errorf("Expected only hot keys to remain, got %s", kc.Key)
+		}
+	}
+	if !d.IsHot("hot:0") {
+		t.Error("Expected a remaining key to still be hot")
+	}
+	if d.IsHot("cold:0") {
+		t.Error("Expected a pruned key not to be hot")
+	}
+}
+
+func TestParseOperation(t *testing.T) {
+	tests := map[string]detector.Operation{
+		"read":  detector.OpRead,
+		"WRITE": detector.OpWrite,
+		"eval":  detector.OpUnknown,
+		"":      detector.OpUnknown,
+	}
+
+	for op, expected := range tests {
+		if got := detector.ParseOperation(op); got != expected {
+			t.Errorf("ParseOperation(%q): expected %v, got %v", op, expected, got)
+		}
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	// A Zipfian distribution: the i-th key is accessed 100000/i times
+	keys := make([]detector.KeyCount, 5000)
+	for i := range keys {
+		keys[i] = detector.KeyCount{Key: fmt.Sprintf("key:%d", i), Count: uint64(100000 / (i + 1))}
+	}
+
+	fine := detector.Calibrate(detector.Config{TopK: 50, Capacity: 1000, ErrorRate: 0.0001}, keys)
+	coarse := detector.Calibrate(detector.Config{TopK: 50, ErrorRate: 0.05}, keys)
+
+	if fine.Keys != len(keys) {
+		t.Errorf("Expected %d keys, got %d", len(keys), fine.Keys)
+	}
+	if fine.MaxRelativeError > 0.05 {
+		t.Errorf("Expected a max relative error below 5%% with ErrorRate 0.0001, got %v", fine.MaxRelativeError)
+	}
+	if fine.MeanRelativeError > fine.MaxRelativeError {
+		t.Errorf("Expected the mean error %v to be at most the max error %v", fine.MeanRelativeError, fine.MaxRelativeError)
+	}
+	if fine.TopKRecall < 0.9 {
+		t.Errorf("Expected the top-K to be found, got recall %v", fine.TopKRecall)
+	}
+
+	// Tracking only TopK keys loses most of the true top-K among 5000 interleaved keys
+	if coarse.TopKRecall >= fine.TopKRecall {
+		t.Errorf("Expected a lower recall without extra capacity, got %v >= %v", coarse.TopKRecall, fine.TopKRecall)
+	}
+	if coarse.MeanRelativeError <= fine.MeanRelativeError {
+		t.Errorf("Expected a coarser sketch to be less accurate, got %v <= %v", coarse.MeanRelativeError, fine.MeanRelativeError)
+	}
+
+	// The 50th key has a count of 2000, so the suggestion bounds the overcount to 200
+	expected := 0.1 * 2000 / float64(fine.TotalCount)
+	if math.Abs(fine.SuggestedErrorRate-expected) > 1e-12 {
+		t.Errorf("Expected a suggested error rate of %v, got %v", expected, fine.SuggestedErrorRate)
+	}
+	if coarse.SuggestedErrorRate >= 0.05 {
+		t.Errorf("Expected a finer error rate to be suggested than 0.05, got %v", coarse.SuggestedErrorRate)
+	}
+}
@@ -2,8 +2,10 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/mingrammer/keyflare/internal/detector"
 	"github.com/mingrammer/keyflare/internal/metrics"
@@ -17,6 +19,18 @@ var (
 	mu sync.RWMutex
 )
 
+// Lifecycle errors of the global instance, wrapped so that callers can match them with errors.Is
+var (
+	ErrNotInitialized     = errors.New("KeyFlare is not initialized")
+	ErrAlreadyInitialized = errors.New("KeyFlare is already initialized")
+	ErrNotRunning         = errors.New("KeyFlare is not running")
+	ErrAlreadyRunning     = errors.New("KeyFlare is already running")
+
+	// ErrDetectorChanged is returned by Reconfigure when the detector dimensions change,
+	// since the accumulated counts can't be carried over to a differently sized detector
+	ErrDetectorChanged = errors.New("detector dimensions can't be changed without a restart")
+)
+
 // Config contains all configuration options for KeyFlare
 type Config struct {
 	// DetectorConfig configures the hot key detector
@@ -30,15 +44,57 @@ type Config struct {
 
 	// EnableMetrics determines whether to enable metrics collection
 	EnableMetrics bool
+
+	// FailClosed makes reads return a policy error to the caller instead of falling back
+	// to the plain backend call
+	FailClosed bool
+
+	// KeyNormalizer normalizes keys before they are counted and looked up, by the client
+	// wrappers and the metrics API alike, so the detector only ever sees normalized keys
+	KeyNormalizer func(string) string
+
+	// Detector is used instead of creating a detector from DetectorConfig, if set
+	// DetectorConfig still configures the key handling of the wrappers
+	Detector detector.Detector
+
+	// PolicyManager is used instead of creating a manager from PolicyConfig, if set
+	PolicyManager policy.Manager
+
+	// AsyncPopulationLimit is the maximum number of concurrent asynchronous local cache
+	// populations across wrappers (default: DefaultAsyncPopulationLimit)
+	AsyncPopulationLimit int
+
+	// BreakerConfig configures the circuit breaker that bypasses failing policies
+	BreakerConfig policy.BreakerConfig
+
+	// OnDecision is called with the policy decision of every wrapped get and set command
+	// It's meant for debugging, and decisions aren't traced if it's nil
+	OnDecision func(Decision)
 }
 
+// DefaultAsyncPopulationLimit is the default maximum number of concurrent local cache populations
+const DefaultAsyncPopulationLimit = 16
+
 // KeyFlare is the core implementation
 type KeyFlare struct {
 	detector  detector.Detector
-	policy    policy.Manager
-	metrics   metrics.Collector
 	config    Config
 	isRunning bool
+
+	// componentsMu guards the components swapped by Reconfigure
+	componentsMu sync.RWMutex
+	policy       policy.Manager
+	breaker      *policy.Breaker
+	metrics      metrics.Collector
+
+	// populating holds the keys whose local cache population is in flight
+	populating sync.Map
+	// populations bounds the number of concurrent populations
+	populations chan struct{}
+	// replicating holds the keys whose shard replication after a look-aside miss is in flight
+	replicating sync.Map
+	// replications holds the generation of the latest shard replication of each split key
+	replications sync.Map
 }
 
 // New creates and returns the global KeyFlare instance
@@ -47,36 +103,121 @@ func New(config Config) error {
 	defer mu.Unlock()
 
 	if globalInstance != nil {
-		return fmt.Errorf("KeyFlare is already initialized")
+		return ErrAlreadyInitialized
 	}
 
 	// Create detector
-	d := detector.New(config.DetectorConfig)
+	d := config.Detector
+	if d == nil {
+		d = detector.New(config.DetectorConfig)
+	}
 
-	// Create policy manager
-	p, err := policy.New(config.PolicyConfig)
+	p, b, m, err := newComponents(config, d)
 	if err != nil {
-		return fmt.Errorf("failed to create policy manager: %w", err)
+		return err
+	}
+
+	if config.AsyncPopulationLimit <= 0 {
+		config.AsyncPopulationLimit = DefaultAsyncPopulationLimit
+	}
+
+	globalInstance = &KeyFlare{
+		detector:    d,
+		policy:      p,
+		breaker:     b,
+		metrics:     m,
+		config:      config,
+		isRunning:   false,
+		populations: make(chan struct{}, config.AsyncPopulationLimit),
 	}
 
+	return nil
+}
+
+// newComponents creates the policy manager, circuit breaker and metrics collector
+// around the detector
+func newComponents(config Config, d detector.Detector) (policy.Manager, *policy.Breaker, metrics.Collector, error) {
+	// Create policy manager
+	p := config.PolicyManager
+	if p == nil {
+		var err error
+		p, err = policy.New(config.PolicyConfig)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to create policy manager: %w", err)
+		}
+	}
+
+	b := policy.NewBreaker(config.BreakerConfig)
+
 	// Create metrics collector
 	var m metrics.Collector
 	if config.EnableMetrics {
 		m = metrics.New(config.MetricsConfig)
 		// Set detector for metrics collection
 		m.SetDetector(d)
+		m.SetPolicyManager(p)
+		m.SetBreaker(b)
+		m.SetKeyNormalizer(config.KeyNormalizer)
 	} else {
 		m = metrics.NewNoop()
 	}
 
-	globalInstance = &KeyFlare{
-		detector:  d,
-		policy:    p,
-		metrics:   m,
-		config:    config,
-		isRunning: false,
+	return p, b, m, nil
+}
+
+// Reconfigure replaces the policy manager, circuit breaker and metrics collector of the
+// global instance, keeping the running detector and its accumulated counts
+// The detector's ErrorRate, TopK and Capacity can't change (ErrDetectorChanged), and the other
+// detector options, KeyNormalizer, FailClosed, AsyncPopulationLimit and OnDecision keep their
+// current values
+func Reconfigure(config Config) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if globalInstance == nil {
+		return fmt.Errorf("%w. Call New() first", ErrNotInitialized)
+	}
+	kf := globalInstance
+
+	current := kf.config.DetectorConfig
+	if config.DetectorConfig.ErrorRate != current.ErrorRate ||
+		config.DetectorConfig.TopK != current.TopK ||
+		config.DetectorConfig.Capacity != current.Capacity {
+		return ErrDetectorChanged
 	}
+	// The counts are keyed by normalized keys, so the new collector must normalize them the same way
+	config.KeyNormalizer = kf.config.KeyNormalizer
 
+	p, b, m, err := newComponents(config, kf.detector)
+	if err != nil {
+		return err
+	}
+
+	// Restart metrics collection with the new collector
+	if kf.isRunning {
+		if err := kf.Metrics().Stop(); err != nil {
+			return fmt.Errorf("failed to stop metrics collector: %w", err)
+		}
+		if err := m.Start(); err != nil {
+			// The instance keeps its components, so it keeps collecting metrics with them
+			if restartErr := kf.Metrics().Start(); restartErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to restart previous metrics collector: %w", restartErr))
+			}
+			return fmt.Errorf("failed to start metrics collector: %w", err)
+		}
+	}
+
+	kf.componentsMu.Lock()
+	defer kf.componentsMu.Unlock()
+
+	kf.policy = p
+	kf.breaker = b
+	kf.metrics = m
+	kf.config.PolicyConfig = config.PolicyConfig
+	kf.config.PolicyManager = config.PolicyManager
+	kf.config.BreakerConfig = config.BreakerConfig
+	kf.config.MetricsConfig = config.MetricsConfig
+	kf.config.EnableMetrics = config.EnableMetrics
 	return nil
 }
 
@@ -86,11 +227,11 @@ func Start() error {
 	defer mu.Unlock()
 
 	if globalInstance == nil {
-		return fmt.Errorf("KeyFlare is not initialized. Call New() first")
+		return fmt.Errorf("%w. Call New() first", ErrNotInitialized)
 	}
 
 	if globalInstance.isRunning {
-		return fmt.Errorf("KeyFlare is already running")
+		return ErrAlreadyRunning
 	}
 
 	// Start metrics collector
@@ -110,7 +251,7 @@ func Stop() error {
 	defer mu.Unlock()
 
 	if globalInstance == nil {
-		return fmt.Errorf("KeyFlare is not initialized")
+		return ErrNotInitialized
 	}
 
 	if globalInstance.isRunning {
@@ -123,6 +264,8 @@ func Stop() error {
 		globalInstance.isRunning = false
 	}
 
+	// Apply the queued async increments and stop their goroutine
+	globalInstance.detector.Close()
 	globalInstance = nil
 	return nil
 }
@@ -134,11 +277,11 @@ func GetInstance() (*KeyFlare, error) {
 	defer mu.RUnlock()
 
 	if globalInstance == nil {
-		return nil, fmt.Errorf("KeyFlare is not initialized. Call New() first")
+		return nil, fmt.Errorf("%w. Call New() first", ErrNotInitialized)
 	}
 
 	if !globalInstance.isRunning {
-		return nil, fmt.Errorf("KeyFlare is not running. Call Start() first")
+		return nil, fmt.Errorf("%w. Call Start() first", ErrNotRunning)
 	}
 
 	return globalInstance, nil
@@ -151,10 +294,114 @@ func (kf *KeyFlare) Detector() detector.Detector {
 
 // PolicyManager returns the policy manager
 func (kf *KeyFlare) PolicyManager() policy.Manager {
+	kf.componentsMu.RLock()
+	defer kf.componentsMu.RUnlock()
 	return kf.policy
 }
 
+// Breaker returns the circuit breaker around policy application
+func (kf *KeyFlare) Breaker() *policy.Breaker {
+	kf.componentsMu.RLock()
+	defer kf.componentsMu.RUnlock()
+	return kf.breaker
+}
+
 // Metrics returns the metrics collector
 func (kf *KeyFlare) Metrics() metrics.Collector {
+	kf.componentsMu.RLock()
+	defer kf.componentsMu.RUnlock()
 	return kf.metrics
 }
+
+// NormalizeKey applies the configured key normalizer to the key
+// Wrappers must use the normalized key for both counting and policy lookup
+func (kf *KeyFlare) NormalizeKey(key string) string {
+	if kf.config.KeyNormalizer == nil {
+		return key
+	}
+	return kf.config.KeyNormalizer(key)
+}
+
+// Countable returns whether accesses to the normalized key are counted
+// With CountOnlyEligible, only keys a policy applies to are counted
+func (kf *KeyFlare) Countable(key string) bool {
+	if !kf.config.DetectorConfig.CountOnlyEligible {
+		return true
+	}
+	return kf.PolicyManager().GetPolicy(key) != nil
+}
+
+// MemberKey returns the composite "key:member" key counted for a member of a sorted set,
+// or false if member-level counting is disabled
+func (kf *KeyFlare) MemberKey(key, member string) (string, bool) {
+	if !kf.config.DetectorConfig.MemberGranularity {
+		return "", false
+	}
+	return key + ":" + member, true
+}
+
+// CapToBackendTTL returns whether wrappers must cap the TTL of locally cached values
+// to the remaining TTL of the key in the backend
+func (kf *KeyFlare) CapToBackendTTL() bool {
+	kf.componentsMu.RLock()
+	defer kf.componentsMu.RUnlock()
+
+	params, ok := kf.config.PolicyConfig.Parameters.(policy.LocalCacheConfig)
+	return ok && params.CapToBackendTTL
+}
+
+// FailClosed returns whether wrappers should return policy errors instead of falling back to the backend
+func (kf *KeyFlare) FailClosed() bool {
+	return kf.config.FailClosed
+}
+
+// PopulateAsync runs populate in a new goroutine to fill the local cache for the normalized key
+// Populations of a key already in flight are coalesced, and populations over the
+// AsyncPopulationLimit are dropped, so a miss storm can't spawn unbounded goroutines.
+// A dropped population is retried by a later miss.
+func (kf *KeyFlare) PopulateAsync(key string, populate func()) {
+	if _, inFlight := kf.populating.LoadOrStore(key, struct{}{}); inFlight {
+		return
+	}
+
+	select {
+	case kf.populations <- struct{}{}:
+	default:
+		kf.populating.Delete(key)
+		return
+	}
+
+	go func() {
+		defer func() {
+			<-kf.populations
+			kf.populating.Delete(key)
+		}()
+		populate()
+	}()
+}
+
+// ReplicateAsync runs replicate in a new goroutine to fill the shards of the normalized key
+// after a look-aside miss. Replications of a key already in flight are skipped, since the
+// shards will be filled shortly, so concurrent misses on a hot key don't each rewrite every shard
+func (kf *KeyFlare) ReplicateAsync(key string, replicate func()) {
+	if _, inFlight := kf.replicating.LoadOrStore(key, struct{}{}); inFlight {
+		return
+	}
+
+	go func() {
+		defer kf.replicating.Delete(key)
+		replicate()
+	}()
+}
+
+// StartReplication records the start of a shard replication of the normalized key and returns
+// a function reporting whether a newer replication of the key has started since, so the shard
+// writes of a superseded value are dropped instead of being retried over the newer value
+func (kf *KeyFlare) StartReplication(key string) (superseded func() bool) {
+	v, _ := kf.replications.LoadOrStore(key, new(atomic.Uint64))
+	generation := v.(*atomic.Uint64)
+	started := generation.Add(1)
+	return func() bool {
+		return generation.Load() != started
+	}
+}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mingrammer/keyflare/internal/metrics"
+	"github.com/mingrammer/keyflare/internal/policy"
+)
+
+// startTestKeyFlare creates and starts the global instance, stopping it when the test finishes
+func startTestKeyFlare(t *testing.T, config Config) *KeyFlare {
+	t.Helper()
+
+	config.PolicyConfig = policy.Config{
+		Type:       policy.LocalCache,
+		Parameters: policy.LocalCacheConfig{TTL: 60, Capacity: 100},
+	}
+	if err := New(config); err != nil {
+		t.Fatalf("Failed to create KeyFlare: %v", err)
+	}
+	if err := Start(); err != nil {
+		t.Fatalf("Failed to start KeyFlare: %v", err)
+	}
+	t.Cleanup(func() { Stop() })
+
+	kf, err := GetInstance()
+	if err != nil {
+		t.Fatalf("Failed to get KeyFlare instance: %v", err)
+	}
+	return kf
+}
+
+func TestKeyFlare_PopulateAsyncBounded(t *testing.T) {
+	kf := startTestKeyFlare(t, Config{AsyncPopulationLimit: 4})
+
+	var running, started, peak atomic.Int64
+	release := make(chan struct{})
+	populate := func() {
+		started.Add(1)
+		n := running.Add(1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		<-release
+		running.Add(-1)
+	}
+
+	// Simulate a miss storm over many keys
+	for i := 0; i < 100; i++ {
+		kf.PopulateAsync(fmt.Sprintf("key:%d", i), populate)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if got := started.Load(); got != 4 {
+		t.Errorf("Expected 4 populations to run, got %d", got)
+	}
+	if got := peak.Load(); got > 4 {
+		t.Errorf("Expected at most 4 concurrent populations, got %d", got)
+	}
+
+	close(release)
+	waitFor(t, func() bool { return running.Load() == 0 })
+
+	// Slots are released once populations finish
+	done := make(chan struct{})
+	kf.PopulateAsync("key:0", func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected population to run after slots were released")
+	}
+}
+
+func TestKeyFlare_PopulateAsyncCoalesces(t *testing.T) {
+	kf := startTestKeyFlare(t, Config{})
+
+	var started atomic.Int64
+	release := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		kf.PopulateAsync("key", func() {
+			started.Add(1)
+			<-release
+		})
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	if got := started.Load(); got != 1 {
+		t.Errorf("Expected 1 population for concurrent misses of the same key, got %d", got)
+	}
+}
+
+func TestReconfigure_KeepsMetricsOnFailedStart(t *testing.T) {
+	// Reserve an address for the running collector and occupy another one
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve an address: %v", err)
+	}
+	address := free.Addr().String()
+	free.Close()
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to occupy an address: %v", err)
+	}
+	defer busy.Close()
+
+	config := Config{EnableMetrics: true, MetricsConfig: metrics.Config{MetricServerAddress: address}}
+	startTestKeyFlare(t, config)
+
+	config.PolicyConfig = policy.Config{Type: policy.LocalCache, Parameters: policy.LocalCacheConfig{TTL: 60, Capacity: 100}}
+	config.MetricsConfig.MetricServerAddress = busy.Addr().String()
+	if err := Reconfigure(config); err == nil {
+		t.Fatal("Expected Reconfigure to fail on an address in use")
+	}
+
+	// The previous collector is serving again
+	resp, err := http.Get("http://" + address + "/metrics")
+	if err != nil {
+		t.Fatalf("Expected the previous metrics server to be restarted: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+}
+
+// waitFor polls the condition until it's true or fails the test after a second
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Condition not met before timeout")
+}
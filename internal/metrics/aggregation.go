@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mingrammer/keyflare/internal/detector"
+)
+
+// hotKeyGroup contains the aggregated count of hot keys matching a pattern
+type hotKeyGroup struct {
+	Pattern string `json:"pattern"`
+	Count   uint64 `json:"count"`
+	Keys    int    `json:"keys"`
+}
+
+// keyAggregator groups hot keys by pattern templates such as "user:*"
+type keyAggregator struct {
+	patterns []string
+	regexps  []*regexp.Regexp
+}
+
+// newKeyAggregator creates a new aggregator for the given pattern templates.
+// A "*" in a template matches any sequence of characters, everything else is
+// matched literally.
+func newKeyAggregator(patterns []string) *keyAggregator {
+	a := &keyAggregator{
+		patterns: make([]string, 0, len(patterns)),
+		regexps:  make([]*regexp.Regexp, 0, len(patterns)),
+	}
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		a.patterns = append(a.patterns, pattern)
+		a.regexps = append(a.regexps, compileTemplate(pattern))
+	}
+	return a
+}
+
+// compileTemplate compiles a pattern template into an anchored regular expression
+func compileTemplate(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// Aggregate returns the aggregated counts for each pattern in configuration order.
+// Each key is attributed to the first pattern it matches.
+func (a *keyAggregator) Aggregate(keys []detector.KeyCount) []hotKeyGroup {
+	if len(a.patterns) == 0 {
+		return nil
+	}
+
+	groups := make([]hotKeyGroup, len(a.patterns))
+	for i, pattern := range a.patterns {
+		groups[i].Pattern = pattern
+	}
+
+	for _, kc := range keys {
+		for i, r := range a.regexps {
+			if r.MatchString(kc.Key) {
+				groups[i].Count += kc.Count
+				groups[i].Keys++
+				break
+			}
+		}
+	}
+
+	return groups
+}
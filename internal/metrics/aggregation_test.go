@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mingrammer/keyflare/internal/detector"
+)
+
+func TestKeyAggregator_Aggregate(t *testing.T) {
+	aggregator := newKeyAggregator([]string{"user:*", "product:*:details"})
+
+	keys := []detector.KeyCount{}
+	for i := 0; i < 50; i++ {
+		keys = append(keys, detector.KeyCount{
+			Key:   fmt.Sprintf("user:%d", i),
+			Count: 10,
+		})
+	}
+	keys = append(keys,
+		detector.KeyCount{Key: "product:1:details", Count: 30},
+		detector.KeyCount{Key: "product:2:details", Count: 20},
+		detector.KeyCount{Key: "product:2:reviews", Count: 100},
+		detector.KeyCount{Key: "users", Count: 100},
+	)
+
+	groups := aggregator.Aggregate(keys)
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %d", len(groups))
+	}
+
+	if groups[0].Pattern != "user:*" {
+		t.Errorf("Expected first group 'user:*', got '%s'", groups[0].Pattern)
+	}
+	if groups[0].Count != 500 {
+		t.Errorf("Expected user:* count 500, got %d", groups[0].Count)
+	}
+	if groups[0].Keys != 50 {
+		t.Errorf("Expected user:* to contain 50 keys, got %d", groups[0].Keys)
+	}
+
+	if groups[1].Count != 50 {
+		t.Errorf("Expected product:*:details count 50, got %d", groups[1].Count)
+	}
+	if groups[1].Keys != 2 {
+		t.Errorf("Expected product:*:details to contain 2 keys, got %d", groups[1].Keys)
+	}
+}
+
+func TestKeyAggregator_NoPatterns(t *testing.T) {
+	aggregator := newKeyAggregator(nil)
+
+	groups := aggregator.Aggregate([]detector.KeyCount{{Key: "user:1", Count: 10}})
+	if groups != nil {
+		t.Errorf("Expected nil groups without patterns, got %v", groups)
+	}
+}
+
+func TestMetricServer_HandleHotKeys_Groups(t *testing.T) {
+	config := Config{
+		Namespace:           "test",
+		MetricServerAddress: ":0",
+		HotKeyMetricLimit:   10,
+		HotKeyHistorySize:   5,
+		AggregationPatterns: []string{"user:*"},
+	}
+
+	server := newMetricServer(config)
+
+	hotKeys := []detector.KeyCount{}
+	for i := 0; i < 20; i++ {
+		hotKeys = append(hotKeys, detector.KeyCount{
+			Key:   fmt.Sprintf("user:%d", i),
+			Count: 5,
+		})
+	}
+	hotKeys = append(hotKeys, detector.KeyCount{Key: "config:global", Count: 40})
+	server.UpdateHotKeys(hotKeys)
+
+	req := httptest.NewRequest("GET", "/hot-keys?limit=1", nil)
+	w := httptest.NewRecorder()
+
+	server.handleHotKeys(w, req)
+
+	var response hotKeysResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	// Groups are aggregated over the whole snapshot regardless of the limit
+	if len(response.Groups) != 1 {
+		t.Fatalf("Expected 1 group, got %d", len(response.Groups))
+	}
+	if response.Groups[0].Pattern != "user:*" || response.Groups[0].Count != 100 {
+		t.Errorf("Expected user:* with count 100, got %s with count %d",
+			response.Groups[0].Pattern, response.Groups[0].Count)
+	}
+
+	// The group metric should be registered and exposed
+	families, err := server.registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+
+	found := false
+	for _, family := range families {
+		if family.GetName() != "test_hot_key_groups" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if m.GetGauge().GetValue() == 100 {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Error("Expected test_hot_key_groups metric with value 100")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/mingrammer/keyflare/internal/detector"
+	"github.com/mingrammer/keyflare/internal/policy"
 )
 
 const (
@@ -13,6 +14,13 @@ const (
 	DefaultCollectionInterval = 15 * time.Second
 )
 
+// DefaultHotKeyCountBuckets are the default bucket boundaries of the hot key count histogram
+var DefaultHotKeyCountBuckets = []float64{10, 50, 100, 500, 1000, 5000, 10000, 50000, 100000}
+
+// operationDurationBuckets are the bucket boundaries of the operation duration histogram,
+// fine-grained below a millisecond where local cache hits fall
+var operationDurationBuckets = []float64{.00001, .00005, .0001, .0005, .001, .0025, .005, .01, .025, .05, .1, .5, 1}
+
 // Config contains configuration options for metrics
 type Config struct {
 	// Namespace is the namespace for metrics
@@ -24,11 +32,55 @@ type Config struct {
 	// CollectionInterval is the interval at which metrics are collected
 	CollectionInterval time.Duration
 
+	// HistorySnapshotInterval is the interval at which hot key snapshots are added to the history
+	// It determines the resolution of the time series API (default: CollectionInterval)
+	HistorySnapshotInterval time.Duration
+
 	// HotKeyMetricLimit is the number of hot keys to expose as metrics (default: 10)
 	HotKeyMetricLimit int
 
+	// MinMetricCount is the count below which hot keys aren't exposed as metrics, so marginally
+	// warm keys flapping in and out of the top-K don't churn the metric's series (0 means no floor)
+	MinMetricCount uint64
+
+	// TrendDeadBand is the percentage of change from the previous count within which
+	// the trend of a hot key is "stable" rather than "rising" or "falling" (0 means any change)
+	TrendDeadBand float64
+
 	// HotKeyHistorySize is the number of historical snapshots to keep (default: 10)
 	HotKeyHistorySize int
+
+	// HotKeyHistoryKeyLimit is the number of top keys kept in each historical snapshot (0 keeps all)
+	// The history holds up to HotKeyHistorySize * HotKeyHistoryKeyLimit keys, and the
+	// hot keys API can't report more keys than it
+	HotKeyHistoryKeyLimit int
+
+	// HotKeyCountBuckets are the bucket boundaries of the hot key count histogram
+	// (default: DefaultHotKeyCountBuckets)
+	HotKeyCountBuckets []float64
+
+	// AggregationPatterns is a list of pattern templates (e.g. "user:*") used to
+	// report aggregated counts of hot keys that share the same template
+	AggregationPatterns []string
+
+	// APIToken is the bearer token required by sensitive endpoints such as /cache-keys
+	// Those endpoints are disabled if it's empty
+	APIToken string
+
+	// ExportFilePath is the file the hot keys are written to as JSON at every ExportInterval
+	// The file is replaced atomically, and nothing is exported if it's empty
+	ExportFilePath string
+
+	// ExportInterval is the interval at which the hot keys are exported (default: CollectionInterval)
+	ExportInterval time.Duration
+
+	// PruneMinCount makes each collection stop tracking keys whose count is below it,
+	// freeing detector capacity for hotter keys (0 disables pruning)
+	PruneMinCount uint64
+
+	// OnCollect is called with the current top keys and the collection time at
+	// the end of each collection cycle (e.g. to export snapshots to an external store)
+	OnCollect func(keys []detector.KeyCount, collectedAt time.Time)
 }
 
 // Collector defines the interface for metrics collection
@@ -39,12 +91,31 @@ type Collector interface {
 	// RecordPolicyApplication records a policy application
 	RecordPolicyApplication(policy string, success bool)
 
+	// RecordReplicationError records a shard write that failed after all its attempts
+	RecordReplicationError()
+
+	// RecordOperationDuration records the duration of a wrapped operation, and whether
+	// it was served from the local cache rather than the backend
+	RecordOperationDuration(operation string, local bool, duration time.Duration)
+
 	// UpdateHotKeys updates the hot keys metric
 	UpdateHotKeys(hotKeys []detector.KeyCount)
 
 	// SetDetector sets the detector for metrics collection
 	SetDetector(d detector.Detector)
 
+	// SetPolicyManager sets the policy manager inspected by the API
+	SetPolicyManager(m policy.Manager)
+
+	// SetBreaker sets the policy circuit breaker whose state is exposed
+	SetBreaker(b *policy.Breaker)
+
+	// SetKeyNormalizer sets the normalizer applied to keys passed to the API
+	SetKeyNormalizer(normalize func(string) string)
+
+	// LastCollection returns the time of the latest hot keys snapshot (zero if none)
+	LastCollection() time.Time
+
 	// Start starts the metrics collector
 	Start() error
 
@@ -77,7 +148,13 @@ type noopCollector struct{}
 
 func (c *noopCollector) RecordKeyAccess(key string)                          {}
 func (c *noopCollector) RecordPolicyApplication(policy string, success bool) {}
+func (c *noopCollector) RecordReplicationError()                             {}
+func (c *noopCollector) RecordOperationDuration(string, bool, time.Duration) {}
 func (c *noopCollector) UpdateHotKeys(hotKeys []detector.KeyCount)           {}
 func (c *noopCollector) SetDetector(d detector.Detector)                     {}
+func (c *noopCollector) SetPolicyManager(m policy.Manager)                   {}
+func (c *noopCollector) SetBreaker(b *policy.Breaker)                        {}
+func (c *noopCollector) SetKeyNormalizer(normalize func(string) string)      {}
+func (c *noopCollector) LastCollection() time.Time                           { return time.Time{} }
 func (c *noopCollector) Start() error                                        { return nil }
 func (c *noopCollector) Stop() error                                         { return nil }
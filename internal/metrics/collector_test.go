@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"fmt"
+	"slices"
 	"testing"
 	"time"
 
@@ -126,6 +128,137 @@ func TestMetricServer_UpdateHotKeys(t *testing.T) {
 	}
 }
 
+func TestMetricServer_MinMetricCount(t *testing.T) {
+	server := newMetricServer(Config{
+		Namespace:         "test",
+		HotKeyMetricLimit: 10,
+		MinMetricCount:    50,
+	})
+
+	server.UpdateHotKeys([]detector.KeyCount{
+		{Key: "key1", Count: 100},
+		{Key: "key2", Count: 50},
+		{Key: "key3", Count: 49},
+		{Key: "key4", Count: 10},
+	})
+
+	families, err := server.registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+
+	var exposed []string
+	for _, family := range families {
+		if family.GetName() != "test_hot_keys" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			exposed = append(exposed, metric.GetLabel()[0].GetValue())
+		}
+	}
+
+	slices.Sort(exposed)
+	if !slices.Equal(exposed, []string{"key1", "key2"}) {
+		t.Errorf("Expected only keys with a count of at least 50 to be exposed, got %v", exposed)
+	}
+
+	// The floor only applies to the metric, the history keeps every key
+	if snapshot := server.hotKeyHistory.GetLatest(); len(snapshot.keys) != 4 {
+		t.Errorf("Expected 4 keys in the history, got %d", len(snapshot.keys))
+	}
+}
+
+func TestMetricServer_DynamicHotThreshold(t *testing.T) {
+	server := newMetricServer(Config{Namespace: "test"})
+
+	det := detector.New(detector.Config{TopK: 3, Capacity: 3})
+	for i, count := range []uint64{40, 25, 70, 10} {
+		det.Increment(fmt.Sprintf("key%d", i), count)
+	}
+	server.SetDetector(det)
+	server.collectMetrics()
+
+	var want uint64
+	for i, kc := range det.TopK() {
+		if i == 0 || kc.Count < want {
+			want = kc.Count
+		}
+	}
+
+	threshold := func() float64 {
+		families, err := server.registry.Gather()
+		if err != nil {
+			t.Fatalf("Failed to gather metrics: %v", err)
+		}
+		for _, family := range families {
+			if family.GetName() == "test_dynamic_hot_threshold" {
+				return family.GetMetric()[0].GetGauge().GetValue()
+			}
+		}
+		t.Fatal("Expected the dynamic_hot_threshold metric")
+		return 0
+	}
+
+	if got := threshold(); want == 0 || got != float64(want) {
+		t.Errorf("Expected dynamic_hot_threshold %d, got %v", want, got)
+	}
+
+	// No keys means no threshold
+	server.UpdateHotKeys(nil)
+	if got := threshold(); got != 0 {
+		t.Errorf("Expected dynamic_hot_threshold 0 without hot keys, got %v", got)
+	}
+}
+
+func TestMetricServer_HotKeyCountHistogram(t *testing.T) {
+	config := Config{
+		Namespace:          "test",
+		HotKeyMetricLimit:  10,
+		HotKeyHistorySize:  5,
+		HotKeyCountBuckets: []float64{10, 100, 1000},
+	}
+
+	server := newMetricServer(config)
+
+	server.UpdateHotKeys([]detector.KeyCount{
+		{Key: "key1", Count: 5000},
+		{Key: "key2", Count: 500},
+		{Key: "key3", Count: 50},
+		{Key: "key4", Count: 5},
+	})
+
+	families, err := server.registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+
+	for _, mf := range families {
+		if mf.GetName() != "test_hot_key_count" {
+			continue
+		}
+
+		histogram := mf.GetMetric()[0].GetHistogram()
+		if histogram.GetSampleCount() != 4 {
+			t.Errorf("Expected 4 observations, got %d", histogram.GetSampleCount())
+		}
+		if histogram.GetSampleSum() != 5555 {
+			t.Errorf("Expected sum 5555, got %v", histogram.GetSampleSum())
+		}
+
+		// Buckets are cumulative: <=10, <=100, <=1000
+		expected := []uint64{1, 2, 3}
+		for i, bucket := range histogram.GetBucket() {
+			if bucket.GetCumulativeCount() != expected[i] {
+				t.Errorf("Expected bucket le=%v to have %d observations, got %d",
+					bucket.GetUpperBound(), expected[i], bucket.GetCumulativeCount())
+			}
+		}
+		return
+	}
+
+	t.Error("Expected hot_key_count histogram to be registered")
+}
+
 func TestMetricServer_SetDetector(t *testing.T) {
 	config := Config{
 		Namespace:           "test",
@@ -191,6 +324,78 @@ func TestHotKeyHistory_Add(t *testing.T) {
 	}
 }
 
+func TestHotKeyHistory_KeyMetaBounded(t *testing.T) {
+	maxSize := 5
+	keysPerSnapshot := 10
+	history := newHotKeyHistory(maxSize)
+
+	// Every snapshot contains distinct keys
+	for i := 0; i < 50; i++ {
+		keys := make([]detector.KeyCount, 0, keysPerSnapshot)
+		for j := 0; j < keysPerSnapshot; j++ {
+			keys = append(keys, detector.KeyCount{
+				Key:   fmt.Sprintf("key:%d:%d", i, j),
+				Count: uint64(j + 1),
+			})
+		}
+		history.Add(keys)
+		time.Sleep(time.Millisecond)
+	}
+
+	if len(history.keyMeta) > maxSize*keysPerSnapshot {
+		t.Errorf("Expected at most %d metadata entries, got %d", maxSize*keysPerSnapshot, len(history.keyMeta))
+	}
+
+	// Keys in retained snapshots keep their metadata
+	if _, ok := history.keyMeta["key:49:0"]; !ok {
+		t.Error("Expected metadata for key in the latest snapshot")
+	}
+	if _, ok := history.keyMeta["key:0:0"]; ok {
+		t.Error("Expected metadata for key only in evicted snapshots to be removed")
+	}
+}
+
+func TestHotKeyHistory_KeyLimit(t *testing.T) {
+	maxSize := 20
+	keyLimit := 10
+	topK := 1000
+	history := newHotKeyHistory(maxSize)
+	history.keyLimit = keyLimit
+
+	// Every snapshot contains a large top-K of distinct keys
+	for i := 0; i < 2*maxSize; i++ {
+		keys := make([]detector.KeyCount, 0, topK)
+		for j := 0; j < topK; j++ {
+			keys = append(keys, detector.KeyCount{
+				Key:   fmt.Sprintf("key:%d:%d", i, j),
+				Count: uint64(topK - j),
+			})
+		}
+		history.Add(keys)
+		time.Sleep(time.Millisecond)
+	}
+
+	retained := 0
+	for _, snapshot := range history.snapshots {
+		if cap(snapshot.keys) > keyLimit {
+			t.Fatalf("Expected snapshots to hold at most %d keys, got capacity %d", keyLimit, cap(snapshot.keys))
+		}
+		retained += len(snapshot.keys)
+	}
+	if retained > maxSize*keyLimit {
+		t.Errorf("Expected at most %d retained keys, got %d", maxSize*keyLimit, retained)
+	}
+	if len(history.keyMeta) > maxSize*keyLimit {
+		t.Errorf("Expected at most %d metadata entries, got %d", maxSize*keyLimit, len(history.keyMeta))
+	}
+
+	// The top keys are the ones kept
+	latest := history.GetLatest()
+	if latest.keys[0].Key != fmt.Sprintf("key:%d:0", 2*maxSize-1) {
+		t.Errorf("Expected the top key to be kept, got %s", latest.keys[0].Key)
+	}
+}
+
 func TestHotKeyHistory_GetLatest_Empty(t *testing.T) {
 	history := newHotKeyHistory(5)
 
@@ -392,6 +597,30 @@ func TestHotKeyHistory_Metadata(t *testing.T) {
 	}
 }
 
+func TestHotKeyHistory_PrevCountSkipCycle(t *testing.T) {
+	history := newHotKeyHistory(5)
+
+	history.Add([]detector.KeyCount{{Key: "flaky", Count: 50}, {Key: "steady", Count: 10}})
+	history.Add([]detector.KeyCount{{Key: "steady", Count: 20}})
+	history.Add([]detector.KeyCount{{Key: "flaky", Count: 70}, {Key: "steady", Count: 30}})
+
+	snapshot := history.GetLatest()
+
+	// The key was absent from the preceding snapshot, so it has no previous count
+	if meta := snapshot.keyMeta["flaky"]; meta.prevCount != 0 {
+		t.Errorf("Expected flaky PrevCount 0 after skipping a cycle, got %d", meta.prevCount)
+	}
+	if meta := snapshot.keyMeta["steady"]; meta.prevCount != 20 {
+		t.Errorf("Expected steady PrevCount 20, got %d", meta.prevCount)
+	}
+
+	// FirstSeen is still preserved for the returning key
+	first := history.snapshots[0].keyMeta["flaky"].firstSeen
+	if !snapshot.keyMeta["flaky"].firstSeen.Equal(first) {
+		t.Error("FirstSeen should be preserved for a key returning after a skipped cycle")
+	}
+}
+
 func TestConfig_Defaults(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -467,10 +696,28 @@ func TestMetricServer_CollectMetrics(t *testing.T) {
 	// Call collectMetrics
 	server.collectMetrics()
 
+	// Collection only updates the metrics, history snapshots are taken separately
+	if server.hotKeyHistory.GetLatest() != nil {
+		t.Error("Expected no snapshot after collectMetrics")
+	}
+
+	families, err := server.registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() == "test_top_k_keys_count" && mf.GetMetric()[0].GetGauge().GetValue() != 2 {
+			t.Errorf("Expected top_k_keys_count 2, got %v", mf.GetMetric()[0].GetGauge().GetValue())
+		}
+	}
+
+	// Call snapshotHistory
+	server.snapshotHistory()
+
 	// Check that history was updated
 	snapshot := server.hotKeyHistory.GetLatest()
 	if snapshot == nil {
-		t.Fatal("Expected snapshot after collectMetrics")
+		t.Fatal("Expected snapshot after snapshotHistory")
 	}
 
 	if len(snapshot.keys) == 0 {
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mingrammer/keyflare/internal/detector"
+)
+
+// exportedKey contains a hot key written to the export file
+type exportedKey struct {
+	Key    string `json:"key"`
+	Count  uint64 `json:"count"`
+	Reads  uint64 `json:"reads"`
+	Writes uint64 `json:"writes"`
+}
+
+// exportSnapshot is the content of the export file
+type exportSnapshot struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Keys      []exportedKey `json:"keys"`
+}
+
+// exportHotKeys writes the current hot keys to the export file as JSON
+func (s *metricServer) exportHotKeys() {
+	if s.detector == nil {
+		return
+	}
+
+	if err := writeExportFile(s.config.ExportFilePath, s.detector.TopK(), time.Now()); err != nil {
+		s.exportErrorTotal.Inc()
+		log.Printf("Failed to export hot keys to %s: %v", s.config.ExportFilePath, err)
+	}
+}
+
+// writeExportFile writes the hot keys to path atomically
+// The snapshot is written to a temporary file in the same directory and renamed over path,
+// so readers never see a partially written file
+func writeExportFile(path string, hotKeys []detector.KeyCount, exportedAt time.Time) error {
+	snapshot := exportSnapshot{
+		Timestamp: exportedAt,
+		Keys:      make([]exportedKey, 0, len(hotKeys)),
+	}
+	for _, kc := range hotKeys {
+		snapshot.Keys = append(snapshot.Keys, exportedKey{
+			Key:    kc.Key,
+			Count:  kc.Count,
+			Reads:  kc.Reads,
+			Writes: kc.Writes,
+		})
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode hot keys: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	// CreateTemp creates the file readable by the owner only, unlike a file written in place
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set temporary file permissions: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mingrammer/keyflare/internal/detector"
+)
+
+func TestMetricServer_ExportFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hot-keys.json")
+	server := newMetricServer(Config{
+		Namespace:           "test",
+		MetricServerAddress: ":0",
+		CollectionInterval:  time.Hour, // Never collected during the test
+		ExportFilePath:      path,
+		ExportInterval:      20 * time.Millisecond,
+	})
+
+	det := detector.New(detector.Config{TopK: 10})
+	det.Increment("hot", 100)
+	det.Increment("warm", 10)
+	server.SetDetector(det)
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := server.Stop(); err != nil {
+		t.Errorf("Failed to stop server: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read export file: %v", err)
+	}
+
+	var snapshot exportSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatalf("Failed to parse export file: %v", err)
+	}
+	if snapshot.Timestamp.IsZero() {
+		t.Error("Expected the export time to be set")
+	}
+	if len(snapshot.Keys) != 2 || snapshot.Keys[0].Key != "hot" || snapshot.Keys[0].Count != 100 {
+		t.Errorf("Expected hot and warm keys ordered by count, got %v", snapshot.Keys)
+	}
+
+	// The export file is readable by other users, like a file written in place
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat export file: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o644 {
+		t.Errorf("Expected export file mode 0644, got %o", mode)
+	}
+
+	// Only the export file is left, temporary files are renamed over it
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("Failed to read export directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the export file, got %d entries", len(entries))
+	}
+}
+
+func TestWriteExportFile_InvalidDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "hot-keys.json")
+	if err := writeExportFile(path, nil, time.Now()); err == nil {
+		t.Error("Expected an error for a missing directory")
+	}
+}
+
+func TestMetricServer_ExportError(t *testing.T) {
+	server := newMetricServer(Config{
+		Namespace:      "test",
+		ExportFilePath: filepath.Join(t.TempDir(), "missing", "hot-keys.json"),
+	})
+	server.SetDetector(detector.New(detector.Config{TopK: 10}))
+
+	server.exportHotKeys()
+
+	families, err := server.registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() == "test_export_errors_total" {
+			if value := mf.GetMetric()[0].GetCounter().GetValue(); value != 1 {
+				t.Errorf("Expected 1 export error, got %v", value)
+			}
+			return
+		}
+	}
+	t.Error("Expected test_export_errors_total to be registered")
+}
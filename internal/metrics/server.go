@@ -1,19 +1,30 @@
 package metrics
 
 import (
+	"cmp"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/mingrammer/keyflare/internal/detector"
+	"github.com/mingrammer/keyflare/internal/policy"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// maxCacheKeysLimit bounds the number of keys returned by the cache keys API
+const maxCacheKeysLimit = 1000
+
 // hotKeyInfo contains detailed information about a hot key (for API responses)
 type hotKeyInfo struct {
 	Key       string    `json:"key"`
@@ -22,6 +33,55 @@ type hotKeyInfo struct {
 	FirstSeen time.Time `json:"first_seen"`
 	LastSeen  time.Time `json:"last_seen"`
 	Trend     string    `json:"trend"` // "rising", "falling", "stable", "new"
+	Reads     uint64    `json:"reads"`
+	Writes    uint64    `json:"writes"`
+}
+
+// shardInfo contains the access count of a shard of a split key (for API responses)
+type shardInfo struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// shardsResponse is the API response for the shard distribution of a split key
+type shardsResponse struct {
+	Key    string      `json:"key"`
+	Total  uint64      `json:"total"`
+	Shards []shardInfo `json:"shards"`
+}
+
+// cacheKeyInfo contains the state of a key held in the local cache (for API responses)
+type cacheKeyInfo struct {
+	Key           string  `json:"key"`
+	TTLRemaining  float64 `json:"ttl_remaining"` // seconds until expiration, negative if expired
+	ShouldRefresh bool    `json:"should_refresh"`
+}
+
+// cacheKeysResponse is the API response for the keys held in the local cache
+type cacheKeysResponse struct {
+	Timestamp time.Time      `json:"timestamp"`
+	Total     int            `json:"total"` // number of cached keys, which may exceed len(Keys)
+	Keys      []cacheKeyInfo `json:"keys"`
+}
+
+// trackedKeyInfo contains the counts of a key tracked by the detector (for API responses)
+type trackedKeyInfo struct {
+	Key    string `json:"key"`
+	Count  uint64 `json:"count"`
+	Reads  uint64 `json:"reads"`
+	Writes uint64 `json:"writes"`
+}
+
+// keysResponse is the API response for the keys tracked by the detector
+type keysResponse struct {
+	Timestamp time.Time        `json:"timestamp"`
+	Keys      []trackedKeyInfo `json:"keys"`
+}
+
+// keyResetResponse is the API response for the reset of a key's count
+type keyResetResponse struct {
+	Key   string `json:"key"`
+	Reset bool   `json:"reset"` // false if the key wasn't counted
 }
 
 // hotKeysResponse is the API response for hot keys
@@ -33,6 +93,36 @@ type hotKeysResponse struct {
 	QueryLimit  int              `json:"query_limit"`
 	ActualLimit int              `json:"actual_limit"`
 	TimeSeries  []timeSeriesData `json:"time_series,omitempty"`
+	Groups      []hotKeyGroup    `json:"groups,omitempty"`
+}
+
+// moverInfo contains the rate change of a hot key (for API responses)
+type moverInfo struct {
+	Key        string  `json:"key"`
+	Count      uint64  `json:"count"`
+	Rate       float64 `json:"rate"`        // count per second in the latest interval
+	RateChange float64 `json:"rate_change"` // rate difference from the previous interval
+}
+
+// moversResponse is the API response for keys with the biggest rate change
+type moversResponse struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Rising    []moverInfo `json:"rising"`
+	Falling   []moverInfo `json:"falling"`
+}
+
+// hourlyKeyInfo contains the count of a key (for the hourly API response)
+type hourlyKeyInfo struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// hourlyKeysResponse is the API response comparing the current hot keys to the usual ones of an hour
+type hourlyKeysResponse struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Hour      int             `json:"hour"`
+	Current   []hourlyKeyInfo `json:"current"` // hot right now
+	Usual     []hourlyKeyInfo `json:"usual"`   // usually hot at the hour
 }
 
 // timeSeriesData represents hot key counts over time
@@ -47,7 +137,7 @@ type timeSeriesData struct {
 type keyMetadata struct {
 	firstSeen time.Time
 	lastSeen  time.Time
-	prevCount uint64
+	prevCount uint64 // count in the immediately preceding snapshot, 0 if absent
 }
 
 // hotKeySnapshot represents a snapshot of hot keys at a point in time
@@ -63,6 +153,12 @@ type hotKeyHistory struct {
 	snapshots []hotKeySnapshot
 	maxSize   int
 	keyMeta   map[string]keyMetadata
+
+	// keyLimit is the number of top keys kept per snapshot (0 keeps all)
+	keyLimit int
+
+	// now returns the current time, replaced by tests to control the clock
+	now func() time.Time
 }
 
 // newHotKeyHistory creates a new hot key history tracker
@@ -74,6 +170,7 @@ func newHotKeyHistory(maxSize int) *hotKeyHistory {
 		snapshots: make([]hotKeySnapshot, 0, maxSize),
 		maxSize:   maxSize,
 		keyMeta:   make(map[string]keyMetadata),
+		now:       time.Now,
 	}
 }
 
@@ -82,22 +179,33 @@ func (h *hotKeyHistory) Add(keys []detector.KeyCount) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	now := time.Now()
+	now := h.now()
+
+	// Counts from the immediately preceding snapshot, used for trend calculation
+	prevCounts := make(map[string]uint64)
+	if len(h.snapshots) > 0 {
+		for _, kc := range h.snapshots[len(h.snapshots)-1].keys {
+			prevCounts[kc.Key] = kc.Count
+		}
+	}
+
+	// Keep only the top keys, copied so the full list can be freed
+	if h.keyLimit > 0 && len(keys) > h.keyLimit {
+		keys = append([]detector.KeyCount(nil), keys[:h.keyLimit]...)
+	}
 
 	// Update key metadata
-	currentMeta := make(map[string]keyMetadata)
+	currentMeta := make(map[string]keyMetadata, len(keys))
 	for _, kc := range keys {
 		existing, ok := h.keyMeta[kc.Key]
 		if !ok {
 			// New key
 			existing = keyMetadata{
 				firstSeen: now,
-				lastSeen:  now,
-				prevCount: 0,
 			}
-		} else {
-			existing.lastSeen = now
 		}
+		existing.lastSeen = now
+		existing.prevCount = prevCounts[kc.Key]
 		currentMeta[kc.Key] = existing
 		h.keyMeta[kc.Key] = existing
 	}
@@ -115,13 +223,13 @@ func (h *hotKeyHistory) Add(keys []detector.KeyCount) {
 	// Remove old snapshots if necessary
 	if len(h.snapshots) > h.maxSize {
 		h.snapshots = h.snapshots[1:]
-	}
 
-	// Update previous counts for next iteration
-	for _, kc := range keys {
-		if meta, ok := h.keyMeta[kc.Key]; ok {
-			meta.prevCount = kc.Count
-			h.keyMeta[kc.Key] = meta
+		// Evict metadata of keys that don't appear in any retained snapshot
+		oldest := h.snapshots[0].timestamp
+		for key, meta := range h.keyMeta {
+			if meta.lastSeen.Before(oldest) {
+				delete(h.keyMeta, key)
+			}
 		}
 	}
 }
@@ -137,6 +245,24 @@ func (h *hotKeyHistory) GetLatest() *hotKeySnapshot {
 	return &h.snapshots[len(h.snapshots)-1]
 }
 
+// RecentKeys returns the keys appearing in any of the last n snapshots
+func (h *hotKeyHistory) RecentKeys(n int) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var keys []string
+	for i := max(len(h.snapshots)-n, 0); i < len(h.snapshots); i++ {
+		for _, kc := range h.snapshots[i].keys {
+			if !seen[kc.Key] {
+				seen[kc.Key] = true
+				keys = append(keys, kc.Key)
+			}
+		}
+	}
+	return keys
+}
+
 // GetTimeSeries returns time series data for specified keys
 func (h *hotKeyHistory) GetTimeSeries(keys []string, maxPoints int) []timeSeriesData {
 	h.mu.RLock()
@@ -222,18 +348,34 @@ func (h *hotKeyHistory) GetTimeSeries(keys []string, maxPoints int) []timeSeries
 type metricServer struct {
 	config           Config
 	detector         detector.Detector
+	policyManager    policy.Manager
+	breaker          *policy.Breaker
+	normalizeKey     func(string) string
 	registry         *prometheus.Registry
 	server           *http.Server
 	collectionTicker *time.Ticker
+	historyTicker    *time.Ticker
+	exportTicker     *time.Ticker
 	stopChan         chan struct{}
+	stopOnce         sync.Once
 	wg               sync.WaitGroup
 	hotKeyHistory    *hotKeyHistory
+	aggregator       *keyAggregator
 
 	// Prometheus metrics
 	keyAccessTotal         *prometheus.CounterVec
 	policyApplicationTotal *prometheus.CounterVec
+	replicationErrorTotal  prometheus.Counter
+	exportErrorTotal       prometheus.Counter
+	operationDuration      *prometheus.HistogramVec
 	hotKeys                *prometheus.GaugeVec
 	topKKeysCount          prometheus.Gauge
+	dynamicHotThreshold    prometheus.Gauge
+	hotKeyGroups           *prometheus.GaugeVec
+	hotKeyCount            prometheus.Histogram
+	localCacheBytes        prometheus.Gauge
+	policyBreakerState     prometheus.Gauge
+	detectorConfig         *prometheus.GaugeVec
 }
 
 // newCollectorServer creates a new metric server
@@ -245,6 +387,13 @@ func newMetricServer(config Config) *metricServer {
 		namespace = "keyflare"
 	}
 
+	if config.HistorySnapshotInterval <= 0 {
+		config.HistorySnapshotInterval = config.CollectionInterval
+	}
+	if config.ExportInterval <= 0 {
+		config.ExportInterval = config.CollectionInterval
+	}
+
 	// Create essential metrics
 	keyAccessTotal := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -264,6 +413,32 @@ func newMetricServer(config Config) *metricServer {
 		[]string{"policy", "success"},
 	)
 
+	replicationErrorTotal := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "shard_replication_errors_total",
+			Help:      "Total number of shard writes that failed after all retries",
+		},
+	)
+
+	exportErrorTotal := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "export_errors_total",
+			Help:      "Total number of hot key exports that failed to be written to the export file",
+		},
+	)
+
+	operationDuration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of the wrapped cache operations, by whether they were served locally or by the backend",
+			Buckets:   operationDurationBuckets,
+		},
+		[]string{"operation", "source"},
+	)
+
 	hotKeys := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: namespace,
@@ -281,26 +456,128 @@ func newMetricServer(config Config) *metricServer {
 		},
 	)
 
+	dynamicHotThreshold := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "dynamic_hot_threshold",
+			Help:      "Smallest count in the top K list, the effective hot threshold when no fixed threshold is set",
+		},
+	)
+
+	hotKeyGroups := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "hot_key_groups",
+			Help:      "Aggregated counts of hot keys grouped by pattern",
+		},
+		[]string{"pattern"},
+	)
+
+	buckets := config.HotKeyCountBuckets
+	if len(buckets) == 0 {
+		buckets = DefaultHotKeyCountBuckets
+	}
+
+	hotKeyCount := prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "hot_key_count",
+			Help:      "Distribution of the counts of the top K keys, observed at each collection",
+			Buckets:   buckets,
+		},
+	)
+
+	localCacheBytes := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "local_cache_bytes",
+			Help:      "Estimated memory taken by the local cache items in bytes",
+		},
+	)
+
+	policyBreakerState := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "policy_breaker_state",
+			Help:      "State of the policy circuit breaker (0: closed, 1: open, 2: half-open)",
+		},
+	)
+
+	detectorConfig := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "detector_config",
+			Help:      "Effective configuration of the hot key detector, as labels of a constant 1",
+		},
+		[]string{
+			"topk", "capacity", "error_rate", "decay_factor", "decay_interval",
+			"hot_threshold", "hot_threshold_percent", "min_hot_count", "min_hot_age", "count_source",
+		},
+	)
+
+	history := newHotKeyHistory(config.HotKeyHistorySize)
+	history.keyLimit = config.HotKeyHistoryKeyLimit
+
 	// Register metrics
 	registry.MustRegister(keyAccessTotal)
 	registry.MustRegister(policyApplicationTotal)
+	registry.MustRegister(replicationErrorTotal)
+	registry.MustRegister(exportErrorTotal)
+	registry.MustRegister(operationDuration)
 	registry.MustRegister(hotKeys)
 	registry.MustRegister(topKKeysCount)
-
-	return &metricServer{
+	registry.MustRegister(dynamicHotThreshold)
+	registry.MustRegister(hotKeyGroups)
+	registry.MustRegister(hotKeyCount)
+	registry.MustRegister(localCacheBytes)
+	registry.MustRegister(policyBreakerState)
+	registry.MustRegister(detectorConfig)
+
+	s := &metricServer{
 		config:                 config,
 		detector:               nil,
 		registry:               registry,
 		server:                 nil,
 		collectionTicker:       nil,
+		historyTicker:          nil,
 		stopChan:               make(chan struct{}),
 		wg:                     sync.WaitGroup{},
-		hotKeyHistory:          newHotKeyHistory(config.HotKeyHistorySize),
+		hotKeyHistory:          history,
+		aggregator:             newKeyAggregator(config.AggregationPatterns),
 		keyAccessTotal:         keyAccessTotal,
 		policyApplicationTotal: policyApplicationTotal,
+		replicationErrorTotal:  replicationErrorTotal,
+		exportErrorTotal:       exportErrorTotal,
+		operationDuration:      operationDuration,
 		hotKeys:                hotKeys,
 		topKKeysCount:          topKKeysCount,
+		dynamicHotThreshold:    dynamicHotThreshold,
+		hotKeyGroups:           hotKeyGroups,
+		hotKeyCount:            hotKeyCount,
+		localCacheBytes:        localCacheBytes,
+		policyBreakerState:     policyBreakerState,
+		detectorConfig:         detectorConfig,
 	}
+
+	// Read the dropped increments from the detector when scraped, since it counts them itself
+	registry.MustRegister(prometheus.NewCounterFunc(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "detector_dropped_increments_total",
+			Help:      "Total number of increments dropped because the async detector buffer was full",
+		},
+		s.droppedIncrements,
+	))
+
+	return s
+}
+
+// droppedIncrements returns the number of increments dropped by the detector
+func (s *metricServer) droppedIncrements() float64 {
+	if s.detector == nil {
+		return 0
+	}
+	return float64(s.detector.Dropped())
 }
 
 // RecordKeyAccess records a key access
@@ -317,11 +594,30 @@ func (s *metricServer) RecordPolicyApplication(policy string, success bool) {
 	s.policyApplicationTotal.WithLabelValues(policy, successStr).Inc()
 }
 
+// RecordReplicationError records a shard write that failed after all its attempts
+func (s *metricServer) RecordReplicationError() {
+	s.replicationErrorTotal.Inc()
+}
+
+// RecordOperationDuration records the duration of a wrapped operation
+func (s *metricServer) RecordOperationDuration(operation string, local bool, duration time.Duration) {
+	source := "backend"
+	if local {
+		source = "local"
+	}
+	s.operationDuration.WithLabelValues(operation, source).Observe(duration.Seconds())
+}
+
 // UpdateHotKeys updates the hot keys metric and history
 func (s *metricServer) UpdateHotKeys(hotKeys []detector.KeyCount) {
 	// Update history for API
 	s.hotKeyHistory.Add(hotKeys)
 
+	s.updateHotKeyMetrics(hotKeys)
+}
+
+// updateHotKeyMetrics updates the hot keys metrics without touching the history
+func (s *metricServer) updateHotKeyMetrics(hotKeys []detector.KeyCount) {
 	// Reset the hot keys metric
 	s.hotKeys.Reset()
 
@@ -331,9 +627,10 @@ func (s *metricServer) UpdateHotKeys(hotKeys []detector.KeyCount) {
 		limit = 10 // default
 	}
 
-	// Update metrics for top P keys only
+	// Update metrics for top P keys only, down to the minimum count
+	// The hot keys are sorted by descending count, so the rest are below it too
 	for i, kc := range hotKeys {
-		if i >= limit {
+		if i >= limit || kc.Count < s.config.MinMetricCount {
 			break
 		}
 		s.hotKeys.WithLabelValues(kc.Key).Set(float64(kc.Count))
@@ -341,22 +638,127 @@ func (s *metricServer) UpdateHotKeys(hotKeys []detector.KeyCount) {
 
 	// Update the total count
 	s.topKKeysCount.Set(float64(len(hotKeys)))
+
+	// In dynamic mode a key is hot if it's in the top K, so the smallest count is the cutoff
+	var threshold uint64
+	for i, kc := range hotKeys {
+		if i == 0 || kc.Count < threshold {
+			threshold = kc.Count
+		}
+	}
+	s.dynamicHotThreshold.Set(float64(threshold))
+
+	// Observe the count of every top K key to show how skewed the distribution is
+	for _, kc := range hotKeys {
+		s.hotKeyCount.Observe(float64(kc.Count))
+	}
+
+	// Update the aggregated counts per pattern
+	for _, group := range s.aggregator.Aggregate(hotKeys) {
+		s.hotKeyGroups.WithLabelValues(group.Pattern).Set(float64(group.Count))
+	}
 }
 
 // SetDetector sets the detector for metrics collection
 func (s *metricServer) SetDetector(d detector.Detector) {
 	s.detector = d
+	s.updateDetectorConfig()
+}
+
+// updateDetectorConfig exposes the effective detector configuration as an info metric,
+// so misconfigured instances stand out when comparing a fleet
+func (s *metricServer) updateDetectorConfig() {
+	s.detectorConfig.Reset()
+	if s.detector == nil {
+		return
+	}
+
+	config := s.detector.Config()
+	s.detectorConfig.WithLabelValues(
+		strconv.Itoa(config.TopK),
+		strconv.Itoa(config.Capacity),
+		strconv.FormatFloat(config.ErrorRate, 'g', -1, 64),
+		strconv.FormatFloat(config.DecayFactor, 'g', -1, 64),
+		config.DecayInterval.String(),
+		strconv.FormatUint(config.HotThreshold, 10),
+		strconv.FormatFloat(config.HotThresholdPercent, 'g', -1, 64),
+		strconv.FormatUint(config.MinHotCount, 10),
+		config.MinHotAge.String(),
+		string(config.CountSource),
+	).Set(1)
+}
+
+// SetPolicyManager sets the policy manager inspected by the API
+func (s *metricServer) SetPolicyManager(m policy.Manager) {
+	s.policyManager = m
+}
+
+// SetBreaker sets the policy circuit breaker whose state is exposed
+func (s *metricServer) SetBreaker(b *policy.Breaker) {
+	s.breaker = b
+}
+
+// SetKeyNormalizer sets the normalizer applied to keys passed to the API
+func (s *metricServer) SetKeyNormalizer(normalize func(string) string) {
+	s.normalizeKey = normalize
+}
+
+// LastCollection returns the time of the latest hot keys snapshot
+func (s *metricServer) LastCollection() time.Time {
+	if snapshot := s.hotKeyHistory.GetLatest(); snapshot != nil {
+		return snapshot.timestamp
+	}
+	return time.Time{}
 }
 
 // collectMetrics collects metrics from the detector and updates Prometheus metrics
 func (s *metricServer) collectMetrics() {
 	// Update hot keys
 	if s.detector != nil {
+		if s.config.PruneMinCount > 0 {
+			s.detector.Prune(s.config.PruneMinCount)
+		}
+
 		hotKeys := s.detector.TopK()
-		s.UpdateHotKeys(hotKeys)
+		s.updateHotKeyMetrics(hotKeys)
+
+		if s.config.OnCollect != nil {
+			go s.notifyCollect(hotKeys, time.Now())
+		}
+	}
+
+	// Update the local cache memory estimate
+	if s.policyManager != nil {
+		if stats, ok := s.policyManager.CacheStats(); ok {
+			s.localCacheBytes.Set(float64(stats.Bytes))
+		}
+	}
+
+	// Update the policy circuit breaker state
+	if s.breaker != nil {
+		s.policyBreakerState.Set(float64(s.breaker.State()))
 	}
 }
 
+// snapshotHistory adds the current hot keys to the history used by the API
+func (s *metricServer) snapshotHistory() {
+	if s.detector != nil {
+		s.hotKeyHistory.Add(s.detector.TopK())
+	}
+}
+
+// notifyCollect invokes the OnCollect callback, recovering from panics so that
+// a failing sink doesn't stop metrics collection
+func (s *metricServer) notifyCollect(hotKeys []detector.KeyCount, collectedAt time.Time) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("OnCollect callback panicked: %v", r)
+		}
+	}()
+
+	s.config.OnCollect(hotKeys, collectedAt)
+}
+
 // handleHotKeys handles the hot keys API endpoint
 func (s *metricServer) handleHotKeys(w http.ResponseWriter, r *http.Request) {
 	// Parse query parameters
@@ -376,6 +778,26 @@ func (s *metricServer) handleHotKeys(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Filter the keys by pattern if requested
+	var pattern *regexp.Regexp
+	if p := r.URL.Query().Get("pattern"); p != "" {
+		var err error
+		if pattern, err = regexp.Compile(p); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid pattern: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Sort by count descending unless requested otherwise
+	var compare func(a, b hotKeyInfo) int
+	if sortBy := r.URL.Query().Get("sort"); sortBy != "" {
+		var ok bool
+		if compare, ok = hotKeyComparator(sortBy); !ok {
+			http.Error(w, "Invalid sort, expected count, first_seen, last_seen or trend, optionally prefixed with -", http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Get latest snapshot
 	snapshot := s.hotKeyHistory.GetLatest()
 	if snapshot == nil {
@@ -392,17 +814,22 @@ func (s *metricServer) handleHotKeys(w http.ResponseWriter, r *http.Request) {
 
 	// Convert to HotKeyInfo with enriched data
 	hotKeys := make([]hotKeyInfo, 0, len(snapshot.keys))
-	topKeyNames := make([]string, 0, limit) // For time series
 	for i, kc := range snapshot.keys {
-		// Apply limit
-		if i >= limit {
+		// Keys are sorted by count, so the limit can be applied right away
+		if compare == nil && len(hotKeys) >= limit {
 			break
 		}
+		// Filtered keys keep their rank among all keys
+		if pattern != nil && !pattern.MatchString(kc.Key) {
+			continue
+		}
 
 		info := hotKeyInfo{
-			Key:   kc.Key,
-			Count: kc.Count,
-			Rank:  i + 1,
+			Key:    kc.Key,
+			Count:  kc.Count,
+			Rank:   i + 1,
+			Reads:  kc.Reads,
+			Writes: kc.Writes,
 		}
 
 		// Add metadata
@@ -411,19 +838,21 @@ func (s *metricServer) handleHotKeys(w http.ResponseWriter, r *http.Request) {
 			info.LastSeen = meta.lastSeen
 
 			// Determine trend
-			if meta.prevCount == 0 {
-				info.Trend = "new"
-			} else if kc.Count > meta.prevCount {
-				info.Trend = "rising"
-			} else if kc.Count < meta.prevCount {
-				info.Trend = "falling"
-			} else {
-				info.Trend = "stable"
-			}
+			info.Trend = classifyTrend(kc.Count, meta.prevCount, s.config.TrendDeadBand)
 		}
 
 		hotKeys = append(hotKeys, info)
-		topKeyNames = append(topKeyNames, kc.Key)
+	}
+
+	// Sort all keys before applying the limit
+	if compare != nil {
+		slices.SortStableFunc(hotKeys, compare)
+		hotKeys = hotKeys[:min(len(hotKeys), limit)]
+	}
+
+	topKeyNames := make([]string, 0, len(hotKeys)) // For time series
+	for _, info := range hotKeys {
+		topKeyNames = append(topKeyNames, info.Key)
 	}
 
 	// Create response
@@ -434,6 +863,7 @@ func (s *metricServer) handleHotKeys(w http.ResponseWriter, r *http.Request) {
 		Keys:        hotKeys,
 		QueryLimit:  limit,
 		ActualLimit: len(hotKeys),
+		Groups:      s.aggregator.Aggregate(snapshot.keys),
 	}
 
 	// Add time series data if requested
@@ -455,6 +885,311 @@ func (s *metricServer) handleHotKeys(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// classifyTrend returns the trend of a key from its current and previous counts
+// Changes within deadBand percent of the previous count are stable, so jitter doesn't flap the trend
+func classifyTrend(count, prevCount uint64, deadBand float64) string {
+	if prevCount == 0 {
+		return "new"
+	}
+
+	change := (float64(count) - float64(prevCount)) / float64(prevCount) * 100
+	switch {
+	case count > prevCount && change > deadBand:
+		return "rising"
+	case count < prevCount && -change > deadBand:
+		return "falling"
+	default:
+		return "stable"
+	}
+}
+
+// trendOrder ranks trends for sorting, from the most to the least surging
+var trendOrder = map[string]int{
+	"new":     0,
+	"rising":  1,
+	"stable":  2,
+	"falling": 3,
+}
+
+// hotKeyComparator returns the comparison function of a sort field of the hot keys API
+// The field is sorted ascending, or descending if it's prefixed with "-"
+// Keys without a trend rank after falling ones
+func hotKeyComparator(sortBy string) (func(a, b hotKeyInfo) int, bool) {
+	field, descending := strings.CutPrefix(sortBy, "-")
+
+	var compare func(a, b hotKeyInfo) int
+	switch field {
+	case "count":
+		compare = func(a, b hotKeyInfo) int { return cmp.Compare(a.Count, b.Count) }
+	case "first_seen":
+		compare = func(a, b hotKeyInfo) int { return a.FirstSeen.Compare(b.FirstSeen) }
+	case "last_seen":
+		compare = func(a, b hotKeyInfo) int { return a.LastSeen.Compare(b.LastSeen) }
+	case "trend":
+		compare = func(a, b hotKeyInfo) int {
+			return cmp.Compare(trendRank(a.Trend), trendRank(b.Trend))
+		}
+	default:
+		return nil, false
+	}
+
+	if descending {
+		return func(a, b hotKeyInfo) int { return compare(b, a) }, true
+	}
+	return compare, true
+}
+
+// trendRank returns the sort rank of a trend
+func trendRank(trend string) int {
+	if rank, ok := trendOrder[trend]; ok {
+		return rank
+	}
+	return len(trendOrder)
+}
+
+// handleMovers handles the top movers API endpoint
+// Keys are ranked by the change of their rate between the two latest intervals,
+// which surfaces newly surging keys before they top the cumulative counts
+func (s *metricServer) handleMovers(w http.ResponseWriter, r *http.Request) {
+	limit := 10 // default
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+
+	response := moversResponse{
+		Timestamp: time.Now(),
+		Rising:    []moverInfo{},
+		Falling:   []moverInfo{},
+	}
+
+	// Three points give the rates of the two latest intervals
+	series := s.hotKeyHistory.GetTimeSeries(s.hotKeyHistory.RecentKeys(3), 3)
+	if len(series) >= 2 {
+		latest := series[len(series)-1]
+		previous := series[len(series)-2]
+		response.Timestamp = latest.Timestamp
+
+		for key, count := range latest.Keys {
+			info := moverInfo{
+				Key:        key,
+				Count:      count,
+				Rate:       latest.Rates[key],
+				RateChange: latest.Rates[key] - previous.Rates[key],
+			}
+			if info.RateChange > 0 {
+				response.Rising = append(response.Rising, info)
+			} else if info.RateChange < 0 {
+				response.Falling = append(response.Falling, info)
+			}
+		}
+
+		slices.SortFunc(response.Rising, func(a, b moverInfo) int {
+			return cmp.Compare(b.RateChange, a.RateChange)
+		})
+		slices.SortFunc(response.Falling, func(a, b moverInfo) int {
+			return cmp.Compare(a.RateChange, b.RateChange)
+		})
+		response.Rising = response.Rising[:min(limit, len(response.Rising))]
+		response.Falling = response.Falling[:min(limit, len(response.Falling))]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// handleHourly handles the hourly hot keys API endpoint
+// It compares what's hot right now to what's usually hot at an hour of day (the current one by default)
+func (s *metricServer) handleHourly(w http.ResponseWriter, r *http.Request) {
+	limit := 10 // default
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+
+	now := time.Now()
+	hour := now.Hour()
+	if h := r.URL.Query().Get("hour"); h != "" {
+		parsed, err := strconv.Atoi(h)
+		if err != nil || parsed < 0 || parsed > 23 {
+			http.Error(w, "Invalid hour, expected 0-23", http.StatusBadRequest)
+			return
+		}
+		hour = parsed
+	}
+
+	var usual []detector.KeyCount
+	if s.detector != nil {
+		usual = s.detector.HourlyTopK(hour)
+	}
+	if usual == nil {
+		http.Error(w, "Hourly tracking is disabled", http.StatusNotFound)
+		return
+	}
+
+	response := hourlyKeysResponse{
+		Timestamp: now,
+		Hour:      hour,
+		Current:   []hourlyKeyInfo{},
+		Usual:     []hourlyKeyInfo{},
+	}
+	current := s.detector.TopK()
+	for _, kc := range current[:min(limit, len(current))] {
+		response.Current = append(response.Current, hourlyKeyInfo{Key: kc.Key, Count: kc.Count})
+	}
+	for _, kc := range usual[:min(limit, len(usual))] {
+		response.Usual = append(response.Usual, hourlyKeyInfo{Key: kc.Key, Count: kc.Count})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// handleShards handles the shard distribution API endpoint
+func (s *metricServer) handleShards(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if key == "" {
+		http.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+	// Shard reads are counted under the normalized key, so the key is normalized like the wrappers do
+	if s.normalizeKey != nil {
+		key = s.normalizeKey(key)
+	}
+
+	response := shardsResponse{
+		Key:    key,
+		Shards: []shardInfo{},
+	}
+	if s.detector != nil {
+		for i, count := range s.detector.ShardCounts(key) {
+			response.Shards = append(response.Shards, shardInfo{
+				Key:   policy.ShardKey(key, i),
+				Count: count,
+			})
+			response.Total += count
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// authorized returns true if the request carries the configured API token
+// Requests are never authorized when no token is configured
+func (s *metricServer) authorized(r *http.Request) bool {
+	if s.config.APIToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.APIToken)) == 1
+}
+
+// handleCacheKeys handles the local cache keys API endpoint
+// It requires the API token since cached keys may reveal user data
+func (s *metricServer) handleCacheKeys(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := 100 // default
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+			limit = min(parsed, maxCacheKeysLimit)
+		}
+	}
+
+	response := cacheKeysResponse{
+		Timestamp: time.Now(),
+		Keys:      []cacheKeyInfo{},
+	}
+	if s.policyManager != nil {
+		if stats, ok := s.policyManager.CacheStats(); ok {
+			response.Total = stats.Size
+		}
+		items, _ := s.policyManager.CacheItems(limit)
+		for _, item := range items {
+			response.Keys = append(response.Keys, cacheKeyInfo{
+				Key:           item.Key,
+				TTLRemaining:  item.Expiration.Sub(response.Timestamp).Seconds(),
+				ShouldRefresh: item.ShouldRefresh(),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// handleKeys returns every key tracked by the detector with its counts
+// It requires the API token since tracked keys may reveal user data
+func (s *metricServer) handleKeys(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	response := keysResponse{
+		Timestamp: time.Now(),
+		Keys:      []trackedKeyInfo{},
+	}
+	if s.detector != nil {
+		for _, kc := range s.detector.Keys() {
+			response.Keys = append(response.Keys, trackedKeyInfo{
+				Key:    kc.Key,
+				Count:  kc.Count,
+				Reads:  kc.Reads,
+				Writes: kc.Writes,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// handleKeyReset resets the count of a key in the detector
+// It changes which keys are hot, so it requires the API token
+func (s *metricServer) handleKeyReset(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	key := r.PathValue("key")
+	if key == "" {
+		http.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+	// The detector counts normalized keys, so the key is normalized like the wrappers do
+	if s.normalizeKey != nil {
+		key = s.normalizeKey(key)
+	}
+
+	response := keyResetResponse{Key: key}
+	if s.detector != nil {
+		response.Reset = s.detector.Remove(key)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
 // handleRoot handles the root endpoint
 func (s *metricServer) handleRoot(w http.ResponseWriter, r *http.Request) {
 	html := `<html>
@@ -464,6 +1199,12 @@ func (s *metricServer) handleRoot(w http.ResponseWriter, r *http.Request) {
 		<ul>
 			<li><a href="/metrics">Prometheus Metrics</a></li>
 			<li><a href="/hot-keys">Hot Key Histories</a></li>
+			<li><a href="/hot-keys/movers">Top Movers</a></li>
+			<li><a href="/hot-keys/hourly">Hourly Hot Keys</a></li>
+			<li>/shards/{key}: Shard Distribution of a Split Key</li>
+			<li>/cache-keys: Local Cache Keys (requires the API token)</li>
+			<li>/keys: Tracked Keys (requires the API token)</li>
+			<li>POST /keys/{key}/reset: Reset the Count of a Key (requires the API token)</li>
 		</ul>
 		</body>
 		</html>`
@@ -490,44 +1231,104 @@ func (s *metricServer) Start() error {
 	// Hot key list endpoint
 	mux.HandleFunc("/hot-keys", s.handleHotKeys)
 
+	// Keys with the biggest rate change
+	mux.HandleFunc("/hot-keys/movers", s.handleMovers)
+
+	// Current hot keys compared to the usual ones of an hour of day
+	mux.HandleFunc("/hot-keys/hourly", s.handleHourly)
+
+	// Shard distribution endpoint for split keys
+	mux.HandleFunc("/shards/{key...}", s.handleShards)
+
+	// Local cache keys endpoint
+	mux.HandleFunc("/cache-keys", s.handleCacheKeys)
+
+	// Tracked keys endpoints, to inspect and reset key counts
+	mux.HandleFunc("GET /keys", s.handleKeys)
+	mux.HandleFunc("POST /keys/{key}/reset", s.handleKeyReset)
+
+	// Listen synchronously so that bind errors (e.g. port already in use) are returned
+	listener, err := net.Listen("tcp", s.config.MetricServerAddress)
+	if err != nil {
+		return fmt.Errorf("failed to start metric server on %s: %w", s.config.MetricServerAddress, err)
+	}
+
 	s.server = &http.Server{
 		Addr:    s.config.MetricServerAddress,
 		Handler: mux,
 	}
 
+	// A stopped server can be started again, e.g. when its replacement fails to start
+	s.stopChan = make(chan struct{})
+	s.stopOnce = sync.Once{}
+
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
-		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Printf("Error starting metric server: %v\n", err)
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("Error serving metric server: %v\n", err)
 		}
 	}()
 
-	// Start metrics collection ticker
+	// Start metrics collection and history snapshot tickers
 	s.collectionTicker = time.NewTicker(s.config.CollectionInterval)
+	s.historyTicker = time.NewTicker(s.config.HistorySnapshotInterval)
+
+	// Receiving from a nil channel blocks forever, so nothing is exported without a file
+	var exportC <-chan time.Time
+	if s.config.ExportFilePath != "" {
+		s.exportTicker = time.NewTicker(s.config.ExportInterval)
+		exportC = s.exportTicker.C
+	}
 
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
-		for {
-			select {
-			case <-s.collectionTicker.C:
-				s.collectMetrics()
-			case <-s.stopChan:
-				return
-			}
-		}
+		s.run(s.collectionTicker.C, s.historyTicker.C, exportC)
 	}()
 
 	return nil
 }
 
+// run collects metrics, snapshots the history and exports the hot keys at each tick of
+// their channel until the server is stopped
+func (s *metricServer) run(collectC, historyC, exportC <-chan time.Time) {
+	for {
+		select {
+		case <-collectC:
+			s.collectMetrics()
+		case <-historyC:
+			s.snapshotHistory()
+		case <-exportC:
+			s.exportHotKeys()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
 // Stop stops the metric server
+// It's safe to call Stop more than once (e.g. after a failed Start); repeated calls return nil
 func (s *metricServer) Stop() error {
-	// Stop collection ticker
+	var err error
+	s.stopOnce.Do(func() {
+		err = s.stop()
+	})
+	return err
+}
+
+// stop shuts down the collection goroutine and the HTTP server, and waits for them to finish
+func (s *metricServer) stop() error {
+	// Stop collection and history tickers
 	if s.collectionTicker != nil {
 		s.collectionTicker.Stop()
 	}
+	if s.historyTicker != nil {
+		s.historyTicker.Stop()
+	}
+	if s.exportTicker != nil {
+		s.exportTicker.Stop()
+	}
 
 	// Signal collection goroutine to stop
 	close(s.stopChan)
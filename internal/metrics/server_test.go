@@ -1,15 +1,20 @@
 package metrics
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/mingrammer/keyflare/internal/detector"
+	"github.com/mingrammer/keyflare/internal/policy"
 )
 
 func TestMetricServer_Start_Stop(t *testing.T) {
@@ -37,6 +42,59 @@ func TestMetricServer_Start_Stop(t *testing.T) {
 	}
 }
 
+func TestMetricServer_Start_PortInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	config := Config{
+		Namespace:           "test",
+		MetricServerAddress: l.Addr().String(),
+		CollectionInterval:  100 * time.Millisecond,
+		HotKeyMetricLimit:   10,
+		HotKeyHistorySize:   5,
+	}
+
+	server := newMetricServer(config)
+
+	if err := server.Start(); err == nil {
+		server.Stop()
+		t.Fatal("Expected error when the port is already in use")
+	}
+
+	// Stopping a server that failed to start must not panic
+	if err := server.Stop(); err != nil {
+		t.Errorf("Expected no error stopping a server that failed to start, got %v", err)
+	}
+}
+
+func TestMetricServer_StopTwice(t *testing.T) {
+	config := Config{
+		Namespace:           "test",
+		MetricServerAddress: ":0",
+		CollectionInterval:  100 * time.Millisecond,
+		HotKeyMetricLimit:   10,
+		HotKeyHistorySize:   5,
+	}
+
+	server := newMetricServer(config)
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	if err := server.Stop(); err != nil {
+		t.Errorf("Failed to stop server: %v", err)
+	}
+
+	// A repeated Stop is a no-op
+	if err := server.Stop(); err != nil {
+		t.Errorf("Expected no error on the second Stop, got %v", err)
+	}
+}
+
 func TestMetricServer_HandleRoot(t *testing.T) {
 	config := Config{
 		Namespace:           "test",
@@ -260,6 +318,145 @@ func TestMetricServer_HandleHotKeys_InvalidLimit(t *testing.T) {
 	}
 }
 
+func TestMetricServer_HandleHotKeys_Pattern(t *testing.T) {
+	config := Config{
+		Namespace:           "test",
+		MetricServerAddress: ":0",
+		HotKeyMetricLimit:   10,
+		HotKeyHistorySize:   5,
+	}
+
+	server := newMetricServer(config)
+
+	// Interleave two key families
+	hotKeys := []detector.KeyCount{}
+	for i := 0; i < 10; i++ {
+		hotKeys = append(hotKeys, detector.KeyCount{
+			Key:   fmt.Sprintf("user:%d", i),
+			Count: uint64(100 - 2*i),
+		}, detector.KeyCount{
+			Key:   fmt.Sprintf("product:%d", i),
+			Count: uint64(99 - 2*i),
+		})
+	}
+	server.hotKeyHistory.Add(hotKeys)
+
+	req := httptest.NewRequest("GET", "/hot-keys?pattern=^user:.*&limit=3", nil)
+	w := httptest.NewRecorder()
+
+	server.handleHotKeys(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response hotKeysResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	// The limit applies after filtering
+	if len(response.Keys) != 3 {
+		t.Fatalf("Expected 3 keys, got %d", len(response.Keys))
+	}
+	for i, info := range response.Keys {
+		if want := fmt.Sprintf("user:%d", i); info.Key != want {
+			t.Errorf("Expected key %d to be %s, got %s", i, want, info.Key)
+		}
+		// Ranks are among all keys
+		if info.Rank != 2*i+1 {
+			t.Errorf("Expected %s to be ranked %d, got %d", info.Key, 2*i+1, info.Rank)
+		}
+	}
+}
+
+func TestMetricServer_HandleHotKeys_InvalidPattern(t *testing.T) {
+	server := newMetricServer(Config{
+		Namespace:           "test",
+		MetricServerAddress: ":0",
+	})
+	server.hotKeyHistory.Add([]detector.KeyCount{{Key: "key1", Count: 100}})
+
+	req := httptest.NewRequest("GET", "/hot-keys?pattern=user:(", nil)
+	w := httptest.NewRecorder()
+
+	server.handleHotKeys(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid pattern, got %d", w.Code)
+	}
+}
+
+func TestMetricServer_HandleHotKeys_Sort(t *testing.T) {
+	config := Config{
+		Namespace:           "test",
+		MetricServerAddress: ":0",
+		HotKeyHistorySize:   5,
+	}
+
+	server := newMetricServer(config)
+	server.hotKeyHistory.Add([]detector.KeyCount{
+		{Key: "a", Count: 300},
+		{Key: "b", Count: 200},
+		{Key: "c", Count: 100},
+	})
+
+	// Pretend the keys were last seen at different times
+	latest := server.hotKeyHistory.GetLatest()
+	base := latest.timestamp
+	for key, offset := range map[string]time.Duration{"a": -2 * time.Minute, "b": 0, "c": -time.Minute} {
+		meta := latest.keyMeta[key]
+		meta.lastSeen = base.Add(offset)
+		latest.keyMeta[key] = meta
+	}
+
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"count", []string{"c", "b"}},
+		{"-count", []string{"a", "b"}},
+		{"-last_seen", []string{"b", "c"}},
+		{"last_seen", []string{"a", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/hot-keys?limit=2&sort="+tt.sort, nil)
+			w := httptest.NewRecorder()
+
+			server.handleHotKeys(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+
+			var response hotKeysResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse JSON response: %v", err)
+			}
+
+			// The limit applies after sorting
+			var got []string
+			for _, info := range response.Keys {
+				got = append(got, info.Key)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected keys %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/hot-keys?sort=key", nil)
+	w := httptest.NewRecorder()
+
+	server.handleHotKeys(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid sort, got %d", w.Code)
+	}
+}
+
 func TestMetricServer_HandleHotKeys_TrendDetection(t *testing.T) {
 	config := Config{
 		Namespace:           "test",
@@ -321,6 +518,52 @@ func TestMetricServer_HandleHotKeys_TrendDetection(t *testing.T) {
 	}
 }
 
+func TestMetricServer_HandleHotKeys_TrendDeadBand(t *testing.T) {
+	server := newMetricServer(Config{
+		Namespace:         "test",
+		HotKeyMetricLimit: 10,
+		HotKeyHistorySize: 5,
+		TrendDeadBand:     5,
+	})
+
+	server.hotKeyHistory.Add([]detector.KeyCount{
+		{Key: "jittery_key", Count: 100},
+		{Key: "rising_key", Count: 100},
+		{Key: "dipping_key", Count: 100},
+	})
+	time.Sleep(10 * time.Millisecond)
+	server.hotKeyHistory.Add([]detector.KeyCount{
+		{Key: "jittery_key", Count: 101}, // 1% change, within the dead band
+		{Key: "rising_key", Count: 150},  // 50% change
+		{Key: "dipping_key", Count: 99},  // -1% change, within the dead band
+	})
+
+	req := httptest.NewRequest("GET", "/hot-keys", nil)
+	w := httptest.NewRecorder()
+	server.handleHotKeys(w, req)
+
+	var response hotKeysResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	trendMap := make(map[string]string)
+	for _, keyInfo := range response.Keys {
+		trendMap[keyInfo.Key] = keyInfo.Trend
+	}
+
+	expected := map[string]string{
+		"jittery_key": "stable",
+		"rising_key":  "rising",
+		"dipping_key": "stable",
+	}
+	for key, trend := range expected {
+		if trendMap[key] != trend {
+			t.Errorf("Expected %s trend to be '%s', got '%s'", key, trend, trendMap[key])
+		}
+	}
+}
+
 func TestMetricServer_CollectionTicker(t *testing.T) {
 	config := Config{
 		Namespace:           "test",
@@ -364,3 +607,738 @@ func TestMetricServer_CollectionTicker(t *testing.T) {
 		t.Errorf("Failed to stop server: %v", err)
 	}
 }
+
+func TestMetricServer_HistorySnapshotInterval(t *testing.T) {
+	server := newMetricServer(Config{
+		Namespace:         "test",
+		HotKeyMetricLimit: 10,
+		HotKeyHistorySize: 100,
+	})
+
+	det := detector.New(detector.Config{TopK: 10, DecayInterval: 60 * time.Second})
+	det.Increment("test_key", 100)
+	server.SetDetector(det)
+
+	// The ticks are sent by hand and the clock advances by half a second at each snapshot
+	start := time.Now()
+	now := start
+	server.hotKeyHistory.now = func() time.Time {
+		now = now.Add(500 * time.Millisecond)
+		return now
+	}
+
+	collectC, historyC := make(chan time.Time), make(chan time.Time)
+	done := make(chan struct{})
+	go func() {
+		server.run(collectC, historyC, nil)
+		close(done)
+	}()
+
+	// Collections don't add to the history, only history ticks do
+	collectC <- start
+	for i := 0; i < 10; i++ {
+		historyC <- start
+	}
+	close(server.stopChan)
+	<-done
+
+	series := server.hotKeyHistory.GetTimeSeries([]string{"test_key"}, 0)
+	if len(series) != 10 {
+		t.Fatalf("Expected a snapshot per history tick, got %d", len(series))
+	}
+	for i, point := range series {
+		if expected := start.Add(time.Duration(i+1) * 500 * time.Millisecond); !point.Timestamp.Equal(expected) {
+			t.Errorf("Expected snapshot %d at %v, got %v", i, expected, point.Timestamp)
+		}
+		if i > 0 && point.Interval != 0.5 {
+			t.Errorf("Expected a 0.5s interval before snapshot %d, got %v", i, point.Interval)
+		}
+	}
+}
+
+func TestMetricServer_OnCollect(t *testing.T) {
+	type collected struct {
+		keys []detector.KeyCount
+		at   time.Time
+	}
+	ch := make(chan collected, 10)
+
+	config := Config{
+		Namespace:         "test",
+		HotKeyMetricLimit: 10,
+		HotKeyHistorySize: 5,
+		OnCollect: func(keys []detector.KeyCount, collectedAt time.Time) {
+			ch <- collected{keys: keys, at: collectedAt}
+		},
+	}
+
+	server := newMetricServer(config)
+
+	det := detector.New(detector.Config{TopK: 10, DecayInterval: 60 * time.Second})
+	det.Increment("test_key", 100)
+	server.SetDetector(det)
+
+	for i := 0; i < 3; i++ {
+		before := time.Now()
+		server.collectMetrics()
+
+		select {
+		case c := <-ch:
+			if len(c.keys) != 1 || c.keys[0].Key != "test_key" || c.keys[0].Count != 100 {
+				t.Errorf("Cycle %d: expected [test_key:100], got %v", i, c.keys)
+			}
+			if c.at.Before(before) {
+				t.Errorf("Cycle %d: expected collection time after %v, got %v", i, before, c.at)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Cycle %d: expected OnCollect to be called", i)
+		}
+	}
+}
+
+func TestMetricServer_OnCollectPanic(t *testing.T) {
+	called := make(chan struct{}, 10)
+
+	config := Config{
+		Namespace:         "test",
+		HotKeyMetricLimit: 10,
+		HotKeyHistorySize: 5,
+		OnCollect: func(keys []detector.KeyCount, collectedAt time.Time) {
+			called <- struct{}{}
+			panic("sink failure")
+		},
+	}
+
+	server := newMetricServer(config)
+
+	det := detector.New(detector.Config{TopK: 10, DecayInterval: 60 * time.Second})
+	det.Increment("test_key", 100)
+	server.SetDetector(det)
+
+	// A panicking callback must not prevent subsequent collections
+	for i := 0; i < 2; i++ {
+		server.collectMetrics()
+
+		select {
+		case <-called:
+		case <-time.After(time.Second):
+			t.Fatalf("Cycle %d: expected OnCollect to be called", i)
+		}
+	}
+
+	server.snapshotHistory()
+	if snapshot := server.hotKeyHistory.GetLatest(); snapshot == nil || len(snapshot.keys) != 1 {
+		t.Error("Expected collection to continue after a panicking callback")
+	}
+}
+
+func TestMetricServer_HandleHotKeys_ReadWriteMix(t *testing.T) {
+	config := Config{
+		Namespace:         "test",
+		HotKeyMetricLimit: 10,
+		HotKeyHistorySize: 5,
+	}
+
+	server := newMetricServer(config)
+
+	det := detector.New(detector.Config{TopK: 10, DecayInterval: 60 * time.Second})
+	ctx := context.Background()
+	det.IncrementOp(ctx, "config:global", 90, detector.OpRead)
+	det.IncrementOp(ctx, "config:global", 10, detector.OpWrite)
+	det.IncrementOp(ctx, "counter:visits", 5, detector.OpRead)
+	det.IncrementOp(ctx, "counter:visits", 95, detector.OpWrite)
+	server.SetDetector(det)
+	server.snapshotHistory()
+
+	req := httptest.NewRequest("GET", "/hot-keys", nil)
+	w := httptest.NewRecorder()
+
+	server.handleHotKeys(w, req)
+
+	var response hotKeysResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	infos := make(map[string]hotKeyInfo)
+	for _, info := range response.Keys {
+		infos[info.Key] = info
+	}
+
+	if info := infos["config:global"]; info.Reads != 90 || info.Writes != 10 {
+		t.Errorf("Expected config:global to be read-heavy (90/10), got %d/%d", info.Reads, info.Writes)
+	}
+	if info := infos["counter:visits"]; info.Reads != 5 || info.Writes != 95 {
+		t.Errorf("Expected counter:visits to be write-heavy (5/95), got %d/%d", info.Reads, info.Writes)
+	}
+}
+
+func TestMetricServer_HandleMovers(t *testing.T) {
+	config := Config{
+		Namespace:         "test",
+		HotKeyMetricLimit: 10,
+		HotKeyHistorySize: 5,
+	}
+
+	server := newMetricServer(config)
+
+	server.hotKeyHistory.Add([]detector.KeyCount{
+		{Key: "steady", Count: 10000},
+		{Key: "surging", Count: 10},
+	})
+	time.Sleep(20 * time.Millisecond)
+	server.hotKeyHistory.Add([]detector.KeyCount{
+		{Key: "steady", Count: 10100},
+		{Key: "surging", Count: 500},
+	})
+
+	req := httptest.NewRequest("GET", "/hot-keys/movers", nil)
+	w := httptest.NewRecorder()
+
+	server.handleMovers(w, req)
+
+	var response moversResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	if len(response.Rising) != 2 {
+		t.Fatalf("Expected 2 rising keys, got %d", len(response.Rising))
+	}
+	if response.Rising[0].Key != "surging" {
+		t.Errorf("Expected the low-count surging key to rank first, got %s", response.Rising[0].Key)
+	}
+	if response.Rising[0].Count != 500 {
+		t.Errorf("Expected count 500, got %d", response.Rising[0].Count)
+	}
+	if response.Rising[0].RateChange <= response.Rising[1].RateChange {
+		t.Errorf("Expected movers sorted by rate change, got %v", response.Rising)
+	}
+}
+
+func TestMetricServer_HandleMovers_Empty(t *testing.T) {
+	server := newMetricServer(Config{Namespace: "test"})
+
+	req := httptest.NewRequest("GET", "/hot-keys/movers", nil)
+	w := httptest.NewRecorder()
+
+	server.handleMovers(w, req)
+
+	var response moversResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if len(response.Rising) != 0 || len(response.Falling) != 0 {
+		t.Errorf("Expected no movers without history, got %v", response)
+	}
+}
+
+func TestMetricServer_HandleCacheKeys(t *testing.T) {
+	server := newMetricServer(Config{Namespace: "test", APIToken: "secret"})
+
+	manager, err := policy.New(policy.Config{
+		Type:       policy.LocalCache,
+		Parameters: policy.LocalCacheConfig{TTL: 60, Capacity: 100, RefreshAhead: 0.8},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create policy manager: %v", err)
+	}
+	server.SetPolicyManager(manager)
+
+	// Populate the local cache through the policy
+	manager.AddWhitelistKey("product:1")
+	manager.AddWhitelistKey("product:2")
+	for _, key := range []string{"product:2", "product:1"} {
+		manager.GetPolicy(key).Apply(policy.Context{Key: key, Data: policy.SetRequest{Value: "value"}})
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		expectedCode  int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"valid token", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/cache-keys", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+
+		server.handleCacheKeys(w, req)
+
+		if w.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.expectedCode, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/cache-keys?limit=1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+
+	server.handleCacheKeys(w, req)
+
+	var response cacheKeysResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if response.Total != 2 {
+		t.Errorf("Expected 2 cached keys in total, got %d", response.Total)
+	}
+	if len(response.Keys) != 1 || response.Keys[0].Key != "product:1" {
+		t.Fatalf("Expected only product:1 with limit 1, got %v", response.Keys)
+	}
+	if ttl := response.Keys[0].TTLRemaining; ttl <= 0 || ttl > 60 {
+		t.Errorf("Expected remaining TTL within (0, 60], got %v", ttl)
+	}
+	if response.Keys[0].ShouldRefresh {
+		t.Error("Expected a fresh item not to need a refresh")
+	}
+}
+
+func TestMetricServer_CollectPrunes(t *testing.T) {
+	server := newMetricServer(Config{Namespace: "test", PruneMinCount: 10})
+	d := detector.New(detector.Config{TopK: 10, Capacity: 20})
+	server.SetDetector(d)
+
+	d.Increment("cold", 1)
+	d.Increment("hot", 100)
+	server.collectMetrics()
+
+	topK := d.TopK()
+	if len(topK) != 1 || topK[0].Key != "hot" {
+		t.Errorf("Expected only the hot key to remain after collection, got %v", topK)
+	}
+}
+
+func TestMetricServer_DetectorConfig(t *testing.T) {
+	server := newMetricServer(Config{Namespace: "test"})
+	server.SetDetector(detector.New(detector.Config{
+		TopK:                100,
+		HotThreshold:        80,
+		HotThresholdPercent: 0.5,
+		MinHotCount:         10,
+		MinHotAge:           5 * time.Second,
+		DecayInterval:       30 * time.Second,
+	}))
+
+	families, err := server.registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != "test_detector_config" {
+			continue
+		}
+
+		metric := mf.GetMetric()[0]
+		if value := metric.GetGauge().GetValue(); value != 1 {
+			t.Errorf("Expected info metric value 1, got %v", value)
+		}
+		expected := map[string]string{
+			"topk":                  "100",
+			"capacity":              "100", // Defaults to TopK
+			"error_rate":            "0.01",
+			"decay_factor":          "0.98",
+			"decay_interval":        "30s",
+			"hot_threshold":         "80",
+			"hot_threshold_percent": "0.5",
+			"min_hot_count":         "10",
+			"min_hot_age":           "5s",
+			"count_source":          "cms",
+		}
+		for _, label := range metric.GetLabel() {
+			if want := expected[label.GetName()]; label.GetValue() != want {
+				t.Errorf("Expected label %s=%q, got %q", label.GetName(), want, label.GetValue())
+			}
+		}
+		if len(metric.GetLabel()) != len(expected) {
+			t.Errorf("Expected %d labels, got %d", len(expected), len(metric.GetLabel()))
+		}
+		return
+	}
+	t.Error("Expected test_detector_config to be registered")
+}
+
+func TestMetricServer_LocalCacheBytes(t *testing.T) {
+	server := newMetricServer(Config{Namespace: "test"})
+
+	manager, err := policy.New(policy.Config{
+		Type:          policy.LocalCache,
+		Parameters:    policy.LocalCacheConfig{TTL: 60, Capacity: 100},
+		WhitelistKeys: []string{"small", "large"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create policy manager: %v", err)
+	}
+	server.SetPolicyManager(manager)
+
+	localCacheBytes := func() float64 {
+		families, err := server.registry.Gather()
+		if err != nil {
+			t.Fatalf("Failed to gather metrics: %v", err)
+		}
+		for _, mf := range families {
+			if mf.GetName() == "test_local_cache_bytes" {
+				return mf.GetMetric()[0].GetGauge().GetValue()
+			}
+		}
+		t.Fatal("Expected test_local_cache_bytes to be registered")
+		return 0
+	}
+
+	manager.GetPolicy("small").Apply(policy.Context{Key: "small", Data: policy.SetRequest{Value: "value"}})
+	server.collectMetrics()
+	before := localCacheBytes()
+	if before <= 0 {
+		t.Fatalf("Expected a positive estimate with a cached item, got %v", before)
+	}
+
+	manager.GetPolicy("large").Apply(policy.Context{Key: "large", Data: policy.SetRequest{Value: make([]byte, 1<<20)}})
+	server.collectMetrics()
+	if after := localCacheBytes(); after < before+1<<20 {
+		t.Errorf("Expected the estimate to grow by at least 1MiB from %v, got %v", before, after)
+	}
+}
+
+func TestMetricServer_PolicyBreakerState(t *testing.T) {
+	server := newMetricServer(Config{Namespace: "test"})
+	breaker := policy.NewBreaker(policy.BreakerConfig{Threshold: 1, Cooldown: time.Minute})
+	server.SetBreaker(breaker)
+
+	breaker.Allow()
+	breaker.Record(errors.New("policy failure"))
+	server.collectMetrics()
+
+	families, err := server.registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() == "test_policy_breaker_state" {
+			if value := mf.GetMetric()[0].GetGauge().GetValue(); value != float64(policy.BreakerOpen) {
+				t.Errorf("Expected open breaker state %d, got %v", policy.BreakerOpen, value)
+			}
+			return
+		}
+	}
+	t.Error("Expected test_policy_breaker_state to be registered")
+}
+
+func TestMetricServer_RecordReplicationError(t *testing.T) {
+	server := newMetricServer(Config{Namespace: "test"})
+
+	server.RecordReplicationError()
+	server.RecordReplicationError()
+
+	families, err := server.registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() == "test_shard_replication_errors_total" {
+			if value := mf.GetMetric()[0].GetCounter().GetValue(); value != 2 {
+				t.Errorf("Expected 2 replication errors, got %v", value)
+			}
+			return
+		}
+	}
+	t.Error("Expected test_shard_replication_errors_total to be registered")
+}
+
+func TestMetricServer_RecordOperationDuration(t *testing.T) {
+	server := newMetricServer(Config{Namespace: "test"})
+
+	server.RecordOperationDuration("get", true, 20*time.Microsecond)
+	server.RecordOperationDuration("get", true, 30*time.Microsecond)
+	server.RecordOperationDuration("get", false, 2*time.Millisecond)
+	server.RecordOperationDuration("set", false, 3*time.Millisecond)
+
+	families, err := server.registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+
+	expected := map[string]uint64{
+		"get/local":   2,
+		"get/backend": 1,
+		"set/backend": 1,
+	}
+	for _, mf := range families {
+		if mf.GetName() != "test_operation_duration_seconds" {
+			continue
+		}
+
+		observed := make(map[string]uint64)
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			observed[labels["operation"]+"/"+labels["source"]] = m.GetHistogram().GetSampleCount()
+		}
+		if len(observed) != len(expected) {
+			t.Errorf("Expected observations %v, got %v", expected, observed)
+		}
+		for labels, count := range expected {
+			if observed[labels] != count {
+				t.Errorf("Expected %d observations for %s, got %d", count, labels, observed[labels])
+			}
+		}
+		return
+	}
+	t.Error("Expected test_operation_duration_seconds to be registered")
+}
+
+func TestMetricServer_HandleCacheKeys_NoToken(t *testing.T) {
+	server := newMetricServer(Config{Namespace: "test"})
+
+	// The endpoint is disabled without a configured token
+	req := httptest.NewRequest("GET", "/cache-keys", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+
+	server.handleCacheKeys(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+}
+
+func TestMetricServer_HandleHourly(t *testing.T) {
+	server := newMetricServer(Config{Namespace: "test"})
+
+	det := detector.New(detector.Config{TopK: 10, HourlyTracking: true})
+	det.Increment("now", 5)
+	server.SetDetector(det)
+
+	hour := time.Now().Hour()
+	req := httptest.NewRequest("GET", fmt.Sprintf("/hot-keys/hourly?hour=%d", hour), nil)
+	w := httptest.NewRecorder()
+
+	server.handleHourly(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response hourlyKeysResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if response.Hour != hour {
+		t.Errorf("Expected hour %d, got %d", hour, response.Hour)
+	}
+	if len(response.Current) != 1 || response.Current[0].Key != "now" {
+		t.Errorf("Expected the current hot key 'now', got %v", response.Current)
+	}
+	if len(response.Usual) != 1 || response.Usual[0].Count != 5 {
+		t.Errorf("Expected the usual hot key 'now' with count 5, got %v", response.Usual)
+	}
+
+	tests := []struct {
+		name         string
+		detector     detector.Detector
+		query        string
+		expectedCode int
+	}{
+		{"invalid hour", det, "?hour=24", http.StatusBadRequest},
+		{"disabled", detector.New(detector.Config{TopK: 10}), "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		server.SetDetector(tt.detector)
+		w := httptest.NewRecorder()
+		server.handleHourly(w, httptest.NewRequest("GET", "/hot-keys/hourly"+tt.query, nil))
+		if w.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.expectedCode, w.Code)
+		}
+	}
+}
+
+func TestMetricServer_HandleShards(t *testing.T) {
+	config := Config{
+		Namespace:         "test",
+		HotKeyMetricLimit: 10,
+		HotKeyHistorySize: 5,
+	}
+
+	server := newMetricServer(config)
+
+	det := detector.New(detector.Config{TopK: 10, DecayInterval: 60 * time.Second})
+	det.Increment("hot", 300)
+
+	// Simulate reads spread across 3 shards
+	for i := 0; i < 300; i++ {
+		det.IncrementShard("hot", i%3)
+	}
+	server.SetDetector(det)
+
+	// The key is normalized like the wrappers do before its shard reads are counted
+	server.SetKeyNormalizer(strings.ToLower)
+	req := httptest.NewRequest("GET", "/shards/HOT", nil)
+	req.SetPathValue("key", "HOT")
+	w := httptest.NewRecorder()
+
+	server.handleShards(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response shardsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	if response.Key != "hot" || response.Total != 300 {
+		t.Errorf("Expected key 'hot' with total 300, got '%s' with total %d", response.Key, response.Total)
+	}
+	if len(response.Shards) != 3 {
+		t.Fatalf("Expected 3 shards, got %d", len(response.Shards))
+	}
+	for i, shard := range response.Shards {
+		if shard.Key != fmt.Sprintf("hot:shard:%d", i) || shard.Count != 100 {
+			t.Errorf("Expected hot:shard:%d with count 100, got %s with count %d", i, shard.Key, shard.Count)
+		}
+	}
+}
+
+func TestMetricServer_HandleShards_Unknown(t *testing.T) {
+	server := newMetricServer(Config{Namespace: "test"})
+	server.SetDetector(detector.New(detector.Config{TopK: 10}))
+
+	req := httptest.NewRequest("GET", "/shards/unknown", nil)
+	req.SetPathValue("key", "unknown")
+	w := httptest.NewRecorder()
+
+	server.handleShards(w, req)
+
+	var response shardsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if response.Total != 0 || len(response.Shards) != 0 {
+		t.Errorf("Expected no shard counts for an unsplit key, got %+v", response)
+	}
+}
+
+func TestMetricServer_HandleKeys(t *testing.T) {
+	server := newMetricServer(Config{Namespace: "test", APIToken: "secret"})
+
+	// Keys beyond TopK are listed as long as they're tracked
+	det := detector.New(detector.Config{TopK: 1, Capacity: 10})
+	det.IncrementOp(context.Background(), "user:1", 5, detector.OpRead)
+	det.IncrementOp(context.Background(), "user:2", 3, detector.OpWrite)
+	server.SetDetector(det)
+
+	// Tracked keys may reveal user data, so they require the token
+	req := httptest.NewRequest("GET", "/keys", nil)
+	w := httptest.NewRecorder()
+	server.handleKeys(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 without a token, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/keys", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+
+	server.handleKeys(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response keysResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	expected := []trackedKeyInfo{
+		{Key: "user:1", Count: 5, Reads: 5},
+		{Key: "user:2", Count: 3, Writes: 3},
+	}
+	if !slices.Equal(response.Keys, expected) {
+		t.Errorf("Expected keys %+v, got %+v", expected, response.Keys)
+	}
+}
+
+func TestMetricServer_HandleKeyReset(t *testing.T) {
+	server := newMetricServer(Config{Namespace: "test", APIToken: "secret"})
+
+	det := detector.New(detector.Config{TopK: 10})
+	det.Increment("user:1", 5)
+	det.Increment("user:2", 3)
+	server.SetDetector(det)
+
+	tests := []struct {
+		name          string
+		key           string
+		authorization string
+		expectedCode  int
+		expectedReset bool
+	}{
+		{"no token", "user:1", "", http.StatusUnauthorized, false},
+		{"wrong token", "user:1", "Bearer wrong", http.StatusUnauthorized, false},
+		{"tracked key", "user:1", "Bearer secret", http.StatusOK, true},
+		{"already reset", "user:1", "Bearer secret", http.StatusOK, false},
+		{"unknown key", "user:3", "Bearer secret", http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/keys/"+tt.key+"/reset", nil)
+		req.SetPathValue("key", tt.key)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+
+		server.handleKeyReset(w, req)
+
+		if w.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.expectedCode, w.Code)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var response keyResetResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", tt.name, err)
+		}
+		if response.Key != tt.key || response.Reset != tt.expectedReset {
+			t.Errorf("%s: expected reset %v of %s, got %+v", tt.name, tt.expectedReset, tt.key, response)
+		}
+	}
+
+	// Only the reset key is gone
+	if count := det.GetCount("user:1"); count != 0 {
+		t.Errorf("Expected the count of user:1 to be reset, got %d", count)
+	}
+	keys := det.Keys()
+	if len(keys) != 1 || keys[0].Key != "user:2" || keys[0].Count != 3 {
+		t.Errorf("Expected only user:2 to remain tracked, got %+v", keys)
+	}
+
+	// The key is normalized like the wrappers normalize the keys they count
+	server.SetKeyNormalizer(strings.ToLower)
+	req := httptest.NewRequest("POST", "/keys/USER:2/reset", nil)
+	req.SetPathValue("key", "USER:2")
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+
+	server.handleKeyReset(w, req)
+
+	var response keyResetResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Key != "user:2" || !response.Reset {
+		t.Errorf("Expected user:2 to be reset, got %+v", response)
+	}
+}
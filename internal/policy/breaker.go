@@ -0,0 +1,147 @@
+package policy
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets policies be applied
+	BreakerClosed BreakerState = iota
+	// BreakerOpen bypasses policies until the cooldown elapses
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe apply a policy to decide whether to close again
+	BreakerHalfOpen
+)
+
+// String returns the name of the state
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerConfig contains configuration options for the policy circuit breaker
+type BreakerConfig struct {
+	// Threshold is the number of consecutive policy errors that opens the breaker
+	// The breaker is disabled if it's 0 or less
+	Threshold int
+
+	// Window is the period the consecutive errors must occur within (0 means no limit)
+	Window time.Duration
+
+	// Cooldown is how long the breaker stays open before probing the policy again
+	Cooldown time.Duration
+}
+
+// Breaker is a circuit breaker around policy application
+// After Threshold consecutive errors within Window, policies are bypassed for Cooldown,
+// then a single probe is let through and closes the breaker again if it succeeds
+type Breaker struct {
+	config BreakerConfig
+	mu     sync.Mutex
+
+	state        BreakerState
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+}
+
+// NewBreaker creates a new circuit breaker
+func NewBreaker(config BreakerConfig) *Breaker {
+	return &Breaker{config: config}
+}
+
+// Allow reports whether a policy can be applied
+// Every allowed application must be followed by a call to Record
+func (b *Breaker) Allow() bool {
+	if b.config.Threshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.config.Cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		// Only one probe at a time
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Record records the outcome of an allowed policy application
+func (b *Breaker) Record(err error) {
+	if b.config.Threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if err == nil {
+		// A late success of an application allowed before the breaker opened doesn't close it
+		if b.state == BreakerOpen {
+			return
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+
+	switch b.state {
+	case BreakerHalfOpen:
+		// The probe failed, so wait for another cooldown
+		b.open(now)
+	case BreakerClosed:
+		if b.failures == 0 || (b.config.Window > 0 && now.Sub(b.firstFailure) > b.config.Window) {
+			b.failures = 0
+			b.firstFailure = now
+		}
+		b.failures++
+		if b.failures >= b.config.Threshold {
+			b.open(now)
+		}
+	}
+}
+
+// open opens the breaker, the caller must hold the lock
+func (b *Breaker) open(now time.Time) {
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.failures = 0
+	b.probing = false
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
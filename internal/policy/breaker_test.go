@@ -0,0 +1,112 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errPolicy = errors.New("policy failure")
+
+func TestBreaker_OpensAfterConsecutiveErrors(t *testing.T) {
+	b := NewBreaker(BreakerConfig{Threshold: 3, Cooldown: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		if !b.Allow() {
+			t.Fatalf("Expected closed breaker to allow application %d", i)
+		}
+		b.Record(errPolicy)
+	}
+
+	// A success resets the consecutive errors
+	b.Allow()
+	b.Record(nil)
+	for i := 0; i < 2; i++ {
+		b.Allow()
+		b.Record(errPolicy)
+	}
+	if state := b.State(); state != BreakerClosed {
+		t.Fatalf("Expected breaker to stay closed, got %s", state)
+	}
+
+	b.Allow()
+	b.Record(errPolicy)
+	if state := b.State(); state != BreakerOpen {
+		t.Fatalf("Expected breaker to open, got %s", state)
+	}
+	if b.Allow() {
+		t.Error("Expected open breaker to bypass policies")
+	}
+}
+
+func TestBreaker_Window(t *testing.T) {
+	b := NewBreaker(BreakerConfig{Threshold: 2, Window: 50 * time.Millisecond, Cooldown: time.Minute})
+
+	b.Allow()
+	b.Record(errPolicy)
+	time.Sleep(60 * time.Millisecond)
+
+	// The first error is outside the window, so the count starts over
+	b.Allow()
+	b.Record(errPolicy)
+	if state := b.State(); state != BreakerClosed {
+		t.Fatalf("Expected breaker to stay closed, got %s", state)
+	}
+
+	b.Allow()
+	b.Record(errPolicy)
+	if state := b.State(); state != BreakerOpen {
+		t.Fatalf("Expected breaker to open, got %s", state)
+	}
+}
+
+func TestBreaker_Recovery(t *testing.T) {
+	b := NewBreaker(BreakerConfig{Threshold: 1, Cooldown: 50 * time.Millisecond})
+
+	b.Allow()
+	b.Record(errPolicy)
+	if b.Allow() {
+		t.Fatal("Expected open breaker to bypass policies")
+	}
+
+	// After the cooldown, a single probe is let through
+	time.Sleep(60 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("Expected a probe after the cooldown")
+	}
+	if state := b.State(); state != BreakerHalfOpen {
+		t.Fatalf("Expected half-open breaker, got %s", state)
+	}
+	if b.Allow() {
+		t.Error("Expected only one probe at a time")
+	}
+
+	// A failed probe opens the breaker for another cooldown
+	b.Record(errPolicy)
+	if state := b.State(); state != BreakerOpen {
+		t.Fatalf("Expected breaker to reopen, got %s", state)
+	}
+
+	// A successful probe closes it
+	time.Sleep(60 * time.Millisecond)
+	b.Allow()
+	b.Record(nil)
+	if state := b.State(); state != BreakerClosed {
+		t.Fatalf("Expected breaker to close, got %s", state)
+	}
+	if !b.Allow() {
+		t.Error("Expected closed breaker to allow policies")
+	}
+}
+
+func TestBreaker_Disabled(t *testing.T) {
+	b := NewBreaker(BreakerConfig{})
+
+	for i := 0; i < 100; i++ {
+		b.Allow()
+		b.Record(errPolicy)
+	}
+	if !b.Allow() || b.State() != BreakerClosed {
+		t.Error("Expected disabled breaker to always allow policies")
+	}
+}
@@ -1,17 +1,84 @@
 package policy
 
 import (
+	"context"
 	"fmt"
+	"hash/fnv"
 	"math/rand/v2"
+	"time"
 )
 
+const (
+	// defaultMaxShards is the default upper bound for the number of shards in AutoShards mode
+	defaultMaxShards = 16
+
+	// defaultShardStep is the default access count per shard in AutoShards mode
+	defaultShardStep = 1000
+
+	// minShards is the lower bound for the number of shards
+	minShards = 2
+
+	// minAutoShards is the lower bound for the number of shards in AutoShards mode
+	minAutoShards = 2
+
+	// defaultFallbackTTL is the default TTL of shards filled from the original key
+	defaultFallbackTTL = time.Minute
+)
+
+// routingKeyContextKey is the context key for the routing key
+type routingKeyContextKey struct{}
+
+// WithRoutingKey returns a copy of ctx carrying the routing key used for consistent shard selection
+func WithRoutingKey(ctx context.Context, routingKey string) context.Context {
+	return context.WithValue(ctx, routingKeyContextKey{}, routingKey)
+}
+
+// RoutingKey returns the routing key carried by ctx, or an empty string
+func RoutingKey(ctx context.Context) string {
+	routingKey, _ := ctx.Value(routingKeyContextKey{}).(string)
+	return routingKey
+}
+
 // keySplittingPolicy implements a policy that splits a key into multiple keys
 type keySplittingPolicy struct {
 	config KeySplittingConfig
 }
 
+// validate returns an error if the configuration can't split keys
+func (c KeySplittingConfig) validate() error {
+	if !c.AutoShards && c.Shards < minShards {
+		return fmt.Errorf("%w for KeySplitting policy: Shards must be at least %d, got %d", ErrInvalidParams, minShards, c.Shards)
+	}
+	return nil
+}
+
 // newKeySplittingPolicy creates a new key splitting policy with the provided parameters
 func newKeySplittingPolicy(config KeySplittingConfig) Policy {
+	if config.AutoShards {
+		if config.MaxShards < minAutoShards {
+			config.MaxShards = defaultMaxShards
+		}
+		if config.ShardStep == 0 {
+			config.ShardStep = defaultShardStep
+		}
+	} else {
+		// Policies built around the validation must not panic on too few shards
+		config.Shards = max(config.Shards, minShards)
+	}
+
+	// Shard TTLs are bounded like local cache TTLs
+	config.TTLJitter = min(max(config.TTLJitter, 0), maxLocalCacheJitter)
+
+	if config.ReplicationAttempts < 1 {
+		config.ReplicationAttempts = 1
+	}
+	if config.ReplicationBackoff < 0 {
+		config.ReplicationBackoff = 0
+	}
+	if config.FallbackTTL <= 0 {
+		config.FallbackTTL = defaultFallbackTTL
+	}
+
 	return &keySplittingPolicy{
 		config: config,
 	}
@@ -22,11 +89,13 @@ func newKeySplittingPolicy(config KeySplittingConfig) Policy {
 func (p *keySplittingPolicy) Apply(ctx Context) Result {
 	key := ctx.Key
 
-	switch ctx.Data.(type) {
+	switch req := ctx.Data.(type) {
 	case GetRequest:
-		return p.handleLookAsideGet(key)
+		return p.handleLookAsideGet(key, ctx.Count, req)
 	case SetRequest:
-		return p.handleLookAsideSet(key, ctx.Data.(SetRequest))
+		return p.handleLookAsideSet(key, ctx.Count, req)
+	case DeleteRequest:
+		return p.handleDelete(key)
 	default:
 		return Result{
 			Error: fmt.Errorf("unsupported operation type: %T", ctx.Data),
@@ -35,54 +104,180 @@ func (p *keySplittingPolicy) Apply(ctx Context) Result {
 }
 
 // handleLookAsideGet handles GET operations with look-aside pattern
-func (p *keySplittingPolicy) handleLookAsideGet(key string) Result {
+func (p *keySplittingPolicy) handleLookAsideGet(key string, count uint64, req GetRequest) Result {
 	// Look-aside pattern: Try to read from a single shard first,
 	// fallback to original key if no sharded data exists
-	shardKeys := p.generateShardKeys(key)
+	// Fills write every shard, like sets, and reads only spread over the first ones
+	shardKeys := p.generateShardKeys(key, p.writtenShards())
+	shardIndex := p.selectShard(req.RoutingKey, p.readShards(count))
 	return Result{
 		Data: KeySplittingGetAction{
 			OriginalKey:  key,
-			RandShardKey: shardKeys[rand.Int()%int(p.config.Shards)],
+			RandShardKey: shardKeys[shardIndex],
+			ShardIndex:   shardIndex,
 			ShardKeys:    shardKeys,
+			TTLJitter:    p.config.TTLJitter,
+			Retry:        p.retryConfig(),
+			FallbackTTL:  p.config.FallbackTTL,
 		},
 	}
 }
 
 // handleLookAsideSet handles SET operations
-func (p *keySplittingPolicy) handleLookAsideSet(key string, req SetRequest) Result {
-	shardKeys := p.generateShardKeys(key)
+func (p *keySplittingPolicy) handleLookAsideSet(key string, count uint64, req SetRequest) Result {
+	shardKeys := p.generateShardKeys(key, p.writtenShards())
 	return Result{
 		Data: KeySplittingSetAction{
 			OriginalKey: key,
 			ShardKeys:   shardKeys,
 			Value:       req.Value,
 			TTL:         req.TTL,
+			TTLJitter:   p.config.TTLJitter,
+			Retry:       p.retryConfig(),
+		},
+	}
+}
+
+// handleDelete handles changes of a key other than sets, whose shards are now stale
+func (p *keySplittingPolicy) handleDelete(key string) Result {
+	return Result{
+		Data: KeySplittingDeleteAction{
+			OriginalKey: key,
+			ShardKeys:   p.generateShardKeys(key, p.writtenShards()),
 		},
 	}
 }
 
+// writtenShards returns the number of shards written by sets and fills
+// In AutoShards mode every shard up to MaxShards is written whatever the key's access count,
+// so a shard doesn't keep a stale value when the count drops and grows again
+func (p *keySplittingPolicy) writtenShards() int {
+	if p.config.AutoShards {
+		return int(p.config.MaxShards)
+	}
+	return int(p.config.Shards)
+}
+
+// readShards returns the number of shards reads of a key with the given access count spread over
+func (p *keySplittingPolicy) readShards(count uint64) int {
+	if !p.config.AutoShards {
+		return int(p.config.Shards)
+	}
+
+	shards := count / p.config.ShardStep
+	return int(min(max(shards, minAutoShards), uint64(p.config.MaxShards)))
+}
+
+// selectShard returns the index of the shard to read
+// With ConsistentRouting, a routing key always maps to the same shard for a given number
+// of shards, and only a few routing keys move when the number of shards changes
+func (p *keySplittingPolicy) selectShard(routingKey string, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+	if !p.config.ConsistentRouting || routingKey == "" {
+		return rand.Int() % shards
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(routingKey))
+	return jumpHash(h.Sum64(), shards)
+}
+
+// jumpHash maps a hash to a bucket in [0, buckets) using jump consistent hashing
+// See https://arxiv.org/abs/1406.2294
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
 // generateShardKeys generates shard keys for the given key
-func (p *keySplittingPolicy) generateShardKeys(key string) []string {
-	// TODO: support auto detection for number of shards.
-	shards := int(p.config.Shards)
+func (p *keySplittingPolicy) generateShardKeys(key string, shards int) []string {
 	shardKeys := make([]string, shards)
 	for i := range shards {
-		shardKeys[i] = fmt.Sprintf("%s:shard:%d", key, i)
+		shardKeys[i] = ShardKey(key, i)
 	}
 	return shardKeys
 }
 
+// ShardKey returns the key of the i-th shard of a split key
+func ShardKey(key string, i int) string {
+	return fmt.Sprintf("%s:shard:%d", key, i)
+}
+
+// JitterTTL returns ttl shortened by a random fraction of up to ttl*jitter
+// Shards never outlive the TTL they're written with, such as the original key's remaining TTL
+// A ttl of 0 (no expiration) is returned as is
+func JitterTTL(ttl time.Duration, jitter float64) time.Duration {
+	if ttl <= 0 || jitter <= 0 {
+		return ttl
+	}
+	return ttl - time.Duration(rand.Float64()*jitter*float64(ttl))
+}
+
+// retryConfig returns the retry settings of shard writes
+func (p *keySplittingPolicy) retryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts: p.config.ReplicationAttempts,
+		Backoff:     p.config.ReplicationBackoff,
+	}
+}
+
+// RetryConfig contains the retry settings of shard writes
+type RetryConfig struct {
+	// MaxAttempts is the number of attempts, including the first
+	MaxAttempts int `json:"max_attempts,omitempty"`
+
+	// Backoff is the delay before the first retry, doubled after each retry
+	Backoff time.Duration `json:"backoff,omitempty"`
+}
+
+// Do calls fn until it succeeds or MaxAttempts is reached, and returns the last error
+// It stops waiting and returns the context error if ctx is done
+func (r RetryConfig) Do(ctx context.Context, fn func() error) error {
+	backoff := r.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
 // Action types for key splitting operations
 type KeySplittingGetAction struct {
-	OriginalKey  string   `json:"original_key"`
-	RandShardKey string   `json:"rand_shard_key"`
-	ShardKeys    []string `json:"shard_keys"`
+	OriginalKey  string        `json:"original_key"`
+	RandShardKey string        `json:"rand_shard_key"`
+	ShardIndex   int           `json:"shard_index"`          // index of RandShardKey in ShardKeys
+	ShardKeys    []string      `json:"shard_keys"`           // shards filled on a miss, which may be more than reads spread over
+	TTLJitter    float64       `json:"ttl_jitter,omitempty"` // randomness factor for the shard TTLs
+	Retry        RetryConfig   `json:"retry"`                // retry settings of the shard writes
+	FallbackTTL  time.Duration `json:"fallback_ttl"`         // shard TTL if the original key's TTL is unknown
 }
 
 type KeySplittingSetAction struct {
+	OriginalKey string      `json:"original_key"`
+	ShardKeys   []string    `json:"shard_keys"`
+	Value       any         `json:"value"`
+	TTL         *float64    `json:"ttl,omitempty"`
+	TTLJitter   float64     `json:"ttl_jitter,omitempty"` // randomness factor for the shard TTLs
+	Retry       RetryConfig `json:"retry"`                // retry settings of the shard writes
+	Action      string      `json:"action"`
+}
+
+type KeySplittingDeleteAction struct {
 	OriginalKey string   `json:"original_key"`
-	ShardKeys   []string `json:"shard_keys"`
-	Value       any      `json:"value"`
-	TTL         *float64 `json:"ttl,omitempty"`
-	Action      string   `json:"action"`
+	ShardKeys   []string `json:"shard_keys"` // shards to delete since they're stale
 }
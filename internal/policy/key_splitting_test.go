@@ -1,8 +1,10 @@
 package policy
 
 import (
+	"context"
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestKeySplittingPolicy_Get(t *testing.T) {
@@ -88,6 +90,53 @@ func TestKeySplittingPolicy_Set(t *testing.T) {
 	}
 }
 
+func TestKeySplittingPolicy_TTLJitter(t *testing.T) {
+	tests := []struct {
+		jitter   float64
+		expected float64
+	}{
+		{0.1, 0.1},
+		{-0.1, 0},
+		{0.9, maxLocalCacheJitter},
+	}
+
+	for _, tt := range tests {
+		policy := newKeySplittingPolicy(KeySplittingConfig{Shards: 3, TTLJitter: tt.jitter})
+
+		set := policy.Apply(Context{Key: "key", Data: SetRequest{Value: "value"}}).Data.(KeySplittingSetAction)
+		if set.TTLJitter != tt.expected {
+			t.Errorf("TTLJitter %v: expected set action jitter %v, got %v", tt.jitter, tt.expected, set.TTLJitter)
+		}
+		get := policy.Apply(Context{Key: "key", Data: GetRequest{}}).Data.(KeySplittingGetAction)
+		if get.TTLJitter != tt.expected {
+			t.Errorf("TTLJitter %v: expected get action jitter %v, got %v", tt.jitter, tt.expected, get.TTLJitter)
+		}
+	}
+}
+
+func TestJitterTTL(t *testing.T) {
+	ttl := time.Hour
+	seen := make(map[time.Duration]bool)
+	for range 100 {
+		jittered := JitterTTL(ttl, 0.1)
+		if jittered < 54*time.Minute || jittered > ttl {
+			t.Fatalf("Expected TTL within 10%% below %v, got %v", ttl, jittered)
+		}
+		seen[jittered] = true
+	}
+	if len(seen) < 2 {
+		t.Error("Expected shard TTLs to vary")
+	}
+
+	// No jitter and no expiration are kept as is
+	if got := JitterTTL(ttl, 0); got != ttl {
+		t.Errorf("Expected %v without jitter, got %v", ttl, got)
+	}
+	if got := JitterTTL(0, 0.1); got != 0 {
+		t.Errorf("Expected no expiration to be kept, got %v", got)
+	}
+}
+
 func TestKeySplittingPolicy_InvalidOperation(t *testing.T) {
 	config := KeySplittingConfig{
 		Shards: 3,
@@ -112,7 +161,7 @@ func TestKeySplittingPolicy_GenerateShardKeys(t *testing.T) {
 	}
 	policy := newKeySplittingPolicy(config).(*keySplittingPolicy)
 
-	shardKeys := policy.generateShardKeys("session:abc123")
+	shardKeys := policy.generateShardKeys("session:abc123", policy.writtenShards())
 
 	if len(shardKeys) != 7 {
 		t.Errorf("Expected 7 shard keys, got %d", len(shardKeys))
@@ -125,3 +174,264 @@ func TestKeySplittingPolicy_GenerateShardKeys(t *testing.T) {
 		}
 	}
 }
+
+func TestKeySplittingPolicy_AutoShards(t *testing.T) {
+	config := KeySplittingConfig{
+		Shards:     3,
+		AutoShards: true,
+		MaxShards:  8,
+		ShardStep:  100,
+	}
+	policy := newKeySplittingPolicy(config)
+
+	tests := []struct {
+		count    uint64
+		expected int
+	}{
+		{0, 2},
+		{150, 2},
+		{300, 3},
+		{550, 5},
+		{800, 8},
+		{100000, 8},
+	}
+
+	for _, tt := range tests {
+		getResult := policy.Apply(Context{Key: "hot-key", Data: GetRequest{}, Count: tt.count})
+		getAction, ok := getResult.Data.(KeySplittingGetAction)
+		if !ok {
+			t.Fatalf("Expected KeySplittingGetAction, got: %T", getResult.Data)
+		}
+
+		setResult := policy.Apply(Context{Key: "hot-key", Data: SetRequest{Value: "v"}, Count: tt.count})
+		setAction, ok := setResult.Data.(KeySplittingSetAction)
+		if !ok {
+			t.Fatalf("Expected KeySplittingSetAction, got: %T", setResult.Data)
+		}
+
+		// Every shard is written whatever the count, so none is left stale when it changes
+		if len(getAction.ShardKeys) != 8 {
+			t.Errorf("Count %d: expected 8 shards filled by get, got %d", tt.count, len(getAction.ShardKeys))
+		}
+		if len(setAction.ShardKeys) != 8 {
+			t.Errorf("Count %d: expected 8 shards written by set, got %d", tt.count, len(setAction.ShardKeys))
+		}
+
+		// Reads only spread over the shards for the count
+		read := make(map[int]bool)
+		for range 200 {
+			result := policy.Apply(Context{Key: "hot-key", Data: GetRequest{}, Count: tt.count})
+			read[result.Data.(KeySplittingGetAction).ShardIndex] = true
+		}
+		if len(read) != tt.expected {
+			t.Errorf("Count %d: expected reads of %d shards, got %v", tt.count, tt.expected, read)
+		}
+		for index := range read {
+			if index >= tt.expected {
+				t.Errorf("Count %d: shard index %d out of range", tt.count, index)
+			}
+		}
+	}
+}
+
+func TestKeySplittingPolicy_AutoShardsDefaults(t *testing.T) {
+	policy := newKeySplittingPolicy(KeySplittingConfig{AutoShards: true}).(*keySplittingPolicy)
+
+	if policy.config.MaxShards != defaultMaxShards {
+		t.Errorf("Expected default max shards %d, got %d", defaultMaxShards, policy.config.MaxShards)
+	}
+	if policy.config.ShardStep != defaultShardStep {
+		t.Errorf("Expected default shard step %d, got %d", defaultShardStep, policy.config.ShardStep)
+	}
+	if got := policy.readShards(defaultShardStep * 1000); got != defaultMaxShards {
+		t.Errorf("Expected %d shards, got %d", defaultMaxShards, got)
+	}
+}
+
+func TestKeySplittingPolicy_Delete(t *testing.T) {
+	tests := []struct {
+		config   KeySplittingConfig
+		expected int
+	}{
+		{KeySplittingConfig{Shards: 3}, 3},
+		{KeySplittingConfig{AutoShards: true, MaxShards: 8}, 8},
+	}
+
+	for _, tt := range tests {
+		result := newKeySplittingPolicy(tt.config).Apply(Context{Key: "key", Data: DeleteRequest{}})
+		action, ok := result.Data.(KeySplittingDeleteAction)
+		if !ok {
+			t.Fatalf("Expected KeySplittingDeleteAction, got %T (err: %v)", result.Data, result.Error)
+		}
+		if len(action.ShardKeys) != tt.expected {
+			t.Errorf("Expected every possible shard (%d) to be deleted, got %v", tt.expected, action.ShardKeys)
+		}
+	}
+}
+
+func TestKeySplittingPolicy_ConsistentRouting(t *testing.T) {
+	config := KeySplittingConfig{
+		Shards:            8,
+		ConsistentRouting: true,
+	}
+	policy := newKeySplittingPolicy(config)
+
+	selected := make(map[int]bool)
+	for i := range 20 {
+		routingKey := fmt.Sprintf("conn-%d", i)
+		ctx := Context{Key: "hot-key", Data: GetRequest{RoutingKey: routingKey}}
+
+		first := policy.Apply(ctx).Data.(KeySplittingGetAction)
+		for range 50 {
+			action := policy.Apply(ctx).Data.(KeySplittingGetAction)
+			if action.ShardIndex != first.ShardIndex || action.RandShardKey != first.RandShardKey {
+				t.Fatalf("Routing key %s: expected shard %d, got %d", routingKey, first.ShardIndex, action.ShardIndex)
+			}
+		}
+		selected[first.ShardIndex] = true
+	}
+
+	if len(selected) < 2 {
+		t.Errorf("Expected routing keys to spread across shards, got %d distinct shards", len(selected))
+	}
+}
+
+func TestJumpHash(t *testing.T) {
+	// Growing the number of buckets only moves keys to the new bucket
+	for key := range uint64(1000) {
+		prev := jumpHash(key, 10)
+		next := jumpHash(key, 11)
+		if prev < 0 || prev >= 10 {
+			t.Fatalf("Bucket %d out of range", prev)
+		}
+		if next != prev && next != 10 {
+			t.Errorf("Key %d moved from bucket %d to %d", key, prev, next)
+		}
+	}
+}
+
+func TestRoutingKey(t *testing.T) {
+	if got := RoutingKey(context.Background()); got != "" {
+		t.Errorf("Expected empty routing key, got %q", got)
+	}
+
+	ctx := WithRoutingKey(context.Background(), "worker-1")
+	if got := RoutingKey(ctx); got != "worker-1" {
+		t.Errorf("Expected routing key 'worker-1', got %q", got)
+	}
+}
+
+// BenchmarkKeySplittingPolicy_Get measures selecting a shard for a read
+func BenchmarkKeySplittingPolicy_Get(b *testing.B) {
+	policy := newKeySplittingPolicy(KeySplittingConfig{Shards: 10})
+	ctx := Context{Key: "test-key", Data: GetRequest{}}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		policy.Apply(ctx)
+	}
+}
+
+func TestRetryConfig_Do(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxAttempts      int
+		failures         int
+		expectedAttempts int
+		expectErr        bool
+	}{
+		{name: "succeeds first", maxAttempts: 3, failures: 0, expectedAttempts: 1},
+		{name: "succeeds after retries", maxAttempts: 3, failures: 2, expectedAttempts: 3},
+		{name: "gives up", maxAttempts: 3, failures: 5, expectedAttempts: 3, expectErr: true},
+		{name: "no retry", maxAttempts: 1, failures: 1, expectedAttempts: 1, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retry := RetryConfig{MaxAttempts: tt.maxAttempts, Backoff: time.Millisecond}
+
+			attempts := 0
+			err := retry.Do(context.Background(), func() error {
+				attempts++
+				if attempts <= tt.failures {
+					return fmt.Errorf("attempt %d failed", attempts)
+				}
+				return nil
+			})
+
+			if attempts != tt.expectedAttempts {
+				t.Errorf("Expected %d attempts, got %d", tt.expectedAttempts, attempts)
+			}
+			if (err != nil) != tt.expectErr {
+				t.Errorf("Expected error: %v, got: %v", tt.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestRetryConfig_DoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	retry := RetryConfig{MaxAttempts: 3, Backoff: time.Hour}
+	attempts := 0
+	err := retry.Do(ctx, func() error {
+		attempts++
+		return fmt.Errorf("failed")
+	})
+
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got: %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("Expected no retry after cancellation, got %d attempts", attempts)
+	}
+}
+
+func TestKeySplittingPolicy_Retry(t *testing.T) {
+	policy := newKeySplittingPolicy(KeySplittingConfig{
+		Shards:              3,
+		ReplicationAttempts: 3,
+		ReplicationBackoff:  10 * time.Millisecond,
+	})
+
+	result := policy.Apply(Context{Key: "test-key", Data: SetRequest{Value: "value"}})
+	action, ok := result.Data.(KeySplittingSetAction)
+	if !ok {
+		t.Fatalf("Expected KeySplittingSetAction, got: %T", result.Data)
+	}
+	if action.Retry.MaxAttempts != 3 || action.Retry.Backoff != 10*time.Millisecond {
+		t.Errorf("Expected retry of 3 attempts with 10ms backoff, got: %+v", action.Retry)
+	}
+
+	// Without retries, a shard write is attempted once
+	policy = newKeySplittingPolicy(KeySplittingConfig{Shards: 3})
+	result = policy.Apply(Context{Key: "test-key", Data: GetRequest{}})
+	if action := result.Data.(KeySplittingGetAction); action.Retry.MaxAttempts != 1 {
+		t.Errorf("Expected 1 attempt by default, got %d", action.Retry.MaxAttempts)
+	}
+}
+
+func TestKeySplittingPolicy_FallbackTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   KeySplittingConfig
+		expected time.Duration
+	}{
+		{name: "configured", config: KeySplittingConfig{Shards: 2, FallbackTTL: 5 * time.Minute}, expected: 5 * time.Minute},
+		{name: "default", config: KeySplittingConfig{Shards: 2}, expected: defaultFallbackTTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := newKeySplittingPolicy(tt.config).Apply(Context{Key: "test-key", Data: GetRequest{}})
+			action, ok := result.Data.(KeySplittingGetAction)
+			if !ok {
+				t.Fatalf("Expected KeySplittingGetAction, got: %T", result.Data)
+			}
+			if action.FallbackTTL != tt.expected {
+				t.Errorf("Expected fallback TTL %v, got %v", tt.expected, action.FallbackTTL)
+			}
+		})
+	}
+}
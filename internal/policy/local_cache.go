@@ -4,10 +4,25 @@ import (
 	"crypto/rand"
 	"fmt"
 	"math"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// maxLocalCacheJitter bounds the jitter so TTLs stay within ±50% of the configured TTL
+	maxLocalCacheJitter = 0.5
+
+	// defaultLocalCacheRefreshAhead is used when RefreshAhead is outside (0.0, 1.0)
+	defaultLocalCacheRefreshAhead = 0.8
+
+	// cacheItemOverhead approximates the bytes taken by an item besides its key and value
+	// (the item itself and its map entry)
+	cacheItemOverhead = 96
+)
+
 // CacheItem represents an item stored in the local cache
 type CacheItem struct {
 	Key        string
@@ -26,6 +41,27 @@ func (c *CacheItem) ShouldRefresh() bool {
 	return time.Now().After(c.RefreshAt)
 }
 
+// SizedValue is implemented by cached values that know the size of the data they hold,
+// such as the results cached by the rueidis wrapper
+type SizedValue interface {
+	Size() int
+}
+
+// estimatedBytes estimates the memory taken by the item
+// Only the sizes of []byte, string and SizedValue values are known, other values count as overhead only
+func (c *CacheItem) estimatedBytes() int64 {
+	size := int64(cacheItemOverhead + len(c.Key))
+	switch v := c.Value.(type) {
+	case []byte:
+		size += int64(len(v))
+	case string:
+		size += int64(len(v))
+	case SizedValue:
+		size += int64(v.Size())
+	}
+	return size
+}
+
 // localCachePolicy implements the Policy interface for local cache
 type localCachePolicy struct {
 	config LocalCacheConfig
@@ -34,10 +70,28 @@ type localCachePolicy struct {
 	cache map[string]*CacheItem
 	mu    sync.RWMutex
 	size  int
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
 }
 
 // newLocalCachePolicy creates a new local cache policy
 func newLocalCachePolicy(config LocalCacheConfig) Policy {
+	if config.Jitter < 0 {
+		config.Jitter = 0
+	} else if config.Jitter > maxLocalCacheJitter {
+		config.Jitter = maxLocalCacheJitter
+	}
+
+	// RefreshAt must be strictly before Expiration and not right after the set
+	if config.RefreshAhead <= 0 || config.RefreshAhead >= 1 {
+		config.RefreshAhead = defaultLocalCacheRefreshAhead
+	}
+
+	if config.MaxTTL < 0 {
+		config.MaxTTL = 0
+	}
+
 	return &localCachePolicy{
 		config: config,
 		cache:  make(map[string]*CacheItem),
@@ -53,6 +107,8 @@ func (p *localCachePolicy) Apply(ctx Context) Result {
 		return p.handleGet(ctx)
 	case SetRequest:
 		return p.handleSet(ctx)
+	case DeleteRequest:
+		return p.handleDelete(ctx)
 	default:
 		return Result{
 			Data:  nil,
@@ -68,6 +124,7 @@ func (p *localCachePolicy) handleGet(ctx Context) Result {
 	p.mu.RUnlock()
 
 	if !ok {
+		p.misses.Add(1)
 		return Result{
 			Data: CacheMiss{Key: ctx.Key},
 		}
@@ -75,6 +132,8 @@ func (p *localCachePolicy) handleGet(ctx Context) Result {
 
 	// Check if item is expired
 	if item.IsExpired() {
+		p.misses.Add(1)
+
 		// Remove expired item
 		p.mu.Lock()
 		delete(p.cache, ctx.Key)
@@ -86,13 +145,24 @@ func (p *localCachePolicy) handleGet(ctx Context) Result {
 		}
 	}
 
+	value := item.Value
+	if p.config.Codec != nil {
+		var err error
+		if value, err = p.config.Codec.Unmarshal(item.Value.([]byte)); err != nil {
+			return Result{
+				Error: fmt.Errorf("failed to decode cached value: %w", err),
+			}
+		}
+	}
+
 	// Check if item should be refreshed
 	shouldRefresh := item.ShouldRefresh()
+	p.hits.Add(1)
 
 	return Result{
 		Data: CacheHit{
 			Key:           ctx.Key,
-			Value:         item.Value,
+			Value:         value,
 			ShouldRefresh: shouldRefresh,
 		},
 	}
@@ -108,6 +178,17 @@ func (p *localCachePolicy) handleSet(ctx Context) Result {
 		}
 	}
 
+	value := req.Value
+	if p.config.Codec != nil {
+		data, err := p.config.Codec.Marshal(req.Value)
+		if err != nil {
+			return Result{
+				Error: fmt.Errorf("failed to encode value: %w", err),
+			}
+		}
+		value = data
+	}
+
 	// Check capacity before adding new item
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -119,13 +200,17 @@ func (p *localCachePolicy) handleSet(ctx Context) Result {
 
 	// Calculate TTL with jitter
 	ttl := p.calculateTTLWithJitter()
-	expiration := time.Now().Add(time.Duration(ttl) * time.Second)
-	refreshAt := time.Now().Add(time.Duration(ttl*p.config.RefreshAhead) * time.Second)
+	if req.MaxTTL > 0 && ttl > req.MaxTTL {
+		ttl = req.MaxTTL
+	}
+	now := time.Now()
+	expiration := now.Add(time.Duration(ttl * float64(time.Second)))
+	refreshAt := now.Add(time.Duration(ttl * p.config.RefreshAhead * float64(time.Second)))
 
 	// Create cache item
 	item := &CacheItem{
 		Key:        ctx.Key,
-		Value:      req.Value,
+		Value:      value,
 		Expiration: expiration,
 		RefreshAt:  refreshAt,
 	}
@@ -141,13 +226,37 @@ func (p *localCachePolicy) handleSet(ctx Context) Result {
 	}
 }
 
-// calculateTTLWithJitter calculates TTL with random jitter
+// handleDelete handles DELETE operations by evicting the key from the cache
+func (p *localCachePolicy) handleDelete(ctx Context) Result {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.cache[ctx.Key]
+	if ok {
+		delete(p.cache, ctx.Key)
+		p.size--
+	}
+
+	return Result{
+		Data: CacheDelete{Key: ctx.Key, Deleted: ok},
+	}
+}
+
+// calculateTTLWithJitter calculates TTL with random jitter, capped at MaxTTL
 func (p *localCachePolicy) calculateTTLWithJitter() float64 {
-	if p.config.Jitter <= 0 {
-		return p.config.TTL
+	ttl := p.config.TTL
+	if p.config.Jitter > 0 {
+		ttl += p.randomJitter()
 	}
 
-	// Generate random jitter between -jitter and +jitter
+	if p.config.MaxTTL > 0 && ttl > p.config.MaxTTL {
+		ttl = p.config.MaxTTL
+	}
+	return ttl
+}
+
+// randomJitter returns a random jitter between -TTL*Jitter and +TTL*Jitter
+func (p *localCachePolicy) randomJitter() float64 {
 	jitterRange := p.config.TTL * p.config.Jitter
 	randomBytes := make([]byte, 8)
 	rand.Read(randomBytes)
@@ -162,8 +271,7 @@ func (p *localCachePolicy) calculateTTLWithJitter() float64 {
 		int64(randomBytes[6])<<8|
 		int64(randomBytes[7])) / float64(math.MaxInt64)
 
-	jitter := randomValue * jitterRange
-	return p.config.TTL + jitter
+	return randomValue * jitterRange
 }
 
 // evictLRU evicts the least recently used item from cache
@@ -188,33 +296,95 @@ func (p *localCachePolicy) evictLRU() {
 	}
 }
 
+// Keys returns the keys currently held in the cache, including expired items not yet removed
+func (p *localCachePolicy) Keys() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	keys := make([]string, 0, len(p.cache))
+	for key := range p.cache {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Items returns copies of up to limit cached items sorted by key (all items if limit <= 0)
+func (p *localCachePolicy) Items(limit int) []CacheItem {
+	p.mu.RLock()
+	items := make([]CacheItem, 0, len(p.cache))
+	for _, item := range p.cache {
+		items = append(items, *item)
+	}
+	p.mu.RUnlock()
+
+	slices.SortFunc(items, func(a, b CacheItem) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+// Reset removes all items and clears the statistics
+// The cache map is kept, so a reset policy can be reused without reallocating
+func (p *localCachePolicy) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	clear(p.cache)
+	p.size = 0
+	p.hits.Store(0)
+	p.misses.Store(0)
+}
+
 // GetCacheStats returns cache statistics for monitoring
 func (p *localCachePolicy) GetCacheStats() CacheStats {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	expiredCount := 0
+	stats := CacheStats{
+		Size:     p.size,
+		Capacity: int(p.config.Capacity),
+		Hits:     p.hits.Load(),
+		Misses:   p.misses.Load(),
+	}
+
 	for _, item := range p.cache {
+		// Expired items still hold memory until they're evicted
+		stats.Bytes += item.estimatedBytes()
+
 		if item.IsExpired() {
-			expiredCount++
+			stats.ExpiredItems++
+			continue
+		}
+		if item.ShouldRefresh() {
+			stats.NeedingRefresh++
+		}
+		if stats.SoonestExpiration.IsZero() || item.Expiration.Before(stats.SoonestExpiration) {
+			stats.SoonestExpiration = item.Expiration
+		}
+		if item.Expiration.After(stats.LatestExpiration) {
+			stats.LatestExpiration = item.Expiration
 		}
 	}
 
-	return CacheStats{
-		Size:         p.size,
-		Capacity:     int(p.config.Capacity),
-		ExpiredItems: expiredCount,
-	}
+	return stats
 }
 
 // Request types for different operations
-type GetRequest struct{}
+type GetRequest struct {
+	RoutingKey string // Optional key for consistent shard selection
+}
 
 type SetRequest struct {
-	Value any
-	TTL   *float64 // Optional TTL override
+	Value  any
+	TTL    *float64 // Optional TTL override
+	MaxTTL float64  // Upper bound for the TTL in seconds, such as the backend TTL (0 means no limit)
 }
 
+type DeleteRequest struct{}
+
 // Response types for different operations
 type CacheHit struct {
 	Key           string
@@ -231,8 +401,24 @@ type CacheSet struct {
 	TTL float64
 }
 
+type CacheDelete struct {
+	Key     string
+	Deleted bool // false if the key wasn't cached
+}
+
 type CacheStats struct {
 	Size         int
 	Capacity     int
 	ExpiredItems int
+	Hits         uint64
+	Misses       uint64
+
+	// NeedingRefresh is the number of unexpired items past their refresh-ahead point
+	NeedingRefresh int
+	// SoonestExpiration and LatestExpiration bound the expirations of unexpired items (zero if none)
+	SoonestExpiration time.Time
+	LatestExpiration  time.Time
+
+	// Bytes is the estimated memory taken by the cached items, including expired ones
+	Bytes int64
 }
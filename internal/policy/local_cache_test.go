@@ -1,7 +1,10 @@
 package policy
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"slices"
 	"testing"
 	"time"
 )
@@ -319,6 +322,82 @@ func TestLocalCachePolicy_Jitter(t *testing.T) {
 	}
 }
 
+func TestLocalCachePolicy_MaxTTL(t *testing.T) {
+	config := LocalCacheConfig{
+		TTL:          60,
+		Jitter:       0.5,
+		Capacity:     100,
+		RefreshAhead: 0.8,
+		MaxTTL:       65,
+	}
+	policy := newLocalCachePolicy(config).(*localCachePolicy)
+
+	for i := 0; i < 100; i++ {
+		if ttl := policy.calculateTTLWithJitter(); ttl > 65 {
+			t.Fatalf("TTL %f exceeds MaxTTL 65", ttl)
+		}
+	}
+}
+
+func TestLocalCachePolicy_JitterClamped(t *testing.T) {
+	config := LocalCacheConfig{
+		TTL:          60,
+		Jitter:       5.0, // Would allow negative TTLs if not clamped
+		Capacity:     100,
+		RefreshAhead: 0.8,
+	}
+	policy := newLocalCachePolicy(config).(*localCachePolicy)
+
+	minTTL := 60 * (1 - maxLocalCacheJitter)
+	maxTTL := 60 * (1 + maxLocalCacheJitter)
+	for i := 0; i < 100; i++ {
+		if ttl := policy.calculateTTLWithJitter(); ttl < minTTL || ttl > maxTTL {
+			t.Fatalf("TTL %f is outside expected range [%f, %f]", ttl, minTTL, maxTTL)
+		}
+	}
+}
+
+func TestLocalCachePolicy_RefreshBeforeExpiration(t *testing.T) {
+	tests := []struct {
+		name         string
+		refreshAhead float64
+	}{
+		{"zero", 0.0},
+		{"one", 1.0},
+		{"negative", -0.5},
+		{"greater than one", 1.5},
+		{"valid", 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := LocalCacheConfig{
+				TTL:          60,
+				Jitter:       0.2,
+				Capacity:     100,
+				RefreshAhead: tt.refreshAhead,
+			}
+			policy := newLocalCachePolicy(config).(*localCachePolicy)
+
+			for i := 0; i < 10; i++ {
+				key := testKey(i)
+				policy.Apply(Context{Key: key, Data: SetRequest{Value: testValue(i)}})
+
+				item := policy.cache[key]
+				if !item.RefreshAt.Before(item.Expiration) {
+					t.Errorf("Expected RefreshAt %v before Expiration %v", item.RefreshAt, item.Expiration)
+				}
+			}
+
+			// A freshly set item shouldn't need a refresh right away
+			result := policy.Apply(Context{Key: testKey(0), Data: GetRequest{}})
+			if hit, ok := result.Data.(CacheHit); !ok || hit.ShouldRefresh {
+				t.Errorf("Expected CacheHit without refresh, got: %+v", result.Data)
+			}
+		})
+	}
+}
+
 func TestLocalCachePolicy_GetCacheStats(t *testing.T) {
 	config := LocalCacheConfig{
 		TTL:          0.1, // Short TTL for testing expired items
@@ -367,6 +446,122 @@ func TestLocalCachePolicy_GetCacheStats(t *testing.T) {
 	}
 }
 
+func TestLocalCachePolicy_GetCacheStats_Freshness(t *testing.T) {
+	policy := newLocalCachePolicy(LocalCacheConfig{TTL: 60, Capacity: 100}).(*localCachePolicy)
+
+	// Items with staggered TTLs: one past its refresh point, two fresh and one expired
+	now := time.Now()
+	items := []CacheItem{
+		{Key: "refresh", Expiration: now.Add(10 * time.Second), RefreshAt: now.Add(-time.Second)},
+		{Key: "fresh1", Expiration: now.Add(30 * time.Second), RefreshAt: now.Add(20 * time.Second)},
+		{Key: "fresh2", Expiration: now.Add(60 * time.Second), RefreshAt: now.Add(50 * time.Second)},
+		{Key: "expired", Expiration: now.Add(-time.Second), RefreshAt: now.Add(-2 * time.Second)},
+	}
+	for _, item := range items {
+		policy.cache[item.Key] = &item
+		policy.size++
+	}
+
+	stats := policy.GetCacheStats()
+
+	if stats.ExpiredItems != 1 {
+		t.Errorf("Expected 1 expired item, got %d", stats.ExpiredItems)
+	}
+	if stats.NeedingRefresh != 1 {
+		t.Errorf("Expected 1 item needing refresh, got %d", stats.NeedingRefresh)
+	}
+	if !stats.SoonestExpiration.Equal(items[0].Expiration) {
+		t.Errorf("Expected soonest expiration %v, got %v", items[0].Expiration, stats.SoonestExpiration)
+	}
+	if !stats.LatestExpiration.Equal(items[2].Expiration) {
+		t.Errorf("Expected latest expiration %v, got %v", items[2].Expiration, stats.LatestExpiration)
+	}
+}
+
+// sizedValue is a SizedValue of the given size
+type sizedValue int
+
+func (v sizedValue) Size() int {
+	return int(v)
+}
+
+func TestLocalCachePolicy_GetCacheStats_Bytes(t *testing.T) {
+	policy := newLocalCachePolicy(LocalCacheConfig{TTL: 60, Capacity: 100}).(*localCachePolicy)
+
+	policy.Apply(Context{Key: "bytes", Data: SetRequest{Value: make([]byte, 1000)}})
+	policy.Apply(Context{Key: "string", Data: SetRequest{Value: "value"}})
+	policy.Apply(Context{Key: "int", Data: SetRequest{Value: 42}})
+	policy.Apply(Context{Key: "sized", Data: SetRequest{Value: sizedValue(500)}})
+
+	expected := int64(4*cacheItemOverhead + len("bytes") + 1000 + len("string") + len("value") + len("int") + len("sized") + 500)
+	if stats := policy.GetCacheStats(); stats.Bytes != expected {
+		t.Errorf("Expected %d estimated bytes, got %d", expected, stats.Bytes)
+	}
+}
+
+func TestLocalCachePolicy_GetCacheStats_Empty(t *testing.T) {
+	policy := newLocalCachePolicy(LocalCacheConfig{TTL: 60, Capacity: 100}).(*localCachePolicy)
+
+	stats := policy.GetCacheStats()
+	if stats.NeedingRefresh != 0 || !stats.SoonestExpiration.IsZero() || !stats.LatestExpiration.IsZero() {
+		t.Errorf("Expected empty freshness stats, got %+v", stats)
+	}
+}
+
+func TestLocalCachePolicy_HitMissStats(t *testing.T) {
+	config := LocalCacheConfig{
+		TTL:          60,
+		Capacity:     100,
+		RefreshAhead: 0.8,
+	}
+	policy := newLocalCachePolicy(config).(*localCachePolicy)
+
+	policy.Apply(Context{Key: "key", Data: GetRequest{}})
+	policy.Apply(Context{Key: "key", Data: SetRequest{Value: "value"}})
+	policy.Apply(Context{Key: "key", Data: GetRequest{}})
+	policy.Apply(Context{Key: "key", Data: GetRequest{}})
+
+	stats := policy.GetCacheStats()
+	if stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("Expected 2 hits and 1 miss, got %d hits and %d misses", stats.Hits, stats.Misses)
+	}
+}
+
+func TestLocalCachePolicy_Delete(t *testing.T) {
+	config := LocalCacheConfig{
+		TTL:          60,
+		Capacity:     100,
+		RefreshAhead: 0.8,
+	}
+	policy := newLocalCachePolicy(config).(*localCachePolicy)
+
+	policy.Apply(Context{Key: "key", Data: SetRequest{Value: "value"}})
+
+	result := policy.Apply(Context{Key: "key", Data: DeleteRequest{}})
+	if result.Error != nil {
+		t.Fatalf("Expected successful delete, got error: %v", result.Error)
+	}
+	if deleted, ok := result.Data.(CacheDelete); !ok || !deleted.Deleted {
+		t.Errorf("Expected deleted CacheDelete, got: %v", result.Data)
+	}
+
+	if _, ok := policy.Apply(Context{Key: "key", Data: GetRequest{}}).Data.(CacheMiss); !ok {
+		t.Error("Expected cache miss after delete")
+	}
+	if size := policy.GetCacheStats().Size; size != 0 {
+		t.Errorf("Expected empty cache, got size %d", size)
+	}
+
+	// Deleting an uncached key is a no-op
+	result = policy.Apply(Context{Key: "key", Data: DeleteRequest{}})
+	if deleted, ok := result.Data.(CacheDelete); !ok || deleted.Deleted {
+		t.Errorf("Expected not deleted CacheDelete, got: %v", result.Data)
+	}
+	if size := policy.GetCacheStats().Size; size != 0 {
+		t.Errorf("Expected empty cache, got size %d", size)
+	}
+}
+
 func TestLocalCachePolicy_SetOverwrite(t *testing.T) {
 	config := LocalCacheConfig{
 		TTL:          60,
@@ -420,6 +615,111 @@ func TestLocalCachePolicy_SetOverwrite(t *testing.T) {
 	}
 }
 
+func TestLocalCachePolicy_Reset(t *testing.T) {
+	policy := newLocalCachePolicy(LocalCacheConfig{TTL: 60, Capacity: 10}).(*localCachePolicy)
+
+	policy.Apply(Context{Key: "key", Data: SetRequest{Value: "value"}})
+	policy.Apply(Context{Key: "key", Data: GetRequest{}})
+	policy.Apply(Context{Key: "missing", Data: GetRequest{}})
+
+	policy.Reset()
+
+	stats := policy.GetCacheStats()
+	if stats.Size != 0 || stats.Hits != 0 || stats.Misses != 0 {
+		t.Errorf("Expected empty cache and statistics after Reset, got %+v", stats)
+	}
+	if _, ok := policy.Apply(Context{Key: "key", Data: GetRequest{}}).Data.(CacheMiss); !ok {
+		t.Error("Expected cache miss after Reset")
+	}
+}
+
+func TestLocalCachePolicy_KeysAndItems(t *testing.T) {
+	policy := newLocalCachePolicy(LocalCacheConfig{TTL: 60, Capacity: 10}).(*localCachePolicy)
+
+	for _, key := range []string{"c", "a", "b"} {
+		policy.Apply(Context{Key: key, Data: SetRequest{Value: "value-" + key}})
+	}
+
+	keys := policy.Keys()
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"a", "b", "c"}) {
+		t.Errorf("Expected keys [a b c], got %v", keys)
+	}
+
+	items := policy.Items(2)
+	if len(items) != 2 || items[0].Key != "a" || items[1].Key != "b" {
+		t.Fatalf("Expected the first 2 items sorted by key, got %v", items)
+	}
+	if items[0].Value != "value-a" {
+		t.Errorf("Expected value 'value-a', got %v", items[0].Value)
+	}
+}
+
+// newBenchmarkLocalCache returns a local cache policy holding n items
+func newBenchmarkLocalCache(n int) *localCachePolicy {
+	policy := newLocalCachePolicy(LocalCacheConfig{
+		TTL:      60,
+		Jitter:   0.1,
+		Capacity: float64(n),
+	}).(*localCachePolicy)
+	for i := 0; i < n; i++ {
+		policy.Apply(Context{Key: testKey(i), Data: SetRequest{Value: testValue(i)}})
+	}
+	return policy
+}
+
+// BenchmarkLocalCachePolicy_GetHit measures serving a cached hot key
+func BenchmarkLocalCachePolicy_GetHit(b *testing.B) {
+	policy := newBenchmarkLocalCache(1000)
+	ctx := Context{Key: testKey(500), Data: GetRequest{}}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		policy.Apply(ctx)
+	}
+}
+
+// BenchmarkLocalCachePolicy_GetHitParallel measures cache hits under lock contention
+func BenchmarkLocalCachePolicy_GetHitParallel(b *testing.B) {
+	policy := newBenchmarkLocalCache(1000)
+	ctx := Context{Key: testKey(500), Data: GetRequest{}}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			policy.Apply(ctx)
+		}
+	})
+}
+
+// BenchmarkLocalCachePolicy_Set measures overwriting cached keys, which doesn't evict
+func BenchmarkLocalCachePolicy_Set(b *testing.B) {
+	policy := newBenchmarkLocalCache(1000)
+	ctx := Context{Key: testKey(500), Data: SetRequest{Value: testValue(500)}}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		policy.Apply(ctx)
+	}
+}
+
+// BenchmarkLocalCachePolicy_SetReset measures filling a cache and resetting it between iterations
+func BenchmarkLocalCachePolicy_SetReset(b *testing.B) {
+	policy := newBenchmarkLocalCache(100)
+	ctxs := make([]Context, 100)
+	for i := range ctxs {
+		ctxs[i] = Context{Key: testKey(i), Data: SetRequest{Value: testValue(i)}}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, ctx := range ctxs {
+			policy.Apply(ctx)
+		}
+		policy.Reset()
+	}
+}
+
 // Helper functions for testing
 func testKey(i int) string {
 	return fmt.Sprintf("key%d", i)
@@ -428,3 +728,61 @@ func testKey(i int) string {
 func testValue(i int) string {
 	return fmt.Sprintf("value%d", i)
 }
+
+// product is a struct cached through jsonCodec
+type product struct {
+	Name  string   `json:"name"`
+	Price int      `json:"price"`
+	Tags  []string `json:"tags"`
+}
+
+// jsonCodec encodes products as JSON
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(value any) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte) (any, error) {
+	var p product
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func TestLocalCachePolicy_Codec(t *testing.T) {
+	policy := newLocalCachePolicy(LocalCacheConfig{TTL: 60, Capacity: 10, Codec: jsonCodec{}})
+
+	original := &product{Name: "keyboard", Price: 120, Tags: []string{"mechanical"}}
+	if result := policy.Apply(Context{Key: "product:1", Data: SetRequest{Value: original}}); result.Error != nil {
+		t.Fatalf("Failed to set value: %v", result.Error)
+	}
+
+	// The cache holds an encoded copy, so later changes to the value don't leak into it
+	original.Price = 0
+	original.Tags[0] = "changed"
+
+	result := policy.Apply(Context{Key: "product:1", Data: GetRequest{}})
+	hit, ok := result.Data.(CacheHit)
+	if !ok {
+		t.Fatalf("Expected CacheHit, got %T (err: %v)", result.Data, result.Error)
+	}
+
+	expected := &product{Name: "keyboard", Price: 120, Tags: []string{"mechanical"}}
+	if !reflect.DeepEqual(hit.Value, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, hit.Value)
+	}
+}
+
+func TestLocalCachePolicy_CodecError(t *testing.T) {
+	policy := newLocalCachePolicy(LocalCacheConfig{TTL: 60, Capacity: 10, Codec: jsonCodec{}})
+
+	// Channels can't be encoded as JSON
+	if result := policy.Apply(Context{Key: "key", Data: SetRequest{Value: make(chan int)}}); result.Error == nil {
+		t.Error("Expected an error for a value the codec can't encode")
+	}
+	if result := policy.Apply(Context{Key: "key", Data: GetRequest{}}); result.Data != (CacheMiss{Key: "key"}) {
+		t.Errorf("Expected the value not to be cached, got %+v", result.Data)
+	}
+}
@@ -2,9 +2,12 @@
 package policy
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Type defines the type of policy
@@ -17,6 +20,9 @@ const (
 	KeySplitting Type = "key-splitting"
 )
 
+// ErrInvalidParams is returned when the parameters don't match the policy type
+var ErrInvalidParams = errors.New("invalid policy parameters")
+
 // Config contains configuration options for policy management
 type Config struct {
 	// Type determines which policy to use
@@ -30,6 +36,10 @@ type Config struct {
 
 	// WhitelistPatterns is a list of regex patterns to whitelist keys
 	WhitelistPatterns []string
+
+	// WhitelistGlobs is a list of glob patterns to whitelist keys
+	// "*" matches any sequence of characters and "?" matches a single character
+	WhitelistGlobs []string
 }
 
 // LocalCacheConfig defines parameters for local cache policy
@@ -45,18 +55,73 @@ type LocalCacheConfig struct {
 
 	// RefreshAhead determines when to refresh items before expiration (0.0-1.0)
 	RefreshAhead float64
+
+	// MaxTTL is the upper bound for the jittered TTL in seconds (0 means no limit)
+	MaxTTL float64
+
+	// CapToBackendTTL makes the wrappers cap the TTL of cached values to the remaining TTL
+	// of the key in the backend, so values expired in the backend aren't served locally
+	CapToBackendTTL bool
+
+	// Codec serializes the cached values, if set
+	// Values are stored encoded and decoded on every hit, so the cache holds a copy of
+	// richer types than strings that can't be mutated by the caller
+	Codec Codec
+}
+
+// Codec encodes and decodes the values held by the local cache
+type Codec interface {
+	// Marshal encodes a value set in the cache
+	Marshal(value any) ([]byte, error)
+
+	// Unmarshal decodes a cached value on a hit
+	Unmarshal(data []byte) (any, error)
 }
 
 // KeySplittingConfig defines parameters for key splitting policy
 type KeySplittingConfig struct {
 	// Shards is the number of shards to split keys into
 	Shards int64
+
+	// AutoShards scales the number of shards with the key's access count
+	// instead of using a fixed Shards
+	AutoShards bool
+
+	// MaxShards is the upper bound for the number of shards in AutoShards mode (default: 16)
+	MaxShards int64
+
+	// ShardStep is the access count per shard in AutoShards mode (default: 1000)
+	// Reads of a key spread over count/ShardStep shards, at least 2 and at most MaxShards,
+	// while writes always fill MaxShards shards
+	ShardStep uint64
+
+	// ConsistentRouting selects the shard to read by consistent hashing of the
+	// request's routing key, so a reader keeps reading the same shard
+	// Reads without a routing key still select a random shard
+	ConsistentRouting bool
+
+	// TTLJitter is the randomness factor for the TTLs of shard keys (0.0-0.5)
+	// Shards written together then expire staggered instead of all at once
+	TTLJitter float64
+
+	// ReplicationAttempts is the number of attempts of each shard write, including the first (default: 1)
+	ReplicationAttempts int
+
+	// ReplicationBackoff is the delay before the first retry of a shard write, doubled after each retry
+	ReplicationBackoff time.Duration
+
+	// FallbackTTL is the TTL of shards filled from the original key after a shard miss,
+	// used when the remaining TTL of the original key can't be obtained (default: 1 minute)
+	FallbackTTL time.Duration
 }
 
 // Context contains runtime context for policy execution
 type Context struct {
 	Key  string
 	Data any
+
+	// Count is the estimated access count of the key, used by adaptive policies
+	Count uint64
 }
 
 // Result contains the result of policy execution
@@ -73,17 +138,30 @@ type Policy interface {
 
 // Manager defines the interface for policy management
 type Manager interface {
-	// GetPolicy returns the policy for a given key
+	// GetPolicy returns the policy for a given key, or nil if no rule matches it
+	// Exact whitelist keys take precedence over patterns and globs, which are matched in
+	// no particular order, so a rule matching a key never depends on the others
 	GetPolicy(key string) Policy
 
 	// RegisterPattern registers a pattern-based policy selection rule
 	RegisterPattern(pattern string) error
 
+	// RegisterGlob registers a glob-based policy selection rule
+	RegisterGlob(glob string) error
+
 	// AddWhitelistKey adds a key to the whitelist
 	AddWhitelistKey(key string)
 
 	// RemoveWhitelistKey removes a key from the whitelist
 	RemoveWhitelistKey(key string)
+
+	// CacheStats returns the local cache statistics
+	// It returns false if the policy is not a local cache
+	CacheStats() (CacheStats, bool)
+
+	// CacheItems returns up to limit items held in the local cache, sorted by key
+	// It returns false if the policy is not a local cache
+	CacheItems(limit int) ([]CacheItem, bool)
 }
 
 // manager implements the Manager interface
@@ -102,13 +180,16 @@ func New(config Config) (Manager, error) {
 	case LocalCache:
 		params, ok := config.Parameters.(LocalCacheConfig)
 		if !ok {
-			return nil, fmt.Errorf("invalid parameters type for LocalCache policy: expected LocalCacheConfig, got %T", config.Parameters)
+			return nil, fmt.Errorf("%w for LocalCache policy: expected LocalCacheConfig, got %T", ErrInvalidParams, config.Parameters)
 		}
 		p = newLocalCachePolicy(params)
 	case KeySplitting:
 		params, ok := config.Parameters.(KeySplittingConfig)
 		if !ok {
-			return nil, fmt.Errorf("invalid parameters type for KeySplitting policy: expected KeySplittingConfig, got %T", config.Parameters)
+			return nil, fmt.Errorf("%w for KeySplitting policy: expected KeySplittingConfig, got %T", ErrInvalidParams, config.Parameters)
+		}
+		if err := params.validate(); err != nil {
+			return nil, err
 		}
 		p = newKeySplittingPolicy(params)
 	default:
@@ -134,10 +215,18 @@ func New(config Config) (Manager, error) {
 		}
 	}
 
+	// Add whitelist globs
+	for _, glob := range config.WhitelistGlobs {
+		if err := m.RegisterGlob(glob); err != nil {
+			return nil, fmt.Errorf("invalid whitelist glob '%s': %w", glob, err)
+		}
+	}
+
 	return m, nil
 }
 
 // GetPolicy returns the policy for a given key
+// The exact key is checked before the patterns
 func (m *manager) GetPolicy(key string) Policy {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -174,6 +263,30 @@ func (m *manager) RegisterPattern(pattern string) error {
 	return nil
 }
 
+// RegisterGlob registers a glob-based policy selection rule
+// The glob is compiled into an anchored regular expression
+func (m *manager) RegisterGlob(glob string) error {
+	return m.RegisterPattern(globToRegexp(glob))
+}
+
+// globToRegexp converts a glob pattern into an anchored regular expression
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
 // AddWhitelistKey adds a key to the whitelist
 func (m *manager) AddWhitelistKey(key string) {
 	m.mu.Lock()
@@ -187,3 +300,21 @@ func (m *manager) RemoveWhitelistKey(key string) {
 	defer m.mu.Unlock()
 	delete(m.whitelistKeys, key)
 }
+
+// CacheStats returns the local cache statistics
+func (m *manager) CacheStats() (CacheStats, bool) {
+	p, ok := m.policy.(*localCachePolicy)
+	if !ok {
+		return CacheStats{}, false
+	}
+	return p.GetCacheStats(), true
+}
+
+// CacheItems returns up to limit items held in the local cache
+func (m *manager) CacheItems(limit int) ([]CacheItem, bool) {
+	p, ok := m.policy.(*localCachePolicy)
+	if !ok {
+		return nil, false
+	}
+	return p.Items(limit), true
+}
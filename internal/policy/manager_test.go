@@ -1,6 +1,7 @@
 package policy
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 )
@@ -39,6 +40,48 @@ func TestManager_InvalidParameters(t *testing.T) {
 	}
 }
 
+func TestManager_InvalidShards(t *testing.T) {
+	for _, shards := range []int64{-1, 0, 1} {
+		_, err := New(Config{
+			Type:       KeySplitting,
+			Parameters: KeySplittingConfig{Shards: shards},
+		})
+		if !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("Expected ErrInvalidParams for %d shards, got: %v", shards, err)
+		}
+	}
+
+	// The number of shards is computed in AutoShards mode
+	if _, err := New(Config{
+		Type:       KeySplitting,
+		Parameters: KeySplittingConfig{AutoShards: true},
+	}); err != nil {
+		t.Errorf("Expected AutoShards without Shards to be valid, got: %v", err)
+	}
+
+	// A policy built around the validation still doesn't panic
+	p := newKeySplittingPolicy(KeySplittingConfig{Shards: 1}).(*keySplittingPolicy)
+	if shard := p.selectShard("", 1); shard != 0 {
+		t.Errorf("Expected shard 0 with a single shard, got %d", shard)
+	}
+	if shard := p.selectShard("", 0); shard != 0 {
+		t.Errorf("Expected shard 0 without shards, got %d", shard)
+	}
+	for _, shards := range []int64{0, -1} {
+		p := newKeySplittingPolicy(KeySplittingConfig{Shards: shards})
+		for _, data := range []any{GetRequest{}, SetRequest{Value: "value"}} {
+			result := p.Apply(Context{Key: "key", Data: data})
+			if result.Error != nil {
+				t.Fatalf("Expected %T to be applied with %d shards, got: %v", data, shards, result.Error)
+			}
+		}
+		get := p.Apply(Context{Key: "key", Data: GetRequest{}}).Data.(KeySplittingGetAction)
+		if len(get.ShardKeys) != minShards {
+			t.Errorf("Expected %d shards to be clamped to %d, got %d", shards, minShards, len(get.ShardKeys))
+		}
+	}
+}
+
 func TestManager_LocalCachePolicy(t *testing.T) {
 	config := Config{
 		Type: LocalCache,
@@ -339,6 +382,118 @@ func TestManager_InitialPatterns(t *testing.T) {
 	}
 }
 
+func TestManager_Precedence(t *testing.T) {
+	m, err := New(Config{
+		Type:              LocalCache,
+		Parameters:        LocalCacheConfig{TTL: 60, Capacity: 100},
+		WhitelistKeys:     []string{"user:1"},
+		WhitelistPatterns: []string{"^user:[0-9]+$"},
+		WhitelistGlobs:    []string{"user:*"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	// A key matching every rule gets the policy of its exact rule
+	p := m.GetPolicy("user:1")
+	if p == nil {
+		t.Fatal("Expected a policy for a key matching every rule")
+	}
+
+	// Any matching rule applies the policy, regardless of the others
+	m.RemoveWhitelistKey("user:1")
+	if m.GetPolicy("user:1") != p {
+		t.Error("Expected the pattern rules to apply the same policy")
+	}
+	if m.GetPolicy("user:abc") != p {
+		t.Error("Expected the glob to apply the policy to a key the regexp doesn't match")
+	}
+
+	m.AddWhitelistKey("product:1")
+	if m.GetPolicy("product:1") != p {
+		t.Error("Expected an exact key to get the policy without matching any pattern")
+	}
+	if m.GetPolicy("product:2") != nil {
+		t.Error("Expected no policy for a key matching no rule")
+	}
+}
+
+func TestManager_WhitelistGlobs(t *testing.T) {
+	config := Config{
+		Type: LocalCache,
+		Parameters: LocalCacheConfig{
+			TTL:      60,
+			Capacity: 100,
+		},
+		WhitelistGlobs: []string{"user:*", "item:?"},
+	}
+
+	manager, err := New(config)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	if manager.GetPolicy("user:123") == nil {
+		t.Error("Expected policy for user:123 matching glob 'user:*'")
+	}
+
+	if manager.GetPolicy("users") != nil {
+		t.Error("Expected nil policy for 'users' not matching glob 'user:*'")
+	}
+
+	if manager.GetPolicy("item:1") == nil {
+		t.Error("Expected policy for item:1 matching glob 'item:?'")
+	}
+
+	if manager.GetPolicy("item:12") != nil {
+		t.Error("Expected nil policy for item:12 not matching glob 'item:?'")
+	}
+
+	// Regexp metacharacters are matched literally
+	if err := manager.RegisterGlob("cache.(v1)*"); err != nil {
+		t.Fatalf("Expected no error registering glob, got: %v", err)
+	}
+	if manager.GetPolicy("cache.(v1):abc") == nil {
+		t.Error("Expected policy for key matching glob with literal metacharacters")
+	}
+	if manager.GetPolicy("cacheX(v1):abc") != nil {
+		t.Error("Expected '.' in glob to be matched literally")
+	}
+}
+
+func TestManager_CacheStats(t *testing.T) {
+	localCache, err := New(Config{
+		Type:          LocalCache,
+		Parameters:    LocalCacheConfig{TTL: 60, Capacity: 100},
+		WhitelistKeys: []string{"key"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	localCache.GetPolicy("key").Apply(Context{Key: "key", Data: SetRequest{Value: "value"}})
+
+	stats, ok := localCache.CacheStats()
+	if !ok {
+		t.Fatal("Expected cache stats for local cache policy")
+	}
+	if stats.Size != 1 || stats.Capacity != 100 {
+		t.Errorf("Expected size 1 and capacity 100, got size %d and capacity %d", stats.Size, stats.Capacity)
+	}
+
+	keySplitting, err := New(Config{
+		Type:       KeySplitting,
+		Parameters: KeySplittingConfig{Shards: 3},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	if _, ok := keySplitting.CacheStats(); ok {
+		t.Error("Expected no cache stats for key splitting policy")
+	}
+}
+
 func TestManager_ConcurrentAccess(t *testing.T) {
 	config := Config{
 		Type: LocalCache,
@@ -0,0 +1,61 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mingrammer/keyflare/internal"
+	"github.com/mingrammer/keyflare/internal/detector"
+	"github.com/mingrammer/keyflare/internal/policy"
+)
+
+// StartKeyFlare initializes and starts the global KeyFlare instance for a test.
+// Metrics are disabled and the instance is stopped when the test finishes.
+func StartKeyFlare(t testing.TB, detectorConfig detector.Config, policyConfig policy.Config) {
+	t.Helper()
+
+	StartKeyFlareConfig(t, internal.Config{
+		DetectorConfig: detectorConfig,
+		PolicyConfig:   policyConfig,
+	})
+}
+
+// StartKeyFlareConfig is like StartKeyFlare but takes the full configuration
+func StartKeyFlareConfig(t testing.TB, config internal.Config) {
+	t.Helper()
+
+	if err := internal.New(config); err != nil {
+		t.Fatalf("Failed to create KeyFlare: %v", err)
+	}
+	if err := internal.Start(); err != nil {
+		t.Fatalf("Failed to start KeyFlare: %v", err)
+	}
+	t.Cleanup(func() { internal.Stop() })
+}
+
+// LocalCachePolicyConfig returns a local cache policy config whitelisting the given keys
+func LocalCachePolicyConfig(keys ...string) policy.Config {
+	return policy.Config{
+		Type: policy.LocalCache,
+		Parameters: policy.LocalCacheConfig{
+			TTL:          60,
+			Capacity:     100,
+			RefreshAhead: 0.8,
+		},
+		WhitelistKeys: keys,
+	}
+}
+
+// Eventually polls the condition until it's true or fails the test after a second
+func Eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Condition not met before timeout")
+}
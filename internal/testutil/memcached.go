@@ -0,0 +1,196 @@
+package testutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memcachedItem is an item stored in the fake memcached server
+type memcachedItem struct {
+	value []byte
+	flags uint32
+	cas   uint64
+}
+
+// MemcachedServer is a minimal in-memory memcached server speaking the text protocol
+type MemcachedServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	items    map[string]*memcachedItem
+	casID    uint64
+	calls    map[string]int
+}
+
+// NewMemcachedServer starts a fake memcached server on a random local port
+func NewMemcachedServer(t *testing.T) *MemcachedServer {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	s := &MemcachedServer{
+		listener: l,
+		items:    make(map[string]*memcachedItem),
+		calls:    make(map[string]int),
+	}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+
+	return s
+}
+
+// Addr returns the address of the fake server
+func (s *MemcachedServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops accepting new connections
+func (s *MemcachedServer) Close() error {
+	return s.listener.Close()
+}
+
+// Calls returns the number of times a command was received for a key
+// A multi-key get counts once for each of its keys
+func (s *MemcachedServer) Calls(command, key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[strings.ToLower(command)+" "+key]
+}
+
+func (s *MemcachedServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *MemcachedServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		s.mu.Lock()
+		if fields[0] == "get" || fields[0] == "gets" {
+			for _, key := range fields[1:] {
+				s.calls[fields[0]+" "+key]++
+			}
+		} else if len(fields) > 1 {
+			s.calls[fields[0]+" "+fields[1]]++
+		}
+
+		switch fields[0] {
+		case "version":
+			fmt.Fprint(rw, "VERSION 1.6.0\r\n")
+		case "get", "gets":
+			for _, key := range fields[1:] {
+				if item, ok := s.items[key]; ok {
+					fmt.Fprintf(rw, "VALUE %s %d %d %d\r\n%s\r\n", key, item.flags, len(item.value), item.cas, item.value)
+				}
+			}
+			fmt.Fprint(rw, "END\r\n")
+		case "set", "add", "replace", "append", "prepend", "cas":
+			flags, _ := strconv.ParseUint(fields[2], 10, 32)
+			size, _ := strconv.Atoi(fields[4])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, data); err != nil {
+				s.mu.Unlock()
+				return
+			}
+			fmt.Fprint(rw, s.store(fields, uint32(flags), data[:size]))
+		case "delete":
+			if _, ok := s.items[fields[1]]; ok {
+				delete(s.items, fields[1])
+				fmt.Fprint(rw, "DELETED\r\n")
+			} else {
+				fmt.Fprint(rw, "NOT_FOUND\r\n")
+			}
+		case "incr", "decr":
+			item, ok := s.items[fields[1]]
+			if !ok {
+				fmt.Fprint(rw, "NOT_FOUND\r\n")
+				break
+			}
+			n, _ := strconv.ParseUint(string(item.value), 10, 64)
+			delta, _ := strconv.ParseUint(fields[2], 10, 64)
+			if fields[0] == "incr" {
+				n += delta
+			} else {
+				n -= min(delta, n)
+			}
+			s.casID++
+			s.items[fields[1]] = &memcachedItem{value: []byte(strconv.FormatUint(n, 10)), flags: item.flags, cas: s.casID}
+			fmt.Fprintf(rw, "%d\r\n", n)
+		case "touch":
+			if _, ok := s.items[fields[1]]; ok {
+				fmt.Fprint(rw, "TOUCHED\r\n")
+			} else {
+				fmt.Fprint(rw, "NOT_FOUND\r\n")
+			}
+		default:
+			fmt.Fprint(rw, "ERROR\r\n")
+		}
+		s.mu.Unlock()
+
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// store applies a storage command and returns the protocol response
+func (s *MemcachedServer) store(fields []string, flags uint32, value []byte) string {
+	key := fields[1]
+	existing, exists := s.items[key]
+
+	switch fields[0] {
+	case "add":
+		if exists {
+			return "NOT_STORED\r\n"
+		}
+	case "replace":
+		if !exists {
+			return "NOT_STORED\r\n"
+		}
+	case "append", "prepend":
+		if !exists {
+			return "NOT_STORED\r\n"
+		}
+		if fields[0] == "append" {
+			value = append(append([]byte{}, existing.value...), value...)
+		} else {
+			value = append(append([]byte{}, value...), existing.value...)
+		}
+		flags = existing.flags
+	case "cas":
+		if !exists {
+			return "NOT_FOUND\r\n"
+		}
+		if cas, _ := strconv.ParseUint(fields[5], 10, 64); cas != existing.cas {
+			return "EXISTS\r\n"
+		}
+	}
+
+	s.casID++
+	s.items[key] = &memcachedItem{value: value, flags: flags, cas: s.casID}
+	return "STORED\r\n"
+}
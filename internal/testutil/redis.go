@@ -0,0 +1,383 @@
+// Package testutil provides in-memory fake cache servers for wrapper tests
+package testutil
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RedisServer is a minimal in-memory Redis server speaking RESP2.
+// It reports itself as a single-node cluster owning all slots.
+type RedisServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	values   map[string]string
+	zsets    map[string]map[string]float64
+	ttls     map[string]time.Duration
+	calls    map[string]int
+	failures map[string]int
+	delays   map[string]time.Duration
+}
+
+// NewRedisServer starts a fake Redis server on a random local port
+func NewRedisServer(t *testing.T) *RedisServer {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	s := &RedisServer{
+		listener: l,
+		values:   make(map[string]string),
+		zsets:    make(map[string]map[string]float64),
+		ttls:     make(map[string]time.Duration),
+		calls:    make(map[string]int),
+		failures: make(map[string]int),
+		delays:   make(map[string]time.Duration),
+	}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+
+	return s
+}
+
+// Addr returns the address of the fake server
+func (s *RedisServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops accepting new connections
+func (s *RedisServer) Close() error {
+	return s.listener.Close()
+}
+
+// Calls returns the number of times a command was received for a key
+func (s *RedisServer) Calls(command, key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[strings.ToUpper(command)+" "+key]
+}
+
+// FailNext makes the next n commands received for a key fail with an error
+func (s *RedisServer) FailNext(command, key string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[strings.ToUpper(command)+" "+key] = n
+}
+
+// Delay makes the commands received for a key wait for d before being executed
+func (s *RedisServer) Delay(command, key string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delays[strings.ToUpper(command)+" "+key] = d
+}
+
+// Set stores a value directly in the fake server
+func (s *RedisServer) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+// SetTTL sets the TTL of a key directly in the fake server
+// TTLs don't count down, they're only recorded and reported
+func (s *RedisServer) SetTTL(key string, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ttls[key] = ttl
+}
+
+// TTL returns the TTL a key was last written with (0 if it doesn't expire)
+func (s *RedisServer) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ttls[key]
+}
+
+// Get reads a value directly from the fake server
+func (s *RedisServer) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+func (s *RedisServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *RedisServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		s.mu.Lock()
+		delay := s.delay(args)
+		s.mu.Unlock()
+		time.Sleep(delay)
+
+		s.mu.Lock()
+		w.WriteString(s.execute(args))
+		s.mu.Unlock()
+
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// delay returns how long a command must wait before being executed
+func (s *RedisServer) delay(args []string) time.Duration {
+	if len(args) < 2 {
+		return 0
+	}
+	return s.delays[strings.ToUpper(args[0])+" "+args[1]]
+}
+
+// execute runs a command and returns the RESP2 encoded reply
+func (s *RedisServer) execute(args []string) string {
+	command := strings.ToUpper(args[0])
+	if len(args) > 1 {
+		call := command + " " + args[1]
+		s.calls[call]++
+		if s.failures[call] > 0 {
+			s.failures[call]--
+			return "-ERR injected failure\r\n"
+		}
+	}
+
+	switch command {
+	case "HELLO":
+		return "-ERR unknown command 'HELLO'\r\n"
+	case "PING":
+		return "+PONG\r\n"
+	case "CLUSTER":
+		if len(args) > 1 && strings.ToUpper(args[1]) == "SLOTS" {
+			host, port, _ := net.SplitHostPort(s.Addr())
+			return fmt.Sprintf("*1\r\n*3\r\n:0\r\n:16383\r\n*2\r\n$%d\r\n%s\r\n:%s\r\n", len(host), host, port)
+		}
+		return "+OK\r\n"
+	case "GET":
+		return bulkString(s.values[args[1]], s.has(args[1]))
+	case "SET":
+		if len(args) < 3 {
+			return "-ERR wrong number of arguments for 'set' command\r\n"
+		}
+		if s.has(args[1]) && slices.ContainsFunc(args[3:], func(arg string) bool { return strings.EqualFold(arg, "NX") }) {
+			return "$-1\r\n"
+		}
+		s.values[args[1]] = args[2]
+		delete(s.ttls, args[1])
+		for i := 3; i+1 < len(args); i++ {
+			n, _ := strconv.Atoi(args[i+1])
+			switch strings.ToUpper(args[i]) {
+			case "EX":
+				s.ttls[args[1]] = time.Duration(n) * time.Second
+			case "PX":
+				s.ttls[args[1]] = time.Duration(n) * time.Millisecond
+			}
+		}
+		return "+OK\r\n"
+	case "PTTL":
+		if !s.has(args[1]) {
+			return ":-2\r\n"
+		}
+		if ttl, ok := s.ttls[args[1]]; ok {
+			return fmt.Sprintf(":%d\r\n", ttl.Milliseconds())
+		}
+		return ":-1\r\n"
+	case "COPY":
+		value, ok := s.values[args[1]]
+		replace := len(args) > 3 && strings.ToUpper(args[len(args)-1]) == "REPLACE"
+		if !ok || (s.has(args[2]) && !replace) {
+			return ":0\r\n"
+		}
+		s.values[args[2]] = value
+		delete(s.ttls, args[2])
+		if ttl, ok := s.ttls[args[1]]; ok {
+			s.ttls[args[2]] = ttl
+		}
+		return ":1\r\n"
+	case "GETDEL":
+		value, ok := s.values[args[1]]
+		delete(s.values, args[1])
+		return bulkString(value, ok)
+	case "DEL", "UNLINK":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := s.zsets[key]; ok || s.has(key) {
+				delete(s.values, key)
+				delete(s.zsets, key)
+				deleted++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	case "INCRBY":
+		n, _ := strconv.ParseInt(s.values[args[1]], 10, 64)
+		by, _ := strconv.ParseInt(args[2], 10, 64)
+		s.values[args[1]] = strconv.FormatInt(n+by, 10)
+		return fmt.Sprintf(":%d\r\n", n+by)
+	case "ZINCRBY":
+		if s.zsets[args[1]] == nil {
+			s.zsets[args[1]] = make(map[string]float64)
+		}
+		by, _ := strconv.ParseFloat(args[2], 64)
+		s.zsets[args[1]][args[3]] += by
+		return bulkString(formatScore(s.zsets[args[1]][args[3]]), true)
+	case "ZSCORE":
+		score, ok := s.zsets[args[1]][args[2]]
+		return bulkString(formatScore(score), ok)
+	case "ZUNIONSTORE":
+		numKeys, _ := strconv.Atoi(args[2])
+		union := make(map[string]float64)
+		for i, key := range args[3 : 3+numKeys] {
+			weight := 1.0
+			if len(args) > 3+numKeys+i+1 && strings.ToUpper(args[3+numKeys]) == "WEIGHTS" {
+				weight, _ = strconv.ParseFloat(args[3+numKeys+1+i], 64)
+			}
+			for member, score := range s.zsets[key] {
+				union[member] += score * weight
+			}
+		}
+		s.zsets[args[1]] = union
+		return fmt.Sprintf(":%d\r\n", len(union))
+	case "ZREVRANGE":
+		members := s.sortedMembers(args[1])
+		slices.Reverse(members)
+		start, stop := rankRange(args[2], args[3], len(members))
+		withScores := len(args) > 4 && strings.ToUpper(args[4]) == "WITHSCORES"
+		var reply []string
+		for _, member := range members[start:stop] {
+			reply = append(reply, bulkString(member, true))
+			if withScores {
+				reply = append(reply, bulkString(formatScore(s.zsets[args[1]][member]), true))
+			}
+		}
+		return fmt.Sprintf("*%d\r\n%s", len(reply), strings.Join(reply, ""))
+	case "ZREMRANGEBYRANK":
+		members := s.sortedMembers(args[1])
+		start, stop := rankRange(args[2], args[3], len(members))
+		for _, member := range members[start:stop] {
+			delete(s.zsets[args[1]], member)
+		}
+		return fmt.Sprintf(":%d\r\n", stop-start)
+	default:
+		return "+OK\r\n"
+	}
+}
+
+// ZScore reads the score of a member of a sorted set directly from the fake server
+func (s *RedisServer) ZScore(key, member string) (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	score, ok := s.zsets[key][member]
+	return score, ok
+}
+
+// sortedMembers returns the members of a sorted set by ascending score, then member
+func (s *RedisServer) sortedMembers(key string) []string {
+	zset := s.zsets[key]
+	members := make([]string, 0, len(zset))
+	for member := range zset {
+		members = append(members, member)
+	}
+	slices.SortFunc(members, func(a, b string) int {
+		return cmp.Or(cmp.Compare(zset[a], zset[b]), strings.Compare(a, b))
+	})
+	return members
+}
+
+// rankRange converts inclusive, possibly negative ranks to a slice range of a set of size n
+func rankRange(startArg, stopArg string, n int) (int, int) {
+	start, _ := strconv.Atoi(startArg)
+	stop, _ := strconv.Atoi(stopArg)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	stop = min(stop+1, n)
+	if start >= stop {
+		return 0, 0
+	}
+	return start, stop
+}
+
+// formatScore formats a sorted set score like Redis does
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+func (s *RedisServer) has(key string) bool {
+	_, ok := s.values[key]
+	return ok
+}
+
+// bulkString encodes a RESP2 bulk string, or a nil reply if the value doesn't exist
+func bulkString(value string, ok bool) string {
+	if !ok {
+		return "$-1\r\n"
+	}
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+// readCommand reads a RESP array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[0] != '*' {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
@@ -2,6 +2,7 @@
 package keyflare
 
 import (
+	"context"
 	"time"
 
 	"github.com/mingrammer/keyflare/internal"
@@ -25,7 +26,19 @@ const (
 	DefaultLocalCacheCapacity     = 1000.0
 	DefaultLocalCacheRefreshAhead = 0.8
 
-	DefaultKeySplittingShards = 10.0
+	DefaultKeySplittingShards    = 10.0
+	DefaultKeySplittingMaxShards = 16
+	DefaultKeySplittingShardStep = 1000
+
+	DefaultKeySplittingReplicationAttempts = 3
+	DefaultKeySplittingReplicationBackoff  = 0.05 // seconds
+	DefaultKeySplittingFallbackTTL         = 60.0 // seconds
+
+	DefaultAsyncPopulationLimit = 16
+
+	DefaultBreakerThreshold = 5
+	DefaultBreakerWindow    = 10 // seconds
+	DefaultBreakerCooldown  = 30 // seconds
 
 	// Metrics defaults
 	DefaultMetricsNamespace          = "keyflare"
@@ -46,75 +59,233 @@ const (
 	KeySplitting PolicyType = "key-splitting"
 )
 
+// CountSource defines where the detector reads key counts from
+type CountSource string
+
+const (
+	// CountSourceCMS reads counts from the Count-Min Sketch, which may overcount but never undercounts
+	CountSourceCMS CountSource = "cms"
+	// CountSourceSpaceSaving reads counts from the Space-Saving structure, which is tighter
+	// for tracked keys but includes the count inherited from evicted keys
+	CountSourceSpaceSaving CountSource = "spacesaving"
+)
+
 // Options contains configuration options for KeyFlare
 type Options struct {
 	// DetectorOptions configures the hot key detector
-	DetectorOptions DetectorOptions
+	DetectorOptions DetectorOptions `json:"detector"`
 
 	// PolicyOptions configures the policy manager
-	PolicyOptions PolicyOptions
+	PolicyOptions PolicyOptions `json:"policy"`
 
 	// MetricsOptions configures the metrics collector
-	MetricsOptions MetricsOptions
+	MetricsOptions MetricsOptions `json:"metrics"`
 
 	// EnableMetrics determines whether to enable metrics collection
-	EnableMetrics bool
+	EnableMetrics bool `json:"enable_metrics"`
+
+	// Detector replaces the built-in detector, if set (see WithDetector)
+	Detector Detector `json:"-"`
+
+	// err holds the errors of options that failed to apply, such as WithEnv,
+	// returned by New and Reconfigure
+	err error
 }
 
 // DetectorOptions contains configuration options for the detector
 type DetectorOptions struct {
 	// ErrorRate is the acceptable error rate for probabilistic algorithms
-	ErrorRate float64
+	ErrorRate float64 `json:"error_rate"`
+
+	// TopK is the number of top hot keys to report
+	TopK int `json:"top_k"`
 
-	// TopK is the number of top hot keys to track
-	TopK int
+	// Capacity is the number of keys tracked to find the top-K (default: TopK)
+	// Tracking more keys than TopK improves the accuracy of the top-K; it's raised to TopK if smaller
+	Capacity int `json:"capacity"`
 
 	// DecayFactor is used to decay old counts over time
-	DecayFactor float64
+	// Set it to 1 to disable decay, e.g. for short-lived batch processes or tests
+	DecayFactor float64 `json:"decay_factor"`
 
 	// DecayInterval is the interval at which decay is applied (in seconds)
-	DecayInterval time.Duration
+	DecayInterval time.Duration `json:"decay_interval"`
+
+	// DecayJitter is the randomness factor for DecayInterval (0.0-1.0)
+	// It staggers decay across nodes to avoid synchronized sawtooth patterns
+	DecayJitter float64 `json:"decay_jitter"`
+
+	// ResetInterval resets all counts at this interval (in seconds) if it's greater than 0,
+	// so the hot keys only reflect the traffic of the current window. It disables decay
+	ResetInterval time.Duration `json:"reset_interval"`
 
 	// HotThreshold is the threshold for determining if a key is hot
 	// If it's 0, then the threshold is dynamically determined based on the Top-K keys
-	HotThreshold uint64
+	HotThreshold uint64 `json:"hot_threshold"`
+
+	// HotThresholdPercent makes a key hot if its count is at least this percentage of all
+	// accesses (e.g. 0.5 for 0.5%), so the threshold scales with traffic
+	// It takes precedence over HotThreshold (0 disables it)
+	HotThresholdPercent float64 `json:"hot_threshold_percent"`
+
+	// MinHotCount is the absolute minimum count for a key to be considered hot
+	// Keys below it are never hot, even if they are in the Top-K (0 disables the floor)
+	MinHotCount uint64 `json:"min_hot_count"`
+
+	// MinHotAge is how long a key must have met the other hot criteria (threshold, top-K,
+	// MinHotCount) before it's considered hot (in seconds), so keys spiking for a moment don't
+	// trigger policies. The key starts over once it stops meeting them
+	MinHotAge time.Duration `json:"min_hot_age"`
+
+	// WarmupCount is the number of accesses to record after Start before any key is considered hot
+	// It prevents the first keys accessed from being flagged as hot in dynamic threshold mode
+	WarmupCount uint64 `json:"warmup_count"`
+
+	// WarmupDuration is the time to wait after Start before any key is considered hot (in seconds)
+	WarmupDuration time.Duration `json:"warmup_duration"`
+
+	// KeyNormalizer normalizes keys before they are counted and looked up, if set, e.g. stripping
+	// request-scoped suffixes so "product:123?ts=..." counts as "product:123"
+	// It applies to every detector, including one set with WithDetector, and the original key
+	// is still used for backend operations
+	KeyNormalizer func(string) string `json:"-"`
+
+	// CountOnlyEligible only counts keys a policy applies to (those matching the whitelist),
+	// so with a huge key space detection focuses on keys that can actually be mitigated
+	CountOnlyEligible bool `json:"count_only_eligible"`
+
+	// MemberGranularity also counts accesses to sorted set members as "key:member"
+	// so you can see which members (e.g. of a leaderboard) drive the hotness of a key
+	MemberGranularity bool `json:"member_granularity"`
+
+	// HourlyTracking keeps a separate top-K per hour of day (in local time), so the
+	// /hot-keys/hourly endpoint can compare what's hot now to what's usually hot at this hour
+	HourlyTracking bool `json:"hourly_tracking"`
+
+	// CountSource selects where TopK, GetCount and IsHot read counts from, and so how the
+	// tracked keys are ranked into the top-K ("cms" or "spacesaving", default: "cms")
+	CountSource CountSource `json:"count_source"`
+
+	// HashKeys tracks keys by a fixed-size hash instead of their full name, so the detector's
+	// memory doesn't grow with key length. Names are still kept for the tracked keys,
+	// so TopK and the hot keys API report them
+	HashKeys bool `json:"hash_keys"`
+
+	// AsyncBufferSize counts keys asynchronously if it's greater than 0: increments are queued
+	// on a buffer of this size and applied by a background goroutine, so requests never wait
+	// on the detector. Increments are dropped when the buffer is full
+	AsyncBufferSize int `json:"async_buffer_size"`
 }
 
 // PolicyOptions contains configuration options for policy management
 type PolicyOptions struct {
 	// Type determines which policy to use
-	Type PolicyType
+	Type PolicyType `json:"type"`
 
 	// Parameters holds the policy-specific parameters
-	Parameters any
+	Parameters any `json:"parameters"`
 
 	// WhitelistKeys is a list of keys to whitelist
 	// TODO: support auto whitelisting
-	WhitelistKeys []string
+	WhitelistKeys []string `json:"whitelist_keys"`
 
 	// WhitelistPatterns is a list of regex patterns to whitelist keys
-	WhitelistPatterns []string
+	WhitelistPatterns []string `json:"whitelist_patterns"`
+
+	// WhitelistGlobs is a list of glob patterns to whitelist keys (e.g. "user:*")
+	// "*" matches any sequence of characters and "?" matches a single character
+	WhitelistGlobs []string `json:"whitelist_globs"`
+
+	// FailClosed makes reads return a policy error to the caller instead of falling back
+	// to the plain backend call (default: false, so a policy error never breaks a request)
+	FailClosed bool `json:"fail_closed"`
+
+	// AsyncPopulationLimit is the maximum number of concurrent background local cache populations
+	// Populations of the same key are coalesced and populations over the limit are skipped (default: 16)
+	AsyncPopulationLimit int `json:"async_population_limit"`
+
+	// BreakerThreshold is the number of consecutive policy errors within BreakerWindow that opens
+	// the circuit breaker, bypassing policies for BreakerCooldown (default: 5, negative disables it)
+	BreakerThreshold int `json:"breaker_threshold"`
+
+	// BreakerWindow is the period the consecutive policy errors must occur within (in seconds)
+	BreakerWindow time.Duration `json:"breaker_window"`
+
+	// BreakerCooldown is how long policies are bypassed before one is probed again (in seconds)
+	BreakerCooldown time.Duration `json:"breaker_cooldown"`
+
+	// OnDecision is called with the policy decision of every wrapped get and set command,
+	// to trace why a request was or wasn't served locally
+	// It's meant for debugging, and decisions aren't traced if it's nil
+	OnDecision func(Decision) `json:"-"`
 }
 
 // MetricsOptions contains configuration options for metrics
 type MetricsOptions struct {
 	// Namespace is the namespace for metrics
-	Namespace string
+	Namespace string `json:"namespace"`
 
 	// MetricServerAddress is the address for the metric server
-	MetricServerAddress string
+	MetricServerAddress string `json:"metric_server_address"`
 
 	// CollectionInterval is the interval at which metrics are collected (in seconds)
-	CollectionInterval time.Duration
+	CollectionInterval time.Duration `json:"collection_interval"`
+
+	// HistorySnapshotInterval is the interval at which hot key snapshots are added to the history (in seconds)
+	// It sets the resolution of the time series API independently of CollectionInterval (default: CollectionInterval)
+	HistorySnapshotInterval time.Duration `json:"history_snapshot_interval"`
 
 	// HotKeyMetricLimit is the number of hot keys to expose as metrics (default: 10)
-	HotKeyMetricLimit int
+	HotKeyMetricLimit int `json:"hot_key_metric_limit"`
+
+	// MinMetricCount is the count below which hot keys aren't exposed by the hot_keys metric,
+	// to reduce the churn of marginally warm keys flapping in and out of it (0 means no floor)
+	MinMetricCount uint64 `json:"min_metric_count"`
+
+	// TrendDeadBand is the percentage of change from the previous count within which the
+	// trend of a hot key in the API is "stable", so small jitter doesn't flap it between
+	// "rising" and "falling" (e.g. 5 for 5%, 0 means any change counts)
+	TrendDeadBand float64 `json:"trend_dead_band"`
 
 	// HotKeyHistorySize is the number of historical snapshots to keep (default: 10)
-	HotKeyHistorySize int
+	HotKeyHistorySize int `json:"hot_key_history_size"`
+
+	// HotKeyHistoryKeyLimit is the number of top keys kept in each historical snapshot (0 keeps all).
+	// The history holds up to HotKeyHistorySize * HotKeyHistoryKeyLimit keys, and the hot keys API
+	// can't report more keys than it
+	HotKeyHistoryKeyLimit int `json:"hot_key_history_key_limit"`
 
 	// EnableAPI enables the hot keys API endpoint
-	EnableAPI bool
+	EnableAPI bool `json:"enable_api"`
+
+	// APIToken is the bearer token required by sensitive API endpoints such as /cache-keys,
+	// which are disabled if it's empty
+	APIToken string `json:"api_token"`
+
+	// HotKeyCountBuckets are the bucket boundaries of the histogram of top-K key counts
+	// It shows whether a single key dominates or the load is spread (default: 10 to 100000)
+	HotKeyCountBuckets []float64 `json:"hot_key_count_buckets"`
+
+	// AggregationPatterns is a list of pattern templates (e.g. "user:*") used to
+	// report aggregated counts of hot keys sharing the same template.
+	// A "*" matches any sequence of characters.
+	AggregationPatterns []string `json:"aggregation_patterns"`
+
+	// ExportFilePath is a file the hot keys are written to as JSON at every ExportInterval,
+	// for environments without Prometheus. The file is replaced atomically (empty disables it).
+	ExportFilePath string `json:"export_file_path"`
+
+	// ExportInterval is the interval at which the hot keys are exported (in seconds, default: CollectionInterval)
+	ExportInterval time.Duration `json:"export_interval"`
+
+	// PruneMinCount makes each collection stop tracking keys whose count is below it,
+	// freeing detector capacity for hotter keys (0 disables pruning)
+	PruneMinCount uint64 `json:"prune_min_count"`
+
+	// OnCollect is called with the current top keys and the collection time after
+	// each collection cycle. It runs in its own goroutine, so it can be used to ship
+	// snapshots to an external store without blocking collection.
+	OnCollect func(keys []KeyCount, collectedAt time.Time) `json:"-"`
 }
 
 // LocalCacheParams defines parameters for local cache policy
@@ -130,18 +301,80 @@ type LocalCacheParams struct {
 
 	// RefreshAhead determines when to refresh items before expiration (0.0-1.0)
 	RefreshAhead float64 `json:"refresh_ahead"`
+
+	// MaxTTL is the upper bound for the jittered TTL in seconds (0 means no limit)
+	MaxTTL float64 `json:"max_ttl"`
+
+	// CapToBackendTTL caps the TTL of cached values to the remaining TTL of the key in the backend,
+	// so values expired in the backend aren't served locally. The Redis wrappers read the TTL when
+	// they populate the cache, while the Memcached wrapper only knows the expiration of its writes
+	CapToBackendTTL bool `json:"cap_to_backend_ttl"`
+
+	// Codec serializes the cached values, so richer types than strings can be cached
+	// Values are stored encoded and decoded on every hit
+	Codec Codec `json:"-"`
+}
+
+// Codec encodes and decodes the values held by the local cache
+// It receives the values the wrappers cache: the values written with Set, and the strings
+// (or bytes) read from the backend on a miss
+type Codec interface {
+	// Marshal encodes a value set in the cache
+	Marshal(value any) ([]byte, error)
+
+	// Unmarshal decodes a cached value on a hit
+	Unmarshal(data []byte) (any, error)
 }
 
 // KeySplittingParams defines parameters for key splitting policy
 type KeySplittingParams struct {
 	// Shards is the number of shards to split keys into
 	Shards int64 `json:"shards"`
+
+	// AutoShards scales the number of shards reads spread over with the key's access count
+	// instead of using Shards. Writes always fill MaxShards shards.
+	AutoShards bool `json:"auto_shards"`
+
+	// MaxShards is the upper bound for the number of shards when AutoShards is enabled
+	MaxShards int64 `json:"max_shards"`
+
+	// ShardStep is the access count per shard when AutoShards is enabled
+	ShardStep uint64 `json:"shard_step"`
+
+	// ConsistentRouting makes reads carrying a routing key (see WithRoutingKey) always select the same shard
+	ConsistentRouting bool `json:"consistent_routing"`
+
+	// TTLJitter is the randomness factor for the TTLs of shard keys (capped at 0.5), so shards don't expire at once
+	// Shard TTLs are only ever shortened, so shards don't outlive the original key
+	TTLJitter float64 `json:"ttl_jitter"`
+
+	// ReplicationAttempts is the number of attempts of each shard write, including the first
+	ReplicationAttempts int `json:"replication_attempts"`
+
+	// ReplicationBackoff is the delay in seconds before the first retry of a shard write, doubled after each retry
+	ReplicationBackoff float64 `json:"replication_backoff"`
+
+	// FallbackTTL is the TTL in seconds of shards filled from the original key after a shard miss,
+	// used when the original key doesn't expire or its remaining TTL can't be obtained
+	FallbackTTL float64 `json:"fallback_ttl"`
 }
 
 // KeyCount represents a key and its estimated count
 type KeyCount struct {
-	Key   string
-	Count uint64
+	Key    string
+	Count  uint64
+	Reads  uint64
+	Writes uint64
+}
+
+// Decision describes how a wrapped command on a key was handled (see PolicyOptions.OnDecision)
+type Decision struct {
+	Key           string
+	Operation     string // "get" or "set"
+	Hot           bool   // whether the detector considered the key hot, even without a policy
+	PolicyApplied bool
+	Result        string // "hit", "miss", "set", "shard", or empty if no policy result was used
+	BackendHit    bool
 }
 
 // HotKeyInfo contains detailed information about a hot key (for API responses)
@@ -152,16 +385,58 @@ type HotKeyInfo struct {
 	FirstSeen string `json:"first_seen"`
 	LastSeen  string `json:"last_seen"`
 	Trend     string `json:"trend"` // "rising", "falling", "stable"
+	Reads     uint64 `json:"reads"`
+	Writes    uint64 `json:"writes"`
+}
+
+// HotKeyGroupInfo contains the aggregated count of hot keys matching a pattern (for API responses)
+type HotKeyGroupInfo struct {
+	Pattern string `json:"pattern"`
+	Count   uint64 `json:"count"`
+	Keys    int    `json:"keys"`
 }
 
 // HotKeysResponse is the API response for hot keys
 type HotKeysResponse struct {
-	Timestamp   string       `json:"timestamp"`
-	TopK        int          `json:"top_k"`
-	TotalKeys   int          `json:"total_keys"`
-	Keys        []HotKeyInfo `json:"keys"`
-	QueryLimit  int          `json:"query_limit"`
-	ActualLimit int          `json:"actual_limit"`
+	Timestamp   string            `json:"timestamp"`
+	TopK        int               `json:"top_k"`
+	TotalKeys   int               `json:"total_keys"`
+	Keys        []HotKeyInfo      `json:"keys"`
+	QueryLimit  int               `json:"query_limit"`
+	ActualLimit int               `json:"actual_limit"`
+	Groups      []HotKeyGroupInfo `json:"groups,omitempty"`
+}
+
+// Statistics is a snapshot of the state of KeyFlare (for debugging and dashboards)
+type Statistics struct {
+	TotalCount     uint64           `json:"total_count"`     // decayed sum of all key accesses
+	TopKLength     int              `json:"top_k_length"`    // number of keys currently in the top-K
+	Cache          *CacheStatistics `json:"cache,omitempty"` // nil unless the local cache policy is used
+	LastCollection time.Time        `json:"last_collection"` // zero if metrics are disabled or not collected yet
+}
+
+// CacheStatistics contains the local cache statistics
+type CacheStatistics struct {
+	Size     int    `json:"size"`
+	Capacity int    `json:"capacity"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+
+	NeedingRefresh    int       `json:"needing_refresh"`    // unexpired items past their refresh-ahead point
+	SoonestExpiration time.Time `json:"soonest_expiration"` // zero if no item is cached
+	LatestExpiration  time.Time `json:"latest_expiration"`  // zero if no item is cached
+}
+
+// CalibrationReport describes how accurately detector options count a key distribution (see Calibrate)
+type CalibrationReport struct {
+	Keys       int    `json:"keys"`
+	TotalCount uint64 `json:"total_count"`
+
+	MeanRelativeError   float64 `json:"mean_relative_error"` // of the true top-K keys
+	MaxRelativeError    float64 `json:"max_relative_error"`  // of the true top-K keys
+	MaxRelativeErrorKey string  `json:"max_relative_error_key"`
+	TopKRecall          float64 `json:"top_k_recall"`         // fraction of the true top-K keys reported
+	SuggestedErrorRate  float64 `json:"suggested_error_rate"` // bounds the top-K error to 10%
 }
 
 // Option is a function that modifies KeyFlare options
@@ -191,10 +466,15 @@ func DefaultDetectorOptions() DetectorOptions {
 // DefaultPolicyOptions returns the default configuration for policy management
 func DefaultPolicyOptions() PolicyOptions {
 	return PolicyOptions{
-		Type:              LocalCache,
-		Parameters:        DefaultLocalCacheParams(),
-		WhitelistKeys:     []string{},
-		WhitelistPatterns: []string{},
+		Type:                 LocalCache,
+		Parameters:           DefaultLocalCacheParams(),
+		WhitelistKeys:        []string{},
+		WhitelistPatterns:    []string{},
+		WhitelistGlobs:       []string{},
+		AsyncPopulationLimit: DefaultAsyncPopulationLimit,
+		BreakerThreshold:     DefaultBreakerThreshold,
+		BreakerWindow:        DefaultBreakerWindow,
+		BreakerCooldown:      DefaultBreakerCooldown,
 	}
 }
 
@@ -223,7 +503,13 @@ func DefaultLocalCacheParams() LocalCacheParams {
 // DefaultKeySplittingParams returns default parameters for key splitting policy
 func DefaultKeySplittingParams() KeySplittingParams {
 	return KeySplittingParams{
-		Shards: DefaultKeySplittingShards,
+		Shards:    DefaultKeySplittingShards,
+		MaxShards: DefaultKeySplittingMaxShards,
+		ShardStep: DefaultKeySplittingShardStep,
+
+		ReplicationAttempts: DefaultKeySplittingReplicationAttempts,
+		ReplicationBackoff:  DefaultKeySplittingReplicationBackoff,
+		FallbackTTL:         DefaultKeySplittingFallbackTTL,
 	}
 }
 
@@ -234,6 +520,15 @@ func WithDetectorOptions(opts DetectorOptions) Option {
 	}
 }
 
+// WithDetector replaces the built-in Count-Min Sketch and Space-Saving detector with d,
+// e.g. one backed by another frequency estimation library or shared across instances.
+// The key handling options, such as the KeyNormalizer and CountOnlyEligible, still apply.
+func WithDetector(d Detector) Option {
+	return func(o *Options) {
+		o.Detector = d
+	}
+}
+
 // WithPolicyOptions sets policy options
 func WithPolicyOptions(opts PolicyOptions) Option {
 	return func(o *Options) {
@@ -264,24 +559,60 @@ func New(opts ...Option) error {
 	for _, opt := range opts {
 		opt(&options)
 	}
+	if options.err != nil {
+		return options.err
+	}
+
+	return internal.New(newConfig(options))
+}
+
+// Reconfigure replaces the policy and metrics configuration of the global KeyFlare instance
+// at runtime, keeping the running detector and its accumulated counts.
+// The detector's ErrorRate, TopK and Capacity can't be changed without a restart, and the other
+// detector options (including KeyNormalizer), FailClosed, AsyncPopulationLimit and OnDecision
+// keep their current values.
+// The metrics collector is restarted, so the hot key history starts over.
+func Reconfigure(opts Options) error {
+	if opts.err != nil {
+		return opts.err
+	}
+	return internal.Reconfigure(newConfig(opts))
+}
 
-	// Apply defaults to any unset fields
+// newConfig converts options to the internal config, applying defaults to any unset fields
+func newConfig(options Options) internal.Config {
 	options = applyOptionsDefaults(options)
 
-	// Convert to internal config
 	config := internal.Config{
 		DetectorConfig: detector.Config{
 			ErrorRate:     options.DetectorOptions.ErrorRate,
 			TopK:          options.DetectorOptions.TopK,
+			Capacity:      options.DetectorOptions.Capacity,
 			DecayFactor:   options.DetectorOptions.DecayFactor,
 			DecayInterval: time.Duration(options.DetectorOptions.DecayInterval) * time.Second,
+			DecayJitter:   options.DetectorOptions.DecayJitter,
+			ResetInterval: time.Duration(options.DetectorOptions.ResetInterval) * time.Second,
 			HotThreshold:  options.DetectorOptions.HotThreshold,
+			MinHotCount:   options.DetectorOptions.MinHotCount,
+
+			HotThresholdPercent: options.DetectorOptions.HotThresholdPercent,
+			MemberGranularity:   options.DetectorOptions.MemberGranularity,
+			CountOnlyEligible:   options.DetectorOptions.CountOnlyEligible,
+			HourlyTracking:      options.DetectorOptions.HourlyTracking,
+			CountSource:         detector.CountSource(options.DetectorOptions.CountSource),
+			HashKeys:            options.DetectorOptions.HashKeys,
+			AsyncBufferSize:     options.DetectorOptions.AsyncBufferSize,
+
+			WarmupCount:    options.DetectorOptions.WarmupCount,
+			WarmupDuration: time.Duration(options.DetectorOptions.WarmupDuration) * time.Second,
+			MinHotAge:      time.Duration(options.DetectorOptions.MinHotAge) * time.Second,
 		},
 		PolicyConfig: policy.Config{
 			Type:              policy.Type(options.PolicyOptions.Type),
 			Parameters:        convertPolicyParams(options.PolicyOptions.Type, options.PolicyOptions.Parameters),
 			WhitelistKeys:     options.PolicyOptions.WhitelistKeys,
 			WhitelistPatterns: options.PolicyOptions.WhitelistPatterns,
+			WhitelistGlobs:    options.PolicyOptions.WhitelistGlobs,
 		},
 		MetricsConfig: metrics.Config{
 			Namespace:           options.MetricsOptions.Namespace,
@@ -289,11 +620,35 @@ func New(opts ...Option) error {
 			CollectionInterval:  time.Duration(options.MetricsOptions.CollectionInterval) * time.Second,
 			HotKeyMetricLimit:   options.MetricsOptions.HotKeyMetricLimit,
 			HotKeyHistorySize:   options.MetricsOptions.HotKeyHistorySize,
+			HotKeyCountBuckets:  options.MetricsOptions.HotKeyCountBuckets,
+			AggregationPatterns: options.MetricsOptions.AggregationPatterns,
+			APIToken:            options.MetricsOptions.APIToken,
+			OnCollect:           convertOnCollect(options.MetricsOptions.OnCollect),
+
+			HistorySnapshotInterval: time.Duration(options.MetricsOptions.HistorySnapshotInterval) * time.Second,
+			HotKeyHistoryKeyLimit:   options.MetricsOptions.HotKeyHistoryKeyLimit,
+			ExportFilePath:          options.MetricsOptions.ExportFilePath,
+			ExportInterval:          time.Duration(options.MetricsOptions.ExportInterval) * time.Second,
+			PruneMinCount:           options.MetricsOptions.PruneMinCount,
+			MinMetricCount:          options.MetricsOptions.MinMetricCount,
+			TrendDeadBand:           options.MetricsOptions.TrendDeadBand,
 		},
 		EnableMetrics: options.EnableMetrics,
+		FailClosed:    options.PolicyOptions.FailClosed,
+		KeyNormalizer: options.DetectorOptions.KeyNormalizer,
+
+		AsyncPopulationLimit: options.PolicyOptions.AsyncPopulationLimit,
+		BreakerConfig: policy.BreakerConfig{
+			Threshold: options.PolicyOptions.BreakerThreshold,
+			Window:    time.Duration(options.PolicyOptions.BreakerWindow) * time.Second,
+			Cooldown:  time.Duration(options.PolicyOptions.BreakerCooldown) * time.Second,
+		},
+		OnDecision: convertOnDecision(options.PolicyOptions.OnDecision),
 	}
-
-	return internal.New(config)
+	if options.Detector != nil {
+		config.Detector = &externalDetector{detector: options.Detector, config: config.DetectorConfig}
+	}
+	return config
 }
 
 // Start starts the global KeyFlare instance
@@ -306,6 +661,85 @@ func Stop() error {
 	return internal.Stop()
 }
 
+// Stats returns a snapshot of the state of the global KeyFlare instance
+func Stats() (Statistics, error) {
+	kf, err := internal.GetInstance()
+	if err != nil {
+		return Statistics{}, err
+	}
+
+	stats := Statistics{
+		TotalCount:     kf.Detector().TotalCount(),
+		TopKLength:     len(kf.Detector().TopK()),
+		LastCollection: kf.Metrics().LastCollection(),
+	}
+
+	if cacheStats, ok := kf.PolicyManager().CacheStats(); ok {
+		stats.Cache = &CacheStatistics{
+			Size:     cacheStats.Size,
+			Capacity: cacheStats.Capacity,
+			Hits:     cacheStats.Hits,
+			Misses:   cacheStats.Misses,
+
+			NeedingRefresh:    cacheStats.NeedingRefresh,
+			SoonestExpiration: cacheStats.SoonestExpiration,
+			LatestExpiration:  cacheStats.LatestExpiration,
+		}
+	}
+
+	return stats, nil
+}
+
+// Increment records count accesses to key, for caches accessed through layers the client
+// wrappers can't wrap (e.g. ORMs or custom clients). The key is normalized like in the
+// client wrappers, and is then reported by the API and metrics like any other key.
+func Increment(key string, count uint64) error {
+	kf, err := internal.GetInstance()
+	if err != nil {
+		return err
+	}
+	if key = kf.NormalizeKey(key); kf.Countable(key) {
+		kf.Detector().Increment(key, count)
+	}
+	return nil
+}
+
+// IsHot reports whether the global KeyFlare instance considers key hot, so application
+// code can branch on hotness directly. The key is normalized like in the client wrappers.
+func IsHot(key string) (bool, error) {
+	kf, err := internal.GetInstance()
+	if err != nil {
+		return false, err
+	}
+	return kf.Detector().IsHot(kf.NormalizeKey(key)), nil
+}
+
+// Calibrate loads a known key distribution, such as counts observed in production, into a new
+// detector with the options and compares its estimates to the true counts, to size ErrorRate
+// and Capacity. It doesn't use the global KeyFlare instance.
+func Calibrate(opts DetectorOptions, keys []KeyCount) CalibrationReport {
+	config := newConfig(Options{DetectorOptions: opts}).DetectorConfig
+
+	counts := make([]detector.KeyCount, len(keys))
+	for i, kc := range keys {
+		counts[i] = detector.KeyCount{Key: kc.Key, Count: kc.Count}
+	}
+	return CalibrationReport(detector.Calibrate(config, counts))
+}
+
+// WithRoutingKey returns a copy of ctx carrying a routing key, such as a connection or worker id
+// With ConsistentRouting enabled, reads of split keys made with the same routing key select the same shard
+func WithRoutingKey(ctx context.Context, routingKey string) context.Context {
+	return policy.WithRoutingKey(ctx, routingKey)
+}
+
+// WithoutTracking returns a copy of ctx whose commands aren't counted by the detector
+// Use it for background jobs such as cache warmers, whose own accesses would otherwise make keys look hot
+// Policies still apply to hot keys
+func WithoutTracking(ctx context.Context) context.Context {
+	return detector.WithoutTracking(ctx)
+}
+
 // applyOptionsDefaults applies default values to missing fields in the provided options
 func applyOptionsDefaults(opts Options) Options {
 	opts.DetectorOptions = applyDetectorDefaults(opts.DetectorOptions)
@@ -327,7 +761,7 @@ func applyDetectorDefaults(opts DetectorOptions) DetectorOptions {
 	if opts.DecayInterval <= 0 {
 		opts.DecayInterval = DefaultDetectorDecayInterval
 	}
-	// HotThreshold can be 0, so no default override needed
+	// DecayJitter, HotThreshold and MinHotCount can be 0, so no default override needed
 	return opts
 }
 
@@ -341,7 +775,7 @@ func applyLocalCacheDefaults(params LocalCacheParams) LocalCacheParams {
 	if params.Capacity <= 0 {
 		params.Capacity = DefaultLocalCacheCapacity
 	}
-	if params.RefreshAhead <= 0 {
+	if params.RefreshAhead <= 0 || params.RefreshAhead >= 1 {
 		params.RefreshAhead = DefaultLocalCacheRefreshAhead
 	}
 	return params
@@ -351,6 +785,21 @@ func applyKeySplittingDefaults(params KeySplittingParams) KeySplittingParams {
 	if params.Shards <= 0 {
 		params.Shards = DefaultKeySplittingShards
 	}
+	if params.MaxShards <= 0 {
+		params.MaxShards = DefaultKeySplittingMaxShards
+	}
+	if params.ShardStep == 0 {
+		params.ShardStep = DefaultKeySplittingShardStep
+	}
+	if params.ReplicationAttempts <= 0 {
+		params.ReplicationAttempts = DefaultKeySplittingReplicationAttempts
+	}
+	if params.ReplicationBackoff <= 0 {
+		params.ReplicationBackoff = DefaultKeySplittingReplicationBackoff
+	}
+	if params.FallbackTTL <= 0 {
+		params.FallbackTTL = DefaultKeySplittingFallbackTTL
+	}
 	return params
 }
 
@@ -381,6 +830,21 @@ func applyPolicyDefaults(opts PolicyOptions) PolicyOptions {
 	if opts.WhitelistPatterns == nil {
 		opts.WhitelistPatterns = []string{}
 	}
+	if opts.WhitelistGlobs == nil {
+		opts.WhitelistGlobs = []string{}
+	}
+	if opts.AsyncPopulationLimit <= 0 {
+		opts.AsyncPopulationLimit = DefaultAsyncPopulationLimit
+	}
+	if opts.BreakerThreshold == 0 {
+		opts.BreakerThreshold = DefaultBreakerThreshold
+	}
+	if opts.BreakerWindow <= 0 {
+		opts.BreakerWindow = DefaultBreakerWindow
+	}
+	if opts.BreakerCooldown <= 0 {
+		opts.BreakerCooldown = DefaultBreakerCooldown
+	}
 	return opts
 }
 
@@ -400,6 +864,7 @@ func applyMetricsDefaults(opts MetricsOptions) MetricsOptions {
 	if opts.HotKeyHistorySize <= 0 {
 		opts.HotKeyHistorySize = DefaultMetricsHotKeyHistorySize
 	}
+	// HistorySnapshotInterval falls back to CollectionInterval in the collector
 	// EnableAPI defaults to true, handled in default options
 	return opts
 }
@@ -414,14 +879,61 @@ func convertPolicyParams(policyType PolicyType, params any) any {
 				Jitter:       p.Jitter,
 				Capacity:     p.Capacity,
 				RefreshAhead: p.RefreshAhead,
+				MaxTTL:       p.MaxTTL,
+				Codec:        p.Codec,
+
+				CapToBackendTTL: p.CapToBackendTTL,
 			}
 		}
 	case KeySplitting:
 		if p, ok := params.(KeySplittingParams); ok {
 			return policy.KeySplittingConfig{
-				Shards: p.Shards,
+				Shards:     p.Shards,
+				AutoShards: p.AutoShards,
+				MaxShards:  p.MaxShards,
+				ShardStep:  p.ShardStep,
+
+				ConsistentRouting: p.ConsistentRouting,
+				TTLJitter:         p.TTLJitter,
+
+				ReplicationAttempts: p.ReplicationAttempts,
+				ReplicationBackoff:  time.Duration(p.ReplicationBackoff * float64(time.Second)),
+				FallbackTTL:         time.Duration(p.FallbackTTL * float64(time.Second)),
 			}
 		}
 	}
 	return nil
 }
+
+// convertOnDecision adapts a public OnDecision callback to the internal callback
+func convertOnDecision(onDecision func(Decision)) func(internal.Decision) {
+	if onDecision == nil {
+		return nil
+	}
+
+	return func(d internal.Decision) {
+		onDecision(Decision{
+			Key:           d.Key,
+			Operation:     d.Operation,
+			Hot:           d.Hot,
+			PolicyApplied: d.PolicyApplied,
+			Result:        d.Result,
+			BackendHit:    d.BackendHit,
+		})
+	}
+}
+
+// convertOnCollect adapts a public OnCollect callback to the internal metrics callback
+func convertOnCollect(onCollect func([]KeyCount, time.Time)) func([]detector.KeyCount, time.Time) {
+	if onCollect == nil {
+		return nil
+	}
+
+	return func(hotKeys []detector.KeyCount, collectedAt time.Time) {
+		keys := make([]KeyCount, len(hotKeys))
+		for i, kc := range hotKeys {
+			keys[i] = KeyCount{Key: kc.Key, Count: kc.Count, Reads: kc.Reads, Writes: kc.Writes}
+		}
+		onCollect(keys, collectedAt)
+	}
+}
@@ -1,9 +1,18 @@
 package keyflare_test
 
 import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/mingrammer/keyflare"
+	"github.com/mingrammer/keyflare/internal/testutil"
+	redisWrapper "github.com/mingrammer/keyflare/pkg/redis"
+	"github.com/redis/go-redis/v9"
 )
 
 func TestNew_WithDefaultOptions(t *testing.T) {
@@ -77,3 +86,354 @@ func TestNew_WithKeySplittingPolicy(t *testing.T) {
 	}
 	defer keyflare.Stop()
 }
+
+func TestStats(t *testing.T) {
+	err := keyflare.New(
+		keyflare.WithMetricsEnabled(false),
+		keyflare.WithPolicyOptions(keyflare.PolicyOptions{
+			Type:          keyflare.LocalCache,
+			WhitelistKeys: []string{"hot"},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create KeyFlare: %v", err)
+	}
+
+	if _, err := keyflare.Stats(); err == nil {
+		t.Error("Expected error before KeyFlare is started")
+	}
+
+	if err := keyflare.Start(); err != nil {
+		t.Fatalf("Failed to start KeyFlare: %v", err)
+	}
+	defer keyflare.Stop()
+
+	// Generate some traffic through a wrapper
+	server := testutil.NewRedisServer(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:    []string{server.Addr()},
+		Protocol: 2,
+	})
+	defer client.Close()
+
+	w, err := redisWrapper.Wrap(client)
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		w.Get(ctx, "hot")
+	}
+	w.Get(ctx, "cold")
+
+	stats, err := keyflare.Stats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+
+	if stats.TotalCount != 6 {
+		t.Errorf("Expected total count 6, got %d", stats.TotalCount)
+	}
+	if stats.TopKLength != 2 {
+		t.Errorf("Expected 2 keys in the top-K, got %d", stats.TopKLength)
+	}
+	if stats.Cache == nil {
+		t.Fatal("Expected cache stats for local cache policy")
+	}
+	if stats.Cache.Capacity != keyflare.DefaultLocalCacheCapacity {
+		t.Errorf("Expected cache capacity %d, got %d", int(keyflare.DefaultLocalCacheCapacity), stats.Cache.Capacity)
+	}
+	if stats.Cache.Misses == 0 {
+		t.Error("Expected cache misses for the hot key")
+	}
+	if !stats.LastCollection.IsZero() {
+		t.Errorf("Expected no collection with metrics disabled, got %v", stats.LastCollection)
+	}
+}
+
+func TestIsHot(t *testing.T) {
+	err := keyflare.New(
+		keyflare.WithMetricsEnabled(false),
+		keyflare.WithDetectorOptions(keyflare.DetectorOptions{TopK: 10, HotThreshold: 3}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create KeyFlare: %v", err)
+	}
+
+	if _, err := keyflare.IsHot("key"); err == nil {
+		t.Error("Expected error before KeyFlare is started")
+	}
+
+	if err := keyflare.Start(); err != nil {
+		t.Fatalf("Failed to start KeyFlare: %v", err)
+	}
+	defer keyflare.Stop()
+
+	server := testutil.NewRedisServer(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:    []string{server.Addr()},
+		Protocol: 2,
+	})
+	defer client.Close()
+
+	w, err := redisWrapper.Wrap(client)
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if hot, err := keyflare.IsHot("key"); err != nil || hot {
+			t.Fatalf("Expected key not to be hot after %d reads (err: %v)", i, err)
+		}
+		w.Get(ctx, "key")
+	}
+
+	if hot, err := keyflare.IsHot("key"); err != nil || !hot {
+		t.Errorf("Expected key to be hot past the threshold (err: %v)", err)
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	err := keyflare.New(
+		keyflare.WithMetricsEnabled(false),
+		keyflare.WithDetectorOptions(keyflare.DetectorOptions{
+			TopK:          10,
+			HotThreshold:  10,
+			KeyNormalizer: func(key string) string { return strings.TrimSuffix(key, "?v=2") },
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create KeyFlare: %v", err)
+	}
+
+	if err := keyflare.Increment("key", 1); err == nil {
+		t.Error("Expected error before KeyFlare is started")
+	}
+
+	if err := keyflare.Start(); err != nil {
+		t.Fatalf("Failed to start KeyFlare: %v", err)
+	}
+	defer keyflare.Stop()
+
+	if err := keyflare.Increment("orm:user:1", 6); err != nil {
+		t.Fatalf("Failed to increment: %v", err)
+	}
+	if err := keyflare.Increment("orm:user:1?v=2", 4); err != nil {
+		t.Fatalf("Failed to increment: %v", err)
+	}
+
+	stats, err := keyflare.Stats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.TotalCount != 10 || stats.TopKLength != 1 {
+		t.Errorf("Expected a total of 10 for a single key, got %d for %d keys", stats.TotalCount, stats.TopKLength)
+	}
+	if hot, err := keyflare.IsHot("orm:user:1"); err != nil || !hot {
+		t.Errorf("Expected manually counted key to be hot (err: %v)", err)
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	options := keyflare.DefaultOptions()
+	options.EnableMetrics = false
+	options.DetectorOptions.TopK = 10
+	options.DetectorOptions.HotThreshold = 1
+	options.PolicyOptions = keyflare.PolicyOptions{
+		Type:          keyflare.LocalCache,
+		Parameters:    keyflare.LocalCacheParams{TTL: 60},
+		WhitelistKeys: []string{"hot"},
+	}
+
+	if err := keyflare.Reconfigure(options); !errors.Is(err, keyflare.ErrNotInitialized) {
+		t.Errorf("Expected ErrNotInitialized before New, got: %v", err)
+	}
+
+	err := keyflare.New(func(o *keyflare.Options) { *o = options })
+	if err != nil {
+		t.Fatalf("Failed to create KeyFlare: %v", err)
+	}
+	if err := keyflare.Start(); err != nil {
+		t.Fatalf("Failed to start KeyFlare: %v", err)
+	}
+	defer keyflare.Stop()
+
+	server := testutil.NewRedisServer(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:    []string{server.Addr()},
+		Protocol: 2,
+	})
+	defer client.Close()
+
+	w, err := redisWrapper.Wrap(client)
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := w.Set(ctx, "hot", "value", 0).Err(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+
+	// The detector dimensions can't change at runtime
+	resized := options
+	resized.DetectorOptions.TopK = 20
+	if err := keyflare.Reconfigure(resized); !errors.Is(err, keyflare.ErrDetectorChanged) {
+		t.Errorf("Expected ErrDetectorChanged, got: %v", err)
+	}
+
+	options.PolicyOptions.Parameters = keyflare.LocalCacheParams{TTL: 3600}
+	if err := keyflare.Reconfigure(options); err != nil {
+		t.Fatalf("Failed to reconfigure: %v", err)
+	}
+
+	// Sets through the existing wrapper use the new TTL
+	if err := w.Set(ctx, "hot", "value", 0).Err(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+	stats, err := keyflare.Stats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.Cache == nil || time.Until(stats.Cache.SoonestExpiration) < 30*time.Minute {
+		t.Errorf("Expected the key to be cached with the new TTL, got %+v", stats.Cache)
+	}
+
+	// The counts accumulated before are kept
+	if stats.TotalCount != 2 || stats.TopKLength != 1 {
+		t.Errorf("Expected a total of 2 for a single key, got %d for %d keys", stats.TotalCount, stats.TopKLength)
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	keys := []keyflare.KeyCount{
+		{Key: "hot", Count: 1000},
+		{Key: "warm", Count: 100},
+		{Key: "cold", Count: 1},
+	}
+
+	report := keyflare.Calibrate(keyflare.DetectorOptions{TopK: 2}, keys)
+	if report.Keys != 3 || report.TotalCount != 1101 {
+		t.Errorf("Expected 3 keys with a total of 1101, got %d keys with %d", report.Keys, report.TotalCount)
+	}
+	if report.MaxRelativeError != 0 || report.TopKRecall != 1 {
+		t.Errorf("Expected exact counts of the top keys, got %+v", report)
+	}
+	if report.SuggestedErrorRate <= 0 {
+		t.Errorf("Expected a suggested error rate, got %v", report.SuggestedErrorRate)
+	}
+}
+
+// countingDetector is a minimal Detector keeping exact counts in a map
+type countingDetector struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+	ops    []string
+}
+
+func (d *countingDetector) Increment(ctx context.Context, key string, count uint64, op string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counts[key] += count
+	d.ops = append(d.ops, op)
+}
+
+func (d *countingDetector) GetCount(key string) uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.counts[key]
+}
+
+func (d *countingDetector) TopK() []keyflare.KeyCount {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	keys := make([]keyflare.KeyCount, 0, len(d.counts))
+	for key, count := range d.counts {
+		keys = append(keys, keyflare.KeyCount{Key: key, Count: count})
+	}
+	return keys
+}
+
+func (d *countingDetector) IsHot(key string) bool {
+	return d.GetCount(key) >= 2
+}
+
+func (d *countingDetector) TotalCount() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var total uint64
+	for _, count := range d.counts {
+		total += count
+	}
+	return total
+}
+
+func (d *countingDetector) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	clear(d.counts)
+}
+
+func TestWithDetector(t *testing.T) {
+	custom := &countingDetector{counts: make(map[string]uint64)}
+	err := keyflare.New(
+		keyflare.WithMetricsEnabled(false),
+		keyflare.WithDetector(custom),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create KeyFlare: %v", err)
+	}
+	if err := keyflare.Start(); err != nil {
+		t.Fatalf("Failed to start KeyFlare: %v", err)
+	}
+	defer keyflare.Stop()
+
+	server := testutil.NewRedisServer(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:    []string{server.Addr()},
+		Protocol: 2,
+	})
+	defer client.Close()
+
+	w, err := redisWrapper.Wrap(client)
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := w.Set(ctx, "user:1", "value", time.Minute).Err(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+	if err := w.Get(ctx, "user:1").Err(); err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+
+	// Commands made without tracking aren't passed to the detector
+	if err := w.Get(keyflare.WithoutTracking(ctx), "user:1").Err(); err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+
+	if count := custom.GetCount("user:1"); count != 2 {
+		t.Errorf("Expected the wrapper to count user:1 twice with the custom detector, got %d", count)
+	}
+	if !slices.Equal(custom.ops, []string{"write", "read"}) {
+		t.Errorf("Expected a write and a read, got %v", custom.ops)
+	}
+
+	// Hotness and stats come from the custom detector
+	if hot, err := keyflare.IsHot("user:1"); err != nil || !hot {
+		t.Errorf("Expected user:1 to be hot per the custom detector (err: %v)", err)
+	}
+	if rank, count, hot := w.KeyStatus("user:1"); rank != 1 || count != 2 || !hot {
+		t.Errorf("Expected user:1 to rank 1st with a count of 2 and be hot, got %d, %d and %v", rank, count, hot)
+	}
+	stats, err := keyflare.Stats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.TotalCount != 2 || stats.TopKLength != 1 {
+		t.Errorf("Expected a total of 2 for a single key, got %d for %d keys", stats.TotalCount, stats.TopKLength)
+	}
+}
@@ -2,17 +2,25 @@
 package memcached
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/mingrammer/keyflare/internal"
+	"github.com/mingrammer/keyflare/internal/detector"
 	"github.com/mingrammer/keyflare/internal/policy"
 )
 
 // Wrapper wraps a gomemcache/memcache client with hot key detection.
 type Wrapper struct {
-	client *memcache.Client
-	kf     *internal.KeyFlare
+	client   *memcache.Client
+	kf       *internal.KeyFlare
+	disabled atomic.Bool
 }
 
 // Wrap creates a new Memcached client wrapper with the provided client.
@@ -34,135 +42,506 @@ func (w *Wrapper) Client() *memcache.Client {
 	return w.client
 }
 
+// SetEnabled enables or disables KeyFlare processing on the wrapper.
+// While disabled, keys aren't counted and policies aren't applied, so commands
+// pass straight through to the client. Other wrappers are unaffected.
+func (w *Wrapper) SetEnabled(enabled bool) {
+	w.disabled.Store(!enabled)
+}
+
+// Enabled returns whether KeyFlare processing is enabled on the wrapper.
+func (w *Wrapper) Enabled() bool {
+	return !w.disabled.Load()
+}
+
 // incrementKey increments the key counter in the detector.
-func (w *Wrapper) incrementKey(key string) {
-	w.kf.Detector().Increment(key, 1)
+func (w *Wrapper) incrementKey(key string, op detector.Operation) {
+	if w.disabled.Load() {
+		return
+	}
+	if key = w.kf.NormalizeKey(key); w.kf.Countable(key) {
+		w.kf.Detector().IncrementOp(context.Background(), key, 1, op)
+	}
+}
+
+// KeyStatus returns how hot key currently is: its rank among the top K keys (1 for the
+// hottest, 0 if it isn't one of them), its estimated count and whether it's hot, so
+// application code can vary behavior by rank, e.g. only handle the top 3 keys specially.
+func (w *Wrapper) KeyStatus(key string) (rank int, count uint64, hot bool) {
+	return w.kf.Detector().KeyStatus(w.kf.NormalizeKey(key))
 }
 
-// applyPolicyIfHot applies the policy if the key is hot.
-func (w *Wrapper) applyPolicyIfHot(key string) (any, error) {
-	if w.kf.Detector().IsHot(key) {
-		p := w.kf.PolicyManager().GetPolicy(key)
-		if p != nil {
+// applyPolicyIfHot applies the policy to the request if the key is hot.
+// Unless FailClosed is set, a policy error is logged and reported as no policy result.
+func (w *Wrapper) applyPolicyIfHot(key string, request any) (data any, err error) {
+	if w.disabled.Load() {
+		return nil, nil
+	}
+	key = w.kf.NormalizeKey(key)
+	var hot, applied bool
+	if w.kf.Debug() {
+		defer func() { w.kf.RecordDecision(internal.NewDecision(key, requestOperation(request), hot, applied, data)) }()
+	}
+
+	// Keys without a policy are skipped before the costlier hot check
+	p := w.kf.PolicyManager().GetPolicy(key)
+	if p != nil {
+		if hot = w.kf.Detector().IsHot(key); hot {
 			ctx := policy.Context{
-				Key: key,
+				Key:   key,
+				Data:  request,
+				Count: w.kf.Detector().GetCount(key),
 			}
-			result := p.Apply(ctx)
 
-			if result.Error == nil {
-				return result.Data, nil
+			// Bypass the policy while the breaker is open after repeated errors
+			if !w.kf.Breaker().Allow() {
+				return nil, nil
 			}
+			result := p.Apply(ctx)
+			w.kf.Breaker().Record(result.Error)
+			applied = true
+
+			if result.Error != nil {
+				if w.kf.FailClosed() {
+					return nil, fmt.Errorf("failed to apply policy for key %s: %w", key, result.Error)
+				}
+				// Fall back to the plain backend call
+				log.Printf("Failed to apply policy for key %s, falling back to Memcached: %v", key, result.Error)
+				return nil, nil
+			}
+			return result.Data, nil
 		}
 	}
 
+	// Keys without a policy skip the hot check, so it's only made for the trace
+	if p == nil && w.kf.Debug() {
+		hot = w.kf.Detector().IsHot(key)
+	}
 	return nil, nil
 }
 
+// requestOperation returns the policy operation of a request
+func requestOperation(request any) string {
+	switch request.(type) {
+	case policy.GetRequest:
+		return "get"
+	case policy.SetRequest:
+		return "set"
+	default:
+		return ""
+	}
+}
+
 // Get wraps memcache.Client.Get.
+// Hot keys held in the local cache are served locally, and fetched hot keys are
+// cached for future hits, the same way as with GetMulti.
 func (w *Wrapper) Get(key string) (*memcache.Item, error) {
+	start := time.Now()
+	var local bool
+	defer func() { w.kf.Metrics().RecordOperationDuration("get", local, time.Since(start)) }()
+
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(key, detector.OpRead)
 
 	// Try to apply policy if hot
-	if value, err := w.applyPolicyIfHot(key); err != nil || value != nil {
+	value, err := w.applyPolicyIfHot(key, policy.GetRequest{})
+	if err != nil || value != nil {
 		// If policy was applied and returned a result
 		if err != nil {
 			return nil, err
 		}
 
-		if value != nil {
-			switch v := value.(type) {
-			case *memcache.Item:
-				return v, nil
-			case []byte:
-				return &memcache.Item{
-					Key:   key,
-					Value: v,
-				}, nil
-			case string:
-				return &memcache.Item{
-					Key:   key,
-					Value: []byte(v),
-				}, nil
-			}
+		if item, ok := toItem(key, value); ok {
+			local = true
+			w.refreshIfDue(key, value)
+			return item, nil
+		}
+		if action, ok := value.(policy.KeySplittingGetAction); ok {
+			return w.handleLookAsideGet(key, action)
 		}
 	}
 
 	// If no policy was applied or policy returned nil, call the original method
-	return w.client.Get(key)
+	item, err := w.client.Get(key)
+	if _, ok := value.(policy.CacheMiss); ok && err == nil {
+		w.populateLocalCache(key, item.Value)
+	}
+	return item, err
 }
 
 // GetMulti wraps memcache.Client.GetMulti.
+// Hot keys held in the local cache are served locally and only the remaining keys
+// are fetched from Memcached. Fetched hot keys are cached for future hits.
 func (w *Wrapper) GetMulti(keys []string) (map[string]*memcache.Item, error) {
-	// Increment key counters
+	start := time.Now()
+	var local bool
+	defer func() { w.kf.Metrics().RecordOperationDuration("get_multi", local, time.Since(start)) }()
+
+	items := make(map[string]*memcache.Item, len(keys))
+	remaining := make([]string, 0, len(keys))
+	missed := make(map[string]bool)
+	for _, key := range keys {
+		// Increment key counter
+		w.incrementKey(key, detector.OpRead)
+
+		value, err := w.applyPolicyIfHot(key, policy.GetRequest{})
+		if err != nil {
+			return nil, err
+		}
+		if item, ok := toItem(key, value); ok {
+			w.refreshIfDue(key, value)
+			items[key] = item
+			continue
+		}
+		if _, ok := value.(policy.CacheMiss); ok {
+			missed[key] = true
+		}
+		remaining = append(remaining, key)
+	}
+
+	if len(remaining) == 0 {
+		// All keys were served from the local cache
+		local = true
+		return items, nil
+	}
+
+	fetched, err := w.client.GetMulti(remaining)
+	if err != nil {
+		return nil, err
+	}
+	for key, item := range fetched {
+		items[key] = item
+		if missed[key] {
+			w.populateLocalCache(key, item.Value)
+		}
+	}
+	return items, nil
+}
+
+// Warmup fetches the keys from Memcached and populates the local cache with them,
+// so the first requests for keys known to be hot are served locally.
+// Keys without a policy or missing from Memcached are skipped, and the fetches aren't counted as accesses.
+func (w *Wrapper) Warmup(keys ...string) error {
+	warmed := make([]string, 0, len(keys))
 	for _, key := range keys {
-		w.incrementKey(key)
+		if w.kf.PolicyManager().GetPolicy(w.kf.NormalizeKey(key)) != nil {
+			warmed = append(warmed, key)
+		}
+	}
+	if len(warmed) == 0 {
+		return nil
+	}
+
+	items, err := w.client.GetMulti(warmed)
+	if err != nil {
+		return fmt.Errorf("failed to fetch keys: %w", err)
+	}
+	for key, item := range items {
+		w.asyncSetLocalCache(key, item.Value)
+	}
+	return nil
+}
+
+// populateLocalCache caches a value fetched after a local cache miss in the background
+// The value is copied since the caller keeps using the fetched item
+func (w *Wrapper) populateLocalCache(key string, value []byte) {
+	value = bytes.Clone(value)
+	w.kf.PopulateAsync(w.kf.NormalizeKey(key), func() {
+		w.asyncSetLocalCache(key, value)
+	})
+}
+
+// asyncSetLocalCache asynchronously sets value in local cache
+func (w *Wrapper) asyncSetLocalCache(key string, value []byte) {
+	key = w.kf.NormalizeKey(key)
+	p := w.kf.PolicyManager().GetPolicy(key)
+	if p != nil {
+		p.Apply(policy.Context{
+			Key:  key,
+			Data: policy.SetRequest{Value: value},
+		})
+	}
+}
+
+// refreshIfDue refreshes the local cache of the key if the policy result is a
+// cache hit past its refresh-ahead point, so the item doesn't expire under load
+func (w *Wrapper) refreshIfDue(key string, value any) {
+	if hit, ok := value.(policy.CacheHit); ok && hit.ShouldRefresh {
+		w.refreshLocalCache(key)
+	}
+}
+
+// refreshLocalCache asynchronously fetches the key from Memcached and repopulates the local cache
+// Concurrent refreshes of the same key are coalesced into a single fetch
+func (w *Wrapper) refreshLocalCache(key string) {
+	w.kf.PopulateAsync(w.kf.NormalizeKey(key), func() {
+		if item, err := w.client.Get(key); err == nil {
+			w.asyncSetLocalCache(key, item.Value)
+		}
+	})
+}
+
+// invalidateLocalCache evicts the key from the local cache, if any, or deletes
+// the shards of a split key, after its value was changed in Memcached
+// Reads of deleted shards fall back to the original key until the next Set
+func (w *Wrapper) invalidateLocalCache(key string) {
+	key = w.kf.NormalizeKey(key)
+	p := w.kf.PolicyManager().GetPolicy(key)
+	if p == nil {
+		return
 	}
 
-	return w.client.GetMulti(keys)
+	result := p.Apply(policy.Context{
+		Key:  key,
+		Data: policy.DeleteRequest{},
+	})
+	if action, ok := result.Data.(policy.KeySplittingDeleteAction); ok {
+		for _, shardKey := range action.ShardKeys {
+			if err := w.client.Delete(shardKey); err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+				w.kf.Metrics().RecordReplicationError()
+			}
+		}
+	}
+}
+
+// toItem converts a policy result to an item for the key.
+// It returns false if the result doesn't carry a value.
+func toItem(key string, value any) (*memcache.Item, bool) {
+	if hit, ok := value.(policy.CacheHit); ok {
+		value = hit.Value
+	}
+
+	switch v := value.(type) {
+	case *memcache.Item:
+		return v, true
+	case []byte:
+		return &memcache.Item{
+			Key:   key,
+			Value: v,
+		}, true
+	case string:
+		return &memcache.Item{
+			Key:   key,
+			Value: []byte(v),
+		}, true
+	}
+	return nil, false
 }
 
 // Set wraps memcache.Client.Set.
+// Hot keys are written to Memcached first and then written through to the policy,
+// so the local cache or shards only ever hold values that were written to Memcached.
 func (w *Wrapper) Set(item *memcache.Item) error {
+	start := time.Now()
+	defer func() { w.kf.Metrics().RecordOperationDuration("set", false, time.Since(start)) }()
+
 	// Increment key counter
-	w.incrementKey(item.Key)
+	w.incrementKey(item.Key, detector.OpWrite)
+
+	if err := w.client.Set(item); err != nil {
+		return err
+	}
+
+	// Try to apply policy if hot
+	value := bytes.Clone(item.Value)
+	set := policy.SetRequest{Value: value}
+	if w.kf.CapToBackendTTL() {
+		set.MaxTTL = expirationSeconds(item.Expiration)
+	}
+	policyResult, err := w.applyPolicyIfHot(item.Key, set)
+	if err != nil {
+		// The write succeeded, so a failure to apply the policy doesn't fail it
+		log.Printf("Failed to apply policy for key %s after setting it: %v", item.Key, err)
+		return nil
+	}
+
+	// Handle different policy types
+	switch result := policyResult.(type) {
+	case policy.KeySplittingSetAction:
+		// Asynchronously write to all target shards
+		superseded := w.kf.StartReplication(result.OriginalKey)
+		go w.replicateToShards(result.ShardKeys, value, item.Flags, item.Expiration, result.TTLJitter, result.Retry, superseded)
+	case policy.CacheSet:
+		// The written value is now in the local cache
+		break
+	}
+
+	return nil
+}
+
+// replicateToShards writes the value to every shard of a split key.
+// Relative expirations are jittered so the shards don't all expire at once.
+// Writes are dropped once superseded reports that a newer value is being replicated.
+func (w *Wrapper) replicateToShards(
+	shardKeys []string, value []byte, flags uint32, expiration int32, jitter float64, retry policy.RetryConfig,
+	superseded func() bool,
+) {
+	for _, shardKey := range shardKeys {
+		shard := &memcache.Item{
+			Key:        shardKey,
+			Value:      value,
+			Flags:      flags,
+			Expiration: jitterExpiration(expiration, jitter),
+		}
+		err := retry.Do(context.Background(), func() error {
+			if superseded() {
+				return nil
+			}
+			return w.client.Set(shard)
+		})
+		if err != nil {
+			// Reads of the missing shard fall back to the original key
+			w.kf.Metrics().RecordReplicationError()
+		}
+	}
+}
+
+// expirationSeconds returns the TTL in seconds of an item expiration, or 0 if it doesn't expire
+// Expirations above maxRelativeExpiration are Unix timestamps
+func expirationSeconds(expiration int32) float64 {
+	switch {
+	case expiration <= 0:
+		return 0
+	case expiration <= maxRelativeExpiration:
+		return float64(expiration)
+	default:
+		return max(time.Until(time.Unix(int64(expiration), 0)).Seconds(), 0)
+	}
+}
 
-	return w.client.Set(item)
+// maxRelativeExpiration is the largest expiration Memcached treats as relative seconds
+// Larger values are absolute Unix timestamps
+const maxRelativeExpiration = 60 * 60 * 24 * 30
+
+// jitterExpiration returns a relative expiration shortened by up to expiration*jitter
+// No expiration and absolute expirations are returned as is, and jittered expirations
+// stay relative so they aren't read as timestamps in 1970
+func jitterExpiration(expiration int32, jitter float64) int32 {
+	if expiration <= 0 || expiration > maxRelativeExpiration {
+		return expiration
+	}
+	ttl := policy.JitterTTL(time.Duration(expiration)*time.Second, jitter)
+	return int32(min(max(ttl/time.Second, 1), maxRelativeExpiration))
+}
+
+// handleLookAsideGet reads a split key from one of its shards, falling back to the original key
+// Shards are only filled by Set, so a missing shard isn't replicated on read
+func (w *Wrapper) handleLookAsideGet(key string, action policy.KeySplittingGetAction) (*memcache.Item, error) {
+	w.kf.Detector().IncrementShard(action.OriginalKey, action.ShardIndex)
+	if shard, err := w.client.Get(action.RandShardKey); err == nil {
+		shard.Key = key
+		return shard, nil
+	}
+	return w.client.Get(key)
 }
 
 // Add wraps memcache.Client.Add.
+// The key is evicted from the local cache and its shards are deleted, since they may be stale.
 func (w *Wrapper) Add(item *memcache.Item) error {
 	// Increment key counter
-	w.incrementKey(item.Key)
+	w.incrementKey(item.Key, detector.OpWrite)
 
+	defer w.invalidateLocalCache(item.Key)
 	return w.client.Add(item)
 }
 
 // Replace wraps memcache.Client.Replace.
+// The key is evicted from the local cache and its shards are deleted, since they're now stale.
 func (w *Wrapper) Replace(item *memcache.Item) error {
 	// Increment key counter
-	w.incrementKey(item.Key)
+	w.incrementKey(item.Key, detector.OpWrite)
 
+	defer w.invalidateLocalCache(item.Key)
 	return w.client.Replace(item)
 }
 
+// Append wraps memcache.Client.Append.
+// The key is evicted from the local cache and its shards are deleted, since they're now stale.
+func (w *Wrapper) Append(item *memcache.Item) error {
+	// Increment key counter
+	w.incrementKey(item.Key, detector.OpWrite)
+
+	defer w.invalidateLocalCache(item.Key)
+	return w.client.Append(item)
+}
+
+// Prepend wraps memcache.Client.Prepend.
+// The key is evicted from the local cache and its shards are deleted, since they're now stale.
+func (w *Wrapper) Prepend(item *memcache.Item) error {
+	// Increment key counter
+	w.incrementKey(item.Key, detector.OpWrite)
+
+	defer w.invalidateLocalCache(item.Key)
+	return w.client.Prepend(item)
+}
+
 // Delete wraps memcache.Client.Delete.
+// The key is evicted from the local cache and its shards are deleted.
 func (w *Wrapper) Delete(key string) error {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(key, detector.OpWrite)
 
+	defer w.invalidateLocalCache(key)
 	return w.client.Delete(key)
 }
 
+// DeleteMulti deletes multiple keys, evicting each from the local cache.
+// Memcached has no multi-key delete, so the keys are deleted one by one.
+// It returns a combined error for the keys that failed to delete.
+func (w *Wrapper) DeleteMulti(keys []string) error {
+	var errs []error
+	for _, key := range keys {
+		if err := w.Delete(key); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete key %s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // Increment wraps memcache.Client.Increment.
+// The key is evicted from the local cache and its shards are deleted, since they're now stale.
 func (w *Wrapper) Increment(key string, delta uint64) (uint64, error) {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(key, detector.OpWrite)
 
+	defer w.invalidateLocalCache(key)
 	return w.client.Increment(key, delta)
 }
 
 // Decrement wraps memcache.Client.Decrement.
+// The key is evicted from the local cache and its shards are deleted, since they're now stale.
 func (w *Wrapper) Decrement(key string, delta uint64) (uint64, error) {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(key, detector.OpWrite)
 
+	defer w.invalidateLocalCache(key)
 	return w.client.Decrement(key, delta)
 }
 
 // CompareAndSwap wraps memcache.Client.CompareAndSwap.
+// A successful swap writes the new value through to the local cache,
+// and a failed one evicts the key since the cached value is likely stale.
+// Either way, the shards of a split key are deleted.
 func (w *Wrapper) CompareAndSwap(item *memcache.Item) error {
 	// Increment key counter
-	w.incrementKey(item.Key)
+	w.incrementKey(item.Key, detector.OpWrite)
 
-	return w.client.CompareAndSwap(item)
+	err := w.client.CompareAndSwap(item)
+	w.invalidateLocalCache(item.Key)
+	if err != nil {
+		return err
+	}
+	w.asyncSetLocalCache(item.Key, bytes.Clone(item.Value))
+	return nil
 }
 
 // Touch wraps memcache.Client.Touch.
+// The shards of a split key are deleted, since they'd expire at the old expiration.
 func (w *Wrapper) Touch(key string, seconds int32) error {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(key, detector.OpWrite)
 
+	defer w.invalidateLocalCache(key)
 	return w.client.Touch(key, seconds)
 }
 
@@ -175,3 +554,16 @@ func (w *Wrapper) FlushAll() error {
 func (w *Wrapper) Ping() error {
 	return w.client.Ping()
 }
+
+// HealthCheck verifies that KeyFlare is running and all Memcached servers are reachable.
+// It returns a combined error describing every failed check.
+func (w *Wrapper) HealthCheck() error {
+	var errs []error
+	if _, err := internal.GetInstance(); err != nil {
+		errs = append(errs, fmt.Errorf("KeyFlare is unhealthy: %w", err))
+	}
+	if err := w.client.Ping(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to ping Memcached: %w", err))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,567 @@
+package memcached
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/mingrammer/keyflare/internal"
+	"github.com/mingrammer/keyflare/internal/detector"
+	"github.com/mingrammer/keyflare/internal/policy"
+	"github.com/mingrammer/keyflare/internal/testutil"
+)
+
+// failingManager is a policy manager whose policies always fail
+type failingManager struct {
+	policy.Manager
+}
+
+func (m failingManager) GetPolicy(key string) policy.Policy {
+	return failingPolicy{}
+}
+
+type failingPolicy struct{}
+
+func (failingPolicy) Apply(ctx policy.Context) policy.Result {
+	return policy.Result{Error: errors.New("policy failure")}
+}
+
+func TestWrapper_PolicyErrorFailClosed(t *testing.T) {
+	for _, failClosed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("FailClosed=%v", failClosed), func(t *testing.T) {
+			server := testutil.NewMemcachedServer(t)
+			testutil.StartKeyFlareConfig(t, internal.Config{
+				DetectorConfig: detector.Config{TopK: 10, HotThreshold: 1},
+				PolicyManager:  failingManager{},
+				FailClosed:     failClosed,
+			})
+
+			w, err := Wrap(memcache.New(server.Addr()))
+			if err != nil {
+				t.Fatalf("Failed to wrap client: %v", err)
+			}
+			if err := w.Client().Set(&memcache.Item{Key: "hot", Value: []byte("value")}); err != nil {
+				t.Fatalf("Failed to set key: %v", err)
+			}
+
+			item, err := w.Get("hot")
+			_, multiErr := w.GetMulti([]string{"hot"})
+			if failClosed {
+				for _, err := range []error{err, multiErr} {
+					if err == nil || !strings.Contains(err.Error(), "policy failure") {
+						t.Errorf("Expected the policy error to be returned, got: %v", err)
+					}
+				}
+				if calls := server.Calls("gets", "hot"); calls != 0 {
+					t.Errorf("Expected no backend read, got %d", calls)
+				}
+			} else {
+				if err != nil || string(item.Value) != "value" {
+					t.Fatalf("Expected the backend result 'value' (err: %v)", err)
+				}
+				if multiErr != nil {
+					t.Errorf("Expected GetMulti to fall back to the backend, got: %v", multiErr)
+				}
+				if calls := server.Calls("gets", "hot"); calls != 2 {
+					t.Errorf("Expected 2 backend reads, got %d", calls)
+				}
+			}
+
+			// The write reached the backend, so a policy failure after it doesn't fail Set
+			if err := w.Set(&memcache.Item{Key: "hot", Value: []byte("new")}); err != nil {
+				t.Errorf("Expected Set to succeed, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestWrapper_HealthCheck(t *testing.T) {
+	server := testutil.NewMemcachedServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10}, testutil.LocalCachePolicyConfig())
+
+	w, err := Wrap(memcache.New(server.Addr()))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	if err := w.HealthCheck(); err != nil {
+		t.Errorf("Expected healthy wrapper, got: %v", err)
+	}
+}
+
+func TestWrapper_HealthCheck_NotRunning(t *testing.T) {
+	server := testutil.NewMemcachedServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10}, testutil.LocalCachePolicyConfig())
+
+	w, err := Wrap(memcache.New(server.Addr()))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// Re-initialize KeyFlare without starting it
+	if err := internal.Stop(); err != nil {
+		t.Fatalf("Failed to stop KeyFlare: %v", err)
+	}
+	if err := internal.New(internal.Config{PolicyConfig: testutil.LocalCachePolicyConfig()}); err != nil {
+		t.Fatalf("Failed to create KeyFlare: %v", err)
+	}
+
+	err = w.HealthCheck()
+	if err == nil {
+		t.Fatal("Expected error when KeyFlare is not running")
+	}
+	if !strings.Contains(err.Error(), "not running") {
+		t.Errorf("Expected 'not running' error, got: %v", err)
+	}
+}
+
+func TestWrapper_HealthCheck_Unreachable(t *testing.T) {
+	server := testutil.NewMemcachedServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10}, testutil.LocalCachePolicyConfig())
+
+	w, err := Wrap(memcache.New(server.Addr()))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	server.Close()
+
+	if err := w.HealthCheck(); err == nil {
+		t.Error("Expected error when Memcached is unreachable")
+	}
+}
+
+func TestWrapper_GetMultiServesCachedHotKeys(t *testing.T) {
+	server := testutil.NewMemcachedServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10}, testutil.LocalCachePolicyConfig("hot"))
+
+	w, err := Wrap(memcache.New(server.Addr()))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	for _, key := range []string{"hot", "cold1", "cold2"} {
+		if err := w.Client().Set(&memcache.Item{Key: key, Value: []byte("value-" + key)}); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+
+	// The first fetch misses the local cache and caches the hot key
+	if _, err := w.GetMulti([]string{"hot"}); err != nil {
+		t.Fatalf("Failed to get keys: %v", err)
+	}
+	p := w.kf.PolicyManager().GetPolicy("hot")
+	testutil.Eventually(t, func() bool {
+		_, ok := p.Apply(policy.Context{Key: "hot", Data: policy.GetRequest{}}).Data.(policy.CacheHit)
+		return ok
+	})
+
+	items, err := w.GetMulti([]string{"hot", "cold1", "cold2", "missing"})
+	if err != nil {
+		t.Fatalf("Failed to get keys: %v", err)
+	}
+
+	if len(items) != 3 {
+		t.Fatalf("Expected 3 items, got %d", len(items))
+	}
+	for _, key := range []string{"hot", "cold1", "cold2"} {
+		item, ok := items[key]
+		if !ok {
+			t.Errorf("Expected item for %s", key)
+			continue
+		}
+		if string(item.Value) != "value-"+key {
+			t.Errorf("Expected value 'value-%s', got '%s'", key, item.Value)
+		}
+	}
+
+	if calls := server.Calls("gets", "hot"); calls != 1 {
+		t.Errorf("Expected only the first fetch of the hot key to reach the backend, got %d", calls)
+	}
+	for _, key := range []string{"cold1", "cold2", "missing"} {
+		if calls := server.Calls("gets", key); calls != 1 {
+			t.Errorf("Expected 1 backend read for %s, got %d", key, calls)
+		}
+	}
+}
+
+func TestWrapper_GetAndGetMultiShareLocalCache(t *testing.T) {
+	server := testutil.NewMemcachedServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10}, testutil.LocalCachePolicyConfig("hot1", "hot2"))
+
+	w, err := Wrap(memcache.New(server.Addr()))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	for _, key := range []string{"hot1", "hot2"} {
+		if err := w.Client().Set(&memcache.Item{Key: key, Value: []byte("value-" + key)}); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+
+	cached := func(key string) func() bool {
+		p := w.kf.PolicyManager().GetPolicy(key)
+		return func() bool {
+			_, ok := p.Apply(policy.Context{Key: key, Data: policy.GetRequest{}}).Data.(policy.CacheHit)
+			return ok
+		}
+	}
+
+	// A key cached by Get is served locally by GetMulti
+	if _, err := w.Get("hot1"); err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+	testutil.Eventually(t, cached("hot1"))
+	items, err := w.GetMulti([]string{"hot1"})
+	if err != nil || string(items["hot1"].Value) != "value-hot1" {
+		t.Fatalf("Expected 'value-hot1', got %v (err: %v)", items, err)
+	}
+
+	// A key cached by GetMulti is served locally by Get
+	if _, err := w.GetMulti([]string{"hot2"}); err != nil {
+		t.Fatalf("Failed to get keys: %v", err)
+	}
+	testutil.Eventually(t, cached("hot2"))
+	item, err := w.Get("hot2")
+	if err != nil || string(item.Value) != "value-hot2" {
+		t.Fatalf("Expected 'value-hot2', got %v (err: %v)", item, err)
+	}
+
+	for _, key := range []string{"hot1", "hot2"} {
+		if calls := server.Calls("gets", key); calls != 1 {
+			t.Errorf("Expected only the first read of %s to reach the backend, got %d", key, calls)
+		}
+	}
+}
+
+func TestWrapper_AppendPrependInvalidateLocalCache(t *testing.T) {
+	server := testutil.NewMemcachedServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10}, testutil.LocalCachePolicyConfig("list"))
+
+	w, err := Wrap(memcache.New(server.Addr()))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	if err := w.Set(&memcache.Item{Key: "list", Value: []byte("b")}); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+
+	p := w.kf.PolicyManager().GetPolicy("list")
+	cached := func() bool {
+		_, ok := p.Apply(policy.Context{Key: "list", Data: policy.GetRequest{}}).Data.(policy.CacheHit)
+		return ok
+	}
+
+	p.Apply(policy.Context{Key: "list", Data: policy.SetRequest{Value: []byte("b")}})
+	if err := w.Append(&memcache.Item{Key: "list", Value: []byte("c")}); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	if cached() {
+		t.Error("Expected the local cache entry to be evicted after append")
+	}
+
+	p.Apply(policy.Context{Key: "list", Data: policy.SetRequest{Value: []byte("bc")}})
+	if err := w.Prepend(&memcache.Item{Key: "list", Value: []byte("a")}); err != nil {
+		t.Fatalf("Failed to prepend: %v", err)
+	}
+	if cached() {
+		t.Error("Expected the local cache entry to be evicted after prepend")
+	}
+
+	if count := w.kf.Detector().GetCount("list"); count != 3 {
+		t.Errorf("Expected count 3, got %d", count)
+	}
+
+	item, err := w.Get("list")
+	if err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+	if string(item.Value) != "abc" {
+		t.Errorf("Expected value 'abc', got '%s'", item.Value)
+	}
+}
+
+func TestWrapper_CompareAndSwapUpdatesLocalCache(t *testing.T) {
+	server := testutil.NewMemcachedServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10}, testutil.LocalCachePolicyConfig("config"))
+
+	w, err := Wrap(memcache.New(server.Addr()))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	if err := w.Set(&memcache.Item{Key: "config", Value: []byte("old")}); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+	p := w.kf.PolicyManager().GetPolicy("config")
+	p.Apply(policy.Context{Key: "config", Data: policy.SetRequest{Value: []byte("old")}})
+
+	item, err := w.Client().Get("config")
+	if err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+	stale := *item
+
+	// A successful swap is written through to the local cache
+	item.Value = []byte("new")
+	if err := w.CompareAndSwap(item); err != nil {
+		t.Fatalf("Failed to compare and swap: %v", err)
+	}
+	item, err = w.Get("config")
+	if err != nil || string(item.Value) != "new" {
+		t.Fatalf("Expected 'new', got %v (err: %v)", item, err)
+	}
+
+	// A conflicting swap evicts the local entry
+	stale.Value = []byte("conflict")
+	if err := w.CompareAndSwap(&stale); !errors.Is(err, memcache.ErrCASConflict) {
+		t.Fatalf("Expected ErrCASConflict, got: %v", err)
+	}
+	if _, ok := p.Apply(policy.Context{Key: "config", Data: policy.GetRequest{}}).Data.(policy.CacheHit); ok {
+		t.Error("Expected the local cache entry to be evicted after a conflict")
+	}
+}
+
+func TestWrapper_SetSplitsHotKeys(t *testing.T) {
+	server := testutil.NewMemcachedServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+		Type:          policy.KeySplitting,
+		Parameters:    policy.KeySplittingConfig{Shards: 3},
+		WhitelistKeys: []string{"hot"},
+	})
+
+	w, err := Wrap(memcache.New(server.Addr()))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	if err := w.Set(&memcache.Item{Key: "hot", Value: []byte("value"), Flags: 7, Expiration: 60}); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+
+	// The write is replicated to every shard
+	for i := 0; i < 3; i++ {
+		shardKey := policy.ShardKey("hot", i)
+		testutil.Eventually(t, func() bool {
+			item, err := w.Client().Get(shardKey)
+			return err == nil && string(item.Value) == "value" && item.Flags == 7
+		})
+	}
+
+	// Reads are then served by the shards
+	item, err := w.Get("hot")
+	if err != nil || item.Key != "hot" || string(item.Value) != "value" {
+		t.Fatalf("Expected 'value' for key hot, got %v (err: %v)", item, err)
+	}
+	if calls := server.Calls("get", "hot"); calls != 0 {
+		t.Errorf("Expected no reads of the original key, got %d", calls)
+	}
+}
+
+func TestJitterExpiration(t *testing.T) {
+	if exp := jitterExpiration(0, 0.5); exp != 0 {
+		t.Errorf("Expected no expiration to be kept, got %d", exp)
+	}
+	absolute := int32(time.Now().Unix())
+	if exp := jitterExpiration(absolute, 0.5); exp != absolute {
+		t.Errorf("Expected an absolute expiration to be kept, got %d", exp)
+	}
+	for i := 0; i < 100; i++ {
+		if exp := jitterExpiration(100, 0.2); exp < 80 || exp > 100 {
+			t.Fatalf("Expected an expiration within [80, 100], got %d", exp)
+		}
+		// Jittered expirations are never read as timestamps
+		if exp := jitterExpiration(maxRelativeExpiration, 0.5); exp > maxRelativeExpiration {
+			t.Fatalf("Expected an expiration of at most %d, got %d", maxRelativeExpiration, exp)
+		}
+	}
+}
+
+func TestWrapper_MutationsDeleteShards(t *testing.T) {
+	mutations := map[string]func(w *Wrapper) error{
+		"Delete":    func(w *Wrapper) error { return w.Delete("hot") },
+		"Add":       func(w *Wrapper) error { return w.Add(&memcache.Item{Key: "hot", Value: []byte("2")}) },
+		"Replace":   func(w *Wrapper) error { return w.Replace(&memcache.Item{Key: "hot", Value: []byte("2")}) },
+		"Append":    func(w *Wrapper) error { return w.Append(&memcache.Item{Key: "hot", Value: []byte("0")}) },
+		"Touch":     func(w *Wrapper) error { return w.Touch("hot", 60) },
+		"Increment": func(w *Wrapper) error { _, err := w.Increment("hot", 1); return err },
+		"Decrement": func(w *Wrapper) error { _, err := w.Decrement("hot", 1); return err },
+		"CompareAndSwap": func(w *Wrapper) error {
+			item, err := w.Client().Get("hot")
+			if err != nil {
+				return err
+			}
+			item.Value = []byte("2")
+			return w.CompareAndSwap(item)
+		},
+	}
+
+	for name, mutate := range mutations {
+		t.Run(name, func(t *testing.T) {
+			server := testutil.NewMemcachedServer(t)
+			testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+				Type:          policy.KeySplitting,
+				Parameters:    policy.KeySplittingConfig{Shards: 3},
+				WhitelistKeys: []string{"hot"},
+			})
+
+			w, err := Wrap(memcache.New(server.Addr()))
+			if err != nil {
+				t.Fatalf("Failed to wrap client: %v", err)
+			}
+
+			if err := w.Set(&memcache.Item{Key: "hot", Value: []byte("1")}); err != nil {
+				t.Fatalf("Failed to set key: %v", err)
+			}
+			for i := 0; i < 3; i++ {
+				shardKey := policy.ShardKey("hot", i)
+				testutil.Eventually(t, func() bool {
+					_, err := w.Client().Get(shardKey)
+					return err == nil
+				})
+			}
+
+			// Add fails since the key exists, but the shards are deleted all the same
+			if err := mutate(w); err != nil && !errors.Is(err, memcache.ErrNotStored) {
+				t.Fatalf("Failed to mutate key: %v", err)
+			}
+
+			for i := 0; i < 3; i++ {
+				if _, err := w.Client().Get(policy.ShardKey("hot", i)); !errors.Is(err, memcache.ErrCacheMiss) {
+					t.Errorf("Expected shard %d to be deleted, got: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestWrapper_DeleteMulti(t *testing.T) {
+	server := testutil.NewMemcachedServer(t)
+	keys := []string{"a", "b", "c"}
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10}, testutil.LocalCachePolicyConfig(keys...))
+
+	w, err := Wrap(memcache.New(server.Addr()))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	for _, key := range keys {
+		if err := w.Client().Set(&memcache.Item{Key: key, Value: []byte("value")}); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+		w.kf.PolicyManager().GetPolicy(key).Apply(policy.Context{Key: key, Data: policy.SetRequest{Value: []byte("value")}})
+	}
+
+	if err := w.DeleteMulti(keys); err != nil {
+		t.Fatalf("Failed to delete keys: %v", err)
+	}
+
+	for _, key := range keys {
+		if count := w.kf.Detector().GetCount(key); count != 1 {
+			t.Errorf("Expected count 1 for %s, got %d", key, count)
+		}
+		p := w.kf.PolicyManager().GetPolicy(key)
+		if _, ok := p.Apply(policy.Context{Key: key, Data: policy.GetRequest{}}).Data.(policy.CacheMiss); !ok {
+			t.Errorf("Expected %s to be evicted from the local cache", key)
+		}
+		if _, err := w.Client().Get(key); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected %s to be deleted, got: %v", key, err)
+		}
+	}
+
+	// Deleting missing keys reports them
+	err = w.DeleteMulti([]string{"a", "missing"})
+	if !errors.Is(err, memcache.ErrCacheMiss) {
+		t.Errorf("Expected cache miss error, got: %v", err)
+	}
+}
+
+func TestWrapper_GetRefreshAhead(t *testing.T) {
+	server := testutil.NewMemcachedServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+		Type: policy.LocalCache,
+		Parameters: policy.LocalCacheConfig{
+			TTL:          0.5,
+			Capacity:     100,
+			RefreshAhead: 0.2, // refresh after 100ms
+		},
+		WhitelistKeys: []string{"hot"},
+	})
+
+	w, err := Wrap(memcache.New(server.Addr()))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+	if err := w.Client().Set(&memcache.Item{Key: "hot", Value: []byte("v1")}); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+
+	// The first fetch misses the local cache and caches the value
+	if _, err := w.GetMulti([]string{"hot"}); err != nil {
+		t.Fatalf("Failed to get keys: %v", err)
+	}
+	p := w.kf.PolicyManager().GetPolicy("hot")
+	cachedValue := func() string {
+		hit, _ := p.Apply(policy.Context{Key: "hot", Data: policy.GetRequest{}}).Data.(policy.CacheHit)
+		value, _ := hit.Value.([]byte)
+		return string(value)
+	}
+	testutil.Eventually(t, func() bool { return cachedValue() == "v1" })
+
+	if err := w.Client().Set(&memcache.Item{Key: "hot", Value: []byte("v2")}); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	// Reads past the refresh-ahead point are still served locally and trigger a single refresh
+	for i := 0; i < 5; i++ {
+		item, err := w.Get("hot")
+		if err != nil || string(item.Value) != "v1" {
+			t.Fatalf("Expected cached 'v1', got %v (err: %v)", item, err)
+		}
+	}
+
+	testutil.Eventually(t, func() bool { return cachedValue() == "v2" })
+	if calls := server.Calls("gets", "hot"); calls != 2 {
+		t.Errorf("Expected the initial fetch and one refresh, got %d backend reads", calls)
+	}
+}
+
+func TestWrapper_Warmup(t *testing.T) {
+	server := testutil.NewMemcachedServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10}, testutil.LocalCachePolicyConfig("hot", "missing"))
+
+	w, err := Wrap(memcache.New(server.Addr()))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	for _, key := range []string{"hot", "cold"} {
+		if err := w.Client().Set(&memcache.Item{Key: key, Value: []byte("value-" + key)}); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+
+	if err := w.Warmup("hot", "cold", "missing"); err != nil {
+		t.Fatalf("Failed to warm up: %v", err)
+	}
+
+	// Keys without a policy aren't fetched
+	if calls := server.Calls("gets", "cold"); calls != 0 {
+		t.Errorf("Expected no fetch of a key without a policy, got %d", calls)
+	}
+
+	// The first read is served from the local cache
+	item, err := w.Get("hot")
+	if err != nil || string(item.Value) != "value-hot" {
+		t.Fatalf("Expected 'value-hot', got %v (err: %v)", item, err)
+	}
+	if calls := server.Calls("gets", "hot"); calls != 1 {
+		t.Errorf("Expected only the warmup fetch to reach the backend, got %d", calls)
+	}
+}
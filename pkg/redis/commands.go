@@ -0,0 +1,286 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+)
+
+// keySpec describes the positions of the keys of a command like the key specs of
+// Redis's COMMAND: keys are the arguments from first to last, every step arguments.
+// A negative last counts from the end, -1 being the last argument.
+// A zero step means the command doesn't access keys.
+type keySpec struct {
+	first int
+	last  int
+	step  int
+}
+
+// keys returns the keys of a command according to the spec.
+func (s keySpec) keys(args []any) []string {
+	if s.step <= 0 || s.first >= len(args) {
+		return nil
+	}
+
+	last := s.last
+	if last < 0 {
+		last += len(args)
+	}
+	last = min(last, len(args)-1)
+	if last < s.first {
+		return nil
+	}
+	return stringArgs(args[s.first:last+1], s.step)
+}
+
+var (
+	// singleKey is the spec of commands whose only key is their first argument
+	singleKey = keySpec{first: 1, last: 1, step: 1}
+
+	// allKeys is the spec of commands whose arguments are all keys
+	allKeys = keySpec{first: 1, last: -1, step: 1}
+
+	// noKeys is the spec of commands that don't access keys
+	noKeys = keySpec{}
+)
+
+// keyExtractors are the key extractors of commands whose keys can't be described by
+// a key spec, such as commands giving the number of keys as an argument
+// They take precedence over the key specs
+var keyExtractors = map[string]func(args []any) []string{
+	"eval":        numKeys(2),
+	"evalsha":     numKeys(2),
+	"eval_ro":     numKeys(2),
+	"evalsha_ro":  numKeys(2),
+	"fcall":       numKeys(2),
+	"fcall_ro":    numKeys(2),
+	"sintercard":  numKeys(1),
+	"zunion":      numKeys(1),
+	"zinter":      numKeys(1),
+	"zdiff":       numKeys(1),
+	"zintercard":  numKeys(1),
+	"lmpop":       numKeys(1),
+	"zmpop":       numKeys(1),
+	"blmpop":      numKeys(2),
+	"bzmpop":      numKeys(2),
+	"zunionstore": storeKeys,
+	"zinterstore": storeKeys,
+	"zdiffstore":  storeKeys,
+	"xread":       streamKeys,
+	"xreadgroup":  streamKeys,
+}
+
+// keySpecs are the key specs of standard commands with other keys than their first argument,
+// or without keys. Commands missing from the table access their first argument only.
+// Commands whose first argument isn't a key, such as credentials, cursors, patterns or
+// channels, must be listed so those arguments aren't counted and reported as keys.
+var keySpecs = map[string]keySpec{
+	// Strings and generic commands
+	"mget":      allKeys,
+	"mset":      {first: 1, last: -1, step: 2},
+	"msetnx":    {first: 1, last: -1, step: 2},
+	"del":       allKeys,
+	"unlink":    allKeys,
+	"exists":    allKeys,
+	"touch":     allKeys,
+	"watch":     allKeys,
+	"rename":    {first: 1, last: 2, step: 1},
+	"renamenx":  {first: 1, last: 2, step: 1},
+	"copy":      {first: 1, last: 2, step: 1},
+	"bitop":     {first: 2, last: -1, step: 1},
+	"object":    {first: 2, last: 2, step: 1}, // OBJECT ENCODING key
+	"memory":    {first: 2, last: 2, step: 1}, // MEMORY USAGE key
+	"pfcount":   allKeys,
+	"pfmerge":   allKeys,
+	"sort":      singleKey,
+	"sort_ro":   singleKey,
+	"georadius": singleKey,
+
+	// Lists
+	"rpoplpush":  {first: 1, last: 2, step: 1},
+	"lmove":      {first: 1, last: 2, step: 1},
+	"blmove":     {first: 1, last: 2, step: 1},
+	"brpoplpush": {first: 1, last: 2, step: 1},
+	"blpop":      {first: 1, last: -2, step: 1},
+	"brpop":      {first: 1, last: -2, step: 1},
+
+	// Sets and sorted sets
+	"sdiff":       allKeys,
+	"sinter":      allKeys,
+	"sunion":      allKeys,
+	"sdiffstore":  allKeys,
+	"sinterstore": allKeys,
+	"sunionstore": allKeys,
+	"smove":       {first: 1, last: 2, step: 1},
+	"zrangestore": {first: 1, last: 2, step: 1},
+	"bzpopmin":    {first: 1, last: -2, step: 1},
+	"bzpopmax":    {first: 1, last: -2, step: 1},
+
+	// Connection commands, whose arguments may be credentials
+	"auth":      noKeys,
+	"hello":     noKeys,
+	"ping":      noKeys,
+	"echo":      noKeys,
+	"select":    noKeys,
+	"client":    noKeys,
+	"reset":     noKeys,
+	"quit":      noKeys,
+	"readonly":  noKeys,
+	"readwrite": noKeys,
+	"asking":    noKeys,
+
+	// Keyspace scans, whose arguments are cursors and patterns
+	"scan":      noKeys,
+	"keys":      noKeys,
+	"randomkey": noKeys,
+
+	// Pub/sub, whose arguments are channels and patterns
+	"publish":      noKeys,
+	"spublish":     noKeys,
+	"subscribe":    noKeys,
+	"ssubscribe":   noKeys,
+	"psubscribe":   noKeys,
+	"unsubscribe":  noKeys,
+	"sunsubscribe": noKeys,
+	"punsubscribe": noKeys,
+	"pubsub":       noKeys,
+
+	// Transactions and server commands
+	"multi":    noKeys,
+	"exec":     noKeys,
+	"discard":  noKeys,
+	"unwatch":  noKeys,
+	"info":     noKeys,
+	"config":   noKeys,
+	"cluster":  noKeys,
+	"command":  noKeys,
+	"acl":      noKeys,
+	"script":   noKeys,
+	"function": noKeys,
+	"dbsize":   noKeys,
+	"time":     noKeys,
+	"flushdb":  noKeys,
+	"flushall": noKeys,
+	"wait":     noKeys,
+	"slowlog":  noKeys,
+	"latency":  noKeys,
+}
+
+// readCommands are the standard commands that only read their keys.
+// Commands missing from the set are counted as writes.
+var readCommands = map[string]bool{
+	// Strings and generic commands
+	"get": true, "mget": true, "strlen": true, "getrange": true, "substr": true, "lcs": true,
+	"getbit": true, "bitcount": true, "bitpos": true, "bitfield_ro": true,
+	"exists": true, "touch": true, "type": true, "ttl": true, "pttl": true,
+	"expiretime": true, "pexpiretime": true, "dump": true, "object": true, "memory": true,
+	"sort_ro": true, "pfcount": true,
+
+	// Hashes
+	"hget": true, "hmget": true, "hgetall": true, "hexists": true, "hlen": true, "hkeys": true,
+	"hvals": true, "hstrlen": true, "hrandfield": true, "hscan": true,
+	"httl": true, "hpttl": true, "hexpiretime": true, "hpexpiretime": true,
+
+	// Lists
+	"llen": true, "lrange": true, "lindex": true, "lpos": true,
+
+	// Sets
+	"smembers": true, "sismember": true, "smismember": true, "scard": true, "srandmember": true,
+	"sscan": true, "sdiff": true, "sinter": true, "sunion": true, "sintercard": true,
+
+	// Sorted sets
+	"zrange": true, "zrangebyscore": true, "zrangebylex": true, "zrevrange": true,
+	"zrevrangebyscore": true, "zrevrangebylex": true, "zrank": true, "zrevrank": true,
+	"zscore": true, "zmscore": true, "zcard": true, "zcount": true, "zlexcount": true,
+	"zrandmember": true, "zscan": true, "zunion": true, "zinter": true, "zdiff": true, "zintercard": true,
+
+	// Streams
+	"xrange": true, "xrevrange": true, "xlen": true, "xread": true, "xinfo": true, "xpending": true,
+
+	// Geospatial indexes
+	"geopos": true, "geodist": true, "geohash": true, "geosearch": true,
+	"georadius_ro": true, "georadiusbymember_ro": true,
+
+	// Read-only scripts and functions
+	"eval_ro": true, "evalsha_ro": true, "fcall_ro": true,
+}
+
+// commandKeys returns the keys accessed by a command from its arguments.
+// Multi-key commands such as MGET and DEL return every key, and script commands return their KEYS.
+// Keys are found by the key extractors, then by the key specs of standard commands,
+// and are otherwise assumed to be the first argument.
+func commandKeys(args []any) []string {
+	if len(args) < 2 {
+		return nil
+	}
+
+	name, _ := args[0].(string)
+	name = strings.ToLower(name)
+	if extract, ok := keyExtractors[name]; ok {
+		return extract(args)
+	}
+	if spec, ok := keySpecs[name]; ok {
+		return spec.keys(args)
+	}
+	return singleKey.keys(args)
+}
+
+// numKeys returns the key extractor of commands giving the number of keys at index,
+// followed by the keys.
+func numKeys(index int) func(args []any) []string {
+	return func(args []any) []string {
+		if index+1 >= len(args) {
+			return nil
+		}
+		n, ok := intArg(args[index])
+		if !ok || n <= 0 || index+1+n > len(args) {
+			return nil
+		}
+		return stringArgs(args[index+1:index+1+n], 1)
+	}
+}
+
+// storeKeys returns the keys of a command storing into its first argument the result of
+// numkeys source keys, such as ZUNIONSTORE.
+func storeKeys(args []any) []string {
+	sources := numKeys(2)(args)
+	if sources == nil {
+		return singleKey.keys(args)
+	}
+	return append(singleKey.keys(args), sources...)
+}
+
+// streamKeys returns the keys of a stream read, given after STREAMS followed by as many IDs.
+func streamKeys(args []any) []string {
+	for i, arg := range args {
+		if s, ok := arg.(string); ok && strings.EqualFold(s, "streams") {
+			rest := args[i+1:]
+			return stringArgs(rest[:len(rest)/2], 1)
+		}
+	}
+	return nil
+}
+
+// intArg returns the value of an integer argument given as an int or a string.
+func intArg(arg any) (int, bool) {
+	switch n := arg.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case string:
+		v, err := strconv.Atoi(n)
+		return v, err == nil
+	}
+	return 0, false
+}
+
+// stringArgs returns every step-th argument that is a string.
+func stringArgs(args []any, step int) []string {
+	keys := make([]string, 0, len(args)/step+1)
+	for i := 0; i < len(args); i += step {
+		if key, ok := args[i].(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
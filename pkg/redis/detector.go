@@ -0,0 +1,329 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mingrammer/keyflare"
+	"github.com/redis/go-redis/v9"
+)
+
+// Defaults of SharedDetectorOptions
+const (
+	DefaultSharedDetectorKey           = "keyflare:hotkeys"
+	DefaultSharedDetectorTopK          = 100
+	DefaultSharedDetectorFlushInterval = time.Second
+	DefaultSharedDetectorDecayFactor   = 0.5
+	DefaultSharedDetectorDecayInterval = time.Minute
+)
+
+// sharedTotalMember is the member of the total sorted set holding the total count
+const sharedTotalMember = "total"
+
+// SharedDetectorOptions contains configuration options for SharedDetector.
+type SharedDetectorOptions struct {
+	// Key is the sorted set holding the shared counts (default: "keyflare:hotkeys").
+	// The total count is kept in Key + ":total", and Key + ":decay" marks the last decay.
+	Key string
+
+	// TopK is the number of hottest keys fetched at each flush and considered hot (default: 100).
+	TopK int
+
+	// Capacity is the number of keys kept in the sorted set (default: 10 * TopK).
+	// The coldest keys are trimmed at each flush so the set doesn't grow with the key space.
+	Capacity int
+
+	// HotThreshold is the minimum shared count for a top-K key to be hot (0 means any top-K key).
+	HotThreshold uint64
+
+	// FlushInterval is how often local increments are sent to Redis (default: 1s).
+	FlushInterval time.Duration
+
+	// DecayFactor is the factor the shared counts are multiplied by every DecayInterval
+	// (default: 0.5), so keys that cooled down make room for new hot keys. 1 disables decay.
+	DecayFactor float64
+
+	// DecayInterval is how often the shared counts decay (default: 1m).
+	// The counts decay once per interval however many instances share them.
+	DecayInterval time.Duration
+
+	// OnError is called with the errors of background flushes, decays and resets
+	// (default: they're logged with the log package).
+	OnError func(err error)
+}
+
+// SharedDetector is a hot key detector whose counts are shared by every instance using
+// the same Redis key, so it detects the keys that are hot across a fleet rather than on
+// a single node. Pass it to keyflare.WithDetector.
+//
+// Increments are buffered locally and sent to a sorted set in one pipeline every
+// FlushInterval, which also fetches the shared top-K. Reads are served from that
+// snapshot, so requests never wait on Redis and counts lag by up to FlushInterval.
+// Keys outside the shared top-K report a count of 0.
+type SharedDetector struct {
+	client   redis.UniversalClient
+	options  SharedDetectorOptions
+	totalKey string
+	decayKey string
+
+	mu           sync.Mutex
+	pending      map[string]uint64
+	pendingTotal uint64
+	topK         []keyflare.KeyCount
+	counts       map[string]uint64
+	total        uint64
+
+	// flushMu serializes flushes, so increments are never sent twice
+	flushMu sync.Mutex
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSharedDetector creates a shared detector counting keys in Redis through client
+// and starts flushing increments in the background. Close stops it.
+func NewSharedDetector(client redis.UniversalClient, options SharedDetectorOptions) *SharedDetector {
+	if options.Key == "" {
+		options.Key = DefaultSharedDetectorKey
+	}
+	if options.TopK <= 0 {
+		options.TopK = DefaultSharedDetectorTopK
+	}
+	if options.Capacity <= 0 {
+		options.Capacity = 10 * options.TopK
+	}
+	options.Capacity = max(options.Capacity, options.TopK)
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = DefaultSharedDetectorFlushInterval
+	}
+	if options.DecayFactor <= 0 {
+		options.DecayFactor = DefaultSharedDetectorDecayFactor
+	}
+	options.DecayFactor = min(options.DecayFactor, 1)
+	if options.DecayInterval <= 0 {
+		options.DecayInterval = DefaultSharedDetectorDecayInterval
+	}
+	if options.OnError == nil {
+		options.OnError = func(err error) { log.Printf("Shared hot key detector: %v", err) }
+	}
+
+	d := &SharedDetector{
+		client:   client,
+		options:  options,
+		totalKey: options.Key + ":total",
+		decayKey: options.Key + ":decay",
+		pending:  make(map[string]uint64),
+		counts:   make(map[string]uint64),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go d.flushPeriodically()
+
+	return d
+}
+
+// flushPeriodically flushes the increments every FlushInterval and decays the counts
+// every DecayInterval until the detector is closed
+func (d *SharedDetector) flushPeriodically() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.options.FlushInterval)
+	defer ticker.Stop()
+
+	var decay <-chan time.Time
+	if d.options.DecayFactor < 1 {
+		decayTicker := time.NewTicker(d.options.DecayInterval)
+		defer decayTicker.Stop()
+		decay = decayTicker.C
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.Flush(context.Background()); err != nil {
+				d.options.OnError(err)
+			}
+		case <-decay:
+			if err := d.Decay(context.Background()); err != nil {
+				d.options.OnError(err)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// Increment buffers count accesses to key until the next flush.
+func (d *SharedDetector) Increment(ctx context.Context, key string, count uint64, op string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pending[key] += count
+	d.pendingTotal += count
+}
+
+// Flush sends the buffered increments to Redis, trims the coldest keys and refreshes
+// the local snapshot of the shared top-K. Increments that fail to be sent are kept
+// for the next flush.
+func (d *SharedDetector) Flush(ctx context.Context) error {
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+
+	d.mu.Lock()
+	pending, total := d.pending, d.pendingTotal
+	d.pending, d.pendingTotal = make(map[string]uint64), 0
+	d.mu.Unlock()
+
+	pipe := d.client.Pipeline()
+	incrCmds := make(map[string]*redis.FloatCmd, len(pending))
+	for key, count := range pending {
+		incrCmds[key] = pipe.ZIncrBy(ctx, d.options.Key, float64(count), key)
+	}
+	var totalIncrCmd *redis.FloatCmd
+	if total > 0 {
+		totalIncrCmd = pipe.ZIncrBy(ctx, d.totalKey, float64(total), sharedTotalMember)
+		pipe.ZRemRangeByRank(ctx, d.options.Key, 0, int64(-d.options.Capacity-1))
+	}
+	topCmd := pipe.ZRevRangeWithScores(ctx, d.options.Key, 0, int64(d.options.TopK-1))
+	totalCmd := pipe.ZScore(ctx, d.totalKey, sharedTotalMember)
+
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		d.restore(incrCmds, pending, totalIncrCmd, total)
+		return fmt.Errorf("failed to flush shared counts: %w", err)
+	}
+
+	topK := make([]keyflare.KeyCount, 0, len(topCmd.Val()))
+	counts := make(map[string]uint64, len(topCmd.Val()))
+	for _, z := range topCmd.Val() {
+		key, _ := z.Member.(string)
+		topK = append(topK, keyflare.KeyCount{Key: key, Count: uint64(z.Score)})
+		counts[key] = uint64(z.Score)
+	}
+	sharedTotal := uint64(totalCmd.Val())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.topK = topK
+	d.counts = counts
+	d.total = sharedTotal
+	return nil
+}
+
+// restore adds the increments whose commands failed back to the buffer
+// Increments whose commands succeeded were counted and aren't sent again
+func (d *SharedDetector) restore(incrCmds map[string]*redis.FloatCmd, pending map[string]uint64, totalIncrCmd *redis.FloatCmd, total uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, cmd := range incrCmds {
+		if cmd.Err() != nil {
+			d.pending[key] += pending[key]
+		}
+	}
+	if totalIncrCmd != nil && totalIncrCmd.Err() != nil {
+		d.pendingTotal += total
+	}
+}
+
+// Decay multiplies the shared counts by DecayFactor, unless another instance already
+// decayed them within the last DecayInterval.
+func (d *SharedDetector) Decay(ctx context.Context) error {
+	if d.options.DecayFactor >= 1 {
+		return nil
+	}
+
+	// The decay key elects a single instance to decay the counts each interval
+	elected, err := d.client.SetNX(ctx, d.decayKey, 1, d.options.DecayInterval).Result()
+	if err != nil {
+		return fmt.Errorf("failed to decay shared counts: %w", err)
+	}
+	if !elected {
+		return nil
+	}
+
+	// The keys are decayed separately since they may be in different cluster slots
+	weights := []float64{d.options.DecayFactor}
+	pipe := d.client.Pipeline()
+	pipe.ZUnionStore(ctx, d.options.Key, &redis.ZStore{Keys: []string{d.options.Key}, Weights: weights})
+	pipe.ZUnionStore(ctx, d.totalKey, &redis.ZStore{Keys: []string{d.totalKey}, Weights: weights})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to decay shared counts: %w", err)
+	}
+	return nil
+}
+
+// GetCount returns the shared count of key as of the last flush.
+func (d *SharedDetector) GetCount(key string) uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.counts[key]
+}
+
+// TopK returns the shared top-K keys as of the last flush.
+func (d *SharedDetector) TopK() []keyflare.KeyCount {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	topK := make([]keyflare.KeyCount, len(d.topK))
+	copy(topK, d.topK)
+	return topK
+}
+
+// IsHot returns whether key is in the shared top-K with a count of at least HotThreshold.
+func (d *SharedDetector) IsHot(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	count, ok := d.counts[key]
+	return ok && count >= d.options.HotThreshold
+}
+
+// TotalCount returns the shared total count as of the last flush.
+func (d *SharedDetector) TotalCount() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.total
+}
+
+// Reset clears the buffered increments and the shared counts, for every instance.
+func (d *SharedDetector) Reset() {
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+
+	// The keys are deleted separately since they may be in different cluster slots
+	ctx := context.Background()
+	pipe := d.client.Pipeline()
+	pipe.Del(ctx, d.options.Key)
+	pipe.Del(ctx, d.totalKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		d.options.OnError(fmt.Errorf("failed to reset shared counts: %w", err))
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	clear(d.pending)
+	d.pendingTotal = 0
+	d.topK = nil
+	clear(d.counts)
+	d.total = 0
+}
+
+// Close stops the background flushes and flushes the remaining increments.
+func (d *SharedDetector) Close() {
+	d.closeOnce.Do(func() {
+		close(d.stop)
+		<-d.done
+		if err := d.Flush(context.Background()); err != nil {
+			d.options.OnError(err)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/mingrammer/keyflare/internal"
+	"github.com/redis/go-redis/v9"
+)
+
+// InstallHook registers a hook on client that counts the keys of every command it processes,
+// including pipelined and transactional ones and commands the Wrapper doesn't wrap.
+// It's an alternative to Wrap that only detects hot keys: policies aren't applied,
+// since a hook can't serve commands from the local cache. Don't use both on the same
+// client, or every access is counted twice.
+// It uses the global KeyFlare instance which must be initialized and started first.
+// The instance is looked up on each command, so the hook keeps working across restarts.
+func InstallHook(client redis.UniversalClient) error {
+	if _, err := internal.GetInstance(); err != nil {
+		return fmt.Errorf("failed to get KeyFlare instance: %w. Call keyflare.New() and keyflare.Start() first", err)
+	}
+
+	client.AddHook(&hook{})
+	return nil
+}
+
+// hook is a go-redis hook counting the keys of processed commands.
+type hook struct{}
+
+// setupCommands are the commands go-redis sends to set up a connection, which aren't counted
+var setupCommands = map[string]bool{
+	"hello":     true,
+	"auth":      true,
+	"client":    true,
+	"select":    true,
+	"readonly":  true,
+	"readwrite": true,
+}
+
+// DialHook implements redis.Hook.
+func (h *hook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+// ProcessHook implements redis.Hook.
+func (h *hook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.count(ctx, cmd)
+		return next(ctx, cmd)
+	}
+}
+
+// ProcessPipelineHook implements redis.Hook.
+func (h *hook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			h.count(ctx, cmd)
+		}
+		return next(ctx, cmds)
+	}
+}
+
+// count increments the counters of the keys accessed by cmd.
+func (h *hook) count(ctx context.Context, cmd redis.Cmder) {
+	name := strings.ToLower(cmd.Name())
+	if setupCommands[name] {
+		return
+	}
+	kf, err := internal.GetInstance()
+	if err != nil {
+		return
+	}
+
+	op := commandOperation(name)
+	for _, key := range commandKeys(cmd.Args()) {
+		if key = kf.NormalizeKey(key); kf.Countable(key) {
+			kf.Detector().IncrementOp(ctx, key, 1, op)
+		}
+	}
+}
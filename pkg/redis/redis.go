@@ -3,18 +3,25 @@ package redis
 
 import (
 	"context"
+	"encoding"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/mingrammer/keyflare/internal"
+	"github.com/mingrammer/keyflare/internal/detector"
 	"github.com/mingrammer/keyflare/internal/policy"
 	"github.com/redis/go-redis/v9"
 )
 
 // Wrapper wraps a go-redis client with KeyFlare hot key detection.
 type Wrapper struct {
-	client *redis.ClusterClient
-	kf     *internal.KeyFlare
+	client   *redis.ClusterClient
+	kf       *internal.KeyFlare
+	disabled atomic.Bool
 }
 
 // Wrap creates a new Redis client wrapper with the provided client.
@@ -36,48 +43,124 @@ func (w *Wrapper) Client() *redis.ClusterClient {
 	return w.client
 }
 
+// SetEnabled enables or disables KeyFlare processing on the wrapper.
+// While disabled, keys aren't counted and policies aren't applied, so commands
+// pass straight through to the client. Other wrappers are unaffected.
+func (w *Wrapper) SetEnabled(enabled bool) {
+	w.disabled.Store(!enabled)
+}
+
+// Enabled returns whether KeyFlare processing is enabled on the wrapper.
+func (w *Wrapper) Enabled() bool {
+	return !w.disabled.Load()
+}
+
 // incrementKey increments the key counter in the detector.
-func (w *Wrapper) incrementKey(key string) {
-	w.kf.Detector().Increment(key, 1)
+func (w *Wrapper) incrementKey(ctx context.Context, key string, op detector.Operation) {
+	if w.disabled.Load() {
+		return
+	}
+	if key = w.kf.NormalizeKey(key); w.kf.Countable(key) {
+		w.kf.Detector().IncrementOp(ctx, key, 1, op)
+	}
+}
+
+// Observe records an access to key by a command the wrapper doesn't wrap,
+// such as a Lua script or a module command, so that it's counted by the detector.
+// op is "read" or "write"; other values are counted without a read/write breakdown.
+// It returns whether the key is hot, so callers can branch on it.
+func (w *Wrapper) Observe(key string, op string) bool {
+	w.incrementKey(context.Background(), key, detector.ParseOperation(op))
+	return w.kf.Detector().IsHot(w.kf.NormalizeKey(key))
+}
+
+// KeyStatus returns how hot key currently is: its rank among the top K keys (1 for the
+// hottest, 0 if it isn't one of them), its estimated count and whether it's hot, so
+// application code can vary behavior by rank, e.g. only handle the top 3 keys specially.
+func (w *Wrapper) KeyStatus(key string) (rank int, count uint64, hot bool) {
+	return w.kf.Detector().KeyStatus(w.kf.NormalizeKey(key))
+}
+
+// incrementMembers increments the key counter and, with member granularity,
+// the counters of the "key:member" composites.
+func (w *Wrapper) incrementMembers(ctx context.Context, key string, op detector.Operation, members ...any) {
+	w.incrementKey(ctx, key, op)
+	for _, member := range members {
+		if memberKey, ok := w.kf.MemberKey(key, toString(member)); ok {
+			w.incrementKey(ctx, memberKey, op)
+		}
+	}
 }
 
 // applyPolicyIfHot applies the policy if the key is hot.
-func (w *Wrapper) applyPolicyIfHot(key string, operation string, value any) (any, error) {
-	if w.kf.Detector().IsHot(key) {
-		p := w.kf.PolicyManager().GetPolicy(key)
-		if p != nil {
+// The set request is applied for set operations.
+// Unless FailClosed is set, a policy error is logged and reported as no policy result.
+// While the policy circuit breaker is open, the policy is bypassed.
+func (w *Wrapper) applyPolicyIfHot(ctx context.Context, key string, operation string, set policy.SetRequest) (data any, err error) {
+	if w.disabled.Load() {
+		return nil, nil
+	}
+	key = w.kf.NormalizeKey(key)
+	var hot, applied bool
+	if w.kf.Debug() {
+		defer func() { w.kf.RecordDecision(internal.NewDecision(key, operation, hot, applied, data)) }()
+	}
+
+	// Keys without a policy are skipped before the costlier hot check
+	p := w.kf.PolicyManager().GetPolicy(key)
+	if p != nil {
+		if hot = w.kf.Detector().IsHot(key); hot {
 			var requestData any
 			switch operation {
 			case "get":
-				requestData = policy.GetRequest{}
+				requestData = policy.GetRequest{RoutingKey: policy.RoutingKey(ctx)}
 			case "set":
-				requestData = policy.SetRequest{Value: value}
+				requestData = set
 			default:
 				return nil, nil
 			}
 
-			ctx := policy.Context{
-				Key:  key,
-				Data: requestData,
+			// Bypass the policy while the breaker is open after repeated errors
+			if !w.kf.Breaker().Allow() {
+				return nil, nil
 			}
-			result := p.Apply(ctx)
+			result := p.Apply(policy.Context{
+				Key:   key,
+				Data:  requestData,
+				Count: w.kf.Detector().GetCount(key),
+			})
+			w.kf.Breaker().Record(result.Error)
+			applied = true
 			if result.Error != nil {
-				return nil, fmt.Errorf("failed to apply policy for key %s: %w", key, result.Error)
+				if w.kf.FailClosed() {
+					return nil, fmt.Errorf("failed to apply policy for key %s: %w", key, result.Error)
+				}
+				// Fall back to the plain backend call
+				log.Printf("Failed to apply policy for key %s, falling back to Redis: %v", key, result.Error)
+				return nil, nil
 			}
 			return result.Data, nil
 		}
 	}
 
+	// Keys without a policy skip the hot check, so it's only made for the trace
+	if p == nil && w.kf.Debug() {
+		hot = w.kf.Detector().IsHot(key)
+	}
 	return nil, nil
 }
 
 // Get wraps redis.Client.Get.
 func (w *Wrapper) Get(ctx context.Context, key string) *redis.StringCmd {
+	start := time.Now()
+	var local bool
+	defer func() { w.kf.Metrics().RecordOperationDuration("get", local, time.Since(start)) }()
+
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpRead)
 
 	// Try to apply policy if hot
-	policyResult, err := w.applyPolicyIfHot(key, "get", nil)
+	policyResult, err := w.applyPolicyIfHot(ctx, key, "get", policy.SetRequest{})
 	if policyResult == nil && err == nil {
 		return w.client.Get(ctx, key)
 	}
@@ -91,20 +174,30 @@ func (w *Wrapper) Get(ctx context.Context, key string) *redis.StringCmd {
 	// Handle different policy types
 	switch result := policyResult.(type) {
 	case policy.CacheHit:
-		// Local cache hit
-		cmd := redis.NewStringCmd(ctx, "get", key)
-		cmd.SetVal(result.Value.(string))
-		return cmd
+		// Local cache hit, refreshed ahead of its expiration to avoid a miss storm
+		if result.ShouldRefresh {
+			w.refreshLocalCache(key)
+		}
+		if value, ok := cachedString(result.Value); ok {
+			local = true
+			cmd := redis.NewStringCmd(ctx, "get", key)
+			cmd.SetVal(value)
+			return cmd
+		}
+		return w.client.Get(ctx, key)
 	case policy.KeySplittingGetAction:
 		// Look-aside key splitting: try shard first, fallback to original
-		return w.handleLookAsideGet(ctx, result)
+		return w.handleLookAsideGet(ctx, key, result)
 	case policy.CacheMiss:
 		// Cache miss, get from Redis and async set to cache
 		redisResult := w.client.Get(ctx, key)
 		fmt.Printf("Cache miss for key %s, fetching from Redis. %v\n", key, redisResult)
 		if redisResult.Err() == nil {
 			// Data found in Redis, asynchronously cache it
-			go w.asyncSetLocalCache(key, redisResult.Val())
+			value := redisResult.Val()
+			w.kf.PopulateAsync(w.kf.NormalizeKey(key), func() {
+				w.asyncSetLocalCache(key, value)
+			})
 		}
 		return redisResult
 	}
@@ -112,56 +205,114 @@ func (w *Wrapper) Get(ctx context.Context, key string) *redis.StringCmd {
 }
 
 // Set wraps redis.Client.Set.
+// Hot keys are written to Redis first and then written through to the policy,
+// so the local cache or shards only ever hold values that were written to Redis.
 func (w *Wrapper) Set(ctx context.Context, key string, value any, expiration time.Duration) *redis.StatusCmd {
+	start := time.Now()
+	defer func() { w.kf.Metrics().RecordOperationDuration("set", false, time.Since(start)) }()
+
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
-	// Try to apply policy if hot
-	if policyResult, err := w.applyPolicyIfHot(key, "set", value); err != nil || policyResult != nil {
-		if err != nil {
-			cmd := redis.NewStatusCmd(ctx, "set", key, value)
-			cmd.SetErr(err)
-			return cmd
-		}
+	cmd := w.client.Set(ctx, key, value, expiration)
+	if cmd.Err() != nil {
+		return cmd
+	}
 
-		// Handle different policy types
-		switch result := policyResult.(type) {
-		case policy.KeySplittingSetAction:
-			// Multi-write to shards
-			return w.handleKeySplittingSet(ctx, result, expiration)
+	// Try to apply policy if hot
+	set := policy.SetRequest{Value: policyValue(value)}
+	if w.kf.CapToBackendTTL() {
+		set.MaxTTL = w.writtenTTL(ctx, key, expiration)
+	}
+	policyResult, err := w.applyPolicyIfHot(ctx, key, "set", set)
+	if err != nil {
+		// The write succeeded, so a failure to apply the policy doesn't fail it
+		log.Printf("Failed to apply policy for key %s after setting it: %v", key, err)
+		return cmd
+	}
 
-		case policy.CacheSet:
-			// Local cache set, continue to Redis
-			break
-		}
+	// Handle different policy types
+	switch result := policyResult.(type) {
+	case policy.KeySplittingSetAction:
+		// Asynchronously write to all target shards, even after the request is canceled
+		superseded := w.kf.StartReplication(result.OriginalKey)
+		go w.replicateToShards(context.WithoutCancel(ctx), result.ShardKeys, result.Value, expiration, result.TTLJitter, time.Time{}, result.Retry, superseded)
+	case policy.CacheSet:
+		// The written value is now in the local cache
+		break
 	}
 
-	return w.client.Set(ctx, key, value, expiration)
+	return cmd
+}
+
+// GetDel wraps redis.Client.GetDel.
+// It's never served from the local cache since it deletes the key, and it evicts the key from the local cache.
+func (w *Wrapper) GetDel(ctx context.Context, key string) *redis.StringCmd {
+	// Increment key counter
+	w.incrementKey(ctx, key, detector.OpWrite)
+	defer w.invalidateLocalCache(ctx, key)
+
+	return w.client.GetDel(ctx, key)
 }
 
 // GetSet wraps redis.Client.GetSet.
 func (w *Wrapper) GetSet(ctx context.Context, key string, value any) *redis.StringCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.GetSet(ctx, key, value)
 }
 
 // Del wraps redis.Client.Del.
+// It evicts the keys from the local cache.
 func (w *Wrapper) Del(ctx context.Context, keys ...string) *redis.IntCmd {
 	// Increment key counters
 	for _, key := range keys {
-		w.incrementKey(key)
+		w.incrementKey(ctx, key, detector.OpWrite)
+	}
+
+	cmd := w.client.Del(ctx, keys...)
+	for _, key := range keys {
+		w.invalidateLocalCache(ctx, key)
+	}
+	return cmd
+}
+
+// Unlink wraps redis.Client.Unlink.
+// It's the non-blocking alternative to Del for large hot keys, and it evicts the keys from the local cache.
+func (w *Wrapper) Unlink(ctx context.Context, keys ...string) *redis.IntCmd {
+	// Increment key counters
+	for _, key := range keys {
+		w.incrementKey(ctx, key, detector.OpWrite)
+	}
+
+	cmd := w.client.Unlink(ctx, keys...)
+	for _, key := range keys {
+		w.invalidateLocalCache(ctx, key)
 	}
+	return cmd
+}
 
-	return w.client.Del(ctx, keys...)
+// Copy wraps redis.Client.Copy.
+// The source key is counted as a read and the destination key as a write, and the
+// destination is evicted from the local cache since its value may be replaced.
+func (w *Wrapper) Copy(ctx context.Context, sourceKey, destKey string, db int, replace bool) *redis.IntCmd {
+	// Increment key counters
+	w.incrementKey(ctx, sourceKey, detector.OpRead)
+	w.incrementKey(ctx, destKey, detector.OpWrite)
+
+	cmd := w.client.Copy(ctx, sourceKey, destKey, db, replace)
+	if cmd.Val() > 0 {
+		w.invalidateLocalCache(ctx, destKey)
+	}
+	return cmd
 }
 
 // MGet wraps redis.Client.MGet.
 func (w *Wrapper) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
 	// Increment key counters
 	for _, key := range keys {
-		w.incrementKey(key)
+		w.incrementKey(ctx, key, detector.OpRead)
 	}
 
 	return w.client.MGet(ctx, keys...)
@@ -172,7 +323,7 @@ func (w *Wrapper) MSet(ctx context.Context, values ...any) *redis.StatusCmd {
 	// Increment key counters
 	for i := 0; i < len(values); i += 2 {
 		if key, ok := values[i].(string); ok {
-			w.incrementKey(key)
+			w.incrementKey(ctx, key, detector.OpWrite)
 		}
 	}
 
@@ -182,7 +333,7 @@ func (w *Wrapper) MSet(ctx context.Context, values ...any) *redis.StatusCmd {
 // Incr wraps redis.Client.Incr.
 func (w *Wrapper) Incr(ctx context.Context, key string) *redis.IntCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.Incr(ctx, key)
 }
@@ -190,7 +341,7 @@ func (w *Wrapper) Incr(ctx context.Context, key string) *redis.IntCmd {
 // IncrBy wraps redis.Client.IncrBy.
 func (w *Wrapper) IncrBy(ctx context.Context, key string, value int64) *redis.IntCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.IncrBy(ctx, key, value)
 }
@@ -198,7 +349,7 @@ func (w *Wrapper) IncrBy(ctx context.Context, key string, value int64) *redis.In
 // Decr wraps redis.Client.Decr.
 func (w *Wrapper) Decr(ctx context.Context, key string) *redis.IntCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.Decr(ctx, key)
 }
@@ -206,7 +357,7 @@ func (w *Wrapper) Decr(ctx context.Context, key string) *redis.IntCmd {
 // DecrBy wraps redis.Client.DecrBy.
 func (w *Wrapper) DecrBy(ctx context.Context, key string, value int64) *redis.IntCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.DecrBy(ctx, key, value)
 }
@@ -215,7 +366,7 @@ func (w *Wrapper) DecrBy(ctx context.Context, key string, value int64) *redis.In
 func (w *Wrapper) Exists(ctx context.Context, keys ...string) *redis.IntCmd {
 	// Increment key counters
 	for _, key := range keys {
-		w.incrementKey(key)
+		w.incrementKey(ctx, key, detector.OpRead)
 	}
 
 	return w.client.Exists(ctx, keys...)
@@ -224,7 +375,7 @@ func (w *Wrapper) Exists(ctx context.Context, keys ...string) *redis.IntCmd {
 // Expire wraps redis.Client.Expire.
 func (w *Wrapper) Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.Expire(ctx, key, expiration)
 }
@@ -232,7 +383,7 @@ func (w *Wrapper) Expire(ctx context.Context, key string, expiration time.Durati
 // TTL wraps redis.Client.TTL.
 func (w *Wrapper) TTL(ctx context.Context, key string) *redis.DurationCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpRead)
 
 	return w.client.TTL(ctx, key)
 }
@@ -240,7 +391,7 @@ func (w *Wrapper) TTL(ctx context.Context, key string) *redis.DurationCmd {
 // HSet wraps redis.Client.HSet.
 func (w *Wrapper) HSet(ctx context.Context, key string, values ...any) *redis.IntCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.HSet(ctx, key, values...)
 }
@@ -248,7 +399,7 @@ func (w *Wrapper) HSet(ctx context.Context, key string, values ...any) *redis.In
 // HGet wraps redis.Client.HGet.
 func (w *Wrapper) HGet(ctx context.Context, key, field string) *redis.StringCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpRead)
 
 	return w.client.HGet(ctx, key, field)
 }
@@ -256,7 +407,7 @@ func (w *Wrapper) HGet(ctx context.Context, key, field string) *redis.StringCmd
 // HGetAll wraps redis.Client.HGetAll.
 func (w *Wrapper) HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpRead)
 
 	return w.client.HGetAll(ctx, key)
 }
@@ -264,7 +415,7 @@ func (w *Wrapper) HGetAll(ctx context.Context, key string) *redis.MapStringStrin
 // HMGet wraps redis.Client.HMGet.
 func (w *Wrapper) HMGet(ctx context.Context, key string, fields ...string) *redis.SliceCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpRead)
 
 	return w.client.HMGet(ctx, key, fields...)
 }
@@ -272,7 +423,7 @@ func (w *Wrapper) HMGet(ctx context.Context, key string, fields ...string) *redi
 // HMSet wraps redis.Client.HMSet.
 func (w *Wrapper) HMSet(ctx context.Context, key string, values ...any) *redis.BoolCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.HMSet(ctx, key, values...)
 }
@@ -280,7 +431,7 @@ func (w *Wrapper) HMSet(ctx context.Context, key string, values ...any) *redis.B
 // HDel wraps redis.Client.HDel.
 func (w *Wrapper) HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.HDel(ctx, key, fields...)
 }
@@ -288,7 +439,7 @@ func (w *Wrapper) HDel(ctx context.Context, key string, fields ...string) *redis
 // LPush wraps redis.Client.LPush.
 func (w *Wrapper) LPush(ctx context.Context, key string, values ...any) *redis.IntCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.LPush(ctx, key, values...)
 }
@@ -296,7 +447,7 @@ func (w *Wrapper) LPush(ctx context.Context, key string, values ...any) *redis.I
 // RPush wraps redis.Client.RPush.
 func (w *Wrapper) RPush(ctx context.Context, key string, values ...any) *redis.IntCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.RPush(ctx, key, values...)
 }
@@ -304,7 +455,7 @@ func (w *Wrapper) RPush(ctx context.Context, key string, values ...any) *redis.I
 // LPop wraps redis.Client.LPop.
 func (w *Wrapper) LPop(ctx context.Context, key string) *redis.StringCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.LPop(ctx, key)
 }
@@ -312,7 +463,7 @@ func (w *Wrapper) LPop(ctx context.Context, key string) *redis.StringCmd {
 // RPop wraps redis.Client.RPop.
 func (w *Wrapper) RPop(ctx context.Context, key string) *redis.StringCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.RPop(ctx, key)
 }
@@ -320,7 +471,7 @@ func (w *Wrapper) RPop(ctx context.Context, key string) *redis.StringCmd {
 // LLen wraps redis.Client.LLen.
 func (w *Wrapper) LLen(ctx context.Context, key string) *redis.IntCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpRead)
 
 	return w.client.LLen(ctx, key)
 }
@@ -328,7 +479,7 @@ func (w *Wrapper) LLen(ctx context.Context, key string) *redis.IntCmd {
 // LRange wraps redis.Client.LRange.
 func (w *Wrapper) LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpRead)
 
 	return w.client.LRange(ctx, key, start, stop)
 }
@@ -336,7 +487,7 @@ func (w *Wrapper) LRange(ctx context.Context, key string, start, stop int64) *re
 // SAdd wraps redis.Client.SAdd.
 func (w *Wrapper) SAdd(ctx context.Context, key string, members ...any) *redis.IntCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.SAdd(ctx, key, members...)
 }
@@ -344,7 +495,7 @@ func (w *Wrapper) SAdd(ctx context.Context, key string, members ...any) *redis.I
 // SMembers wraps redis.Client.SMembers.
 func (w *Wrapper) SMembers(ctx context.Context, key string) *redis.StringSliceCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpRead)
 
 	return w.client.SMembers(ctx, key)
 }
@@ -352,15 +503,19 @@ func (w *Wrapper) SMembers(ctx context.Context, key string) *redis.StringSliceCm
 // SRem wraps redis.Client.SRem.
 func (w *Wrapper) SRem(ctx context.Context, key string, members ...any) *redis.IntCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpWrite)
 
 	return w.client.SRem(ctx, key, members...)
 }
 
 // ZAdd wraps redis.Client.ZAdd.
 func (w *Wrapper) ZAdd(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd {
-	// Increment key counter
-	w.incrementKey(key)
+	// Increment key and member counters
+	names := make([]any, len(members))
+	for i, z := range members {
+		names[i] = z.Member
+	}
+	w.incrementMembers(ctx, key, detector.OpWrite, names...)
 
 	return w.client.ZAdd(ctx, key, members...)
 }
@@ -368,7 +523,7 @@ func (w *Wrapper) ZAdd(ctx context.Context, key string, members ...redis.Z) *red
 // ZRange wraps redis.Client.ZRange.
 func (w *Wrapper) ZRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpRead)
 
 	return w.client.ZRange(ctx, key, start, stop)
 }
@@ -376,48 +531,171 @@ func (w *Wrapper) ZRange(ctx context.Context, key string, start, stop int64) *re
 // ZRangeWithScores wraps redis.Client.ZRangeWithScores.
 func (w *Wrapper) ZRangeWithScores(ctx context.Context, key string, start, stop int64) *redis.ZSliceCmd {
 	// Increment key counter
-	w.incrementKey(key)
+	w.incrementKey(ctx, key, detector.OpRead)
 
 	return w.client.ZRangeWithScores(ctx, key, start, stop)
 }
 
 // ZRank wraps redis.Client.ZRank.
 func (w *Wrapper) ZRank(ctx context.Context, key, member string) *redis.IntCmd {
-	// Increment key counter
-	w.incrementKey(key)
+	// Increment key and member counters
+	w.incrementMembers(ctx, key, detector.OpRead, member)
 
 	return w.client.ZRank(ctx, key, member)
 }
 
 // ZRem wraps redis.Client.ZRem.
 func (w *Wrapper) ZRem(ctx context.Context, key string, members ...any) *redis.IntCmd {
-	// Increment key counter
-	w.incrementKey(key)
+	// Increment key and member counters
+	w.incrementMembers(ctx, key, detector.OpWrite, members...)
 
 	return w.client.ZRem(ctx, key, members...)
 }
 
 // ZScore wraps redis.Client.ZScore.
 func (w *Wrapper) ZScore(ctx context.Context, key, member string) *redis.FloatCmd {
-	// Increment key counter
-	w.incrementKey(key)
+	// Increment key and member counters
+	w.incrementMembers(ctx, key, detector.OpRead, member)
 
 	return w.client.ZScore(ctx, key, member)
 }
 
+// ZIncrBy wraps redis.Client.ZIncrBy.
+func (w *Wrapper) ZIncrBy(ctx context.Context, key string, increment float64, member string) *redis.FloatCmd {
+	// Increment key and member counters
+	w.incrementMembers(ctx, key, detector.OpWrite, member)
+
+	return w.client.ZIncrBy(ctx, key, increment, member)
+}
+
+// Eval wraps redis.Client.Eval.
+// Every key in keys (KEYS in the script) is counted, as a write since scripts may modify them.
+func (w *Wrapper) Eval(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd {
+	// Increment key counters
+	for _, key := range keys {
+		w.incrementKey(ctx, key, detector.OpWrite)
+	}
+
+	return w.client.Eval(ctx, script, keys, args...)
+}
+
+// EvalSha wraps redis.Client.EvalSha.
+// Every key in keys (KEYS in the script) is counted, as a write since scripts may modify them.
+func (w *Wrapper) EvalSha(ctx context.Context, sha1 string, keys []string, args ...any) *redis.Cmd {
+	// Increment key counters
+	for _, key := range keys {
+		w.incrementKey(ctx, key, detector.OpWrite)
+	}
+
+	return w.client.EvalSha(ctx, sha1, keys, args...)
+}
+
+// Do wraps redis.Client.Do for custom commands.
+// The keys are extracted from the arguments, so commands the wrapper doesn't wrap are counted too.
+// Policies are not applied to the result, and the keys of write commands are evicted from the local cache.
+func (w *Wrapper) Do(ctx context.Context, args ...any) *redis.Cmd {
+	if len(args) == 0 {
+		return w.client.Do(ctx, args...)
+	}
+
+	// Increment key counters
+	name, _ := args[0].(string)
+	op := commandOperation(name)
+	keys := commandKeys(args)
+	for _, key := range keys {
+		w.incrementKey(ctx, key, op)
+	}
+
+	cmd := w.client.Do(ctx, args...)
+	if op == detector.OpWrite {
+		for _, key := range keys {
+			w.invalidateLocalCache(ctx, key)
+		}
+	}
+	return cmd
+}
+
 // Ping wraps redis.Client.Ping.
 func (w *Wrapper) Ping(ctx context.Context) *redis.StatusCmd {
 	return w.client.Ping(ctx)
 }
 
+// HealthCheck verifies that KeyFlare is running and the Redis cluster is reachable.
+// It returns a combined error describing every failed check.
+func (w *Wrapper) HealthCheck(ctx context.Context) error {
+	var errs []error
+	if _, err := internal.GetInstance(); err != nil {
+		errs = append(errs, fmt.Errorf("KeyFlare is unhealthy: %w", err))
+	}
+	if err := w.client.Ping(ctx).Err(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to ping Redis: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// Warmup fetches the keys from Redis and populates the local cache with them,
+// so the first requests for keys known to be hot are served locally.
+// Keys without a policy or missing from Redis are skipped, and the fetches aren't counted as accesses.
+// It returns a combined error describing every failed fetch.
+func (w *Wrapper) Warmup(ctx context.Context, keys ...string) error {
+	var errs []error
+	for _, key := range keys {
+		if w.kf.PolicyManager().GetPolicy(w.kf.NormalizeKey(key)) == nil {
+			continue
+		}
+
+		value, err := w.client.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to fetch key %s: %w", key, err))
+			continue
+		}
+		w.asyncSetLocalCache(key, value)
+	}
+	return errors.Join(errs...)
+}
+
 // Pipeline wraps redis.Client.Pipeline.
+// Keys of the queued commands are counted when the pipeline is executed.
 func (w *Wrapper) Pipeline() redis.Pipeliner {
-	return w.client.Pipeline()
+	return &pipeliner{Pipeliner: w.client.Pipeline(), w: w}
 }
 
 // TxPipeline wraps redis.Client.TxPipeline.
+// Keys of the queued commands are counted when the pipeline is executed.
 func (w *Wrapper) TxPipeline() redis.Pipeliner {
-	return w.client.TxPipeline()
+	return &pipeliner{Pipeliner: w.client.TxPipeline(), w: w}
+}
+
+// pipeliner wraps redis.Pipeliner to count the keys of executed commands.
+type pipeliner struct {
+	redis.Pipeliner
+	w *Wrapper
+}
+
+// Exec wraps redis.Pipeliner.Exec.
+func (p *pipeliner) Exec(ctx context.Context) ([]redis.Cmder, error) {
+	cmds, err := p.Pipeliner.Exec(ctx)
+
+	// Increment key counters
+	for _, cmd := range cmds {
+		op := commandOperation(cmd.Name())
+		for _, key := range commandKeys(cmd.Args()) {
+			p.w.incrementKey(ctx, key, op)
+		}
+	}
+
+	return cmds, err
+}
+
+// commandOperation returns whether a command reads or writes its keys.
+func commandOperation(name string) detector.Operation {
+	if readCommands[strings.ToLower(name)] {
+		return detector.OpRead
+	}
+	return detector.OpWrite
 }
 
 // Subscribe wraps redis.Client.Subscribe.
@@ -434,69 +712,221 @@ func (w *Wrapper) Publish(ctx context.Context, channel string, message any) *red
 func (w *Wrapper) asyncSetLocalCache(key, value string) {
 	// Get policy manager and try to cache regardless of hot key status
 	// This ensures cache miss data gets cached for future hits
-	p := w.kf.PolicyManager().GetPolicy(key)
+	normalized := w.kf.NormalizeKey(key)
+	p := w.kf.PolicyManager().GetPolicy(normalized)
 	if p != nil {
+		req := policy.SetRequest{Value: value}
+		if w.kf.CapToBackendTTL() {
+			ttl, ok := w.backendTTL(context.Background(), key)
+			if !ok {
+				return
+			}
+			req.MaxTTL = ttl
+		}
+
 		ctx := policy.Context{
-			Key:  key,
-			Data: policy.SetRequest{Value: value},
+			Key:  normalized,
+			Data: req,
 		}
 		result := p.Apply(ctx)
 		_ = result // Cache set operation completed
 	}
 }
 
-// handleKeySplittingSet implements multi-write for key splitting
-func (w *Wrapper) handleKeySplittingSet(
-	ctx context.Context, action policy.KeySplittingSetAction, ttl time.Duration,
-) *redis.StatusCmd {
-	// Write to original key
-	originalCmd := w.client.Set(ctx, action.OriginalKey, action.Value, ttl)
-	if originalCmd.Err() != nil {
-		return originalCmd
+// backendTTL returns the remaining TTL in seconds of the key in Redis, or 0 if it doesn't expire.
+// It returns false if the key no longer exists or its TTL can't be read, so it isn't cached.
+func (w *Wrapper) backendTTL(ctx context.Context, key string) (float64, bool) {
+	ttl, err := w.client.PTTL(ctx, key).Result()
+	switch {
+	case err != nil || ttl == -2:
+		return 0, false
+	case ttl < 0:
+		return 0, true
+	default:
+		return ttl.Seconds(), true
 	}
+}
 
-	// Asynchronously write to all target shards
-	go w.replicateToShards(ctx, action.ShardKeys, action.Value, ttl)
+// writtenTTL returns the TTL in seconds of a key written with the expiration, or 0 if it doesn't expire.
+func (w *Wrapper) writtenTTL(ctx context.Context, key string, expiration time.Duration) float64 {
+	switch {
+	case expiration == redis.KeepTTL:
+		ttl, _ := w.backendTTL(ctx, key)
+		return ttl
+	case expiration > 0:
+		return expiration.Seconds()
+	default:
+		return 0
+	}
+}
 
-	// Return success from original write
-	return originalCmd
+// refreshLocalCache asynchronously fetches the key from Redis and repopulates the local cache
+// Concurrent refreshes of the same key are coalesced into a single fetch
+func (w *Wrapper) refreshLocalCache(key string) {
+	w.kf.PopulateAsync(w.kf.NormalizeKey(key), func() {
+		result := w.client.Get(context.Background(), key)
+		if result.Err() == nil {
+			w.asyncSetLocalCache(key, result.Val())
+		}
+	})
 }
 
-// replicateToShards writes to shard keys asynchronously
+// invalidateLocalCache evicts the key from the local cache, if any, or deletes
+// the shards of a split key, after it was deleted or changed in Redis
+// Reads of deleted shards fall back to the original key until the next Set
+func (w *Wrapper) invalidateLocalCache(ctx context.Context, key string) {
+	key = w.kf.NormalizeKey(key)
+	p := w.kf.PolicyManager().GetPolicy(key)
+	if p == nil {
+		return
+	}
+
+	result := p.Apply(policy.Context{
+		Key:  key,
+		Data: policy.DeleteRequest{},
+	})
+	if action, ok := result.Data.(policy.KeySplittingDeleteAction); ok && len(action.ShardKeys) > 0 {
+		// The shards are deleted separately since they may be in different cluster slots
+		pipe := w.client.Pipeline()
+		for _, shardKey := range action.ShardKeys {
+			pipe.Del(ctx, shardKey)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			w.kf.Metrics().RecordReplicationError()
+		}
+	}
+}
+
+// replicateToShards writes to shard keys asynchronously.
+// Each shard's TTL is jittered so the shards don't all expire at once,
+// and failed shard writes are retried with backoff before giving up.
+// Writes are dropped once superseded reports that a newer value is being replicated.
+// If expiresAt isn't zero, shard TTLs are capped so the shards don't outlive it.
 func (w *Wrapper) replicateToShards(
-	ctx context.Context, shardKeys []string, value any, ttl time.Duration,
+	ctx context.Context, shardKeys []string, value any, ttl time.Duration, jitter float64, expiresAt time.Time,
+	retry policy.RetryConfig, superseded func() bool,
 ) {
 	// Write to all shards
 	for _, shardKey := range shardKeys {
-		w.client.Set(ctx, shardKey, value, ttl)
+		jittered := policy.JitterTTL(ttl, jitter)
+		err := retry.Do(ctx, func() error {
+			if superseded() {
+				return nil
+			}
+			ttl := jittered
+			if !expiresAt.IsZero() {
+				// The ceiling is taken at each attempt, so retries don't extend the shards
+				remaining := time.Until(expiresAt)
+				if remaining <= 0 {
+					// The original key has expired, there's nothing left to replicate
+					return nil
+				}
+				ttl = min(ttl, remaining)
+			}
+			return w.client.Set(ctx, shardKey, value, ttl).Err()
+		})
+		if err != nil {
+			// Reads of the missing shard fall back to the original key
+			w.kf.Metrics().RecordReplicationError()
+		}
 	}
 }
 
 // handleLookAsideGet implements look-aside pattern for key splitting
 func (w *Wrapper) handleLookAsideGet(
-	ctx context.Context, action policy.KeySplittingGetAction,
+	ctx context.Context, key string, action policy.KeySplittingGetAction,
 ) *redis.StringCmd {
 	// Step 1: Try to read from primary shard
+	w.kf.Detector().IncrementShard(action.OriginalKey, action.ShardIndex)
 	shardResult := w.client.Get(ctx, action.RandShardKey)
 	if shardResult.Err() == nil {
 		// Shard data exists, return it
 		return shardResult
 	}
 
+	// A shard that failed (rather than missed) may be on an unreachable node,
+	// so try another shard before reading the original key
+	if !errors.Is(shardResult.Err(), redis.Nil) && len(action.ShardKeys) > 1 {
+		next := (action.ShardIndex + 1) % len(action.ShardKeys)
+		w.kf.Detector().IncrementShard(action.OriginalKey, next)
+		if other := w.client.Get(ctx, action.ShardKeys[next]); other.Err() == nil {
+			return other
+		}
+	}
+
 	// Step 2: Shard doesn't exist, try original key
-	original := w.client.Get(ctx, action.OriginalKey)
+	original := w.client.Get(ctx, key)
 	if original.Err() != nil {
-		// Neither shard nor original exists
+		// The key doesn't exist (redis.Nil) or can't be read, there's nothing to replicate
 		return original
 	}
 
 	// Step 3: Original data exists, asynchronously replicate to shards
-	go w.replicateToShards(ctx, action.ShardKeys, original.Val(), time.Hour)
+	// with the remaining TTL of the original key, so shards don't outlive it
+	// Concurrent misses on the key share a single replication
+	// The replication outlives the request, so it isn't canceled with it
+	value := original.Val()
+	replicateCtx := context.WithoutCancel(ctx)
+	w.kf.ReplicateAsync(action.OriginalKey, func() {
+		superseded := w.kf.StartReplication(action.OriginalKey)
+		ttl, expiresAt := action.FallbackTTL, time.Time{}
+		if remaining, ok := w.remainingTTL(replicateCtx, key); ok {
+			ttl, expiresAt = remaining, time.Now().Add(remaining)
+		}
+		w.replicateToShards(replicateCtx, action.ShardKeys, value, ttl, action.TTLJitter, expiresAt, action.Retry, superseded)
+	})
 
 	// Return original data immediately
 	return original
 }
 
+// remainingTTL returns the remaining TTL of the key, and false if the key
+// doesn't expire or its TTL can't be obtained
+func (w *Wrapper) remainingTTL(ctx context.Context, key string) (time.Duration, bool) {
+	if ttl, err := w.client.PTTL(ctx, key).Result(); err == nil && ttl > 0 {
+		return ttl, true
+	}
+	return 0, false
+}
+
+// toString converts a value to its string form as stored by Redis
+func toString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// policyValue returns the value written by Set as passed to the policy.
+// Values implementing encoding.BinaryMarshaler are passed as is, so a local cache Codec
+// can encode them, and other values as the string written to Redis.
+func policyValue(value any) any {
+	if _, ok := value.(encoding.BinaryMarshaler); ok {
+		return value
+	}
+	return toString(value)
+}
+
+// cachedString returns the string of a value held by the local cache,
+// encoding values implementing encoding.BinaryMarshaler like go-redis writes them.
+func cachedString(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case encoding.BinaryMarshaler:
+		data, err := v.MarshalBinary()
+		if err != nil {
+			return "", false
+		}
+		return string(data), true
+	}
+	return "", false
+}
+
 // Close wraps redis.Client.Close.
 func (w *Wrapper) Close() error {
 	return w.client.Close()
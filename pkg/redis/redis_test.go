@@ -0,0 +1,1717 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mingrammer/keyflare"
+	"github.com/mingrammer/keyflare/internal"
+	"github.com/mingrammer/keyflare/internal/detector"
+	"github.com/mingrammer/keyflare/internal/policy"
+	"github.com/mingrammer/keyflare/internal/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestClient creates a go-redis cluster client connected to the fake server
+func newTestClient(t *testing.T, server *testutil.RedisServer) *redis.ClusterClient {
+	t.Helper()
+
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:    []string{server.Addr()},
+		Protocol: 2,
+	})
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// failingManager is a policy manager whose policies always fail
+type failingManager struct {
+	policy.Manager
+}
+
+func (m failingManager) GetPolicy(key string) policy.Policy {
+	return failingPolicy{}
+}
+
+type failingPolicy struct{}
+
+func (failingPolicy) Apply(ctx policy.Context) policy.Result {
+	return policy.Result{Error: errors.New("policy failure")}
+}
+
+func TestWrapper_SetWriteThrough(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, testutil.LocalCachePolicyConfig("hot"))
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := w.Set(ctx, "hot", []byte("written"), time.Minute).Err(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+
+	// The written value is in the local cache right after the write
+	result := w.kf.PolicyManager().GetPolicy("hot").Apply(policy.Context{Key: "hot", Data: policy.GetRequest{}})
+	hit, ok := result.Data.(policy.CacheHit)
+	if !ok {
+		t.Fatalf("Expected CacheHit after Set, got: %T", result.Data)
+	}
+	if hit.Value != "written" {
+		t.Errorf("Expected cached value 'written', got '%v'", hit.Value)
+	}
+
+	// The read is served locally without a backend read
+	value, err := w.Get(ctx, "hot").Result()
+	if err != nil || value != "written" {
+		t.Fatalf("Expected 'written', got '%s' (err: %v)", value, err)
+	}
+	if calls := server.Calls("GET", "hot"); calls != 0 {
+		t.Errorf("Expected no backend read after write-through, got %d", calls)
+	}
+}
+
+// user is a struct written to Redis as JSON through encoding.BinaryMarshaler
+type user struct {
+	Name string `json:"name"`
+}
+
+func (u *user) MarshalBinary() ([]byte, error)    { return json.Marshal(u) }
+func (u *user) UnmarshalBinary(data []byte) error { return json.Unmarshal(data, u) }
+
+// userCodec caches users, and the JSON strings read from Redis on a miss, as JSON
+type userCodec struct{}
+
+func (userCodec) Marshal(value any) ([]byte, error) {
+	if s, ok := value.(string); ok {
+		return []byte(s), nil
+	}
+	return json.Marshal(value)
+}
+
+func (userCodec) Unmarshal(data []byte) (any, error) {
+	u := &user{}
+	return u, json.Unmarshal(data, u)
+}
+
+func TestWrapper_SetWithCodec(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	policyConfig := testutil.LocalCachePolicyConfig("hot")
+	params := policyConfig.Parameters.(policy.LocalCacheConfig)
+	params.Codec = userCodec{}
+	policyConfig.Parameters = params
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policyConfig)
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	written := &user{Name: "gopher"}
+	if err := w.Set(ctx, "hot", written, time.Minute).Err(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+	written.Name = "changed"
+
+	// The cached copy is decoded on a hit and served without a backend read
+	var read user
+	if err := w.Get(ctx, "hot").Scan(&read); err != nil {
+		t.Fatalf("Failed to read key: %v", err)
+	}
+	if read.Name != "gopher" {
+		t.Errorf("Expected cached user 'gopher', got '%s'", read.Name)
+	}
+	if calls := server.Calls("GET", "hot"); calls != 0 {
+		t.Errorf("Expected no backend read, got %d", calls)
+	}
+}
+
+func TestWrapper_SetColdKey(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 100}, testutil.LocalCachePolicyConfig("cold"))
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := w.Set(ctx, "cold", "value", time.Minute).Err(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+
+	result := w.kf.PolicyManager().GetPolicy("cold").Apply(policy.Context{Key: "cold", Data: policy.GetRequest{}})
+	if _, ok := result.Data.(policy.CacheMiss); !ok {
+		t.Errorf("Expected cold key to not be cached, got: %T", result.Data)
+	}
+
+	if value, ok := server.Get("cold"); !ok || value != "value" {
+		t.Errorf("Expected 'value' written to Redis, got '%s'", value)
+	}
+}
+
+// cutQuery normalizes keys by dropping their "?..." suffix
+func cutQuery(key string) string {
+	key, _, _ = strings.Cut(key, "?")
+	return key
+}
+
+func TestWrapper_KeyNormalizer(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("product:123?ts=1", "value")
+	server.Set("product:123?ts=2", "value")
+	testutil.StartKeyFlareConfig(t, internal.Config{
+		DetectorConfig: detector.Config{TopK: 10, HotThreshold: 2},
+		PolicyConfig:   testutil.LocalCachePolicyConfig("product:123"),
+		KeyNormalizer:  cutQuery,
+	})
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	w.Get(ctx, "product:123?ts=1")
+	w.Get(ctx, "product:123?ts=2")
+
+	// Both noisy variants are counted as one logical key
+	topK := w.kf.Detector().TopK()
+	if len(topK) != 1 || topK[0].Key != "product:123" {
+		t.Fatalf("Expected a single 'product:123' entry, got %v", topK)
+	}
+	if count := w.kf.Detector().GetCount("product:123"); count != 2 {
+		t.Errorf("Expected normalized count 2, got %d", count)
+	}
+	if !w.kf.Detector().IsHot("product:123") {
+		t.Error("Expected normalized key to be hot")
+	}
+
+	// The local cache is keyed by the normalized key, so any variant is served from it
+	p := w.kf.PolicyManager().GetPolicy("product:123")
+	testutil.Eventually(t, func() bool {
+		_, ok := p.Apply(policy.Context{Key: "product:123", Data: policy.GetRequest{}}).Data.(policy.CacheHit)
+		return ok
+	})
+
+	value, err := w.Get(ctx, "product:123?ts=3").Result()
+	if err != nil || value != "value" {
+		t.Fatalf("Expected 'value' from the local cache, got '%s' (err: %v)", value, err)
+	}
+	if calls := server.Calls("GET", "product:123?ts=3"); calls != 0 {
+		t.Errorf("Expected no backend read for a cached variant, got %d", calls)
+	}
+}
+
+func TestWrapper_PipelineCountsKeys(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("a", "1")
+	server.Set("b", "2")
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 100}, testutil.LocalCachePolicyConfig())
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	pipe := w.Pipeline()
+	pipe.Get(ctx, "a")
+	pipe.Get(ctx, "b")
+	pipe.Get(ctx, "a")
+	pipe.Get(ctx, "c")
+
+	// Nothing is counted until the pipeline is executed
+	if count := w.kf.Detector().GetCount("a"); count != 0 {
+		t.Errorf("Expected no count before Exec, got %d", count)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		t.Fatalf("Failed to execute pipeline: %v", err)
+	}
+
+	expected := map[string]uint64{"a": 2, "b": 1, "c": 1}
+	for key, want := range expected {
+		if count := w.kf.Detector().GetCount(key); count != want {
+			t.Errorf("Expected count %d for key %s, got %d", want, key, count)
+		}
+	}
+}
+
+func TestCommandKeys(t *testing.T) {
+	tests := []struct {
+		args     []any
+		expected []string
+	}{
+		{[]any{"get", "k"}, []string{"k"}},
+		{[]any{"set", "k", "v", "ex", 10}, []string{"k"}},
+		{[]any{"mget", "a", "b"}, []string{"a", "b"}},
+		{[]any{"mset", "a", "1", "b", "2"}, []string{"a", "b"}},
+		{[]any{"copy", "src", "dst", "replace"}, []string{"src", "dst"}},
+		{[]any{"eval", "return 1", 2, "a", "b", "arg"}, []string{"a", "b"}},
+		{[]any{"evalsha", "sha", "1", "a"}, []string{"a"}},
+		{[]any{"eval", "return 1", 0, "arg"}, nil},
+		{[]any{"eval", "return 1", 3, "a"}, nil},
+		{[]any{"ping"}, nil},
+		{[]any{"publish", "channel", "message"}, nil},
+		{[]any{"auth", "user", "secret"}, nil},
+		{[]any{"hello", 3, "auth", "user", "secret"}, nil},
+		{[]any{"scan", "0", "match", "user:*"}, nil},
+		{[]any{"keys", "user:*"}, nil},
+		{[]any{"subscribe", "channel"}, nil},
+		{[]any{"psubscribe", "news.*"}, nil},
+		{[]any{"xread", "count", 2, "streams", "s1", "s2", "0", "0"}, []string{"s1", "s2"}},
+		{[]any{"xreadgroup", "group", "g", "c", "streams", "s1", ">"}, []string{"s1"}},
+		{[]any{"zunionstore", "dst", 2, "a", "b", "weights", 1, 2}, []string{"dst", "a", "b"}},
+		{[]any{"blpop", "a", "b", 0}, []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		got := commandKeys(tt.args)
+		if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("commandKeys(%v) = %v, expected %v", tt.args, got, tt.expected)
+		}
+	}
+}
+
+func TestCommandOperation(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected detector.Operation
+	}{
+		{"get", detector.OpRead},
+		{"GET", detector.OpRead},
+		{"zrevrange", detector.OpRead},
+		{"zmscore", detector.OpRead},
+		{"hstrlen", detector.OpRead},
+		{"lpos", detector.OpRead},
+		{"bitcount", detector.OpRead},
+		{"xrange", detector.OpRead},
+		{"georadius_ro", detector.OpRead},
+		{"pfcount", detector.OpRead},
+		{"memory", detector.OpRead},
+		{"expiretime", detector.OpRead},
+		{"set", detector.OpWrite},
+		{"del", detector.OpWrite},
+		{"georadius", detector.OpWrite},
+		{"xreadgroup", detector.OpWrite},
+		{"mymodule.cmd", detector.OpWrite},
+	}
+
+	for _, tt := range tests {
+		if got := commandOperation(tt.name); got != tt.expected {
+			t.Errorf("commandOperation(%q) = %v, expected %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestWrapper_DoCountsKeys(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("user:1", "alice")
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 100}, testutil.LocalCachePolicyConfig())
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	value, err := w.Do(ctx, "get", "user:1").Text()
+	if err != nil {
+		t.Fatalf("Failed to do GET: %v", err)
+	}
+	if value != "alice" {
+		t.Errorf("Expected value alice, got %s", value)
+	}
+	if err := w.Do(ctx, "del", "user:2", "user:3").Err(); err != nil {
+		t.Fatalf("Failed to do DEL: %v", err)
+	}
+
+	for _, key := range []string{"user:1", "user:2", "user:3"} {
+		if count := w.kf.Detector().GetCount(key); count != 1 {
+			t.Errorf("Expected count 1 for key %s, got %d", key, count)
+		}
+	}
+	for _, kc := range w.kf.Detector().TopK() {
+		if kc.Key == "user:1" && kc.Reads != 1 {
+			t.Errorf("Expected GET to be counted as a read, got %d reads", kc.Reads)
+		}
+	}
+}
+
+func TestWrapper_Observe(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 3}, testutil.LocalCachePolicyConfig())
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// Keys of commands the wrapper doesn't wrap (e.g. EVALSHA) are fed manually
+	if w.Observe("script:key", "read") {
+		t.Error("Expected key not to be hot after a single access")
+	}
+	w.Observe("script:key", "read")
+	if !w.Observe("script:key", "write") {
+		t.Error("Expected key to be hot once it reaches the threshold")
+	}
+
+	topK := w.kf.Detector().TopK()
+	if len(topK) != 1 || topK[0].Key != "script:key" {
+		t.Fatalf("Expected 'script:key' in TopK, got %v", topK)
+	}
+	if topK[0].Reads != 2 || topK[0].Writes != 1 {
+		t.Errorf("Expected 2 reads and 1 write, got %d/%d", topK[0].Reads, topK[0].Writes)
+	}
+	if calls := server.Calls("GET", "script:key"); calls != 0 {
+		t.Errorf("Expected no backend calls from Observe, got %d", calls)
+	}
+}
+
+func TestWrapper_WithoutTracking(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 100}, testutil.LocalCachePolicyConfig())
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	server.Set("key", "value")
+
+	if err := w.Get(ctx, "key").Err(); err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+	if count := w.kf.Detector().GetCount("key"); count != 1 {
+		t.Fatalf("Expected a tracked get to be counted, got %d", count)
+	}
+
+	// A bypassed get still reaches the backend but isn't counted
+	val, err := w.Get(detector.WithoutTracking(ctx), "key").Result()
+	if err != nil || val != "value" {
+		t.Fatalf("Expected 'value', got %q (err: %v)", val, err)
+	}
+	if count := w.kf.Detector().GetCount("key"); count != 1 {
+		t.Errorf("Expected a bypassed get to not be counted, got %d", count)
+	}
+	if calls := server.Calls("GET", "key"); calls != 2 {
+		t.Errorf("Expected 2 backend calls, got %d", calls)
+	}
+}
+
+func TestInstallHook(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 100}, testutil.LocalCachePolicyConfig())
+
+	client := newTestClient(t, server)
+	if err := InstallHook(client); err != nil {
+		t.Fatalf("Failed to install hook: %v", err)
+	}
+	kf, err := internal.GetInstance()
+	if err != nil {
+		t.Fatalf("Failed to get KeyFlare instance: %v", err)
+	}
+
+	// Commands the Wrapper doesn't wrap are counted too
+	ctx := context.Background()
+	client.Set(ctx, "a", "1", 0)
+	client.Get(ctx, "a")
+	client.Do(ctx, "PFADD", "visitors", "x")
+	client.MSet(ctx, "b", "1", "c", "1")
+
+	// Pipelined and transactional commands are counted when they're executed
+	pipe := client.Pipeline()
+	pipe.Get(ctx, "a")
+	pipe.Incr(ctx, "b")
+	pipe.Exec(ctx)
+
+	tx := client.TxPipeline()
+	tx.Get(ctx, "c")
+	tx.Exec(ctx)
+
+	expected := map[string]uint64{"a": 3, "visitors": 1, "b": 2, "c": 2}
+	for key, want := range expected {
+		if count := kf.Detector().GetCount(key); count != want {
+			t.Errorf("Expected count %d for key %s, got %d", want, key, count)
+		}
+	}
+
+	topK := kf.Detector().TopK()
+	for _, kc := range topK {
+		if kc.Key == "a" && (kc.Reads != 2 || kc.Writes != 1) {
+			t.Errorf("Expected 2 reads and 1 write of key a, got %d/%d", kc.Reads, kc.Writes)
+		}
+	}
+
+	// Connection setup commands aren't counted, so credentials aren't reported as keys
+	client.Do(ctx, "AUTH", "user", "secret")
+	client.Do(ctx, "CLIENT", "SETNAME", "app")
+	for _, key := range []string{"user", "secret", "SETNAME"} {
+		if count := kf.Detector().GetCount(key); count != 0 {
+			t.Errorf("Expected %s not to be counted, got %d", key, count)
+		}
+	}
+
+	// A restarted instance is used by the installed hook
+	if err := internal.Stop(); err != nil {
+		t.Fatalf("Failed to stop KeyFlare: %v", err)
+	}
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 100}, testutil.LocalCachePolicyConfig())
+	restarted, err := internal.GetInstance()
+	if err != nil {
+		t.Fatalf("Failed to get KeyFlare instance: %v", err)
+	}
+	client.Get(ctx, "a")
+	if count := restarted.Detector().GetCount("a"); count != 1 {
+		t.Errorf("Expected the restarted instance to count key a once, got %d", count)
+	}
+}
+
+func TestWrapper_EvalCountsKeys(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 100}, testutil.LocalCachePolicyConfig())
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	script := "return redis.call('INCR', KEYS[1]) + redis.call('INCR', KEYS[2])"
+	if err := w.Eval(ctx, script, []string{"counter:a", "counter:b"}, "arg").Err(); err != nil {
+		t.Fatalf("Failed to eval script: %v", err)
+	}
+	if err := w.EvalSha(ctx, "0123456789abcdef0123456789abcdef01234567", []string{"counter:a"}).Err(); err != nil {
+		t.Fatalf("Failed to evalsha script: %v", err)
+	}
+
+	expected := map[string]uint64{"counter:a": 2, "counter:b": 1}
+	for key, want := range expected {
+		if count := w.kf.Detector().GetCount(key); count != want {
+			t.Errorf("Expected count %d for key %s, got %d", want, key, count)
+		}
+	}
+
+	// Arguments that aren't keys are not counted
+	if count := w.kf.Detector().GetCount("arg"); count != 0 {
+		t.Errorf("Expected script arguments not to be counted, got %d", count)
+	}
+}
+
+func TestWrapper_PolicyErrorFailClosed(t *testing.T) {
+	for _, failClosed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("FailClosed=%v", failClosed), func(t *testing.T) {
+			server := testutil.NewRedisServer(t)
+			server.Set("hot", "value")
+			testutil.StartKeyFlareConfig(t, internal.Config{
+				DetectorConfig: detector.Config{TopK: 10, HotThreshold: 1},
+				PolicyManager:  failingManager{},
+				FailClosed:     failClosed,
+			})
+
+			w, err := Wrap(newTestClient(t, server))
+			if err != nil {
+				t.Fatalf("Failed to wrap client: %v", err)
+			}
+
+			value, err := w.Get(context.Background(), "hot").Result()
+			if failClosed {
+				if err == nil || !strings.Contains(err.Error(), "policy failure") {
+					t.Errorf("Expected the policy error to be returned, got: %v", err)
+				}
+			} else {
+				if err != nil || value != "value" {
+					t.Errorf("Expected the backend result 'value', got '%s' (err: %v)", value, err)
+				}
+				if calls := server.Calls("GET", "hot"); calls != 1 {
+					t.Errorf("Expected a backend read, got %d", calls)
+				}
+			}
+
+			// A write that reached Redis succeeds even if the policy fails afterwards
+			if err := w.Set(context.Background(), "hot", "written", 0).Err(); err != nil {
+				t.Errorf("Expected the set to succeed, got: %v", err)
+			}
+			if value, _ := server.Get("hot"); value != "written" {
+				t.Errorf("Expected 'written' in Redis, got '%s'", value)
+			}
+		})
+	}
+}
+
+func TestWrapper_PolicyBreaker(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("hot", "value")
+	testutil.StartKeyFlareConfig(t, internal.Config{
+		DetectorConfig: detector.Config{TopK: 10, HotThreshold: 1},
+		PolicyManager:  failingManager{},
+		FailClosed:     true,
+		BreakerConfig:  policy.BreakerConfig{Threshold: 2, Cooldown: 50 * time.Millisecond},
+	})
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := w.Get(ctx, "hot").Err(); err == nil {
+			t.Fatalf("Expected the policy error to be returned on get %d", i)
+		}
+	}
+
+	// The breaker is open, so the policy is bypassed
+	if state := w.kf.Breaker().State(); state != policy.BreakerOpen {
+		t.Fatalf("Expected open breaker, got %s", state)
+	}
+	if value, err := w.Get(ctx, "hot").Result(); err != nil || value != "value" {
+		t.Errorf("Expected the backend result 'value', got '%s' (err: %v)", value, err)
+	}
+
+	// After the cooldown, the policy is probed again
+	time.Sleep(60 * time.Millisecond)
+	if err := w.Get(ctx, "hot").Err(); err == nil {
+		t.Error("Expected the probe's policy error to be returned")
+	}
+	if state := w.kf.Breaker().State(); state != policy.BreakerOpen {
+		t.Errorf("Expected the failed probe to reopen the breaker, got %s", state)
+	}
+}
+
+func TestWrapper_OnDecision(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("other", "value")
+
+	var decisions []internal.Decision
+	testutil.StartKeyFlareConfig(t, internal.Config{
+		DetectorConfig: detector.Config{TopK: 10, HotThreshold: 1},
+		PolicyConfig:   testutil.LocalCachePolicyConfig("hot"),
+		OnDecision: func(d internal.Decision) {
+			decisions = append(decisions, d)
+		},
+	})
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := w.Set(ctx, "hot", "value", time.Minute).Err(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+	if err := w.Get(ctx, "hot").Err(); err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+	if err := w.Get(ctx, "other").Err(); err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+
+	expected := []internal.Decision{
+		{Key: "hot", Operation: "set", Hot: true, PolicyApplied: true, Result: internal.DecisionSet, BackendHit: true},
+		{Key: "hot", Operation: "get", Hot: true, PolicyApplied: true, Result: internal.DecisionHit, BackendHit: false},
+		// The key has no policy, but the trace still tells whether it's hot
+		{Key: "other", Operation: "get", Hot: true, PolicyApplied: false, Result: "", BackendHit: true},
+	}
+	if len(decisions) != len(expected) {
+		t.Fatalf("Expected %d decisions, got %d: %+v", len(expected), len(decisions), decisions)
+	}
+	for i, d := range decisions {
+		if d != expected[i] {
+			t.Errorf("Expected decision %d to be %+v, got %+v", i, expected[i], d)
+		}
+	}
+}
+
+func TestWrapper_LookAsideShardTTL(t *testing.T) {
+	tests := []struct {
+		name        string
+		originalTTL time.Duration
+		jitter      float64
+		failures    int
+		minTTL      time.Duration
+		maxTTL      time.Duration
+	}{
+		{name: "original TTL", originalTTL: 30 * time.Second, minTTL: 29 * time.Second, maxTTL: 30 * time.Second},
+		{name: "jittered original TTL", originalTTL: 30 * time.Second, jitter: 0.5, minTTL: 15 * time.Second, maxTTL: 30 * time.Second},
+		// The retried write happens 500ms later, when less of the original TTL remains
+		{name: "retried original TTL", originalTTL: 2 * time.Second, failures: 1, minTTL: time.Second, maxTTL: 1500 * time.Millisecond},
+		{name: "fallback TTL", originalTTL: 0, minTTL: 5 * time.Minute, maxTTL: 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testutil.NewRedisServer(t)
+			server.Set("hot", "value")
+			if tt.originalTTL > 0 {
+				server.SetTTL("hot", tt.originalTTL)
+			}
+			server.FailNext("SET", "hot:shard:0", tt.failures)
+			testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+				Type: policy.KeySplitting,
+				Parameters: policy.KeySplittingConfig{
+					Shards:              2,
+					FallbackTTL:         5 * time.Minute,
+					TTLJitter:           tt.jitter,
+					ReplicationAttempts: 2,
+					ReplicationBackoff:  500 * time.Millisecond,
+				},
+				WhitelistKeys: []string{"hot"},
+			})
+
+			w, err := Wrap(newTestClient(t, server))
+			if err != nil {
+				t.Fatalf("Failed to wrap client: %v", err)
+			}
+
+			// The shard miss falls back to the original key and fills the shards
+			value, err := w.Get(context.Background(), "hot").Result()
+			if err != nil || value != "value" {
+				t.Fatalf("Expected 'value', got '%s' (err: %v)", value, err)
+			}
+
+			for i := 0; i < 2; i++ {
+				shardKey := fmt.Sprintf("hot:shard:%d", i)
+				testutil.Eventually(t, func() bool {
+					_, ok := server.Get(shardKey)
+					return ok
+				})
+				if ttl := server.TTL(shardKey); ttl < tt.minTTL || ttl > tt.maxTTL {
+					t.Errorf("Expected TTL in [%v, %v] for %s, got %v", tt.minTTL, tt.maxTTL, shardKey, ttl)
+				}
+			}
+		})
+	}
+}
+
+func TestWrapper_LookAsideNormalizesKeys(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("product:123?ts=1", "value")
+
+	stub := &stubDetector{Detector: detector.New(detector.Config{TopK: 10})}
+	testutil.StartKeyFlareConfig(t, internal.Config{
+		Detector: stub,
+		PolicyConfig: policy.Config{
+			Type:          policy.KeySplitting,
+			Parameters:    policy.KeySplittingConfig{Shards: 2, FallbackTTL: time.Minute},
+			WhitelistKeys: []string{"product:123"},
+		},
+		KeyNormalizer: cutQuery,
+	})
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// The shard miss falls back to the key as passed, and fills the shards of the normalized key
+	value, err := w.Get(context.Background(), "product:123?ts=1").Result()
+	if err != nil || value != "value" {
+		t.Fatalf("Expected 'value', got '%s' (err: %v)", value, err)
+	}
+	if calls := server.Calls("GET", "product:123?ts=1"); calls != 1 {
+		t.Errorf("Expected a backend read of the original key, got %d", calls)
+	}
+	for i := 0; i < 2; i++ {
+		shardKey := fmt.Sprintf("product:123:shard:%d", i)
+		testutil.Eventually(t, func() bool {
+			value, ok := server.Get(shardKey)
+			return ok && value == "value"
+		})
+	}
+
+	// The detector only ever sees the normalized key
+	for _, key := range append(stub.increments, stub.hotChecks...) {
+		if key != "product:123" {
+			t.Errorf("Expected the detector to only see 'product:123', got %q", key)
+		}
+	}
+}
+
+func TestWrapper_LookAsideReplicatesOnce(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("hot", "value")
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+		Type:          policy.KeySplitting,
+		Parameters:    policy.KeySplittingConfig{Shards: 2, FallbackTTL: 5 * time.Minute},
+		WhitelistKeys: []string{"hot"},
+	})
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// The replication reads the TTL of the original key first, so it stays in flight
+	// while the other misses come in
+	server.Delay("PTTL", "hot", 200*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := w.Get(context.Background(), "hot").Result(); err != nil || value != "value" {
+				t.Errorf("Expected 'value', got '%s' (err: %v)", value, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	testutil.Eventually(t, func() bool {
+		_, ok0 := server.Get("hot:shard:0")
+		_, ok1 := server.Get("hot:shard:1")
+		return ok0 && ok1
+	})
+
+	if calls := server.Calls("PTTL", "hot"); calls != 1 {
+		t.Errorf("Expected a single replication, got %d", calls)
+	}
+	for i := range 2 {
+		if calls := server.Calls("SET", fmt.Sprintf("hot:shard:%d", i)); calls != 1 {
+			t.Errorf("Expected shard %d to be written once, got %d", i, calls)
+		}
+	}
+}
+
+func TestWrapper_LookAsideGet(t *testing.T) {
+	tests := []struct {
+		name          string
+		shards        map[string]string
+		failingShard  string
+		original      bool
+		expected      string
+		expectedErr   error
+		originalReads int
+	}{
+		{
+			name:     "shard hit",
+			shards:   map[string]string{"hot:shard:0": "shard", "hot:shard:1": "shard"},
+			original: true,
+			expected: "shard",
+		},
+		{
+			name:          "shard miss, original hit",
+			original:      true,
+			expected:      "value",
+			originalReads: 1,
+		},
+		{
+			name:          "both missing",
+			expectedErr:   redis.Nil,
+			originalReads: 1,
+		},
+		{
+			// A failing shard is followed by the other shard rather than the original key
+			// The read is repeated so the failing shard is almost surely selected
+			name:         "shard error, other shard hit",
+			shards:       map[string]string{"hot:shard:1": "shard"},
+			failingShard: "hot:shard:0",
+			original:     true,
+			expected:     "shard",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testutil.NewRedisServer(t)
+			for key, value := range tt.shards {
+				server.Set(key, value)
+			}
+			if tt.original {
+				server.Set("hot", "value")
+			}
+			if tt.failingShard != "" {
+				server.FailNext("GET", tt.failingShard, 100)
+			}
+			testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+				Type:          policy.KeySplitting,
+				Parameters:    policy.KeySplittingConfig{Shards: 2},
+				WhitelistKeys: []string{"hot"},
+			})
+
+			w, err := Wrap(newTestClient(t, server))
+			if err != nil {
+				t.Fatalf("Failed to wrap client: %v", err)
+			}
+
+			reads := 1
+			if tt.failingShard != "" {
+				reads = 20
+			}
+			for i := 0; i < reads; i++ {
+				value, err := w.Get(context.Background(), "hot").Result()
+				if !errors.Is(err, tt.expectedErr) || value != tt.expected {
+					t.Fatalf("Expected %q (err: %v), got %q (err: %v)", tt.expected, tt.expectedErr, value, err)
+				}
+			}
+
+			if calls := server.Calls("GET", "hot"); calls != tt.originalReads {
+				t.Errorf("Expected %d reads of the original key, got %d", tt.originalReads, calls)
+			}
+			if tt.failingShard != "" && server.Calls("GET", tt.failingShard) == 0 {
+				t.Errorf("Expected %s to be read", tt.failingShard)
+			}
+		})
+	}
+}
+
+func TestWrapper_GetRefreshAhead(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("hot", "v1")
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+		Type: policy.LocalCache,
+		Parameters: policy.LocalCacheConfig{
+			TTL:          0.5,
+			Capacity:     100,
+			RefreshAhead: 0.2, // refresh after 100ms
+		},
+		WhitelistKeys: []string{"hot"},
+	})
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// The first read misses the local cache and caches the value
+	ctx := context.Background()
+	w.Get(ctx, "hot")
+	p := w.kf.PolicyManager().GetPolicy("hot")
+	cachedValue := func() any {
+		hit, _ := p.Apply(policy.Context{Key: "hot", Data: policy.GetRequest{}}).Data.(policy.CacheHit)
+		return hit.Value
+	}
+	testutil.Eventually(t, func() bool { return cachedValue() == "v1" })
+
+	server.Set("hot", "v2")
+	time.Sleep(150 * time.Millisecond)
+
+	// Reads past the refresh-ahead point are still served locally and trigger a single refresh
+	for i := 0; i < 5; i++ {
+		if value, err := w.Get(ctx, "hot").Result(); err != nil || value != "v1" {
+			t.Fatalf("Expected cached 'v1', got '%s' (err: %v)", value, err)
+		}
+	}
+
+	testutil.Eventually(t, func() bool { return cachedValue() == "v2" })
+	if calls := server.Calls("GET", "hot"); calls != 2 {
+		t.Errorf("Expected the initial fetch and one refresh, got %d backend reads", calls)
+	}
+}
+
+func TestWrapper_ZIncrByMemberGranularity(t *testing.T) {
+	for _, memberGranularity := range []bool{false, true} {
+		t.Run(fmt.Sprintf("MemberGranularity=%v", memberGranularity), func(t *testing.T) {
+			server := testutil.NewRedisServer(t)
+			testutil.StartKeyFlare(t, detector.Config{
+				TopK:              10,
+				HotThreshold:      100,
+				MemberGranularity: memberGranularity,
+			}, testutil.LocalCachePolicyConfig())
+
+			w, err := Wrap(newTestClient(t, server))
+			if err != nil {
+				t.Fatalf("Failed to wrap client: %v", err)
+			}
+
+			ctx := context.Background()
+			w.ZIncrBy(ctx, "leaderboard", 10, "alice")
+			w.ZIncrBy(ctx, "leaderboard", 5, "alice")
+			w.ZAdd(ctx, "leaderboard", redis.Z{Score: 1, Member: "bob"})
+
+			if count := w.kf.Detector().GetCount("leaderboard"); count != 3 {
+				t.Errorf("Expected count 3 for the key, got %d", count)
+			}
+
+			expected := map[string]uint64{"leaderboard:alice": 0, "leaderboard:bob": 0}
+			if memberGranularity {
+				expected = map[string]uint64{"leaderboard:alice": 2, "leaderboard:bob": 1}
+			}
+			for key, want := range expected {
+				if count := w.kf.Detector().GetCount(key); count != want {
+					t.Errorf("Expected count %d for %s, got %d", want, key, count)
+				}
+			}
+		})
+	}
+}
+
+func TestWrapper_DeleteEvictsLocalCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		delete func(w *Wrapper, ctx context.Context, keys ...string) *redis.IntCmd
+	}{
+		{name: "Del", delete: (*Wrapper).Del},
+		{name: "Unlink", delete: (*Wrapper).Unlink},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testutil.NewRedisServer(t)
+			testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, testutil.LocalCachePolicyConfig("hot1", "hot2"))
+
+			w, err := Wrap(newTestClient(t, server))
+			if err != nil {
+				t.Fatalf("Failed to wrap client: %v", err)
+			}
+
+			// Writing the hot keys caches them locally
+			ctx := context.Background()
+			keys := []string{"hot1", "hot2"}
+			for _, key := range keys {
+				if err := w.Set(ctx, key, "value", time.Minute).Err(); err != nil {
+					t.Fatalf("Failed to set key: %v", err)
+				}
+			}
+			cached := func(key string) bool {
+				p := w.kf.PolicyManager().GetPolicy(key)
+				_, ok := p.Apply(policy.Context{Key: key, Data: policy.GetRequest{}}).Data.(policy.CacheHit)
+				return ok
+			}
+
+			deleted, err := tt.delete(w, ctx, keys...).Result()
+			if err != nil || deleted != 2 {
+				t.Fatalf("Expected 2 deleted keys, got %d (err: %v)", deleted, err)
+			}
+
+			for _, key := range keys {
+				if count := w.kf.Detector().GetCount(key); count != 2 {
+					t.Errorf("Expected count 2 for %s after Set and %s, got %d", key, tt.name, count)
+				}
+				if cached(key) {
+					t.Errorf("Expected the local entry of %s to be evicted", key)
+				}
+				if _, ok := server.Get(key); ok {
+					t.Errorf("Expected %s to be deleted from the backend", key)
+				}
+			}
+		})
+	}
+}
+
+func TestWrapper_DoEvictsWrittenKeys(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, testutil.LocalCachePolicyConfig("hot"))
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// Writing the hot key caches it locally
+	ctx := context.Background()
+	if err := w.Set(ctx, "hot", "old", time.Minute).Err(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+
+	if err := w.Do(ctx, "set", "hot", "new").Err(); err != nil {
+		t.Fatalf("Failed to do SET: %v", err)
+	}
+
+	value, err := w.Get(ctx, "hot").Result()
+	if err != nil || value != "new" {
+		t.Errorf("Expected the value written by Do 'new', got '%s' (err: %v)", value, err)
+	}
+}
+
+func TestWrapper_Copy(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("src", "value")
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, testutil.LocalCachePolicyConfig("dst"))
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// A stale destination value is cached locally
+	ctx := context.Background()
+	if err := w.Set(ctx, "dst", "stale", time.Minute).Err(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+
+	copied, err := w.Copy(ctx, "src", "dst", 0, true).Result()
+	if err != nil || copied != 1 {
+		t.Fatalf("Expected the key to be copied, got %d (err: %v)", copied, err)
+	}
+
+	// Both keys are counted, with the source as a read and the destination as a write
+	counts := make(map[string]detector.KeyCount)
+	for _, kc := range w.kf.Detector().TopK() {
+		counts[kc.Key] = kc
+	}
+	if src := counts["src"]; src.Count != 1 || src.Reads != 1 {
+		t.Errorf("Expected src to be counted once as a read, got %+v", src)
+	}
+	if dst := counts["dst"]; dst.Count != 2 || dst.Writes != 2 {
+		t.Errorf("Expected dst to be counted as a write by Set and Copy, got %+v", dst)
+	}
+
+	// The copied value is read from Redis instead of the stale local value
+	if value, err := w.Get(ctx, "dst").Result(); err != nil || value != "value" {
+		t.Errorf("Expected 'value', got '%s' (err: %v)", value, err)
+	}
+}
+
+func TestWrapper_SetEnabled(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("user:1", "value")
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, testutil.LocalCachePolicyConfig("user:1"))
+
+	client := newTestClient(t, server)
+	enabled, err := Wrap(client)
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+	disabled, err := Wrap(client)
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+	disabled.SetEnabled(false)
+	if disabled.Enabled() || !enabled.Enabled() {
+		t.Fatalf("Expected only the second wrapper to be disabled")
+	}
+
+	// The disabled wrapper passes commands through without counting keys
+	ctx := context.Background()
+	for range 3 {
+		if value, err := disabled.Get(ctx, "user:1").Result(); err != nil || value != "value" {
+			t.Fatalf("Expected 'value', got '%s' (err: %v)", value, err)
+		}
+	}
+	if count := disabled.kf.Detector().GetCount("user:1"); count != 0 {
+		t.Errorf("Expected a disabled wrapper not to count keys, got %d", count)
+	}
+
+	if err := enabled.Get(ctx, "user:1").Err(); err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+	if count := enabled.kf.Detector().GetCount("user:1"); count != 1 {
+		t.Errorf("Expected an enabled wrapper to count keys, got %d", count)
+	}
+
+	// The key is hot now, but the disabled wrapper still reads from Redis
+	server.Set("user:1", "updated")
+	if value, err := disabled.Get(ctx, "user:1").Result(); err != nil || value != "updated" {
+		t.Errorf("Expected 'updated' from Redis, got '%s' (err: %v)", value, err)
+	}
+}
+
+func TestWrapper_CountOnlyEligible(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, CountOnlyEligible: true}, testutil.LocalCachePolicyConfig("user:1"))
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	for _, key := range []string{"user:1", "user:2", "session:1", "user:2"} {
+		if err := w.Set(ctx, key, "value", time.Minute).Err(); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+		if err := w.Get(ctx, key).Err(); err != nil {
+			t.Fatalf("Failed to get %s: %v", key, err)
+		}
+	}
+
+	// Only the key a policy applies to is counted
+	topK := w.kf.Detector().TopK()
+	if len(topK) != 1 || topK[0].Key != "user:1" {
+		t.Errorf("Expected only user:1 to be tracked, got %v", topK)
+	}
+	if total := w.kf.Detector().TotalCount(); total != 2 {
+		t.Errorf("Expected only the 2 accesses to user:1 to be counted, got %d", total)
+	}
+}
+
+// stubDetector is a detector recording the calls of the wrappers, considering every key hot
+type stubDetector struct {
+	detector.Detector
+	increments []string
+	hotChecks  []string
+}
+
+func (d *stubDetector) IncrementOp(ctx context.Context, key string, count uint64, op detector.Operation) {
+	d.increments = append(d.increments, key)
+	d.Detector.IncrementOp(ctx, key, count, op)
+}
+
+func (d *stubDetector) IsHot(key string) bool {
+	d.hotChecks = append(d.hotChecks, key)
+	return true
+}
+
+func TestWrapper_CustomDetector(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("user:1", "value")
+
+	stub := &stubDetector{Detector: detector.New(detector.Config{TopK: 10})}
+	testutil.StartKeyFlareConfig(t, internal.Config{
+		Detector:     stub,
+		PolicyConfig: testutil.LocalCachePolicyConfig("user:1"),
+	})
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+	if w.kf.Detector() != stub {
+		t.Fatal("Expected the injected detector to be used")
+	}
+
+	ctx := context.Background()
+	if value, err := w.Get(ctx, "user:1").Result(); err != nil || value != "value" {
+		t.Fatalf("Expected 'value', got '%s' (err: %v)", value, err)
+	}
+
+	if len(stub.increments) != 1 || stub.increments[0] != "user:1" {
+		t.Errorf("Expected the wrapper to count user:1 with the injected detector, got %v", stub.increments)
+	}
+	if len(stub.hotChecks) != 1 || stub.hotChecks[0] != "user:1" {
+		t.Errorf("Expected the wrapper to check user:1 with the injected detector, got %v", stub.hotChecks)
+	}
+
+	// The key is hot per the injected detector, so it's cached locally
+	p := w.kf.PolicyManager().GetPolicy("user:1")
+	testutil.Eventually(t, func() bool {
+		_, ok := p.Apply(policy.Context{Key: "user:1", Data: policy.GetRequest{}}).Data.(policy.CacheHit)
+		return ok
+	})
+}
+
+func TestWrapper_KeyStatus(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 2, Capacity: 10, HotThreshold: 3}, testutil.LocalCachePolicyConfig())
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	for key, reads := range map[string]int{"top": 5, "second": 2, "third": 1} {
+		for range reads {
+			w.Get(ctx, key)
+		}
+	}
+
+	tests := []struct {
+		key   string
+		rank  int
+		count uint64
+		hot   bool
+	}{
+		{key: "top", rank: 1, count: 5, hot: true},
+		{key: "second", rank: 2, count: 2, hot: false},
+		{key: "missing", rank: 0, count: 0, hot: false},
+	}
+	for _, tt := range tests {
+		rank, count, hot := w.KeyStatus(tt.key)
+		if rank != tt.rank || count != tt.count || hot != tt.hot {
+			t.Errorf("Expected %s to have rank %d, count %d and hot %v, got %d, %d and %v",
+				tt.key, tt.rank, tt.count, tt.hot, rank, count, hot)
+		}
+	}
+}
+
+func TestWrapper_GetDelEvictsLocalCache(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, testutil.LocalCachePolicyConfig("token"))
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// Writing the hot key caches it locally
+	ctx := context.Background()
+	if err := w.Set(ctx, "token", "one-shot", time.Minute).Err(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+	p := w.kf.PolicyManager().GetPolicy("token")
+	cached := func() bool {
+		_, ok := p.Apply(policy.Context{Key: "token", Data: policy.GetRequest{}}).Data.(policy.CacheHit)
+		return ok
+	}
+	if !cached() {
+		t.Fatal("Expected the key to be cached after Set")
+	}
+
+	// GETDEL always reaches the backend and evicts the local entry
+	value, err := w.GetDel(ctx, "token").Result()
+	if err != nil || value != "one-shot" {
+		t.Fatalf("Expected 'one-shot', got '%s' (err: %v)", value, err)
+	}
+	if calls := server.Calls("GETDEL", "token"); calls != 1 {
+		t.Errorf("Expected 1 backend GETDEL, got %d", calls)
+	}
+	if cached() {
+		t.Error("Expected the local entry to be evicted")
+	}
+
+	// The deleted key is no longer served
+	if err := w.GetDel(ctx, "token").Err(); err != redis.Nil {
+		t.Errorf("Expected redis.Nil for a deleted key, got %v", err)
+	}
+}
+
+func TestWrapper_DeleteRemovesShards(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(ctx context.Context, w *Wrapper) error
+		expected string
+	}{
+		{
+			name:   "Del",
+			change: func(ctx context.Context, w *Wrapper) error { return w.Del(ctx, "hot").Err() },
+		},
+		{
+			name:   "Unlink",
+			change: func(ctx context.Context, w *Wrapper) error { return w.Unlink(ctx, "hot").Err() },
+		},
+		{
+			name:   "GetDel",
+			change: func(ctx context.Context, w *Wrapper) error { return w.GetDel(ctx, "hot").Err() },
+		},
+		{
+			name:     "Do",
+			change:   func(ctx context.Context, w *Wrapper) error { return w.Do(ctx, "set", "hot", "new").Err() },
+			expected: "new",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testutil.NewRedisServer(t)
+			testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+				Type:          policy.KeySplitting,
+				Parameters:    policy.KeySplittingConfig{Shards: 2},
+				WhitelistKeys: []string{"hot"},
+			})
+
+			w, err := Wrap(newTestClient(t, server))
+			if err != nil {
+				t.Fatalf("Failed to wrap client: %v", err)
+			}
+
+			// Writing the hot key replicates it to its shards
+			ctx := context.Background()
+			if err := w.Set(ctx, "hot", "old", time.Minute).Err(); err != nil {
+				t.Fatalf("Failed to set key: %v", err)
+			}
+			shardKeys := []string{"hot:shard:0", "hot:shard:1"}
+			testutil.Eventually(t, func() bool {
+				for _, shardKey := range shardKeys {
+					if _, ok := server.Get(shardKey); !ok {
+						return false
+					}
+				}
+				return true
+			})
+
+			if err := tt.change(ctx, w); err != nil {
+				t.Fatalf("Failed to change key: %v", err)
+			}
+			for _, shardKey := range shardKeys {
+				if _, ok := server.Get(shardKey); ok {
+					t.Errorf("Expected %s to be deleted", shardKey)
+				}
+			}
+
+			// Reads fall back to the original key rather than the stale shards
+			value, err := w.Get(ctx, "hot").Result()
+			if tt.expected == "" {
+				if err != redis.Nil {
+					t.Errorf("Expected redis.Nil for a deleted key, got '%s' (err: %v)", value, err)
+				}
+			} else if err != nil || value != tt.expected {
+				t.Errorf("Expected '%s', got '%s' (err: %v)", tt.expected, value, err)
+			}
+		})
+	}
+}
+
+func BenchmarkWrapper_ApplyPolicyIfHot(b *testing.B) {
+	// Dynamic mode, where a hot check looks up the key among the top K
+	testutil.StartKeyFlare(b, detector.Config{TopK: 100, Capacity: 1000}, testutil.LocalCachePolicyConfig("whitelisted"))
+
+	w, err := Wrap(nil)
+	if err != nil {
+		b.Fatalf("Failed to wrap client: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		w.kf.Detector().Increment(fmt.Sprintf("key:%d", i), uint64(i%50+1))
+	}
+	w.kf.Detector().Increment("hot", 1000)
+	w.kf.Detector().Increment("whitelisted", 1000)
+
+	ctx := context.Background()
+
+	// A hot key without a policy is skipped before the hot check
+	b.Run("NotWhitelisted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			w.applyPolicyIfHot(ctx, "hot", "get", policy.SetRequest{})
+		}
+	})
+
+	// The hot check a key without a policy no longer pays for
+	b.Run("IsHot", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			w.kf.Detector().IsHot("hot")
+		}
+	})
+
+	b.Run("Whitelisted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			w.applyPolicyIfHot(ctx, "whitelisted", "get", policy.SetRequest{})
+		}
+	})
+}
+
+func TestWrapper_SetRetriesShardWrites(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.FailNext("SET", "hot:shard:1", 2)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+		Type: policy.KeySplitting,
+		Parameters: policy.KeySplittingConfig{
+			Shards:              3,
+			ReplicationAttempts: 3,
+			ReplicationBackoff:  time.Millisecond,
+		},
+		WhitelistKeys: []string{"hot"},
+	})
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	if err := w.Set(context.Background(), "hot", "value", time.Minute).Err(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+
+	// The shard write failing twice lands on the third attempt
+	testutil.Eventually(t, func() bool {
+		value, ok := server.Get("hot:shard:1")
+		return ok && value == "value"
+	})
+	if calls := server.Calls("SET", "hot:shard:1"); calls != 3 {
+		t.Errorf("Expected 3 shard write attempts, got %d", calls)
+	}
+}
+
+func TestWrapper_SetReplicatesAfterCancel(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Delay("SET", "hot:shard:0", 50*time.Millisecond)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+		Type:          policy.KeySplitting,
+		Parameters:    policy.KeySplittingConfig{Shards: 3},
+		WhitelistKeys: []string{"hot"},
+	})
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// The request is canceled while the first shard is being written
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := w.Set(ctx, "hot", "value", time.Minute).Err(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+	cancel()
+
+	for i := 0; i < 3; i++ {
+		shardKey := policy.ShardKey("hot", i)
+		testutil.Eventually(t, func() bool {
+			value, ok := server.Get(shardKey)
+			return ok && value == "value"
+		})
+	}
+}
+
+func TestWrapper_SetDropsSupersededShardWrites(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.FailNext("SET", "hot:shard:1", 1)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+		Type: policy.KeySplitting,
+		Parameters: policy.KeySplittingConfig{
+			Shards:              3,
+			ReplicationAttempts: 3,
+			ReplicationBackoff:  50 * time.Millisecond,
+		},
+		WhitelistKeys: []string{"hot"},
+	})
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// The first value fails to be written to a shard and is waiting to be retried
+	ctx := context.Background()
+	if err := w.Set(ctx, "hot", "v1", time.Minute).Err(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+	testutil.Eventually(t, func() bool { return server.Calls("SET", "hot:shard:1") == 1 })
+
+	if err := w.Set(ctx, "hot", "v2", time.Minute).Err(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+	testutil.Eventually(t, func() bool {
+		value, _ := server.Get("hot:shard:1")
+		return value == "v2"
+	})
+
+	// The retry of the superseded value is dropped instead of overwriting the shard
+	time.Sleep(100 * time.Millisecond)
+	if value, _ := server.Get("hot:shard:1"); value != "v2" {
+		t.Errorf("Expected the shard to keep the newer value v2, got %s", value)
+	}
+	if calls := server.Calls("SET", "hot:shard:1"); calls != 2 {
+		t.Errorf("Expected 2 shard writes, got %d", calls)
+	}
+}
+
+func TestWrapper_Warmup(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("hot", "value")
+	server.Set("cold", "value")
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, testutil.LocalCachePolicyConfig("hot", "missing"))
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := w.Warmup(ctx, "hot", "cold", "missing"); err != nil {
+		t.Fatalf("Failed to warm up: %v", err)
+	}
+
+	// Keys without a policy aren't fetched
+	if calls := server.Calls("GET", "cold"); calls != 0 {
+		t.Errorf("Expected no fetch of a key without a policy, got %d", calls)
+	}
+
+	// The first read is served from the local cache
+	value, err := w.Get(ctx, "hot").Result()
+	if err != nil || value != "value" {
+		t.Fatalf("Expected 'value', got '%s' (err: %v)", value, err)
+	}
+	if calls := server.Calls("GET", "hot"); calls != 1 {
+		t.Errorf("Expected only the warmup fetch to reach the backend, got %d", calls)
+	}
+}
+
+func TestWrapper_CapToBackendTTL(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("hot", "value")
+	server.SetTTL("hot", 10*time.Second)
+	policyConfig := testutil.LocalCachePolicyConfig("hot", "written")
+	params := policyConfig.Parameters.(policy.LocalCacheConfig)
+	params.CapToBackendTTL = true
+	policyConfig.Parameters = params
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policyConfig)
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// The miss populates the local cache with the remaining TTL of the key in Redis
+	ctx := context.Background()
+	if err := w.Get(ctx, "hot").Err(); err != nil {
+		t.Fatalf("Failed to read key: %v", err)
+	}
+	if err := w.Set(ctx, "written", "value", 5*time.Second).Err(); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	expirations := make(map[string]time.Time)
+	testutil.Eventually(t, func() bool {
+		items, _ := w.kf.PolicyManager().CacheItems(0)
+		for _, item := range items {
+			expirations[item.Key] = item.Expiration
+		}
+		return len(expirations) == 2
+	})
+
+	// The local TTL is 60 seconds, but the keys expire sooner in Redis
+	for key, ttl := range map[string]time.Duration{"hot": 10 * time.Second, "written": 5 * time.Second} {
+		if remaining := time.Until(expirations[key]); remaining > ttl || remaining < ttl-time.Second {
+			t.Errorf("Expected '%s' to expire locally in %v, got %v", key, ttl, remaining)
+		}
+	}
+}
+
+func TestSharedDetector(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	options := SharedDetectorOptions{TopK: 2, FlushInterval: time.Hour}
+
+	// Two instances of a fleet share the counts through the same server
+	d1 := NewSharedDetector(newTestClient(t, server), options)
+	d2 := NewSharedDetector(newTestClient(t, server), options)
+	t.Cleanup(d1.Close)
+	t.Cleanup(d2.Close)
+
+	var _ keyflare.Detector = d1
+
+	ctx := context.Background()
+	d1.Increment(ctx, "a", 3, "read")
+	d1.Increment(ctx, "b", 1, "read")
+	d2.Increment(ctx, "a", 2, "read")
+	d2.Increment(ctx, "c", 4, "write")
+
+	// Nothing is shared before a flush
+	if topK := d1.TopK(); len(topK) != 0 {
+		t.Errorf("Expected no keys before flushing, got %v", topK)
+	}
+
+	for _, d := range []*SharedDetector{d1, d2, d1} {
+		if err := d.Flush(ctx); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+	}
+
+	// Both instances see each other's increments
+	for i, d := range []*SharedDetector{d1, d2} {
+		expected := []keyflare.KeyCount{{Key: "a", Count: 5}, {Key: "c", Count: 4}}
+		if topK := d.TopK(); !slices.Equal(topK, expected) {
+			t.Errorf("Expected detector %d to report %v, got %v", i+1, expected, topK)
+		}
+		if total := d.TotalCount(); total != 10 {
+			t.Errorf("Expected detector %d to report a total of 10, got %d", i+1, total)
+		}
+		if !d.IsHot("a") || !d.IsHot("c") || d.IsHot("b") {
+			t.Errorf("Expected detector %d to consider only the shared top 2 keys hot", i+1)
+		}
+		if count := d.GetCount("a"); count != 5 {
+			t.Errorf("Expected detector %d to count a 5 times, got %d", i+1, count)
+		}
+	}
+
+	// Resetting clears the shared counts
+	d1.Reset()
+	if err := d2.Flush(ctx); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if topK := d2.TopK(); len(topK) != 0 || d2.TotalCount() != 0 {
+		t.Errorf("Expected no keys after a reset, got %v with a total of %d", topK, d2.TotalCount())
+	}
+}
+
+func TestSharedDetector_TrimsColdKeys(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	d := NewSharedDetector(newTestClient(t, server), SharedDetectorOptions{TopK: 1, Capacity: 2, FlushInterval: time.Hour})
+	t.Cleanup(d.Close)
+
+	ctx := context.Background()
+	d.Increment(ctx, "hot", 10, "read")
+	d.Increment(ctx, "warm", 5, "read")
+	d.Increment(ctx, "cold", 1, "read")
+	if err := d.Flush(ctx); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	if _, ok := server.ZScore(DefaultSharedDetectorKey, "cold"); ok {
+		t.Error("Expected the coldest key to be trimmed from the shared set")
+	}
+	if score, ok := server.ZScore(DefaultSharedDetectorKey, "warm"); !ok || score != 5 {
+		t.Errorf("Expected warm to be kept with a count of 5, got %v", score)
+	}
+	if topK := d.TopK(); len(topK) != 1 || topK[0].Key != "hot" {
+		t.Errorf("Expected only hot in the top 1, got %v", topK)
+	}
+}
+
+func TestSharedDetector_KeepsIncrementsOnFailure(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	d := NewSharedDetector(newTestClient(t, server), SharedDetectorOptions{FlushInterval: time.Hour})
+	t.Cleanup(d.Close)
+
+	ctx := context.Background()
+	d.Increment(ctx, "a", 3, "read")
+	d.Increment(ctx, "b", 2, "read")
+
+	// Only one of the increments fails
+	server.FailNext("ZINCRBY", DefaultSharedDetectorKey, 1)
+	if err := d.Flush(ctx); err == nil {
+		t.Fatal("Expected the flush to fail")
+	}
+
+	// Only the failed increment is sent again by the next flush
+	if err := d.Flush(ctx); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if a, b := d.GetCount("a"), d.GetCount("b"); a != 3 || b != 2 {
+		t.Errorf("Expected counts of 3 and 2 after retrying, got %d and %d", a, b)
+	}
+	if total := d.TotalCount(); total != 5 {
+		t.Errorf("Expected a total of 5 after retrying, got %d", total)
+	}
+}
+
+func TestSharedDetector_DecayMakesRoomForNewKeys(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	client := newTestClient(t, server)
+	options := SharedDetectorOptions{TopK: 1, Capacity: 2, FlushInterval: time.Hour, DecayFactor: 0.1}
+	d1 := NewSharedDetector(client, options)
+	d2 := NewSharedDetector(client, options)
+	t.Cleanup(d1.Close)
+	t.Cleanup(d2.Close)
+
+	ctx := context.Background()
+	d1.Increment(ctx, "old", 100, "read")
+	d1.Increment(ctx, "warm", 50, "read")
+	if err := d1.Flush(ctx); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	// Without decay, a new key is trimmed from the full set before it can catch up
+	d1.Increment(ctx, "new", 20, "read")
+	if err := d1.Flush(ctx); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if _, ok := server.ZScore(DefaultSharedDetectorKey, "new"); ok {
+		t.Fatal("Expected the new key to be trimmed before decay")
+	}
+
+	// The counts decay once per interval, however many instances try
+	for _, d := range []*SharedDetector{d1, d2} {
+		if err := d.Decay(ctx); err != nil {
+			t.Fatalf("Failed to decay: %v", err)
+		}
+	}
+	if score, _ := server.ZScore(DefaultSharedDetectorKey, "old"); score != 10 {
+		t.Errorf("Expected old to decay once to 10, got %v", score)
+	}
+
+	d1.Increment(ctx, "new", 20, "read")
+	if err := d1.Flush(ctx); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if !d1.IsHot("new") || d1.IsHot("old") {
+		t.Errorf("Expected the new key to replace old as the hottest, got %v", d1.TopK())
+	}
+	if total := d1.TotalCount(); total != 37 {
+		t.Errorf("Expected a decayed total of 37, got %d", total)
+	}
+}
@@ -0,0 +1,106 @@
+package rueidis
+
+// keySpec describes the positions of the keys of a command like the key specs of
+// Redis's COMMAND: keys are the arguments from first to last, every step arguments.
+// A negative last counts from the end, -1 being the last argument.
+// A zero step means the command doesn't access keys.
+type keySpec struct {
+	first int
+	last  int
+	step  int
+}
+
+// keys returns the keys of a command according to the spec.
+func (s keySpec) keys(commands []string) []string {
+	if s.step <= 0 || s.first >= len(commands) {
+		return nil
+	}
+
+	last := s.last
+	if last < 0 {
+		last += len(commands)
+	}
+	last = min(last, len(commands)-1)
+	if last < s.first {
+		return nil
+	}
+
+	keys := make([]string, 0, (last-s.first)/s.step+1)
+	for i := s.first; i <= last; i += s.step {
+		keys = append(keys, commands[i])
+	}
+	return keys
+}
+
+var (
+	// singleKey is the spec of commands whose only key is their first argument
+	singleKey = keySpec{first: 1, last: 1, step: 1}
+
+	// allKeys is the spec of commands whose arguments are all keys
+	allKeys = keySpec{first: 1, last: -1, step: 1}
+
+	// noKeys is the spec of commands that don't access keys
+	noKeys = keySpec{}
+)
+
+// keySpecs are the key specs of standard commands with other keys than their first argument,
+// or without keys. Commands missing from the table access their first argument only.
+var keySpecs = map[string]keySpec{
+	// Strings and generic commands
+	"MGET":      allKeys,
+	"MSET":      {first: 1, last: -1, step: 2},
+	"MSETNX":    {first: 1, last: -1, step: 2},
+	"DEL":       allKeys,
+	"UNLINK":    allKeys,
+	"EXISTS":    allKeys,
+	"TOUCH":     allKeys,
+	"WATCH":     allKeys,
+	"RENAME":    {first: 1, last: 2, step: 1},
+	"RENAMENX":  {first: 1, last: 2, step: 1},
+	"COPY":      {first: 1, last: 2, step: 1},
+	"BITOP":     {first: 2, last: -1, step: 1},
+	"OBJECT":    {first: 2, last: 2, step: 1}, // OBJECT ENCODING key
+	"MEMORY":    {first: 2, last: 2, step: 1}, // MEMORY USAGE key
+	"PFCOUNT":   allKeys,
+	"PFMERGE":   allKeys,
+	"SORT":      singleKey,
+	"SORT_RO":   singleKey,
+	"GEORADIUS": singleKey,
+
+	// Lists
+	"RPOPLPUSH":  {first: 1, last: 2, step: 1},
+	"LMOVE":      {first: 1, last: 2, step: 1},
+	"BLMOVE":     {first: 1, last: 2, step: 1},
+	"BRPOPLPUSH": {first: 1, last: 2, step: 1},
+	"BLPOP":      {first: 1, last: -2, step: 1},
+	"BRPOP":      {first: 1, last: -2, step: 1},
+
+	// Sets and sorted sets
+	"SDIFF":       allKeys,
+	"SINTER":      allKeys,
+	"SUNION":      allKeys,
+	"SDIFFSTORE":  allKeys,
+	"SINTERSTORE": allKeys,
+	"SUNIONSTORE": allKeys,
+	"SMOVE":       {first: 1, last: 2, step: 1},
+	"ZRANGESTORE": {first: 1, last: 2, step: 1},
+	"BZPOPMIN":    {first: 1, last: -2, step: 1},
+	"BZPOPMAX":    {first: 1, last: -2, step: 1},
+
+	// Commands without keys
+	"MULTI":    noKeys,
+	"EXEC":     noKeys,
+	"DISCARD":  noKeys,
+	"PING":     noKeys,
+	"ECHO":     noKeys,
+	"SELECT":   noKeys,
+	"INFO":     noKeys,
+	"CONFIG":   noKeys,
+	"CLIENT":   noKeys,
+	"CLUSTER":  noKeys,
+	"PUBLISH":  noKeys,
+	"SCRIPT":   noKeys,
+	"FUNCTION": noKeys,
+	"DBSIZE":   noKeys,
+	"TIME":     noKeys,
+}
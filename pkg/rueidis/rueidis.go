@@ -3,17 +3,27 @@ package rueidis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mingrammer/keyflare/internal"
+	"github.com/mingrammer/keyflare/internal/detector"
+	"github.com/mingrammer/keyflare/internal/policy"
 	"github.com/redis/rueidis"
 )
 
 // Wrapper wraps a rueidis client with KeyFlare hot key detection.
 type Wrapper struct {
-	client rueidis.Client
-	kf     *internal.KeyFlare
+	client   rueidis.Client
+	kf       *internal.KeyFlare
+	disabled atomic.Bool
 }
 
 // Wrap creates a new Rueidis client wrapper with the provided client.
@@ -35,67 +45,718 @@ func (w *Wrapper) Client() rueidis.Client {
 	return w.client
 }
 
+// SetEnabled enables or disables KeyFlare processing on the wrapper.
+// While disabled, keys aren't counted and policies aren't applied, so commands
+// pass straight through to the client. Other wrappers are unaffected.
+func (w *Wrapper) SetEnabled(enabled bool) {
+	w.disabled.Store(!enabled)
+}
+
+// Enabled returns whether KeyFlare processing is enabled on the wrapper.
+func (w *Wrapper) Enabled() bool {
+	return !w.disabled.Load()
+}
+
 // extractKeyFromCommand attempts to extract the key from a Redis command.
 // It uses the Commands() method which returns the command as a slice of strings.
 // For most Redis commands, the key is at index 1 (after the command name).
 func extractKeyFromCommand(cmd rueidis.Completed) string {
-	commands := cmd.Commands()
-	if len(commands) > 1 {
-		return commands[1] // Key is typically at index 1
-	}
-	return "" // No key found
+	return firstKey(cmd.Commands())
+}
+
+// extractKeysFromCommand extracts every key accessed by a Redis command,
+// such as all the keys of MGET or every other argument of MSET.
+func extractKeysFromCommand(cmd rueidis.Completed) []string {
+	return commandKeys(cmd.Commands())
 }
 
 // extractKeyFromCacheable attempts to extract the key from a cacheable command.
 func extractKeyFromCacheable(cmd rueidis.Cacheable) string {
 	// Cacheable commands also have Commands() method
-	commands := cmd.Commands()
-	if len(commands) > 1 {
-		return commands[1]
+	return firstKey(cmd.Commands())
+}
+
+// firstKey returns the first key accessed by a command, or an empty string if it has none.
+func firstKey(commands []string) string {
+	if keys := commandKeys(commands); len(keys) > 0 {
+		return keys[0]
+	}
+	return "" // No key found
+}
+
+// commandName returns the upper-cased name of a Redis command.
+func commandName(commands []string) string {
+	if len(commands) == 0 {
+		return ""
+	}
+	return strings.ToUpper(commands[0])
+}
+
+// KeyExtractor returns the keys accessed by a command, given as its name followed by its arguments.
+type KeyExtractor func(commands []string) []string
+
+// KeyAt returns a KeyExtractor for commands whose only key is the argument at index
+// (the command name is at index 0).
+func KeyAt(index int) KeyExtractor {
+	return func(commands []string) []string {
+		if index <= 0 || index >= len(commands) {
+			return nil
+		}
+		return commands[index : index+1]
+	}
+}
+
+var (
+	keyExtractorsMu sync.RWMutex
+
+	// keyExtractors are the key extractors of commands whose keys can't be described by
+	// a key spec, such as commands giving the number of keys as an argument
+	// They take precedence over the key specs
+	keyExtractors = map[string]KeyExtractor{
+		"EVAL":        scriptKeys,
+		"EVALSHA":     scriptKeys,
+		"EVAL_RO":     scriptKeys,
+		"EVALSHA_RO":  scriptKeys,
+		"FCALL":       scriptKeys,
+		"FCALL_RO":    scriptKeys,
+		"SINTERCARD":  numKeys(1),
+		"ZUNION":      numKeys(1),
+		"ZINTER":      numKeys(1),
+		"ZDIFF":       numKeys(1),
+		"ZINTERCARD":  numKeys(1),
+		"LMPOP":       numKeys(1),
+		"ZMPOP":       numKeys(1),
+		"BLMPOP":      numKeys(2),
+		"BZMPOP":      numKeys(2),
+		"ZUNIONSTORE": storeKeys,
+		"ZINTERSTORE": storeKeys,
+		"ZDIFFSTORE":  storeKeys,
+		"XREAD":       streamKeys,
+		"XREADGROUP":  streamKeys,
+	}
+)
+
+// RegisterKeyExtractor overrides how the keys of a command are extracted, for commands
+// whose key isn't their first argument (e.g. module commands). The command name is case-insensitive.
+// It applies to every wrapper and should be called before the wrappers are used.
+func RegisterKeyExtractor(command string, extract KeyExtractor) {
+	keyExtractorsMu.Lock()
+	defer keyExtractorsMu.Unlock()
+
+	keyExtractors[strings.ToUpper(command)] = extract
+}
+
+// commandKeys returns the keys accessed by a command.
+// Multi-key commands such as MGET and DEL return every key, and script commands return their KEYS.
+// Keys are found by the registered key extractors, then by the key specs of standard commands,
+// and are otherwise assumed to be the first argument.
+func commandKeys(commands []string) []string {
+	if len(commands) < 2 {
+		return nil
+	}
+
+	name := commandName(commands)
+	keyExtractorsMu.RLock()
+	extract, ok := keyExtractors[name]
+	keyExtractorsMu.RUnlock()
+	if ok {
+		return extract(commands)
+	}
+	if spec, ok := keySpecs[name]; ok {
+		return spec.keys(commands)
+	}
+	return commands[1:2]
+}
+
+// numKeys returns a KeyExtractor for commands giving the number of keys at index,
+// followed by the keys.
+func numKeys(index int) KeyExtractor {
+	return func(commands []string) []string {
+		if index+1 >= len(commands) {
+			return nil
+		}
+		n, err := strconv.Atoi(commands[index])
+		if err != nil || n <= 0 || index+1+n > len(commands) {
+			return nil
+		}
+		return commands[index+1 : index+1+n]
+	}
+}
+
+// storeKeys returns the keys of a command storing into its first argument the result of
+// numkeys source keys, such as ZUNIONSTORE.
+func storeKeys(commands []string) []string {
+	sources := numKeys(2)(commands)
+	if sources == nil {
+		return commands[1:2]
+	}
+	return append([]string{commands[1]}, sources...)
+}
+
+// streamKeys returns the keys of a stream read, given after STREAMS followed by as many IDs.
+func streamKeys(commands []string) []string {
+	for i, arg := range commands {
+		if strings.EqualFold(arg, "STREAMS") {
+			args := commands[i+1:]
+			return args[:len(args)/2]
+		}
+	}
+	return nil
+}
+
+// scriptKeys returns the keys passed to a script command, given as numkeys followed by the keys.
+func scriptKeys(commands []string) []string {
+	if len(commands) < 3 {
+		return nil
+	}
+	numKeys, err := strconv.Atoi(commands[2])
+	if err != nil || numKeys <= 0 || 3+numKeys > len(commands) {
+		return nil
+	}
+	return commands[3 : 3+numKeys]
+}
+
+// parseSetExpiration extracts the expiration from the options of a SET command.
+// It returns 0 (no expiration) if neither EX nor PX is given.
+func parseSetExpiration(commands []string) time.Duration {
+	for i := 3; i < len(commands)-1; i++ {
+		value, err := strconv.ParseInt(commands[i+1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch strings.ToUpper(commands[i]) {
+		case "EX":
+			return time.Duration(value) * time.Second
+		case "PX":
+			return time.Duration(value) * time.Millisecond
+		}
+	}
+	return 0
+}
+
+// commandOperation returns whether a command reads or writes its key.
+func commandOperation(cmd rueidis.Completed) detector.Operation {
+	if cmd.IsReadOnly() {
+		return detector.OpRead
+	}
+	return detector.OpWrite
+}
+
+// SendToReplicas returns a function for rueidis.ClientOption.SendToReplicas that
+// routes read-only commands on hot keys to replicas, offloading the primary.
+// Other commands are routed by fallback, or to the primary if fallback is nil.
+// The global KeyFlare instance is looked up on each command, so the client can be
+// created before KeyFlare is started.
+func SendToReplicas(fallback func(cmd rueidis.Completed) bool) func(cmd rueidis.Completed) bool {
+	return func(cmd rueidis.Completed) bool {
+		if cmd.IsReadOnly() {
+			if key := extractKeyFromCommand(cmd); key != "" {
+				if kf, err := internal.GetInstance(); err == nil && kf.Detector().IsHot(kf.NormalizeKey(key)) {
+					return true
+				}
+			}
+		}
+		if fallback != nil {
+			return fallback(cmd)
+		}
+		return false
 	}
-	return ""
 }
 
 // incrementKey increments the key counter in the detector.
-func (w *Wrapper) incrementKey(key string) {
-	if key != "" { // Only track non-empty keys
-		w.kf.Detector().Increment(key, 1)
+func (w *Wrapper) incrementKey(ctx context.Context, key string, op detector.Operation) {
+	if key == "" || w.disabled.Load() { // Only track non-empty keys
+		return
 	}
+	if key = w.kf.NormalizeKey(key); w.kf.Countable(key) {
+		w.kf.Detector().IncrementOp(ctx, key, 1, op)
+	}
+}
+
+// incrementKeys increments the counters of all keys of a command.
+func (w *Wrapper) incrementKeys(ctx context.Context, keys []string, op detector.Operation) {
+	for _, key := range keys {
+		w.incrementKey(ctx, key, op)
+	}
+}
+
+// Observe records an access to key by a command the wrapper doesn't wrap,
+// such as a Lua script or a module command, so that it's counted by the detector.
+// op is "read" or "write"; other values are counted without a read/write breakdown.
+// It returns whether the key is hot, so callers can branch on it.
+func (w *Wrapper) Observe(key string, op string) bool {
+	w.incrementKey(context.Background(), key, detector.ParseOperation(op))
+	return w.kf.Detector().IsHot(w.kf.NormalizeKey(key))
+}
+
+// KeyStatus returns how hot key currently is: its rank among the top K keys (1 for the
+// hottest, 0 if it isn't one of them), its estimated count and whether it's hot, so
+// application code can vary behavior by rank, e.g. only handle the top 3 keys specially.
+func (w *Wrapper) KeyStatus(key string) (rank int, count uint64, hot bool) {
+	return w.kf.Detector().KeyStatus(w.kf.NormalizeKey(key))
+}
+
+// applyPolicyIfHot applies the policy if the key is hot.
+// The set request is applied for set operations.
+// Unless FailClosed is set, a policy error is logged and reported as no policy result.
+func (w *Wrapper) applyPolicyIfHot(ctx context.Context, key string, operation string, set policy.SetRequest) (data any, err error) {
+	if w.disabled.Load() {
+		return nil, nil
+	}
+	key = w.kf.NormalizeKey(key)
+	var hot, applied bool
+	if w.kf.Debug() {
+		defer func() { w.kf.RecordDecision(internal.NewDecision(key, operation, hot, applied, data)) }()
+	}
+
+	// Keys without a policy are skipped before the costlier hot check
+	p := w.kf.PolicyManager().GetPolicy(key)
+	if p != nil {
+		if hot = key != "" && w.kf.Detector().IsHot(key); hot {
+			var requestData any
+			switch operation {
+			case "get":
+				requestData = policy.GetRequest{RoutingKey: policy.RoutingKey(ctx)}
+			case "set":
+				requestData = set
+			default:
+				return nil, nil
+			}
+
+			// Bypass the policy while the breaker is open after repeated errors
+			if !w.kf.Breaker().Allow() {
+				return nil, nil
+			}
+			result := p.Apply(policy.Context{
+				Key:   key,
+				Data:  requestData,
+				Count: w.kf.Detector().GetCount(key),
+			})
+			w.kf.Breaker().Record(result.Error)
+			applied = true
+			if result.Error != nil {
+				if w.kf.FailClosed() {
+					return nil, fmt.Errorf("failed to apply policy for key %s: %w", key, result.Error)
+				}
+				// Fall back to the plain backend call
+				log.Printf("Failed to apply policy for key %s, falling back to Redis: %v", key, result.Error)
+				return nil, nil
+			}
+			return result.Data, nil
+		}
+	}
+
+	// Keys without a policy skip the hot check, so it's only made for the trace
+	if p == nil && w.kf.Debug() {
+		hot = key != "" && w.kf.Detector().IsHot(key)
+	}
+	return nil, nil
 }
 
 // Do wraps rueidis.Client.Do.
+// GET and SET commands on hot keys are served according to the configured policy,
+// and the keys of other write commands are evicted from the local cache.
 func (w *Wrapper) Do(
 	ctx context.Context, cmd rueidis.Completed,
 ) rueidis.RedisResult {
-	// Extract and track key automatically using Commands() method
+	// Extract and track keys automatically using Commands() method
+	commands := cmd.Commands()
+	keys := commandKeys(commands)
+	op := commandOperation(cmd)
+	w.incrementKeys(ctx, keys, op)
+
 	key := extractKeyFromCommand(cmd)
-	w.incrementKey(key)
+	switch commandName(commands) {
+	case "GET":
+		return w.handleGet(ctx, key, func() rueidis.RedisResult {
+			return w.client.Do(ctx, cmd)
+		})
+	case "SET":
+		return w.handleSet(ctx, key, parseSetExpiration(commands), cmd)
+	}
 
-	return w.client.Do(ctx, cmd)
+	if op != detector.OpWrite {
+		return w.client.Do(ctx, cmd)
+	}
+	// The keys are copied before the command is sent, since rueidis recycles sent commands
+	keys = slices.Clone(keys)
+	result := w.client.Do(ctx, cmd)
+	for _, key := range keys {
+		w.invalidateLocalCache(ctx, key)
+	}
+	return result
 }
 
 // DoCache wraps rueidis.Client.DoCache.
+// GET commands on hot keys are served according to the configured policy.
 func (w *Wrapper) DoCache(
 	ctx context.Context, cmd rueidis.Cacheable, ttl time.Duration,
 ) rueidis.RedisResult {
-	// Extract and track key automatically using Commands() method
+	// Extract and track keys automatically using Commands() method
+	w.incrementKeys(ctx, commandKeys(cmd.Commands()), detector.OpRead)
+
 	key := extractKeyFromCacheable(cmd)
-	w.incrementKey(key)
+	if commandName(cmd.Commands()) == "GET" {
+		return w.handleGet(ctx, key, func() rueidis.RedisResult {
+			return w.client.DoCache(ctx, cmd, ttl)
+		})
+	}
 
 	return w.client.DoCache(ctx, cmd, ttl)
 }
 
+// handleGet applies the policy to a GET command.
+// fetch executes the original command against Redis.
+func (w *Wrapper) handleGet(
+	ctx context.Context, key string, fetch func() rueidis.RedisResult,
+) rueidis.RedisResult {
+	start := time.Now()
+	var local bool
+	defer func() { w.kf.Metrics().RecordOperationDuration("get", local, time.Since(start)) }()
+
+	policyResult, err := w.applyPolicyIfHot(ctx, key, "get", policy.SetRequest{})
+	if err != nil {
+		return w.errorResult(ctx, err)
+	}
+	if policyResult == nil {
+		return fetch()
+	}
+
+	// Handle different policy types
+	switch result := policyResult.(type) {
+	case policy.CacheHit:
+		// Local cache hit, the cached value is the result of a previous read
+		if cached, ok := result.Value.(cachedResult); ok {
+			local = true
+			return cached.RedisResult
+		}
+		// A value written through by a SET isn't a result, so it's replaced by one
+		return w.fetchAndCache(key, fetch)
+	case policy.CacheMiss:
+		// Cache miss, get from Redis and async set to cache
+		return w.fetchAndCache(key, fetch)
+	case policy.KeySplittingGetAction:
+		// Look-aside key splitting: try shard first, fallback to original
+		return w.handleLookAsideGet(ctx, key, result, fetch)
+	}
+
+	return fetch()
+}
+
+// errorResult returns a RedisResult failed with err without sending anything to Redis
+// rueidis has no constructor for failed results, so a PING is issued with a context
+// that is already done with err, which the client returns as the result's error
+func (w *Wrapper) errorResult(ctx context.Context, err error) rueidis.RedisResult {
+	return w.client.Do(failedContext{Context: ctx, err: err}, w.client.B().Ping().Build())
+}
+
+// failedContext is a context that is already done with err
+type failedContext struct {
+	context.Context
+	err error
+}
+
+// closedDone is the Done channel of every failedContext
+var closedDone = func() chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}()
+
+// Done returns a closed channel
+func (c failedContext) Done() <-chan struct{} {
+	return closedDone
+}
+
+// Err returns the error the context failed with
+func (c failedContext) Err() error {
+	return c.err
+}
+
+// handleSet applies the policy to a SET command.
+// For hot keys the written value is passed to the policy once Redis acknowledged it,
+// so shards of split keys are written with it. rueidis results can't be built from a
+// value, so a value written through to the local cache is replaced by the result of
+// the next read. SETs with the GET option don't tell whether they wrote, so they only
+// evict the key from the local cache.
+func (w *Wrapper) handleSet(
+	ctx context.Context, key string, ttl time.Duration, cmd rueidis.Completed,
+) rueidis.RedisResult {
+	start := time.Now()
+	defer func() { w.kf.Metrics().RecordOperationDuration("set", false, time.Since(start)) }()
+
+	// Keys without a policy are skipped before the costlier hot check
+	normalized := w.kf.NormalizeKey(key)
+	if key == "" || w.disabled.Load() || w.kf.PolicyManager().GetPolicy(normalized) == nil || !w.kf.Detector().IsHot(normalized) {
+		if key != "" && !w.disabled.Load() && w.kf.Debug() {
+			w.kf.RecordDecision(internal.NewDecision(normalized, "set", w.kf.Detector().IsHot(normalized), false, nil))
+		}
+		return w.client.Do(ctx, cmd)
+	}
+
+	// The value is read before the command is sent, since rueidis recycles sent commands
+	commands := cmd.Commands()
+	if len(commands) < 3 {
+		// A SET without a value is left for Redis to reject
+		return w.client.Do(ctx, cmd)
+	}
+	if slices.ContainsFunc(commands[3:], func(arg string) bool { return strings.EqualFold(arg, "GET") }) {
+		defer w.invalidateLocalCache(ctx, key)
+		return w.client.Do(ctx, cmd)
+	}
+	value := commands[2]
+
+	// The TTL of the written key is read within the same round trip to cap its local TTL
+	multi := []rueidis.Completed{cmd}
+	capTTL := w.kf.CapToBackendTTL()
+	if capTTL {
+		multi = append(multi, w.client.B().Pttl().Key(key).Build())
+	}
+	results := w.client.DoMulti(ctx, multi...)
+	written := results[0]
+	if reply, err := written.ToString(); err != nil || reply != "OK" {
+		// The write failed, or a conditional SET (NX, XX) didn't write
+		return written
+	}
+
+	set := policy.SetRequest{Value: value}
+	if capTTL {
+		set.MaxTTL, _ = pttlSeconds(results[1])
+	}
+	policyResult, err := w.applyPolicyIfHot(ctx, key, "set", set)
+	if err != nil {
+		// The write succeeded, so a failure to apply the policy doesn't fail it
+		log.Printf("Failed to apply policy for key %s after setting it: %v", key, err)
+		return written
+	}
+
+	if action, ok := policyResult.(policy.KeySplittingSetAction); ok {
+		// Asynchronously write to all target shards, even after the request is canceled
+		superseded := w.kf.StartReplication(action.OriginalKey)
+		go w.replicateToShards(context.WithoutCancel(ctx), action.ShardKeys, value, ttl, action.TTLJitter, time.Time{}, action.Retry, superseded)
+	}
+
+	return written
+}
+
+// invalidateLocalCache evicts the key from the local cache, if any, or deletes
+// the shards of a split key, after it was deleted or changed in Redis
+// Reads of deleted shards fall back to the original key until the next SET
+func (w *Wrapper) invalidateLocalCache(ctx context.Context, key string) {
+	normalized := w.kf.NormalizeKey(key)
+	p := w.kf.PolicyManager().GetPolicy(normalized)
+	if p == nil {
+		return
+	}
+
+	result := p.Apply(policy.Context{
+		Key:  normalized,
+		Data: policy.DeleteRequest{},
+	})
+	if action, ok := result.Data.(policy.KeySplittingDeleteAction); ok && len(action.ShardKeys) > 0 {
+		// The shards are deleted separately since they may be in different cluster slots
+		dels := make([]rueidis.Completed, len(action.ShardKeys))
+		for i, shardKey := range action.ShardKeys {
+			dels[i] = w.client.B().Del().Key(shardKey).Build()
+		}
+		for _, reply := range w.client.DoMulti(ctx, dels...) {
+			if reply.Error() != nil {
+				w.kf.Metrics().RecordReplicationError()
+			}
+		}
+	}
+}
+
+// fetchAndCache reads the key from Redis and caches the result asynchronously
+func (w *Wrapper) fetchAndCache(key string, fetch func() rueidis.RedisResult) rueidis.RedisResult {
+	redisResult := fetch()
+	if redisResult.Error() == nil {
+		w.kf.PopulateAsync(w.kf.NormalizeKey(key), func() {
+			w.asyncSetLocalCache(key, redisResult)
+		})
+	}
+	return redisResult
+}
+
+// asyncSetLocalCache asynchronously sets the result in local cache
+func (w *Wrapper) asyncSetLocalCache(key string, result rueidis.RedisResult) {
+	// Get policy manager and try to cache regardless of hot key status
+	// This ensures cache miss data gets cached for future hits
+	normalized := w.kf.NormalizeKey(key)
+	p := w.kf.PolicyManager().GetPolicy(normalized)
+	if p != nil {
+		req := policy.SetRequest{Value: newCachedResult(result)}
+		if w.kf.CapToBackendTTL() {
+			ttl, ok := pttlSeconds(w.client.Do(context.Background(), w.client.B().Pttl().Key(key).Build()))
+			if !ok {
+				return
+			}
+			req.MaxTTL = ttl
+		}
+
+		ctx := policy.Context{
+			Key:  normalized,
+			Data: req,
+		}
+		result := p.Apply(ctx)
+		_ = result // Cache set operation completed
+	}
+}
+
+// cachedResult is a result held by the local cache along with the size of its value,
+// which the local cache can't read from the result itself
+type cachedResult struct {
+	rueidis.RedisResult
+	size int
+}
+
+// newCachedResult wraps a result for the local cache
+func newCachedResult(result rueidis.RedisResult) cachedResult {
+	value, _ := result.ToString()
+	return cachedResult{RedisResult: result, size: len(value)}
+}
+
+// Size returns the size of the cached value, for the memory estimate of the local cache
+func (r cachedResult) Size() int {
+	return r.size
+}
+
+// pttlSeconds returns the TTL in seconds of a PTTL result, or 0 if the key doesn't expire.
+// It returns false if the key doesn't exist or the TTL can't be read.
+func pttlSeconds(result rueidis.RedisResult) (float64, bool) {
+	ms, err := result.AsInt64()
+	switch {
+	case err != nil || ms == -2:
+		return 0, false
+	case ms < 0:
+		return 0, true
+	default:
+		return float64(ms) / 1000, true
+	}
+}
+
+// replicateToShards writes to shard keys asynchronously.
+// Each shard's TTL is jittered so the shards don't all expire at once,
+// and failed shard writes are retried with backoff before giving up.
+// Writes are dropped once superseded reports that a newer value is being replicated.
+// If expiresAt isn't zero, shard TTLs are capped so the shards don't outlive it.
+func (w *Wrapper) replicateToShards(
+	ctx context.Context, shardKeys []string, value string, ttl time.Duration, jitter float64, expiresAt time.Time,
+	retry policy.RetryConfig, superseded func() bool,
+) {
+	// Write to all shards
+	for _, shardKey := range shardKeys {
+		jittered := policy.JitterTTL(ttl, jitter)
+		err := retry.Do(ctx, func() error {
+			if superseded() {
+				return nil
+			}
+			ttl := jittered
+			if !expiresAt.IsZero() {
+				// The ceiling is taken at each attempt, so retries don't extend the shards
+				remaining := time.Until(expiresAt)
+				if remaining <= 0 {
+					// The original key has expired, there's nothing left to replicate
+					return nil
+				}
+				ttl = min(ttl, remaining)
+			}
+			return w.client.Do(ctx, w.buildSet(shardKey, value, ttl)).Error()
+		})
+		if err != nil {
+			// Reads of the missing shard fall back to the original key
+			w.kf.Metrics().RecordReplicationError()
+		}
+	}
+}
+
+// buildSet builds a SET command with an optional expiration
+func (w *Wrapper) buildSet(key, value string, ttl time.Duration) rueidis.Completed {
+	if ttl > 0 {
+		return w.client.B().Set().Key(key).Value(value).Px(ttl).Build()
+	}
+	return w.client.B().Set().Key(key).Value(value).Build()
+}
+
+// remainingTTL returns the remaining TTL of the key, and false if the key
+// doesn't expire or its TTL can't be obtained
+func (w *Wrapper) remainingTTL(ctx context.Context, key string) (time.Duration, bool) {
+	if ms, err := w.client.Do(ctx, w.client.B().Pttl().Key(key).Build()).AsInt64(); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond, true
+	}
+	return 0, false
+}
+
+// handleLookAsideGet implements look-aside pattern for key splitting
+func (w *Wrapper) handleLookAsideGet(
+	ctx context.Context, key string, action policy.KeySplittingGetAction, fetch func() rueidis.RedisResult,
+) rueidis.RedisResult {
+	// Step 1: Try to read from primary shard
+	w.kf.Detector().IncrementShard(action.OriginalKey, action.ShardIndex)
+	shardResult := w.client.Do(ctx, w.client.B().Get().Key(action.RandShardKey).Build())
+	if shardResult.Error() == nil {
+		// Shard data exists, return it
+		return shardResult
+	}
+
+	// A shard that failed (rather than missed) may be on an unreachable node,
+	// so try another shard before reading the original key
+	if !rueidis.IsRedisNil(shardResult.Error()) && len(action.ShardKeys) > 1 {
+		next := (action.ShardIndex + 1) % len(action.ShardKeys)
+		w.kf.Detector().IncrementShard(action.OriginalKey, next)
+		other := w.client.Do(ctx, w.client.B().Get().Key(action.ShardKeys[next]).Build())
+		if other.Error() == nil {
+			return other
+		}
+	}
+
+	// Step 2: Shard doesn't exist, try original key
+	original := fetch()
+	value, err := original.ToString()
+	if err != nil {
+		// The key doesn't exist (a Redis nil) or can't be read, there's nothing to replicate
+		return original
+	}
+
+	// Step 3: Original data exists, asynchronously replicate to shards
+	// with the remaining TTL of the original key, so shards don't outlive it
+	// Concurrent misses on the key share a single replication
+	// The replication outlives the request, so it isn't canceled with it
+	replicateCtx := context.WithoutCancel(ctx)
+	w.kf.ReplicateAsync(action.OriginalKey, func() {
+		superseded := w.kf.StartReplication(action.OriginalKey)
+		ttl, expiresAt := action.FallbackTTL, time.Time{}
+		if remaining, ok := w.remainingTTL(replicateCtx, key); ok {
+			ttl, expiresAt = remaining, time.Now().Add(remaining)
+		}
+		w.replicateToShards(replicateCtx, action.ShardKeys, value, ttl, action.TTLJitter, expiresAt, action.Retry, superseded)
+	})
+
+	// Return original data immediately
+	return original
+}
+
 // DoMulti wraps rueidis.Client.DoMulti.
+// The keys of write commands are evicted from the local cache.
 func (w *Wrapper) DoMulti(
 	ctx context.Context, multi ...rueidis.Completed,
 ) []rueidis.RedisResult {
 	// Extract and track keys automatically for all commands
+	// The written keys are copied, since rueidis recycles sent commands
+	var written []string
 	for _, cmd := range multi {
-		key := extractKeyFromCommand(cmd)
-		w.incrementKey(key)
+		keys, op := extractKeysFromCommand(cmd), commandOperation(cmd)
+		w.incrementKeys(ctx, keys, op)
+		if op == detector.OpWrite {
+			written = append(written, keys...)
+		}
 	}
 
-	return w.client.DoMulti(ctx, multi...)
+	results := w.client.DoMulti(ctx, multi...)
+	for _, key := range written {
+		w.invalidateLocalCache(ctx, key)
+	}
+	return results
 }
 
 // DoMultiCache wraps rueidis.Client.DoMultiCache.
@@ -104,8 +765,7 @@ func (w *Wrapper) DoMultiCache(
 ) []rueidis.RedisResult {
 	// Extract and track keys automatically for all cacheable commands
 	for _, cacheable := range multi {
-		key := extractKeyFromCacheable(cacheable.Cmd)
-		w.incrementKey(key)
+		w.incrementKeys(ctx, commandKeys(cacheable.Cmd.Commands()), detector.OpRead)
 	}
 
 	return w.client.DoMultiCache(ctx, multi...)
@@ -115,9 +775,8 @@ func (w *Wrapper) DoMultiCache(
 func (w *Wrapper) DoStream(
 	ctx context.Context, cmd rueidis.Completed,
 ) rueidis.RedisResultStream {
-	// Extract and track key automatically
-	key := extractKeyFromCommand(cmd)
-	w.incrementKey(key)
+	// Extract and track keys automatically
+	w.incrementKeys(ctx, extractKeysFromCommand(cmd), commandOperation(cmd))
 
 	return w.client.DoStream(ctx, cmd)
 }
@@ -128,13 +787,58 @@ func (w *Wrapper) DoMultiStream(
 ) rueidis.MultiRedisResultStream {
 	// Extract and track keys automatically for all commands
 	for _, cmd := range multi {
-		key := extractKeyFromCommand(cmd)
-		w.incrementKey(key)
+		w.incrementKeys(ctx, extractKeysFromCommand(cmd), commandOperation(cmd))
 	}
 
 	return w.client.DoMultiStream(ctx, multi...)
 }
 
+// HealthCheck verifies that KeyFlare is running and the Redis server is reachable.
+// It returns a combined error describing every failed check.
+func (w *Wrapper) HealthCheck(ctx context.Context) error {
+	var errs []error
+	if _, err := internal.GetInstance(); err != nil {
+		errs = append(errs, fmt.Errorf("KeyFlare is unhealthy: %w", err))
+	}
+	if err := w.client.Do(ctx, w.client.B().Ping().Build()).Error(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to ping Redis: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// Warmup fetches the keys from Redis and populates the local cache with them,
+// so the first requests for keys known to be hot are served locally.
+// Keys without a policy or missing from Redis are skipped, and the fetches aren't counted as accesses.
+// It returns a combined error describing every failed fetch.
+func (w *Wrapper) Warmup(ctx context.Context, keys ...string) error {
+	warmed := make([]string, 0, len(keys))
+	cmds := make(rueidis.Commands, 0, len(keys))
+	for _, key := range keys {
+		if w.kf.PolicyManager().GetPolicy(w.kf.NormalizeKey(key)) == nil {
+			continue
+		}
+		warmed = append(warmed, key)
+		cmds = append(cmds, w.client.B().Get().Key(key).Build())
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	var errs []error
+	for i, result := range w.client.DoMulti(ctx, cmds...) {
+		err := result.Error()
+		if rueidis.IsRedisNil(err) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to fetch key %s: %w", warmed[i], err))
+			continue
+		}
+		w.asyncSetLocalCache(warmed[i], result)
+	}
+	return errors.Join(errs...)
+}
+
 // B wraps rueidis.Client.B.
 func (w *Wrapper) B() rueidis.Builder {
 	return w.client.B()
@@ -191,9 +895,19 @@ func (w *DedicatedWrapper) Client() rueidis.DedicatedClient {
 }
 
 // incrementKey increments the key counter in the detector.
-func (w *DedicatedWrapper) incrementKey(key string) {
-	if key != "" { // Only track non-empty keys
-		w.kf.Detector().Increment(key, 1)
+func (w *DedicatedWrapper) incrementKey(ctx context.Context, key string, op detector.Operation) {
+	if key == "" { // Only track non-empty keys
+		return
+	}
+	if key = w.kf.NormalizeKey(key); w.kf.Countable(key) {
+		w.kf.Detector().IncrementOp(ctx, key, 1, op)
+	}
+}
+
+// incrementKeys increments the counters of all keys of a command.
+func (w *DedicatedWrapper) incrementKeys(ctx context.Context, keys []string, op detector.Operation) {
+	for _, key := range keys {
+		w.incrementKey(ctx, key, op)
 	}
 }
 
@@ -201,9 +915,8 @@ func (w *DedicatedWrapper) incrementKey(key string) {
 func (w *DedicatedWrapper) Do(
 	ctx context.Context, cmd rueidis.Completed,
 ) rueidis.RedisResult {
-	// Extract and track key automatically
-	key := extractKeyFromCommand(cmd)
-	w.incrementKey(key)
+	// Extract and track keys automatically
+	w.incrementKeys(ctx, extractKeysFromCommand(cmd), commandOperation(cmd))
 
 	return w.client.Do(ctx, cmd)
 }
@@ -214,8 +927,7 @@ func (w *DedicatedWrapper) DoMulti(
 ) []rueidis.RedisResult {
 	// Extract and track keys automatically for all commands
 	for _, cmd := range multi {
-		key := extractKeyFromCommand(cmd)
-		w.incrementKey(key)
+		w.incrementKeys(ctx, extractKeysFromCommand(cmd), commandOperation(cmd))
 	}
 
 	return w.client.DoMulti(ctx, multi...)
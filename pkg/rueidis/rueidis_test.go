@@ -0,0 +1,793 @@
+package rueidis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mingrammer/keyflare/internal"
+	"github.com/mingrammer/keyflare/internal/detector"
+	"github.com/mingrammer/keyflare/internal/policy"
+	"github.com/mingrammer/keyflare/internal/testutil"
+	"github.com/redis/rueidis"
+)
+
+// newTestClient creates a rueidis client connected to the fake server
+func newTestClient(t *testing.T, server *testutil.RedisServer) rueidis.Client {
+	t.Helper()
+
+	client, err := rueidis.NewClient(rueidis.ClientOption{
+		InitAddress:       []string{server.Addr()},
+		ForceSingleClient: true,
+		AlwaysRESP2:       true,
+		DisableCache:      true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create rueidis client: %v", err)
+	}
+	t.Cleanup(client.Close)
+
+	return client
+}
+
+// failingManager is a policy manager whose policies always fail
+type failingManager struct {
+	policy.Manager
+}
+
+func (m failingManager) GetPolicy(key string) policy.Policy {
+	return failingPolicy{}
+}
+
+type failingPolicy struct{}
+
+func (failingPolicy) Apply(ctx policy.Context) policy.Result {
+	return policy.Result{Error: errors.New("policy failure")}
+}
+
+func TestWrapper_Do_PolicyErrorFailClosed(t *testing.T) {
+	for _, failClosed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("FailClosed=%v", failClosed), func(t *testing.T) {
+			server := testutil.NewRedisServer(t)
+			server.Set("hot", "value")
+			testutil.StartKeyFlareConfig(t, internal.Config{
+				DetectorConfig: detector.Config{TopK: 10, HotThreshold: 1},
+				PolicyManager:  failingManager{},
+				FailClosed:     failClosed,
+			})
+
+			w, err := Wrap(newTestClient(t, server))
+			if err != nil {
+				t.Fatalf("Failed to wrap client: %v", err)
+			}
+
+			value, err := w.Do(context.Background(), w.B().Get().Key("hot").Build()).ToString()
+			if failClosed {
+				if err == nil || !strings.Contains(err.Error(), "policy failure") {
+					t.Errorf("Expected the policy error to be returned, got: %v", err)
+				}
+				if calls := server.Calls("GET", "hot"); calls != 0 {
+					t.Errorf("Expected no backend read, got %d", calls)
+				}
+			} else {
+				if err != nil || value != "value" {
+					t.Errorf("Expected the backend result 'value', got '%s' (err: %v)", value, err)
+				}
+				if calls := server.Calls("GET", "hot"); calls != 1 {
+					t.Errorf("Expected a backend read, got %d", calls)
+				}
+			}
+		})
+	}
+}
+
+func TestWrapper_Do_LocalCacheHit(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("hot", "value")
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, testutil.LocalCachePolicyConfig("hot"))
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+
+	// First read is a cache miss served by Redis
+	value, err := w.Do(ctx, w.B().Get().Key("hot").Build()).ToString()
+	if err != nil || value != "value" {
+		t.Fatalf("Expected 'value', got '%s' (err: %v)", value, err)
+	}
+
+	// Wait for the async local cache population
+	p := w.kf.PolicyManager().GetPolicy("hot")
+	testutil.Eventually(t, func() bool {
+		_, ok := p.Apply(policy.Context{Key: "hot", Data: policy.GetRequest{}}).Data.(policy.CacheHit)
+		return ok
+	})
+
+	// Second read is served from the local cache
+	value, err = w.Do(ctx, w.B().Get().Key("hot").Build()).ToString()
+	if err != nil || value != "value" {
+		t.Fatalf("Expected 'value', got '%s' (err: %v)", value, err)
+	}
+
+	if calls := server.Calls("GET", "hot"); calls != 1 {
+		t.Errorf("Expected 1 backend read, got %d", calls)
+	}
+
+	// The cached result carries the size of its value for the memory estimate
+	hit := p.Apply(policy.Context{Key: "hot", Data: policy.GetRequest{}}).Data.(policy.CacheHit)
+	if size := hit.Value.(policy.SizedValue).Size(); size != len("value") {
+		t.Errorf("Expected cached size %d, got %d", len("value"), size)
+	}
+}
+
+func TestWrapper_DoCache_LocalCacheHit(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("hot", "value")
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, testutil.LocalCachePolicyConfig("hot"))
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	cmd := func() rueidis.Cacheable { return w.B().Get().Key("hot").Cache() }
+
+	if value, err := w.DoCache(ctx, cmd(), time.Minute).ToString(); err != nil || value != "value" {
+		t.Fatalf("Expected 'value', got '%s' (err: %v)", value, err)
+	}
+
+	p := w.kf.PolicyManager().GetPolicy("hot")
+	testutil.Eventually(t, func() bool {
+		_, ok := p.Apply(policy.Context{Key: "hot", Data: policy.GetRequest{}}).Data.(policy.CacheHit)
+		return ok
+	})
+
+	if value, err := w.DoCache(ctx, cmd(), time.Minute).ToString(); err != nil || value != "value" {
+		t.Fatalf("Expected 'value', got '%s' (err: %v)", value, err)
+	}
+
+	if calls := server.Calls("GET", "hot"); calls != 1 {
+		t.Errorf("Expected 1 backend read, got %d", calls)
+	}
+}
+
+func TestWrapper_Warmup(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("hot", "value")
+	server.Set("cold", "value")
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, testutil.LocalCachePolicyConfig("hot", "missing"))
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := w.Warmup(ctx, "hot", "cold", "missing"); err != nil {
+		t.Fatalf("Failed to warm up: %v", err)
+	}
+
+	// Keys without a policy aren't fetched
+	if calls := server.Calls("GET", "cold"); calls != 0 {
+		t.Errorf("Expected no fetch of a key without a policy, got %d", calls)
+	}
+
+	// The first read is served from the local cache
+	value, err := w.Do(ctx, w.B().Get().Key("hot").Build()).ToString()
+	if err != nil || value != "value" {
+		t.Fatalf("Expected 'value', got '%s' (err: %v)", value, err)
+	}
+	if calls := server.Calls("GET", "hot"); calls != 1 {
+		t.Errorf("Expected only the warmup fetch to reach the backend, got %d", calls)
+	}
+}
+
+func TestWrapper_Do_ColdKey(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.Set("cold", "value")
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 100}, testutil.LocalCachePolicyConfig("cold"))
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if value, err := w.Do(ctx, w.B().Get().Key("cold").Build()).ToString(); err != nil || value != "value" {
+			t.Fatalf("Expected 'value', got '%s' (err: %v)", value, err)
+		}
+	}
+
+	if calls := server.Calls("GET", "cold"); calls != 3 {
+		t.Errorf("Expected 3 backend reads for a cold key, got %d", calls)
+	}
+}
+
+func TestWrapper_Do_KeySplitting(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+		Type:          policy.KeySplitting,
+		Parameters:    policy.KeySplittingConfig{Shards: 3},
+		WhitelistKeys: []string{"hot"},
+	})
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := w.Do(ctx, w.B().Set().Key("hot").Value("value").Build()).Error(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+
+	// The write is replicated to all shards
+	for i := 0; i < 3; i++ {
+		shardKey := fmt.Sprintf("hot:shard:%d", i)
+		testutil.Eventually(t, func() bool {
+			value, ok := server.Get(shardKey)
+			return ok && value == "value"
+		})
+	}
+
+	// Reads are served from a shard
+	reads := server.Calls("GET", "hot")
+	if value, err := w.Do(ctx, w.B().Get().Key("hot").Build()).ToString(); err != nil || value != "value" {
+		t.Fatalf("Expected 'value', got '%s' (err: %v)", value, err)
+	}
+	if calls := server.Calls("GET", "hot") - reads; calls != 0 {
+		t.Errorf("Expected no read of the original key, got %d", calls)
+	}
+
+	// The shard read is tracked for the distribution report
+	var total uint64
+	for _, count := range w.kf.Detector().ShardCounts("hot") {
+		total += count
+	}
+	if total != 1 {
+		t.Errorf("Expected 1 tracked shard read, got %d", total)
+	}
+}
+
+func TestWrapper_Do_KeySplittingRetry(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	server.FailNext("SET", "hot:shard:1", 2)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+		Type: policy.KeySplitting,
+		Parameters: policy.KeySplittingConfig{
+			Shards:              3,
+			ReplicationAttempts: 3,
+			ReplicationBackoff:  time.Millisecond,
+		},
+		WhitelistKeys: []string{"hot"},
+	})
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := w.Do(ctx, w.B().Set().Key("hot").Value("value").Build()).Error(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+
+	// The shard write failing twice lands on the third attempt
+	testutil.Eventually(t, func() bool {
+		value, ok := server.Get("hot:shard:1")
+		return ok && value == "value"
+	})
+	if calls := server.Calls("SET", "hot:shard:1"); calls != 3 {
+		t.Errorf("Expected 3 shard write attempts, got %d", calls)
+	}
+}
+
+func TestWrapper_Do_LookAsideShardTTL(t *testing.T) {
+	tests := []struct {
+		name        string
+		originalTTL time.Duration
+		jitter      float64
+		failures    int
+		minTTL      time.Duration
+		maxTTL      time.Duration
+	}{
+		{name: "original TTL", originalTTL: 30 * time.Second, minTTL: 29 * time.Second, maxTTL: 30 * time.Second},
+		{name: "jittered original TTL", originalTTL: 30 * time.Second, jitter: 0.5, minTTL: 15 * time.Second, maxTTL: 30 * time.Second},
+		// The retried write happens 500ms later, when less of the original TTL remains
+		{name: "retried original TTL", originalTTL: 2 * time.Second, failures: 1, minTTL: time.Second, maxTTL: 1500 * time.Millisecond},
+		{name: "fallback TTL", originalTTL: 0, minTTL: 5 * time.Minute, maxTTL: 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testutil.NewRedisServer(t)
+			server.Set("hot", "value")
+			if tt.originalTTL > 0 {
+				server.SetTTL("hot", tt.originalTTL)
+			}
+			server.FailNext("SET", "hot:shard:0", tt.failures)
+			testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+				Type: policy.KeySplitting,
+				Parameters: policy.KeySplittingConfig{
+					Shards:              2,
+					FallbackTTL:         5 * time.Minute,
+					TTLJitter:           tt.jitter,
+					ReplicationAttempts: 2,
+					ReplicationBackoff:  500 * time.Millisecond,
+				},
+				WhitelistKeys: []string{"hot"},
+			})
+
+			w, err := Wrap(newTestClient(t, server))
+			if err != nil {
+				t.Fatalf("Failed to wrap client: %v", err)
+			}
+
+			// The shard miss falls back to the original key and fills the shards
+			ctx := context.Background()
+			value, err := w.Do(ctx, w.B().Get().Key("hot").Build()).ToString()
+			if err != nil || value != "value" {
+				t.Fatalf("Expected 'value', got '%s' (err: %v)", value, err)
+			}
+
+			for i := 0; i < 2; i++ {
+				shardKey := fmt.Sprintf("hot:shard:%d", i)
+				testutil.Eventually(t, func() bool {
+					_, ok := server.Get(shardKey)
+					return ok
+				})
+				if ttl := server.TTL(shardKey); ttl < tt.minTTL || ttl > tt.maxTTL {
+					t.Errorf("Expected TTL in [%v, %v] for %s, got %v", tt.minTTL, tt.maxTTL, shardKey, ttl)
+				}
+			}
+		})
+	}
+}
+
+func TestWrapper_Do_SetWriteThrough(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, testutil.LocalCachePolicyConfig("hot"))
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := w.Do(ctx, w.B().Set().Key("hot").Value("written").Build()).Error(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+
+	// The written value is cached without reading the key back
+	if calls := server.Calls("GET", "hot"); calls != 0 {
+		t.Errorf("Expected no backend read on Set, got %d", calls)
+	}
+	result := w.kf.PolicyManager().GetPolicy("hot").Apply(policy.Context{Key: "hot", Data: policy.GetRequest{}})
+	hit, ok := result.Data.(policy.CacheHit)
+	if !ok {
+		t.Fatalf("Expected CacheHit after Set, got: %T", result.Data)
+	}
+	if value, ok := hit.Value.(string); !ok || value != "written" {
+		t.Errorf("Expected cached value 'written', got %v", hit.Value)
+	}
+
+	// The next read returns the written value and caches its result
+	if value, err := w.Do(ctx, w.B().Get().Key("hot").Build()).ToString(); err != nil || value != "written" {
+		t.Fatalf("Expected 'written', got '%s' (err: %v)", value, err)
+	}
+	testutil.Eventually(t, func() bool {
+		result := w.kf.PolicyManager().GetPolicy("hot").Apply(policy.Context{Key: "hot", Data: policy.GetRequest{}})
+		hit, ok := result.Data.(policy.CacheHit)
+		if !ok {
+			return false
+		}
+		_, ok = hit.Value.(cachedResult)
+		return ok
+	})
+}
+
+func TestWrapper_Do_WritesEvictLocalCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(ctx context.Context, w *Wrapper) error
+		expected string
+	}{
+		{
+			name: "DEL",
+			change: func(ctx context.Context, w *Wrapper) error {
+				return w.Do(ctx, w.B().Del().Key("hot").Build()).Error()
+			},
+		},
+		{
+			name: "GETDEL",
+			change: func(ctx context.Context, w *Wrapper) error {
+				return w.Do(ctx, w.B().Getdel().Key("hot").Build()).Error()
+			},
+		},
+		{
+			name: "DoMulti SET",
+			change: func(ctx context.Context, w *Wrapper) error {
+				results := w.DoMulti(ctx,
+					w.B().Get().Key("other").Build(),
+					w.B().Set().Key("hot").Value("new").Build(),
+				)
+				return results[1].Error()
+			},
+			expected: "new",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testutil.NewRedisServer(t)
+			server.Set("hot", "old")
+			testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, testutil.LocalCachePolicyConfig("hot"))
+
+			w, err := Wrap(newTestClient(t, server))
+			if err != nil {
+				t.Fatalf("Failed to wrap client: %v", err)
+			}
+
+			// Reading the hot key caches it locally
+			ctx := context.Background()
+			if err := w.Do(ctx, w.B().Get().Key("hot").Build()).Error(); err != nil {
+				t.Fatalf("Failed to get key: %v", err)
+			}
+			p := w.kf.PolicyManager().GetPolicy("hot")
+			cached := func() bool {
+				_, ok := p.Apply(policy.Context{Key: "hot", Data: policy.GetRequest{}}).Data.(policy.CacheHit)
+				return ok
+			}
+			testutil.Eventually(t, cached)
+
+			if err := tt.change(ctx, w); err != nil {
+				t.Fatalf("Failed to change key: %v", err)
+			}
+			if cached() {
+				t.Error("Expected the local entry to be evicted")
+			}
+
+			// The next read reaches Redis rather than returning the stale value
+			value, err := w.Do(ctx, w.B().Get().Key("hot").Build()).ToString()
+			if tt.expected == "" {
+				if !rueidis.IsRedisNil(err) {
+					t.Errorf("Expected a nil reply for a deleted key, got '%s' (err: %v)", value, err)
+				}
+			} else if err != nil || value != tt.expected {
+				t.Errorf("Expected '%s', got '%s' (err: %v)", tt.expected, value, err)
+			}
+		})
+	}
+}
+
+func TestWrapper_Do_DeleteRemovesShards(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, policy.Config{
+		Type:          policy.KeySplitting,
+		Parameters:    policy.KeySplittingConfig{Shards: 2},
+		WhitelistKeys: []string{"hot"},
+	})
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// Writing the hot key replicates it to its shards
+	ctx := context.Background()
+	if err := w.Do(ctx, w.B().Set().Key("hot").Value("value").Build()).Error(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+	shardKeys := []string{"hot:shard:0", "hot:shard:1"}
+	for _, shardKey := range shardKeys {
+		testutil.Eventually(t, func() bool {
+			_, ok := server.Get(shardKey)
+			return ok
+		})
+	}
+
+	if err := w.Do(ctx, w.B().Del().Key("hot").Build()).Error(); err != nil {
+		t.Fatalf("Failed to delete key: %v", err)
+	}
+	for _, shardKey := range shardKeys {
+		if _, ok := server.Get(shardKey); ok {
+			t.Errorf("Expected %s to be deleted", shardKey)
+		}
+	}
+
+	// Reads fall back to the original key rather than the stale shards
+	if value, err := w.Do(ctx, w.B().Get().Key("hot").Build()).ToString(); !rueidis.IsRedisNil(err) {
+		t.Errorf("Expected a nil reply for a deleted key, got '%s' (err: %v)", value, err)
+	}
+}
+
+func TestWrapper_Do_SetWithoutValue(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 1}, testutil.LocalCachePolicyConfig("hot"))
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+	w.kf.Detector().Increment("hot", 10)
+
+	// The malformed command is rejected by Redis rather than failing in the wrapper
+	if err := w.Do(context.Background(), w.B().Arbitrary("SET").Keys("hot").Build()).Error(); err == nil {
+		t.Error("Expected an error for a SET without a value")
+	}
+}
+
+func TestWrapper_Do_OnDecision(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+
+	var decisions []internal.Decision
+	testutil.StartKeyFlareConfig(t, internal.Config{
+		DetectorConfig: detector.Config{TopK: 10, HotThreshold: 1},
+		PolicyConfig:   testutil.LocalCachePolicyConfig("hot"),
+		OnDecision: func(d internal.Decision) {
+			decisions = append(decisions, d)
+		},
+	})
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// Commands on keys without a policy are traced too, with their hotness
+	ctx := context.Background()
+	if err := w.Do(ctx, w.B().Set().Key("other").Value("value").Build()).Error(); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+	if err := w.Do(ctx, w.B().Get().Key("other").Build()).Error(); err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+
+	expected := []internal.Decision{
+		{Key: "other", Operation: "set", Hot: true, PolicyApplied: false, Result: "", BackendHit: true},
+		{Key: "other", Operation: "get", Hot: true, PolicyApplied: false, Result: "", BackendHit: true},
+	}
+	if len(decisions) != len(expected) {
+		t.Fatalf("Expected %d decisions, got %d: %+v", len(expected), len(decisions), decisions)
+	}
+	for i, d := range decisions {
+		if d != expected[i] {
+			t.Errorf("Expected decision %d to be %+v, got %+v", i, expected[i], d)
+		}
+	}
+}
+
+func TestParseSetExpiration(t *testing.T) {
+	tests := []struct {
+		commands []string
+		expected time.Duration
+	}{
+		{[]string{"SET", "k", "v"}, 0},
+		{[]string{"SET", "k", "v", "EX", "10"}, 10 * time.Second},
+		{[]string{"SET", "k", "v", "NX", "px", "1500"}, 1500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if got := parseSetExpiration(tt.commands); got != tt.expected {
+			t.Errorf("parseSetExpiration(%v) = %v, expected %v", tt.commands, got, tt.expected)
+		}
+	}
+}
+
+func TestSendToReplicas(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 5}, testutil.LocalCachePolicyConfig())
+
+	client := newTestClient(t, server)
+	w, err := Wrap(client)
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+	w.kf.Detector().Increment("hot", 10)
+	w.kf.Detector().Increment("cold", 1)
+
+	// The fallback records the commands that weren't routed by KeyFlare
+	var fallbackCalls []string
+	sendToReplicas := SendToReplicas(func(cmd rueidis.Completed) bool {
+		fallbackCalls = append(fallbackCalls, commandName(cmd.Commands())+" "+extractKeyFromCommand(cmd))
+		return false
+	})
+
+	tests := []struct {
+		name     string
+		cmd      rueidis.Completed
+		expected bool
+	}{
+		{"hot read", client.B().Get().Key("hot").Build(), true},
+		{"cold read", client.B().Get().Key("cold").Build(), false},
+		{"hot write", client.B().Set().Key("hot").Value("v").Build(), false},
+	}
+
+	for _, tt := range tests {
+		if got := sendToReplicas(tt.cmd); got != tt.expected {
+			t.Errorf("%s: expected replica routing %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+
+	if len(fallbackCalls) != 2 || fallbackCalls[0] != "GET cold" || fallbackCalls[1] != "SET hot" {
+		t.Errorf("Expected fallback for the cold read and the hot write, got %v", fallbackCalls)
+	}
+
+	// Without a fallback, other commands go to the primary
+	if SendToReplicas(nil)(client.B().Get().Key("cold").Build()) {
+		t.Error("Expected cold read to be routed to the primary")
+	}
+}
+
+func TestWrapper_Observe(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 2}, testutil.LocalCachePolicyConfig())
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// Keys of module commands are fed manually
+	if w.Observe("module:key", "write") {
+		t.Error("Expected key not to be hot after a single access")
+	}
+	if !w.Observe("module:key", "write") {
+		t.Error("Expected key to be hot once it reaches the threshold")
+	}
+
+	topK := w.kf.Detector().TopK()
+	if len(topK) != 1 || topK[0].Key != "module:key" || topK[0].Writes != 2 {
+		t.Fatalf("Expected 'module:key' with 2 writes in TopK, got %v", topK)
+	}
+
+	// Empty keys are ignored
+	w.Observe("", "read")
+	if len(w.kf.Detector().TopK()) != 1 {
+		t.Error("Expected empty key not to be tracked")
+	}
+}
+
+func TestWrapper_Do_EvalCountsKeys(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 100}, testutil.LocalCachePolicyConfig())
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	script := "return redis.call('INCR', KEYS[1]) + redis.call('INCR', KEYS[2])"
+	if err := w.Do(ctx, w.B().Eval().Script(script).Numkeys(2).Key("counter:a", "counter:b").Arg("arg").Build()).Error(); err != nil {
+		t.Fatalf("Failed to eval script: %v", err)
+	}
+
+	expected := map[string]uint64{"counter:a": 1, "counter:b": 1, script: 0, "arg": 0}
+	for key, want := range expected {
+		if count := w.kf.Detector().GetCount(key); count != want {
+			t.Errorf("Expected count %d for key %s, got %d", want, key, count)
+		}
+	}
+}
+
+func TestWrapper_DoMulti_CountsAllKeys(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 100}, testutil.LocalCachePolicyConfig())
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	ctx := context.Background()
+	w.DoMulti(ctx,
+		w.B().Get().Key("a").Build(),
+		w.B().Mget().Key("b", "c", "d").Build(),
+	)
+	w.DoMultiCache(ctx,
+		rueidis.CT(w.B().Mget().Key("a", "b").Cache(), time.Minute),
+	)
+
+	expected := map[string]uint64{"a": 2, "b": 2, "c": 1, "d": 1}
+	for key, want := range expected {
+		if count := w.kf.Detector().GetCount(key); count != want {
+			t.Errorf("Expected count %d for key %s, got %d", want, key, count)
+		}
+	}
+}
+
+func TestRegisterKeyExtractor(t *testing.T) {
+	server := testutil.NewRedisServer(t)
+	testutil.StartKeyFlare(t, detector.Config{TopK: 10, HotThreshold: 100}, testutil.LocalCachePolicyConfig())
+
+	w, err := Wrap(newTestClient(t, server))
+	if err != nil {
+		t.Fatalf("Failed to wrap client: %v", err)
+	}
+
+	// A module command taking its key after a subcommand
+	RegisterKeyExtractor("mod.cmd", KeyAt(2))
+	t.Cleanup(func() {
+		keyExtractorsMu.Lock()
+		delete(keyExtractors, "MOD.CMD")
+		keyExtractorsMu.Unlock()
+	})
+
+	ctx := context.Background()
+	w.Do(ctx, w.B().Arbitrary("MOD.CMD", "SUB").Keys("k").Build())
+	w.Do(ctx, w.B().Eval().Script("return 1").Numkeys(1).Key("script:key").Arg("arg").Build())
+
+	for _, key := range []string{"k", "script:key"} {
+		if count := w.kf.Detector().GetCount(key); count != 1 {
+			t.Errorf("Expected count 1 for key %s, got %d", key, count)
+		}
+	}
+	if count := w.kf.Detector().GetCount("SUB"); count != 0 {
+		t.Errorf("Expected the subcommand to not be counted, got %d", count)
+	}
+}
+
+func TestExtractKeysFromCommand(t *testing.T) {
+	client := newTestClient(t, testutil.NewRedisServer(t))
+
+	tests := []struct {
+		name     string
+		cmd      rueidis.Completed
+		expected []string
+	}{
+		{"MGET", client.B().Mget().Key("a", "b", "c").Build(), []string{"a", "b", "c"}},
+		{"MSET", client.B().Mset().KeyValue().KeyValue("a", "1").KeyValue("b", "2").Build(), []string{"a", "b"}},
+		{"GEORADIUS", client.B().Georadius().Key("geo").Longitude(15).Latitude(37).Radius(200).Km().Build(), []string{"geo"}},
+		{"SET", client.B().Set().Key("k").Value("v").Build(), []string{"k"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractKeysFromCommand(tt.cmd)
+			if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("extractKeysFromCommand(%v) = %v, expected %v", tt.cmd.Commands(), got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCommandKeys(t *testing.T) {
+	tests := []struct {
+		commands []string
+		expected []string
+	}{
+		{[]string{"GET", "k"}, []string{"k"}},
+		{[]string{"SET", "k", "v", "EX", "10"}, []string{"k"}},
+		{[]string{"MGET", "a", "b", "c"}, []string{"a", "b", "c"}},
+		{[]string{"DEL", "a", "b"}, []string{"a", "b"}},
+		{[]string{"MSET", "a", "1", "b", "2"}, []string{"a", "b"}},
+		{[]string{"GEORADIUS", "geo", "15", "37", "200", "km"}, []string{"geo"}},
+		{[]string{"RENAME", "a", "b"}, []string{"a", "b"}},
+		{[]string{"BLPOP", "a", "b", "0"}, []string{"a", "b"}},
+		{[]string{"BITOP", "AND", "dest", "a", "b"}, []string{"dest", "a", "b"}},
+		{[]string{"ZUNIONSTORE", "dest", "2", "a", "b", "WEIGHTS", "1", "2"}, []string{"dest", "a", "b"}},
+		{[]string{"LMPOP", "2", "a", "b", "LEFT"}, []string{"a", "b"}},
+		{[]string{"EVALSHA", "sha", "1", "a", "arg"}, []string{"a"}},
+		{[]string{"EVAL", "return 1", "2", "a", "b", "arg"}, []string{"a", "b"}},
+		{[]string{"OBJECT", "ENCODING", "k"}, []string{"k"}},
+		{[]string{"XREAD", "COUNT", "10", "STREAMS", "s1", "s2", "0", "0"}, []string{"s1", "s2"}},
+		{[]string{"PUBLISH", "channel", "message"}, nil},
+		{[]string{"PING"}, nil},
+	}
+
+	for _, tt := range tests {
+		got := commandKeys(tt.commands)
+		if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("commandKeys(%v) = %v, expected %v", tt.commands, got, tt.expected)
+		}
+	}
+}